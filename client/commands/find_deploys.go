@@ -1,7 +1,9 @@
 package commands
 
 import (
+	"context"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/ocuroot/gittools"
@@ -13,20 +15,26 @@ import (
 )
 
 var FindDeploysCmd = &cobra.Command{
-	Use:   "find-deploys [startCommit] [endCommit]",
+	Use:   "find-deploys [start] [end]",
 	Short: "Find deployments containing specific commits",
 	Long: `Find deployments that contain specific commits.
 	
 This command searches through the deployment history to locate any deployments
 that include the changes between the specified start and end commits (inclusive).
 
+The start and end may also be release refs, including relative selectors, in
+which case the commit of that release is used.
+
 Example:
-  ocuroot find-deploys abc123 def456`,
+  ocuroot find-deploys abc123 def456
+  ocuroot find-deploys ./@deployed:staging ./@~1`,
 	Args: cobra.ExactArgs(2),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		ctx := cmd.Context()
 
-		ref, err := GetRef(cmd, args)
+		// The arguments are commits or release refs, so work from the
+		// current package
+		ref, err := GetRef(cmd, nil)
 		if err != nil {
 			return fmt.Errorf("failed to get ref: %w", err)
 		}
@@ -40,8 +48,14 @@ Example:
 
 		defer w.Cleanup()
 
-		startCommit := args[0]
-		endCommit := args[1]
+		startCommit, err := resolveCommitArg(ctx, w, args[0])
+		if err != nil {
+			return err
+		}
+		endCommit, err := resolveCommitArg(ctx, w, args[1])
+		if err != nil {
+			return err
+		}
 
 		// Get the repository path (assuming current directory if not specified)
 		repoPath := w.Tracker.RepoPath
@@ -107,6 +121,30 @@ Example:
 	},
 }
 
+// resolveCommitArg returns the commit for an argument that may be either a
+// commit or a release ref, such as ./@deployed:production.
+func resolveCommitArg(ctx context.Context, w *work.Worker, arg string) (string, error) {
+	if !strings.Contains(arg, "@") {
+		return arg, nil
+	}
+
+	ref, err := refs.Parse(arg)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse ref %s: %w", arg, err)
+	}
+	ref, err = ref.RelativeTo(w.Tracker.Ref)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve ref %s: %w", arg, err)
+	}
+	releaseRef := ref.SetSubPathType(refs.SubPathTypeNone).SetSubPath("").SetFragment("")
+
+	var releaseInfo librelease.ReleaseInfo
+	if err := w.Tracker.State.Get(ctx, releaseRef.String(), &releaseInfo); err != nil {
+		return "", fmt.Errorf("failed to get release info (%v): %w", releaseRef.String(), err)
+	}
+	return releaseInfo.Commit, nil
+}
+
 func init() {
 	RootCmd.AddCommand(FindDeploysCmd)
 }
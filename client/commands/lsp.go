@@ -0,0 +1,89 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/ocuroot/ocuroot/client/lsp"
+	"github.com/ocuroot/ocuroot/client/work"
+	"github.com/ocuroot/ocuroot/refs"
+	"github.com/ocuroot/ocuroot/refs/refstore"
+	"github.com/spf13/cobra"
+)
+
+var LSPCmd = &cobra.Command{
+	Use:   "lsp",
+	Short: "Run a language server for .ocu.star files",
+	Long: `Run a Language Server Protocol server for .ocu.star files over stdin and
+stdout, for use by editors.
+
+The server provides completion and hover docs for the SDK, go to definition
+across loaded files and diagnostics from evaluating each file when it is
+opened or saved.
+
+With --check-refs, refs to other packages used in ref() and input() are
+looked up in the state store configured in repo.ocu.star, and a warning is
+shown for any that don't exist.`,
+	Args: cobra.ExactArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+
+		var checker lsp.RefChecker
+		if checkRefs, _ := cmd.Flags().GetBool("check-refs"); checkRefs {
+			c := &stateRefChecker{}
+			defer c.Close()
+			checker = c
+		}
+
+		server := lsp.NewServer(os.Stdin, os.Stdout, checker)
+		if err := server.Serve(cmd.Context()); err != nil {
+			return fmt.Errorf("language server failed: %w", err)
+		}
+		return nil
+	},
+}
+
+// stateRefChecker checks refs against the state store of the repo in the
+// working directory. The store is opened on first use.
+type stateRefChecker struct {
+	once sync.Once
+	w    *work.Worker
+	err  error
+}
+
+func (c *stateRefChecker) RefExists(ctx context.Context, pkg string, ref refs.Ref) (bool, error) {
+	c.once.Do(func() {
+		c.w, c.err = work.NewWorker(ctx, refs.Ref{Filename: "."})
+	})
+	if c.err != nil {
+		return false, fmt.Errorf("failed to open state: %w", c.err)
+	}
+
+	ref, err := ref.RelativeTo(refs.Ref{Repo: c.w.Tracker.Ref.Repo, Filename: pkg})
+	if err != nil {
+		return false, err
+	}
+
+	// Fields may not be set until a release has run, so only the document
+	// itself is checked
+	var v any
+	err = c.w.Tracker.State.Get(ctx, ref.SetFragment("").String(), &v)
+	if errors.Is(err, refstore.ErrRefNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (c *stateRefChecker) Close() {
+	if c.w != nil {
+		c.w.Cleanup()
+	}
+}
+
+func init() {
+	RootCmd.AddCommand(LSPCmd)
+	LSPCmd.Flags().Bool("check-refs", false, "Warn about refs to other packages that don't exist in state")
+}
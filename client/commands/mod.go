@@ -0,0 +1,57 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/ocuroot/ocuroot/client"
+	"github.com/ocuroot/ocuroot/client/modules"
+	"github.com/spf13/cobra"
+)
+
+var ModCmd = &cobra.Command{
+	Use:   "mod",
+	Short: "Manage remote Starlark modules",
+	Long: `Manage remote Starlark modules.
+
+Modules can be loaded from remote git repos at a tag, branch or commit:
+
+  load("git+https://github.com/org/lib//helpers.ocu.star@v1.2.0", "deploy")
+
+The commit and content hash of each loaded file are pinned in ocuroot.lock at
+the repo root the first time it is loaded. Relative loads within a remote
+module resolve in the same repo at the same version.
+`,
+}
+
+var ModVendorCmd = &cobra.Command{
+	Use:   "vendor",
+	Short: "Fetch remote modules into the repo for offline builds",
+	Long: `Fetch all remote modules loaded by the repo into the ocuroot_vendor
+directory, and remove unused modules from ocuroot.lock.
+
+Vendored modules are loaded in place of their remote repos, and must match
+the hashes in ocuroot.lock.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+
+		root, err := client.FindSourceRepoRoot(".")
+		if err != nil {
+			return fmt.Errorf("failed to find repo root: %w", err)
+		}
+
+		vendored, err := modules.Vendor(root)
+		if err != nil {
+			return fmt.Errorf("failed to vendor modules: %w", err)
+		}
+		for _, m := range vendored {
+			fmt.Fprintln(cmd.OutOrStdout(), m.String())
+		}
+		return nil
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(ModCmd)
+	ModCmd.AddCommand(ModVendorCmd)
+}
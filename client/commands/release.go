@@ -45,6 +45,10 @@ var NewReleaseCmd = &cobra.Command{
 
 		force := cmd.Flag("force").Changed
 		cascade := cmd.Flag("cascade").Changed
+		parallelism, err := cmd.Flags().GetInt("parallelism")
+		if err != nil {
+			return fmt.Errorf("failed to get parallelism flag: %w", err)
+		}
 
 		ref, err := GetRef(cmd, args)
 		if err != nil {
@@ -58,6 +62,7 @@ var NewReleaseCmd = &cobra.Command{
 			return err
 		}
 		defer worker.Cleanup()
+		worker.Parallelism = parallelism
 
 		tc := worker.Tracker
 
@@ -312,6 +317,7 @@ func checkFinalReleaseState(
 func init() {
 	NewReleaseCmd.Flags().BoolP("force", "f", false, "Create a new release even if there are existing releases for this commit")
 	NewReleaseCmd.Flags().Bool("cascade", false, "Create a new release and cascade follow on work for dependant releases")
+	NewReleaseCmd.Flags().Int("parallelism", 1, "Maximum number of tasks to run concurrently within each phase of the release")
 
 	ReleaseCmd.AddCommand(NewReleaseCmd)
 
@@ -12,6 +12,7 @@ import (
 	"github.com/charmbracelet/lipgloss"
 	"github.com/charmbracelet/log"
 	"github.com/charmbracelet/x/term"
+	"github.com/ocuroot/ocuroot/client/modules"
 	"github.com/ocuroot/ocuroot/client/release"
 	"github.com/ocuroot/ocuroot/client/work"
 	"github.com/ocuroot/ocuroot/refs"
@@ -88,7 +89,7 @@ func runSingleCommand(ctx context.Context, filePath string, command string) erro
 		// Load the .ocu.star file using sdk.LoadConfig to get user-defined functions
 		config, err := sdk.LoadConfig(
 			ctx,
-			sdk.NewFSResolver(os.DirFS(w.Tracker.RepoPath)),
+			modules.NewRepoResolver(w.Tracker.RepoPath),
 			filePath,
 			backend,
 			func(thread *starlark.Thread, msg string) {
@@ -185,7 +186,7 @@ func runStarlarkReplWithFile(ctx context.Context, filePath string) error {
 	// Load the .ocu.star file using sdk.LoadConfig to get user-defined functions
 	config, err := sdk.LoadConfig(
 		ctx,
-		sdk.NewFSResolver(os.DirFS(w.Tracker.RepoPath)),
+		modules.NewRepoResolver(w.Tracker.RepoPath),
 		filePath,
 		backend,
 		func(thread *starlark.Thread, msg string) {
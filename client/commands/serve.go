@@ -0,0 +1,154 @@
+package commands
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/charmbracelet/log"
+	"github.com/ocuroot/ocuroot/client/work"
+	"github.com/ocuroot/ocuroot/refs/refstore"
+	"github.com/spf13/cobra"
+)
+
+var ServeCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve state and intent over HTTP.",
+	Long: `Serve state and intent over HTTP, so workers can share the stores
+configured in repo.ocu.star by setting store.http(url) instead.
+
+Clients must authenticate with a token. A read-write token may be set with
+the OCUROOT_SERVE_TOKEN environment variable, and more tokens listed in
+--token-file, one per line. Each token may be followed by "actor=<name>",
+recorded as the actor of changes made with it in place of the server's own,
+and by "read-only" if it may only read.
+
+Clients send their token from the OCUROOT_STORE_TOKEN environment variable.
+
+The server listens on localhost by default. Tokens are sent in the clear, so
+when serving other hosts with --addr, put the server behind a proxy that
+terminates TLS.`,
+	Args: cobra.ExactArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		ref, err := GetRef(cmd, args)
+		if err != nil {
+			return fmt.Errorf("failed to get ref: %w", err)
+		}
+
+		addr, _ := cmd.Flags().GetString("addr")
+		tokenFile, _ := cmd.Flags().GetString("token-file")
+		transactionTimeout, _ := cmd.Flags().GetDuration("transaction-timeout")
+
+		tokens, err := serveTokens(tokenFile)
+		if err != nil {
+			return err
+		}
+
+		w, err := work.NewWorker(ctx, ref)
+		if err != nil {
+			return fmt.Errorf("failed to create worker: %w", err)
+		}
+		w.Cleanup()
+		defer w.Tracker.State.Close()
+		defer w.Tracker.Intent.Close()
+
+		cmd.SilenceUsage = true
+
+		handler := refstore.NewHTTPHandler(refstore.HTTPHandlerConfig{
+			Stores: map[string]refstore.Store{
+				"state":  w.Tracker.State,
+				"intent": w.Tracker.Intent,
+			},
+			Tokens:             tokens,
+			TransactionTimeout: transactionTimeout,
+		})
+		server := &http.Server{
+			Addr:    addr,
+			Handler: handler,
+		}
+
+		errs := make(chan error, 1)
+		go func() {
+			errs <- server.ListenAndServe()
+		}()
+		fmt.Fprintf(os.Stderr, "Serving state on %s\n", addr)
+		log.Info("Serving state", "addr", addr, "tokens", len(tokens))
+
+		select {
+		case err := <-errs:
+			return fmt.Errorf("server failed: %w", err)
+		case <-ctx.Done():
+		}
+
+		if err := server.Shutdown(context.WithoutCancel(ctx)); err != nil {
+			return fmt.Errorf("failed to stop server: %w", err)
+		}
+		if err := handler.Close(); err != nil {
+			return fmt.Errorf("failed to roll back open transactions: %w", err)
+		}
+		return nil
+	},
+}
+
+// serveTokens loads the tokens clients may authenticate with from the
+// environment and an optional token file.
+func serveTokens(tokenFile string) ([]refstore.HTTPToken, error) {
+	var tokens []refstore.HTTPToken
+	if token := os.Getenv("OCUROOT_SERVE_TOKEN"); token != "" {
+		tokens = append(tokens, refstore.HTTPToken{Token: token})
+	}
+
+	if tokenFile != "" {
+		f, err := os.Open(tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open token file: %w", err)
+		}
+		defer f.Close()
+
+		scanner := bufio.NewScanner(f)
+		for line := 1; scanner.Scan(); line++ {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+				continue
+			}
+			token := refstore.HTTPToken{Token: fields[0]}
+			for _, field := range fields[1:] {
+				actor, isActor := strings.CutPrefix(field, "actor=")
+				switch {
+				case field == "read-only":
+					token.ReadOnly = true
+				case isActor && actor != "":
+					token.Actor = actor
+				default:
+					return nil, fmt.Errorf("%s:%d: expected a token, optionally followed by actor=<name> and read-only", tokenFile, line)
+				}
+			}
+			tokens = append(tokens, token)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read token file: %w", err)
+		}
+	}
+
+	if len(tokens) == 0 {
+		return nil, errors.New("no tokens configured, set OCUROOT_SERVE_TOKEN or --token-file")
+	}
+	return tokens, nil
+}
+
+func init() {
+	RootCmd.AddCommand(ServeCmd)
+	AddRefFlags(ServeCmd, false)
+	ServeCmd.Flags().String("addr", "127.0.0.1:8080", "Address to listen on.")
+	ServeCmd.Flags().String("token-file", "", "File listing tokens clients may authenticate with.")
+	ServeCmd.Flags().Duration("transaction-timeout", refstore.DefaultHTTPTransactionTimeout, "How long a transaction may be left idle before it is rolled back.")
+}
@@ -1,14 +1,24 @@
 package commands
 
 import (
+	"bytes"
 	"context"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/charmbracelet/log"
+	"github.com/google/go-cmp/cmp"
 	"github.com/ocuroot/ocuroot/client/release"
 	"github.com/ocuroot/ocuroot/client/state"
 	"github.com/ocuroot/ocuroot/client/work"
+	librelease "github.com/ocuroot/ocuroot/lib/release"
 	"github.com/ocuroot/ocuroot/refs"
 	"github.com/ocuroot/ocuroot/refs/refstore"
 	"github.com/ocuroot/ocuroot/sdk"
@@ -44,13 +54,37 @@ var StateGetCmd = &cobra.Command{
 		}
 		w.Cleanup()
 
+		at, err := cmd.Flags().GetString("at")
+		if err != nil {
+			return fmt.Errorf("failed to get at flag: %w", err)
+		}
+
 		var v any
-		err = w.Tracker.State.Get(cmd.Context(), w.Tracker.Ref.String(), &v)
+		if at != "" {
+			err = getStateAt(ctx, w.Tracker.State, w.Tracker.Ref.String(), at, &v)
+		} else {
+			err = w.Tracker.State.Get(cmd.Context(), w.Tracker.Ref.String(), &v)
+		}
 		if err != nil {
 			log.Error("Failed to get state", "ref", w.Tracker.Ref.String(), "error", err)
 			return fmt.Errorf("failed to get state: %w", err)
 		}
 
+		meta, err := cmd.Flags().GetBool("meta")
+		if err != nil {
+			return fmt.Errorf("failed to get meta flag: %w", err)
+		}
+		if meta {
+			metadata, err := refstore.GetMetadata(ctx, w.Tracker.State, w.Tracker.Ref.String())
+			if err != nil {
+				return fmt.Errorf("failed to get metadata: %w", err)
+			}
+			v = map[string]any{
+				"value":    v,
+				"metadata": metadata,
+			}
+		}
+
 		jv, err := json.MarshalIndent(v, "", "  ")
 		if err != nil {
 			log.Error("Failed to marshal state", "error", err)
@@ -63,6 +97,132 @@ var StateGetCmd = &cobra.Command{
 	},
 }
 
+// getStateAt gets the value of a ref at a commit, revision or point in time.
+func getStateAt(ctx context.Context, store refstore.Store, ref string, at string, v any) error {
+	revision := at
+	if t, ok := parseStateTime(at); ok {
+		var err error
+		revision, err = refstore.RevisionAt(ctx, store, t)
+		if errors.Is(err, refstore.ErrRefNotFound) {
+			return fmt.Errorf("no state was recorded at or before %s", t.Format(time.RFC3339))
+		}
+		if err != nil {
+			return fmt.Errorf("failed to find revision: %w", err)
+		}
+	}
+	return refstore.GetAt(ctx, store, ref, revision, v)
+}
+
+var stateTimeFormats = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// parseStateTime parses a timestamp passed to --at.
+// Timestamps without a zone are in local time.
+func parseStateTime(value string) (time.Time, bool) {
+	for _, format := range stateTimeFormats {
+		if t, err := time.ParseInLocation(format, value, time.Local); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+var StateLogCmd = &cobra.Command{
+	Use:   "log [ref]",
+	Short: "List changes to a ref.",
+	Long: `List changes to a ref, most recent first.
+
+Each change is shown with its revision, time and message, followed by the
+difference from the previous value. A revision can be passed to
+'ocuroot state get --at' to read state as it was after that change.
+
+History is available for git stores, and for filesystem stores with history
+enabled.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+
+		ref, err := GetRef(cmd, args)
+		if err != nil {
+			return fmt.Errorf("failed to get ref: %w", err)
+		}
+
+		cmd.SilenceUsage = true
+
+		w, err := work.NewWorker(ctx, ref)
+		if err != nil {
+			return fmt.Errorf("failed to create worker: %w", err)
+		}
+		w.Cleanup()
+
+		entries, err := refstore.History(ctx, w.Tracker.State, w.Tracker.Ref.String())
+		if err != nil {
+			return fmt.Errorf("failed to get history: %w", err)
+		}
+
+		for i, entry := range entries {
+			var previous *refstore.HistoryEntry
+			if i+1 < len(entries) {
+				previous = &entries[i+1]
+			}
+
+			change, err := describeStateChange(previous, entry)
+			if err != nil {
+				return fmt.Errorf("failed to describe change at %s: %w", entry.Revision, err)
+			}
+
+			fmt.Printf("%s %s\n", entry.Revision, entry.Time.Local().Format(time.RFC3339))
+			if entry.Audit != nil {
+				fmt.Printf("Actor: %s\n", entry.Audit.Actor)
+				if entry.Audit.JobURL != "" {
+					fmt.Printf("Job: %s\n", entry.Audit.JobURL)
+				}
+			}
+			if message := strings.TrimSpace(entry.Message); message != "" {
+				for _, line := range strings.Split(message, "\n") {
+					fmt.Println(strings.TrimRight("    "+line, " "))
+				}
+			}
+			fmt.Println()
+			fmt.Println(change)
+		}
+		return nil
+	},
+}
+
+// describeStateChange summarizes a change from a previous history entry,
+// which is nil for the first recorded change.
+func describeStateChange(previous *refstore.HistoryEntry, entry refstore.HistoryEntry) (string, error) {
+	if entry.Deleted {
+		return "deleted\n", nil
+	}
+	if entry.Link != "" {
+		return fmt.Sprintf("linked to %s\n", entry.Link), nil
+	}
+
+	if previous == nil || len(previous.Body) == 0 {
+		var out bytes.Buffer
+		if err := json.Indent(&out, entry.Body, "", "  "); err != nil {
+			return "", err
+		}
+		return out.String() + "\n", nil
+	}
+
+	var before, after any
+	if err := json.Unmarshal(previous.Body, &before); err != nil {
+		return "", err
+	}
+	if err := json.Unmarshal(entry.Body, &after); err != nil {
+		return "", err
+	}
+	return cmp.Diff(before, after), nil
+}
+
 var StateMatchCmd = &cobra.Command{
 	Use:   "match [glob]",
 	Short: "List refs matching the specified glob.",
@@ -109,6 +269,430 @@ var StateMatchCmd = &cobra.Command{
 	},
 }
 
+var StateWatchCmd = &cobra.Command{
+	Use:   "watch [glob...]",
+	Short: "Print changes to refs matching the specified globs as they happen.",
+	Long: `Print changes to refs matching the specified globs as they happen.
+
+Each change is printed as a line of JSON, including changes made by other
+workers. All refs are watched if no globs are specified.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+
+		ref, err := GetRef(cmd, args)
+		if err != nil {
+			return fmt.Errorf("failed to get ref: %w", err)
+		}
+
+		w, err := work.NewWorker(ctx, ref)
+		if err != nil {
+			return fmt.Errorf("failed to create worker: %w", err)
+		}
+		w.Cleanup()
+
+		cmd.SilenceUsage = true
+
+		events, err := refstore.Watch(ctx, w.Tracker.State, args...)
+		if err != nil {
+			return fmt.Errorf("failed to watch state: %w", err)
+		}
+
+		encoder := json.NewEncoder(os.Stdout)
+		for event := range events {
+			if err := encoder.Encode(event); err != nil {
+				return fmt.Errorf("failed to write event: %w", err)
+			}
+		}
+		return nil
+	},
+}
+
+var StateMigrateCmd = &cobra.Command{
+	Use:   "migrate [config-file]",
+	Short: "Copy state and intent to the stores set in another config file.",
+	Long: `Copy state and intent to the stores set in another config file.
+
+Every ref, link and dependency is copied from the stores configured for the
+current repo to the stores set by the store.set call in the given file, which
+may be a copy of repo.ocu.star with a different store. Once copied, both stores
+are compared to verify the migration.
+
+The destination stores must be empty unless --overwrite is set.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+
+		dryRun, err := cmd.Flags().GetBool("dry-run")
+		if err != nil {
+			return fmt.Errorf("failed to get dry-run flag: %w", err)
+		}
+		overwrite, err := cmd.Flags().GetBool("overwrite")
+		if err != nil {
+			return fmt.Errorf("failed to get overwrite flag: %w", err)
+		}
+
+		ref, err := GetRef(cmd, nil)
+		if err != nil {
+			return fmt.Errorf("failed to get ref: %w", err)
+		}
+
+		cmd.SilenceUsage = true
+
+		w, err := work.NewWorker(ctx, ref)
+		if err != nil {
+			return fmt.Errorf("failed to create worker: %w", err)
+		}
+		w.Cleanup()
+
+		storeConfig, err := work.LoadStoreConfig(ctx, args[0])
+		if err != nil {
+			return fmt.Errorf("failed to load destination store config: %w", err)
+		}
+		toState, toIntent, err := release.NewRefStore(storeConfig, w.Tracker.Ref.Repo, w.Tracker.RepoPath)
+		if err != nil {
+			return fmt.Errorf("failed to create destination stores: %w", err)
+		}
+		defer toState.Close()
+		defer toIntent.Close()
+
+		migrations := []struct {
+			name     string
+			from, to refstore.Store
+		}{
+			{"state", w.Tracker.State, toState},
+			{"intent", w.Tracker.Intent, toIntent},
+		}
+
+		options := refstore.MigrateOptions{
+			DryRun:    dryRun,
+			Overwrite: overwrite,
+		}
+		for _, m := range migrations {
+			contents, err := refstore.Migrate(ctx, m.from, m.to, options)
+			if err != nil {
+				return fmt.Errorf("failed to migrate %s: %w", m.name, err)
+			}
+
+			verb := "Copied"
+			if dryRun {
+				verb = "Would copy"
+			}
+			fmt.Printf("%s %s: %d refs, %d links, %d dependencies\n", verb, m.name, len(contents.Values), len(contents.Links), contents.DependencyCount())
+		}
+		if dryRun {
+			return nil
+		}
+
+		var failed bool
+		for _, m := range migrations {
+			problems, err := refstore.VerifyMigration(ctx, m.from, m.to)
+			if err != nil {
+				return fmt.Errorf("failed to verify %s: %w", m.name, err)
+			}
+			for _, problem := range problems {
+				fmt.Printf("%s: %s\n", m.name, problem)
+			}
+			failed = failed || len(problems) > 0
+		}
+		if failed {
+			return fmt.Errorf("migrated stores do not match")
+		}
+		fmt.Println("Verified migrated stores")
+		return nil
+	},
+}
+
+var StateExportCmd = &cobra.Command{
+	Use:   "export [glob...]",
+	Short: "Export state and intent to an archive.",
+	Long: `Export state and intent to an archive.
+
+Refs matching the globs, along with links and dependencies involving them, are
+written to a zstd compressed tarball that can be loaded into any store with
+'ocuroot state import'. All refs are exported if no globs are specified.
+
+The archive is written to stdout unless --output is set:
+
+	ocuroot state export "github.com/example/repo.git/-/**" > state.tar.zst`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+
+		output, err := cmd.Flags().GetString("output")
+		if err != nil {
+			return fmt.Errorf("failed to get output flag: %w", err)
+		}
+
+		ref, err := GetRef(cmd, nil)
+		if err != nil {
+			return fmt.Errorf("failed to get ref: %w", err)
+		}
+
+		cmd.SilenceUsage = true
+
+		w, err := work.NewWorker(ctx, ref)
+		if err != nil {
+			return fmt.Errorf("failed to create worker: %w", err)
+		}
+		w.Cleanup()
+
+		archive, err := refstore.ExportArchive(ctx, []refstore.NamedStore{
+			{Name: "state", Store: w.Tracker.State},
+			{Name: "intent", Store: w.Tracker.Intent},
+		}, args...)
+		if err != nil {
+			return fmt.Errorf("failed to export: %w", err)
+		}
+
+		out := os.Stdout
+		if output != "" && output != "-" {
+			out, err = os.Create(output)
+			if err != nil {
+				return fmt.Errorf("failed to create %s: %w", output, err)
+			}
+			defer out.Close()
+		}
+		if _, err := archive.WriteTo(out); err != nil {
+			return fmt.Errorf("failed to write archive: %w", err)
+		}
+
+		for _, name := range archive.Manifest.Stores {
+			contents := archive.Stores[name]
+			fmt.Fprintf(os.Stderr, "Exported %s: %d refs, %d links, %d dependencies\n", name, len(contents.Values), len(contents.Links), contents.DependencyCount())
+		}
+		return nil
+	},
+}
+
+var StateImportCmd = &cobra.Command{
+	Use:   "import [archive]",
+	Short: "Import state and intent from an archive.",
+	Long: `Import state and intent from an archive created by 'ocuroot state export'.
+
+The archive is read from stdin if no file is given, or the file is '-'.
+
+With --mode=merge (the default), refs in the archive are added to the stores,
+replacing any that already exist. With --mode=replace, refs in the stores that
+match the globs the archive was exported with are removed first.
+
+Repos can be renamed as they are imported, which also applies to repo aliases:
+
+	ocuroot state import --rename-repo github.com/customer/app.git=app state.tar.zst`,
+	Args: cobra.RangeArgs(0, 1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+
+		mode, err := cmd.Flags().GetString("mode")
+		if err != nil {
+			return fmt.Errorf("failed to get mode flag: %w", err)
+		}
+		renameRepos, err := cmd.Flags().GetStringToString("rename-repo")
+		if err != nil {
+			return fmt.Errorf("failed to get rename-repo flag: %w", err)
+		}
+
+		ref, err := GetRef(cmd, nil)
+		if err != nil {
+			return fmt.Errorf("failed to get ref: %w", err)
+		}
+
+		cmd.SilenceUsage = true
+
+		in := os.Stdin
+		if len(args) > 0 && args[0] != "-" {
+			in, err = os.Open(args[0])
+			if err != nil {
+				return fmt.Errorf("failed to open %s: %w", args[0], err)
+			}
+			defer in.Close()
+		}
+		archive, err := refstore.ReadArchive(in)
+		if err != nil {
+			return fmt.Errorf("failed to read archive: %w", err)
+		}
+
+		w, err := work.NewWorker(ctx, ref)
+		if err != nil {
+			return fmt.Errorf("failed to create worker: %w", err)
+		}
+		w.Cleanup()
+
+		stores := map[string]refstore.Store{
+			"state":  w.Tracker.State,
+			"intent": w.Tracker.Intent,
+		}
+		options := refstore.ImportOptions{
+			Mode:        refstore.ImportMode(mode),
+			RenameRepos: renameRepos,
+		}
+		for _, name := range archive.Manifest.Stores {
+			store, ok := stores[name]
+			if !ok {
+				return fmt.Errorf("archive contains unknown store %s", name)
+			}
+			contents, err := refstore.ImportArchive(ctx, store, archive, name, options)
+			if err != nil {
+				return fmt.Errorf("failed to import %s: %w", name, err)
+			}
+			fmt.Printf("Imported %s: %d refs, %d links, %d dependencies\n", name, len(contents.Values), len(contents.Links), contents.DependencyCount())
+		}
+		return nil
+	},
+}
+
+var StateFsckCmd = &cobra.Command{
+	Use:   "fsck",
+	Short: "Check state and intent for inconsistencies.",
+	Long: `Check state and intent for inconsistencies.
+
+Reports links to refs that no longer exist, runs with more than one status,
+dependencies that are only recorded for one of the refs involved and releases
+that reference functions missing from their package.
+
+With --repair, links to missing refs are removed, stale statuses are removed
+and one-sided dependencies are recorded for both refs. All repairs to a store
+are made in a single transaction. Other issues are reported but left in place.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+
+		repair, err := cmd.Flags().GetBool("repair")
+		if err != nil {
+			return fmt.Errorf("failed to get repair flag: %w", err)
+		}
+
+		ref, err := GetRef(cmd, nil)
+		if err != nil {
+			return fmt.Errorf("failed to get ref: %w", err)
+		}
+
+		cmd.SilenceUsage = true
+
+		w, err := work.NewWorker(ctx, ref)
+		if err != nil {
+			return fmt.Errorf("failed to create worker: %w", err)
+		}
+		w.Cleanup()
+
+		stores := []struct {
+			name  string
+			store refstore.Store
+			check func(context.Context, refstore.Store) ([]refstore.Issue, error)
+		}{
+			{"state", w.Tracker.State, librelease.Fsck},
+			{"intent", w.Tracker.Intent, func(ctx context.Context, store refstore.Store) ([]refstore.Issue, error) {
+				linkIssues, err := refstore.CheckLinks(ctx, store)
+				if err != nil {
+					return nil, err
+				}
+				depIssues, err := refstore.CheckDependencies(ctx, store)
+				if err != nil {
+					return nil, err
+				}
+				return append(linkIssues, depIssues...), nil
+			}},
+		}
+
+		var remaining int
+		for _, s := range stores {
+			issues, err := s.check(ctx, s.store)
+			if err != nil {
+				return fmt.Errorf("failed to check %s: %w", s.name, err)
+			}
+			for _, issue := range issues {
+				fmt.Printf("%s: %s\n", s.name, issue)
+			}
+			remaining += len(issues)
+
+			if !repair {
+				continue
+			}
+			repaired, err := refstore.Repair(ctx, s.store, issues)
+			if err != nil {
+				return fmt.Errorf("failed to repair %s: %w", s.name, err)
+			}
+			if repaired > 0 {
+				fmt.Printf("Repaired %d issues in %s\n", repaired, s.name)
+			}
+			remaining -= repaired
+		}
+
+		if remaining > 0 {
+			return fmt.Errorf("found %d issues", remaining)
+		}
+		return nil
+	},
+}
+
+var StateGCCmd = &cobra.Command{
+	Use:   "gc",
+	Short: "Remove old releases and logs from state.",
+	Long: `Remove old releases and logs from state.
+
+Retention is configured in repo.ocu.star:
+
+	retention = {
+		"keep_releases": 10, # Releases kept for each package
+		"log_days": 30,      # Days logs are kept for in remaining releases
+	}
+
+Releases that are linked, such as those currently deployed or tagged, and
+releases with unfinished runs are always kept.
+
+Lists what would be removed unless --apply is set. Refs are deleted in batches,
+with one transaction per batch.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+
+		apply, err := cmd.Flags().GetBool("apply")
+		if err != nil {
+			return fmt.Errorf("failed to get apply flag: %w", err)
+		}
+		batchSize, err := cmd.Flags().GetInt("batch-size")
+		if err != nil {
+			return fmt.Errorf("failed to get batch-size flag: %w", err)
+		}
+
+		ref, err := GetRef(cmd, nil)
+		if err != nil {
+			return fmt.Errorf("failed to get ref: %w", err)
+		}
+
+		cmd.SilenceUsage = true
+
+		w, err := work.NewWorker(ctx, ref)
+		if err != nil {
+			return fmt.Errorf("failed to create worker: %w", err)
+		}
+		w.Cleanup()
+
+		if w.Settings.Retention == nil {
+			return fmt.Errorf("no retention configured, set retention in repo.ocu.star")
+		}
+
+		plan, err := librelease.PlanGC(ctx, w.Tracker.State, *w.Settings.Retention, time.Now())
+		if err != nil {
+			return fmt.Errorf("failed to plan garbage collection: %w", err)
+		}
+
+		for _, releaseRef := range plan.Releases {
+			fmt.Printf("release %s\n", releaseRef)
+		}
+		for _, logRef := range plan.Logs {
+			fmt.Printf("logs %s\n", logRef)
+		}
+
+		if !apply {
+			fmt.Printf("Would remove %d releases and %d logs (%d refs), use --apply to remove them\n", len(plan.Releases), len(plan.Logs), len(plan.Refs))
+			return nil
+		}
+
+		if err := librelease.ApplyGC(ctx, w.Tracker.State, plan, batchSize); err != nil {
+			return fmt.Errorf("failed to collect garbage: %w", err)
+		}
+		fmt.Printf("Removed %d releases and %d logs (%d refs)\n", len(plan.Releases), len(plan.Logs), len(plan.Refs))
+		return nil
+	},
+}
+
 var StateDiffCmd = &cobra.Command{
 	Use:   "diff",
 	Short: "Diff intent with current state.",
@@ -177,6 +761,60 @@ var StateDeleteIntentCmd = &cobra.Command{
 	},
 }
 
+var StateSchemaCmd = &cobra.Command{
+	Use:   "schema [ref]",
+	Short: "Show the schema for a ref",
+	Long: `Show the JSON Schema that values written to a ref must match.
+
+Built-in schemas describe the documents Ocuroot writes for releases, tasks,
+deployments and environments. Schemas for custom state are declared with
+store.set(..., schemas={...}) in repo.ocu.star.
+`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+
+		ref, err := GetRef(cmd, args)
+		if err != nil {
+			return fmt.Errorf("failed to get ref: %w", err)
+		}
+
+		intent, err := cmd.Flags().GetBool("intent")
+		if err != nil {
+			return fmt.Errorf("failed to get intent flag: %w", err)
+		}
+
+		cmd.SilenceUsage = true
+
+		w, err := work.NewWorker(ctx, ref)
+		if err != nil {
+			return fmt.Errorf("failed to create worker: %w", err)
+		}
+		w.Cleanup()
+
+		stateSchemas, intentSchemas, err := release.SchemaSets(w.Tracker.StoreConfig)
+		if err != nil {
+			return fmt.Errorf("failed to load schemas: %w", err)
+		}
+		schemas := stateSchemas
+		if intent {
+			schemas = intentSchemas
+		}
+
+		schema := schemas.Lookup(w.Tracker.Ref.String())
+		if schema == nil {
+			return fmt.Errorf("no schema is defined for %s, any value may be written", w.Tracker.Ref.String())
+		}
+
+		out, err := json.MarshalIndent(schema, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal schema: %w", err)
+		}
+		fmt.Println(string(out))
+		return nil
+	},
+}
+
 var StateSetIntentCmd = &cobra.Command{
 	Use:   "set [intent-ref] [value]",
 	Short: "Set intent",
@@ -289,6 +927,133 @@ var StateApplyIntentCmd = &cobra.Command{
 	},
 }
 
+var StateQueryCmd = &cobra.Command{
+	Use:   "query [query]",
+	Short: "Find refs by their path and content.",
+	Long: `Find refs by their path and content.
+
+A query is a glob, optionally followed by a where clause filtering on fields
+of each ref's value and a select clause listing fields to output. Fields are
+paths within the value, in the same form as a ref fragment, and $ref is the
+ref itself.
+
+Comparisons are =, !=, <, <=, >, >=, ~ (matching a glob) and contains, and may
+be combined with and, or, not and parentheses. For example:
+
+  ocuroot state query '**/@/deploy/* where release ~ "*/@r1*" select release'
+  ocuroot state query '**/@*/task/build where output/image contains "sha256:abc"'
+`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+
+		query, err := refstore.ParseQuery(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid query: %w", err)
+		}
+
+		format, err := cmd.Flags().GetString("format")
+		if err != nil {
+			return fmt.Errorf("failed to get format flag: %w", err)
+		}
+		intent, err := cmd.Flags().GetBool("intent")
+		if err != nil {
+			return fmt.Errorf("failed to get intent flag: %w", err)
+		}
+
+		ref, err := GetRef(cmd, nil)
+		if err != nil {
+			return fmt.Errorf("failed to get ref: %w", err)
+		}
+
+		cmd.SilenceUsage = true
+
+		w, err := work.NewWorker(ctx, ref)
+		if err != nil {
+			return fmt.Errorf("failed to create worker: %w", err)
+		}
+		w.Cleanup()
+
+		store := w.Tracker.State
+		if intent {
+			store = w.Tracker.Intent
+		}
+
+		results, err := refstore.RunQuery(ctx, store, query)
+		if err != nil {
+			return fmt.Errorf("failed to run query: %w", err)
+		}
+		return writeQueryResults(os.Stdout, format, query, results)
+	},
+}
+
+// writeQueryResults prints the results of a query as a table, JSON or CSV.
+func writeQueryResults(out io.Writer, format string, query *refstore.Query, results []refstore.QueryResult) error {
+	switch format {
+	case "json":
+		rows := []map[string]any{}
+		for _, result := range results {
+			row := map[string]any{"ref": result.Ref}
+			if len(query.Select) == 0 {
+				row["value"] = result.Value
+			} else {
+				fields := make(map[string]any)
+				for i, field := range query.Select {
+					fields[field] = result.Fields[i]
+				}
+				row["fields"] = fields
+			}
+			rows = append(rows, row)
+		}
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(rows)
+	case "csv":
+		writer := csv.NewWriter(out)
+		if err := writer.Write(append([]string{"ref"}, query.Select...)); err != nil {
+			return err
+		}
+		for _, result := range results {
+			if err := writer.Write(queryRow(result)); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	case "table":
+		writer := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		header := []string{"REF"}
+		for _, field := range query.Select {
+			header = append(header, strings.ToUpper(field))
+		}
+		fmt.Fprintln(writer, strings.Join(header, "\t"))
+		for _, result := range results {
+			fmt.Fprintln(writer, strings.Join(queryRow(result), "\t"))
+		}
+		return writer.Flush()
+	default:
+		return fmt.Errorf("unknown format %q, expected table, json or csv", format)
+	}
+}
+
+// queryRow formats a query result as text, with strings as they are and
+// other values as JSON.
+func queryRow(result refstore.QueryResult) []string {
+	row := []string{result.Ref}
+	for _, field := range result.Fields {
+		switch field := field.(type) {
+		case nil:
+			row = append(row, "")
+		case string:
+			row = append(row, field)
+		default:
+			content, _ := json.Marshal(field)
+			row = append(row, string(content))
+		}
+	}
+	return row
+}
+
 var StateViewCmd = &cobra.Command{
 	Use:   "view",
 	Short: "View state in a web browser.",
@@ -322,9 +1087,32 @@ func init() {
 
 	StateCmd.AddCommand(StateDiffCmd)
 	StateCmd.AddCommand(StateGetCmd)
+	StateGetCmd.Flags().String("at", "", "Get state as it was at a revision, commit or time (e.g. '2025-01-02 15:04').")
+	StateGetCmd.Flags().Bool("meta", false, "Include metadata, such as who created and last changed the ref.")
+	StateCmd.AddCommand(StateLogCmd)
 	StateCmd.AddCommand(StateMatchCmd)
 	StateMatchCmd.Flags().BoolP("no-links", "l", false, "Do not match links.")
+	StateCmd.AddCommand(StateWatchCmd)
+
+	StateCmd.AddCommand(StateMigrateCmd)
+	StateMigrateCmd.Flags().Bool("dry-run", false, "Report what would be copied without writing to the destination.")
+	StateMigrateCmd.Flags().Bool("overwrite", false, "Allow copying into stores that already contain refs.")
+	StateCmd.AddCommand(StateExportCmd)
+	StateExportCmd.Flags().StringP("output", "o", "", "File to write the archive to, defaults to stdout.")
+	StateCmd.AddCommand(StateImportCmd)
+	StateImportCmd.Flags().String("mode", string(refstore.ImportModeMerge), "How to combine the archive with existing refs. One of 'merge' or 'replace'.")
+	StateImportCmd.Flags().StringToString("rename-repo", nil, "Rename a repo or alias in the archive, as old=new. May be repeated.")
+	StateCmd.AddCommand(StateFsckCmd)
+	StateFsckCmd.Flags().Bool("repair", false, "Repair issues that can be fixed safely.")
+	StateCmd.AddCommand(StateGCCmd)
+	StateGCCmd.Flags().Bool("apply", false, "Remove refs rather than listing them.")
+	StateGCCmd.Flags().Int("batch-size", librelease.DefaultGCBatchSize, "Number of refs removed in each transaction.")
 
+	StateCmd.AddCommand(StateQueryCmd)
+	StateQueryCmd.Flags().StringP("format", "f", "table", "Output format. One of 'table', 'json' or 'csv'.")
+	StateQueryCmd.Flags().Bool("intent", false, "Query the intent store rather than state.")
+	StateCmd.AddCommand(StateSchemaCmd)
+	StateSchemaCmd.Flags().Bool("intent", false, "Show the schema for the ref in the intent store.")
 	StateCmd.AddCommand(StateSetIntentCmd)
 	StateSetIntentCmd.Flags().StringP("format", "f", "string", "format of the input value. One of 'string', 'starlark' or 'json'.")
 
@@ -0,0 +1,163 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ocuroot/ocuroot/client"
+	"github.com/ocuroot/ocuroot/client/startest"
+	"github.com/spf13/cobra"
+)
+
+var TestCmd = &cobra.Command{
+	Use:   "test [paths...]",
+	Short: "Run unit tests for .ocu.star files",
+	Long: `Run unit tests for .ocu.star files.
+
+Tests are functions named test_* in files named *_test.ocu.star. Paths may be
+test files or directories to search, defaulting to the current directory.
+
+Tests run against a mock backend. Shell commands and HTTP requests fail unless
+mocked. The following globals are available alongside the SDK:
+
+  assert.eq(actual, expected), assert.ne(actual, unexpected)
+  assert.true(cond), assert.false(cond), assert.contains(container, item)
+  assert.fails(fn, match="")
+  mock.shell(command, stdout="", stderr="", exit_code=0)
+  mock.http(url, method="", status_code=200, body="", headers={})
+  mock.environment(name, attributes={})
+  mock.ref(ref, value)
+  mock.shell_calls(), mock.http_calls()
+  run("task/<name>" or "deploy/<environment>", inputs={}, package="", down=False)
+
+Commands and URLs in mocks may be globs. Relative refs and run() default to
+the package under test, the test file without the _test suffix.
+
+Example:
+
+  ocuroot test
+  ocuroot test tests/versioning --run prerelease --junit report.xml
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+
+		if len(args) == 0 {
+			args = []string{"."}
+		}
+		files, err := startest.FindTestFiles(args)
+		if err != nil {
+			return fmt.Errorf("failed to find tests: %w", err)
+		}
+
+		var filter *regexp.Regexp
+		if run, _ := cmd.Flags().GetString("run"); run != "" {
+			filter, err = regexp.Compile(run)
+			if err != nil {
+				return fmt.Errorf("invalid --run pattern: %w", err)
+			}
+		}
+		verbose, _ := cmd.Flags().GetBool("verbose")
+
+		out := cmd.OutOrStdout()
+		runners := map[string]*startest.Runner{}
+		var results []startest.Result
+		start := time.Now()
+		for _, file := range files {
+			root, err := testRoot(file)
+			if err != nil {
+				return err
+			}
+			runner, ok := runners[root]
+			if !ok {
+				runner, err = startest.NewRunner(cmd.Context(), root)
+				if err != nil {
+					return err
+				}
+				runner.Filter = filter
+				runners[root] = runner
+			}
+
+			for _, r := range runner.RunFile(cmd.Context(), file) {
+				results = append(results, r)
+				printTestResult(out, r, verbose)
+			}
+		}
+
+		var failed int
+		for _, r := range results {
+			if !r.Passed() {
+				failed++
+			}
+		}
+
+		if junit, _ := cmd.Flags().GetString("junit"); junit != "" {
+			f, err := os.Create(junit)
+			if err != nil {
+				return fmt.Errorf("failed to create JUnit report: %w", err)
+			}
+			defer f.Close()
+			if err := startest.WriteJUnit(f, results); err != nil {
+				return fmt.Errorf("failed to write JUnit report: %w", err)
+			}
+		}
+
+		elapsed := time.Since(start).Seconds()
+		if failed > 0 {
+			fmt.Fprintf(out, "FAIL\t%d of %d tests failed (%.3fs)\n", failed, len(results), elapsed)
+			return fmt.Errorf("%d tests failed", failed)
+		}
+		fmt.Fprintf(out, "ok\t%d tests passed (%.3fs)\n", len(results), elapsed)
+		return nil
+	},
+}
+
+// testRoot returns the repo root for a test file, or its directory if it is
+// not in a repo.
+func testRoot(file string) (string, error) {
+	dir := filepath.Dir(file)
+	root, err := client.FindSourceRepoRoot(dir)
+	if errors.Is(err, client.ErrRootNotFound) {
+		return filepath.Abs(dir)
+	}
+	return root, err
+}
+
+func printTestResult(out io.Writer, r startest.Result, verbose bool) {
+	status := "PASS"
+	if !r.Passed() {
+		status = "FAIL"
+	}
+	fmt.Fprintf(out, "--- %s: %s:%s (%.3fs)\n", status, r.File, r.Name, r.Duration.Seconds())
+	if r.Passed() && !verbose {
+		return
+	}
+	if r.Output != "" {
+		fmt.Fprint(out, indent(r.Output))
+	}
+	if !r.Passed() {
+		fmt.Fprint(out, indent(startest.FormatError(r.Err)+"\n"))
+	}
+}
+
+func indent(s string) string {
+	lines := strings.SplitAfter(s, "\n")
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = "    " + line
+		}
+	}
+	return strings.Join(lines, "")
+}
+
+func init() {
+	RootCmd.AddCommand(TestCmd)
+	TestCmd.Flags().String("run", "", "Only run tests with names matching this regular expression")
+	TestCmd.Flags().String("junit", "", "Write a JUnit XML report to this file")
+	TestCmd.Flags().BoolP("verbose", "v", false, "Show output from passing tests")
+}
@@ -31,6 +31,10 @@ var WorkContinueCmd = &cobra.Command{
 		}
 
 		dryRun := cmd.Flag("dryrun").Changed
+		parallelism, err := cmd.Flags().GetInt("parallelism")
+		if err != nil {
+			return fmt.Errorf("failed to get parallelism flag: %w", err)
+		}
 		cmd.SilenceUsage = true
 
 		worker, err := work.NewWorker(ctx, ref)
@@ -38,6 +42,7 @@ var WorkContinueCmd = &cobra.Command{
 			return fmt.Errorf("failed to create worker: %w", err)
 		}
 		defer worker.Cleanup()
+		worker.Parallelism = parallelism
 
 		todo, err := worker.ReadyRuns(ctx, work.IdentifyWorkRequest{
 			GitFilter: work.GitFilterCurrentCommitOnly,
@@ -335,6 +340,7 @@ func init() {
 	RootCmd.AddCommand(WorkCmd)
 
 	WorkContinueCmd.Flags().BoolP("dryrun", "d", false, "List refs for work that would be triggered")
+	WorkContinueCmd.Flags().Int("parallelism", 1, "Maximum number of tasks to run concurrently within each phase of a release")
 	WorkCmd.AddCommand(WorkContinueCmd)
 
 	WorkTriggerCommand.Flags().BoolP("dryrun", "d", false, "List refs for work that would be triggered")
@@ -12,6 +12,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
 
 	"github.com/charmbracelet/log"
 	"github.com/ocuroot/ocuroot/refs"
@@ -75,10 +76,7 @@ type PrintBackend struct {
 
 // Print implements sdk.PrintBackend.
 func (p *PrintBackend) Print(thread *starlark.Thread, msg string, next func(thread *starlark.Thread, msg string)) {
-	for _, secret := range p.Secrets.Values {
-		msg = strings.ReplaceAll(msg, secret, "<secret>")
-	}
-	next(thread, msg)
+	next(thread, p.Secrets.Redact(msg))
 }
 
 type RepoBackend struct {
@@ -158,14 +156,27 @@ func (h *HTTPBackend) Req(ctx context.Context, req sdk.HTTPRequest) (sdk.HTTPRes
 }
 
 type SecretsBackend struct {
+	mu     sync.RWMutex
 	Values []string
 }
 
 // Register implements sdk.SecretsBackend.
 func (s *SecretsBackend) Register(value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.Values = append(s.Values, value)
 }
 
+// Redact replaces any registered secret values in msg.
+func (s *SecretsBackend) Redact(msg string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, secret := range s.Values {
+		msg = strings.ReplaceAll(msg, secret, "<secret>")
+	}
+	return msg
+}
+
 var _ sdk.HostBackend = (*HostBackend)(nil)
 
 type HostBackend struct {
@@ -3,9 +3,9 @@ package local
 import (
 	"context"
 	"fmt"
-	"os"
 
 	"github.com/charmbracelet/log"
+	"github.com/ocuroot/ocuroot/client/modules"
 	"github.com/ocuroot/ocuroot/refs"
 	"github.com/ocuroot/ocuroot/sdk"
 	"go.starlark.net/starlark"
@@ -23,7 +23,7 @@ func ExecutePackageWithLogging(ctx context.Context, root string, ref refs.Ref, b
 	log.Info("Loading config", "root", root, "filename", configFile, "ref", ref)
 	config, err := sdk.LoadConfig(
 		ctx,
-		sdk.NewFSResolver(os.DirFS(root)),
+		modules.NewRepoResolver(root),
 		configFile,
 		backend,
 		logf,
@@ -0,0 +1,233 @@
+package lsp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/charmbracelet/log"
+	"github.com/ocuroot/ocuroot/client/modules"
+	"github.com/ocuroot/ocuroot/refs"
+	"github.com/ocuroot/ocuroot/sdk"
+	"go.starlark.net/resolve"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+const diagnosticSource = "ocuroot"
+
+// diagnose checks a document. Syntax and refs are checked on every change.
+// When full is set, as it is when a document is opened or saved, the document
+// is also evaluated against a mock backend and its package validated.
+func (s *Server) diagnose(ctx context.Context, d *document, full bool) []Diagnostic {
+	out := []Diagnostic{}
+
+	f, err := d.parse()
+	if err != nil {
+		return append(out, errorDiagnostics(d, err)...)
+	}
+	out = append(out, s.refDiagnostics(ctx, d, f)...)
+
+	// Evaluation would fail on the first invalid ref, which is already reported
+	if full && !hasErrors(out) {
+		out = append(out, s.evalDiagnostics(ctx, d)...)
+	}
+	return out
+}
+
+func hasErrors(diags []Diagnostic) bool {
+	for _, d := range diags {
+		if d.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// evalDiagnostics loads a document with sdk.LoadConfig, or sdk.LoadRepo for
+// repo.ocu.star, and validates the resulting package.
+func (s *Server) evalDiagnostics(ctx context.Context, d *document) []Diagnostic {
+	r := modules.NewResolver(d.Root, &resolver{server: s, root: d.Root})
+	backend := sdk.NewMockBackend()
+	discard := func(thread *starlark.Thread, msg string) {}
+
+	if filepath.Base(d.Path) == "repo.ocu.star" {
+		if _, _, err := sdk.LoadRepo(ctx, r, d.Path, backend, discard); err != nil {
+			return errorDiagnostics(d, err)
+		}
+		return nil
+	}
+
+	config, err := sdk.LoadConfig(ctx, r, d.Path, backend, discard)
+	if err != nil {
+		return errorDiagnostics(d, err)
+	}
+	if config.Package == nil {
+		return nil
+	}
+
+	var out []Diagnostic
+	for _, err := range config.Package.Validate() {
+		out = append(out, Diagnostic{
+			Range:    lineRange(d, Position{}),
+			Severity: SeverityError,
+			Source:   diagnosticSource,
+			Message:  err.Error(),
+		})
+	}
+	return out
+}
+
+// refDiagnostics checks string literals passed to ref() and input(). Refs
+// that don't parse are errors. If the server has a RefChecker, refs to other
+// packages that can't be found in state are warnings.
+func (s *Server) refDiagnostics(ctx context.Context, d *document, f *syntax.File) []Diagnostic {
+	var out []Diagnostic
+	syntax.Walk(f, func(n syntax.Node) bool {
+		call, ok := n.(*syntax.CallExpr)
+		if !ok {
+			return true
+		}
+		lit := refArgument(call)
+		if lit == nil {
+			return true
+		}
+
+		value := lit.Value.(string)
+		ref, err := refs.Parse(value)
+		if err != nil {
+			out = append(out, Diagnostic{
+				Range:    nodeRange(lit),
+				Severity: SeverityError,
+				Source:   diagnosticSource,
+				Message:  fmt.Sprintf("invalid ref %q: %v", value, err),
+			})
+			return true
+		}
+
+		if s.refChecker == nil || !isOtherPackage(ref) {
+			return true
+		}
+		exists, err := s.refChecker.RefExists(ctx, d.Path, ref)
+		if err != nil {
+			log.Debug("Failed to check ref", "ref", value, "err", err)
+			return true
+		}
+		if !exists {
+			out = append(out, Diagnostic{
+				Range:    nodeRange(lit),
+				Severity: SeverityWarning,
+				Source:   diagnosticSource,
+				Message:  fmt.Sprintf("ref %s was not found in state", value),
+			})
+		}
+		return true
+	})
+	return out
+}
+
+// refArgument returns the string literal given as the ref of a call to ref()
+// or input(), if any.
+func refArgument(call *syntax.CallExpr) *syntax.Literal {
+	fn, ok := call.Fn.(*syntax.Ident)
+	if !ok || (fn.Name != "ref" && fn.Name != "input") {
+		return nil
+	}
+	for i, arg := range call.Args {
+		if kw, ok := arg.(*syntax.BinaryExpr); ok && kw.Op == syntax.EQ {
+			if name, ok := kw.X.(*syntax.Ident); ok && name.Name == "ref" {
+				arg = kw.Y
+			} else {
+				continue
+			}
+		} else if i != 0 {
+			continue
+		}
+		if lit, ok := arg.(*syntax.Literal); ok && lit.Token == syntax.STRING {
+			return lit
+		}
+	}
+	return nil
+}
+
+// isOtherPackage returns true if a ref refers to something outside the
+// package using it. Refs within the package may not exist until it is
+// released.
+func isOtherPackage(ref refs.Ref) bool {
+	return ref.Global ||
+		(ref.Repo != "" && ref.Repo != ".") ||
+		(ref.Filename != "" && ref.Filename != ".")
+}
+
+// errorDiagnostics converts an error from parsing or evaluating a document
+// into diagnostics, positioned within the document where possible.
+func errorDiagnostics(d *document, err error) []Diagnostic {
+	var resolveErrs resolve.ErrorList
+	if errors.As(err, &resolveErrs) {
+		var out []Diagnostic
+		for _, e := range resolveErrs {
+			out = append(out, positionDiagnostic(d, e.Pos, e.Msg))
+		}
+		return out
+	}
+
+	var syntaxErr syntax.Error
+	if errors.As(err, &syntaxErr) {
+		return []Diagnostic{positionDiagnostic(d, syntaxErr.Pos, syntaxErr.Msg)}
+	}
+
+	var evalErr *starlark.EvalError
+	if errors.As(err, &evalErr) {
+		// Report the error at the innermost call within this document
+		for i := len(evalErr.CallStack) - 1; i >= 0; i-- {
+			pos := evalErr.CallStack[i].Pos
+			if inDocument(d, pos) {
+				return []Diagnostic{positionDiagnostic(d, pos, evalErr.Msg)}
+			}
+		}
+		return []Diagnostic{positionDiagnostic(d, evalErr.CallStack.At(0).Pos, evalErr.Msg)}
+	}
+
+	return []Diagnostic{{
+		Range:    lineRange(d, Position{}),
+		Severity: SeverityError,
+		Source:   diagnosticSource,
+		Message:  err.Error(),
+	}}
+}
+
+// positionDiagnostic reports an error at a position. Errors in other files
+// are reported at the start of the document, with their position.
+func positionDiagnostic(d *document, pos syntax.Position, msg string) Diagnostic {
+	start := Position{}
+	if inDocument(d, pos) {
+		start = toPosition(pos)
+	} else if pos.IsValid() {
+		msg = fmt.Sprintf("%s: %s", pos, msg)
+	}
+	return Diagnostic{
+		Range:    lineRange(d, start),
+		Severity: SeverityError,
+		Source:   diagnosticSource,
+		Message:  msg,
+	}
+}
+
+func inDocument(d *document, pos syntax.Position) bool {
+	if !pos.IsValid() {
+		return false
+	}
+	name := pos.Filename()
+	return name == d.Path || name == filepath.Base(d.Path)
+}
+
+// lineRange returns the range from a position to the end of its line.
+func lineRange(d *document, start Position) Range {
+	end := Position{Line: start.Line, Character: len([]rune(strings.TrimRight(d.line(start.Line), " \t")))}
+	if end.Character <= start.Character {
+		end.Character = start.Character + 1
+	}
+	return Range{Start: start, End: end}
+}
@@ -0,0 +1,243 @@
+package lsp
+
+import (
+	"net/url"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/ocuroot/ocuroot/client"
+	"github.com/ocuroot/ocuroot/sdk"
+	"go.starlark.net/syntax"
+)
+
+// document is a file open in the editor.
+type document struct {
+	URI  string
+	Text string
+
+	// Root is the root of the source repo containing the document, and Path
+	// the document's path relative to it.
+	Root string
+	Path string
+}
+
+func newDocument(uri string, text string) *document {
+	d := &document{URI: uri, Text: text}
+	abs := uriToPath(uri)
+	d.Root = filepath.Dir(abs)
+	if root, err := client.FindSourceRepoRoot(d.Root); err == nil {
+		d.Root = root
+	}
+	d.Path = filepath.Base(abs)
+	if rel, err := filepath.Rel(d.Root, abs); err == nil {
+		d.Path = rel
+	}
+	return d
+}
+
+// parse parses the document, returning a syntax error if it is invalid.
+func (d *document) parse() (*syntax.File, error) {
+	return syntax.LegacyFileOptions().Parse(d.Path, d.Text, 0)
+}
+
+// sdkVersion returns the SDK version requested by the document.
+func (d *document) sdkVersion() string {
+	version, err := sdk.IdentifySDKVersion(d.Path, []byte(d.Text))
+	if err != nil || version == "" {
+		versions := sdk.AvailableVersions()
+		if len(versions) == 0 {
+			return ""
+		}
+		return versions[len(versions)-1]
+	}
+	return sdk.ResolveVersion(version)
+}
+
+// line returns a line of the document by its 0-based index.
+func (d *document) line(line int) string {
+	lines := strings.Split(d.Text, "\n")
+	if line < 0 || line >= len(lines) {
+		return ""
+	}
+	return strings.TrimSuffix(lines[line], "\r")
+}
+
+// wordAt returns the dotted identifier around a position, such as
+// host.shell, along with its range.
+func (d *document) wordAt(pos Position) (string, Range) {
+	line := []rune(d.line(pos.Line))
+	if pos.Character > len(line) {
+		return "", Range{}
+	}
+	isWord := func(r rune) bool {
+		return r == '_' || r == '.' || unicode.IsLetter(r) || unicode.IsDigit(r)
+	}
+	start, end := pos.Character, pos.Character
+	for start > 0 && isWord(line[start-1]) {
+		start--
+	}
+	for end < len(line) && isWord(line[end]) {
+		end++
+	}
+	// Anything after the dot containing the cursor isn't part of the word
+	if i := strings.IndexRune(string(line[pos.Character:end]), '.'); i >= 0 {
+		end = pos.Character + utf8.RuneCountInString(string(line[pos.Character:end])[:i])
+	}
+	word := strings.Trim(string(line[start:end]), ".")
+	return word, Range{
+		Start: Position{Line: pos.Line, Character: start},
+		End:   Position{Line: pos.Line, Character: end},
+	}
+}
+
+// prefixAt returns the dotted identifier before a position, for completion.
+func (d *document) prefixAt(pos Position) string {
+	line := []rune(d.line(pos.Line))
+	if pos.Character > len(line) {
+		pos.Character = len(line)
+	}
+	start := pos.Character
+	for start > 0 && (line[start-1] == '_' || line[start-1] == '.' || unicode.IsLetter(line[start-1]) || unicode.IsDigit(line[start-1])) {
+		start--
+	}
+	return string(line[start:pos.Character])
+}
+
+// loadAt returns the module of a load statement whose string contains the
+// position, if any.
+func loadAt(f *syntax.File, pos Position) (string, bool) {
+	for _, stmt := range f.Stmts {
+		load, ok := stmt.(*syntax.LoadStmt)
+		if !ok {
+			continue
+		}
+		if contains(load.Module, pos) {
+			return load.ModuleName(), true
+		}
+	}
+	return "", false
+}
+
+// definitions returns the names bound at the top level of a file, by
+// function definitions, assignments and loads.
+func definitions(src string, f *syntax.File, uri string) map[string]*symbol {
+	out := map[string]*symbol{}
+	for _, stmt := range f.Stmts {
+		switch stmt := stmt.(type) {
+		case *syntax.DefStmt:
+			s := defSymbol(src, stmt)
+			s.Location = &Location{URI: uri, Range: identRange(stmt.Name)}
+			out[stmt.Name.Name] = s
+		case *syntax.AssignStmt:
+			for _, ident := range assignedIdents(stmt.LHS) {
+				if _, exists := out[ident.Name]; exists {
+					continue
+				}
+				out[ident.Name] = &symbol{
+					Name:     ident.Name,
+					Location: &Location{URI: uri, Range: identRange(ident)},
+				}
+			}
+		case *syntax.LoadStmt:
+			for i, to := range stmt.To {
+				out[to.Name] = &symbol{
+					Name:     to.Name,
+					Doc:      "Loaded from `" + stmt.ModuleName() + "` as " + stmt.From[i].Name,
+					Location: &Location{URI: uri, Range: identRange(to)},
+				}
+			}
+		}
+	}
+	return out
+}
+
+func assignedIdents(expr syntax.Expr) []*syntax.Ident {
+	switch expr := expr.(type) {
+	case *syntax.Ident:
+		return []*syntax.Ident{expr}
+	case *syntax.TupleExpr:
+		var out []*syntax.Ident
+		for _, x := range expr.List {
+			out = append(out, assignedIdents(x)...)
+		}
+		return out
+	case *syntax.ParenExpr:
+		return assignedIdents(expr.X)
+	case *syntax.ListExpr:
+		var out []*syntax.Ident
+		for _, x := range expr.List {
+			out = append(out, assignedIdents(x)...)
+		}
+		return out
+	}
+	return nil
+}
+
+// loadedName returns the load statement binding a name and the name it
+// has in the loaded module.
+func loadedName(f *syntax.File, name string) (*syntax.LoadStmt, string, bool) {
+	for _, stmt := range f.Stmts {
+		load, ok := stmt.(*syntax.LoadStmt)
+		if !ok {
+			continue
+		}
+		for i, to := range load.To {
+			if to.Name == name {
+				return load, load.From[i].Name, true
+			}
+		}
+	}
+	return nil, "", false
+}
+
+func identRange(ident *syntax.Ident) Range {
+	start, end := ident.Span()
+	return Range{Start: toPosition(start), End: toPosition(end)}
+}
+
+func nodeRange(node syntax.Node) Range {
+	start, end := node.Span()
+	return Range{Start: toPosition(start), End: toPosition(end)}
+}
+
+// toPosition converts a 1-based Starlark position into a 0-based LSP
+// position. Columns are counted in runes, which matches the UTF-16 offsets
+// LSP uses outside of the astral planes.
+func toPosition(pos syntax.Position) Position {
+	out := Position{Line: int(pos.Line) - 1, Character: int(pos.Col) - 1}
+	if out.Line < 0 {
+		out.Line = 0
+	}
+	if out.Character < 0 {
+		out.Character = 0
+	}
+	return out
+}
+
+func contains(node syntax.Node, pos Position) bool {
+	r := nodeRange(node)
+	if pos.Line < r.Start.Line || pos.Line > r.End.Line {
+		return false
+	}
+	if pos.Line == r.Start.Line && pos.Character < r.Start.Character {
+		return false
+	}
+	if pos.Line == r.End.Line && pos.Character > r.End.Character {
+		return false
+	}
+	return true
+}
+
+func uriToPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	return filepath.FromSlash(u.Path)
+}
+
+func pathToURI(path string) string {
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
@@ -0,0 +1,41 @@
+package lsp
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/ocuroot/ocuroot/sdk"
+)
+
+// resolver resolves modules relative to the repo root in the same way as
+// sdk.NewFSResolver, preferring the unsaved contents of open documents.
+type resolver struct {
+	server   *Server
+	root     string
+	basePath string
+}
+
+var _ sdk.ModuleResolver = (*resolver)(nil)
+
+func (r *resolver) Resolve(module string) (string, []byte, error) {
+	filename := module
+	if r.basePath != "" {
+		filename = filepath.Join(r.basePath, filename)
+	}
+	if doc, ok := r.server.docs[filepath.Join(r.root, filename)]; ok {
+		return filename, []byte(doc.Text), nil
+	}
+	data, err := os.ReadFile(filepath.Join(r.root, filename))
+	if err != nil {
+		return "", nil, err
+	}
+	return filename, data, nil
+}
+
+func (r *resolver) Child(module string) sdk.ModuleResolver {
+	return &resolver{
+		server:   r.server,
+		root:     r.root,
+		basePath: filepath.Dir(filepath.Join(r.basePath, module)),
+	}
+}
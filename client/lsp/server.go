@@ -0,0 +1,408 @@
+// Package lsp implements a Language Server Protocol server for .ocu.star
+// files, providing completion and hover docs from the SDK stubs, go to
+// definition across loaded files and diagnostics from evaluating packages.
+package lsp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/charmbracelet/log"
+	"github.com/ocuroot/ocuroot/about"
+	"github.com/ocuroot/ocuroot/refs"
+	"go.starlark.net/syntax"
+)
+
+// RefChecker looks up refs in state, so the server can warn about inputs
+// that don't exist.
+type RefChecker interface {
+	// RefExists reports whether a ref used by the package at pkg, a path
+	// relative to the repo root, exists.
+	RefExists(ctx context.Context, pkg string, ref refs.Ref) (bool, error)
+}
+
+// Server is a language server communicating over a pair of streams, usually
+// stdin and stdout. Requests are handled one at a time.
+type Server struct {
+	in         *bufio.Reader
+	out        io.Writer
+	outMu      sync.Mutex
+	refChecker RefChecker
+
+	// docs holds the open documents by absolute path
+	docs map[string]*document
+	// symbols caches the SDK builtins by version
+	symbols map[string]map[string]*symbol
+
+	shutdown bool
+}
+
+// NewServer creates a server. The refChecker may be nil to skip checking
+// refs against state.
+func NewServer(in io.Reader, out io.Writer, refChecker RefChecker) *Server {
+	return &Server{
+		in:         bufio.NewReader(in),
+		out:        out,
+		refChecker: refChecker,
+		docs:       map[string]*document{},
+		symbols:    map[string]map[string]*symbol{},
+	}
+}
+
+// Serve handles messages until the client sends exit or closes the input.
+func (s *Server) Serve(ctx context.Context) error {
+	for {
+		body, err := readMessage(s.in)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var msg message
+		if err := json.Unmarshal(body, &msg); err != nil {
+			log.Error("Failed to decode message", "err", err)
+			continue
+		}
+		if msg.Method == "exit" {
+			return nil
+		}
+
+		result, err := s.handle(ctx, msg)
+		if msg.ID == nil {
+			if err != nil {
+				log.Error("Failed to handle notification", "method", msg.Method, "err", err)
+			}
+			continue
+		}
+		if err := s.reply(msg.ID, result, err); err != nil {
+			return fmt.Errorf("failed to reply: %w", err)
+		}
+	}
+}
+
+func (s *Server) reply(id json.RawMessage, result any, err error) error {
+	resp := response{JSONRPC: "2.0", ID: id}
+	if err != nil {
+		var respErr *responseError
+		if !errors.As(err, &respErr) {
+			respErr = &responseError{Code: codeInvalidRequest, Message: err.Error()}
+		}
+		resp.Error = respErr
+	} else {
+		body, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+		resp.Result = body
+	}
+
+	s.outMu.Lock()
+	defer s.outMu.Unlock()
+	return writeMessage(s.out, resp)
+}
+
+func (s *Server) notify(method string, params any) error {
+	s.outMu.Lock()
+	defer s.outMu.Unlock()
+	return writeMessage(s.out, notification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+func (s *Server) handle(ctx context.Context, msg message) (any, error) {
+	if s.shutdown && msg.Method != "exit" {
+		return nil, &responseError{Code: codeInvalidRequest, Message: "server is shutting down"}
+	}
+
+	switch msg.Method {
+	case "initialize":
+		return map[string]any{
+			"capabilities": map[string]any{
+				"textDocumentSync": map[string]any{
+					"openClose": true,
+					"change":    1, // Full
+					"save":      map[string]any{"includeText": false},
+				},
+				"completionProvider": map[string]any{
+					"triggerCharacters": []string{"."},
+				},
+				"hoverProvider":      true,
+				"definitionProvider": true,
+			},
+			"serverInfo": map[string]any{
+				"name":    "ocuroot",
+				"version": about.Version,
+			},
+		}, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		var params DidOpenTextDocumentParams
+		if err := decodeParams(msg, &params); err != nil {
+			return nil, err
+		}
+		d := newDocument(params.TextDocument.URI, params.TextDocument.Text)
+		s.docs[uriToPath(d.URI)] = d
+		return nil, s.publish(ctx, d, true)
+	case "textDocument/didChange":
+		var params DidChangeTextDocumentParams
+		if err := decodeParams(msg, &params); err != nil {
+			return nil, err
+		}
+		d, err := s.document(params.TextDocument.URI)
+		if err != nil {
+			return nil, err
+		}
+		// Changes are always sent in full
+		if n := len(params.ContentChanges); n > 0 {
+			d.Text = params.ContentChanges[n-1].Text
+		}
+		return nil, s.publish(ctx, d, false)
+	case "textDocument/didSave":
+		var params DidSaveTextDocumentParams
+		if err := decodeParams(msg, &params); err != nil {
+			return nil, err
+		}
+		d, err := s.document(params.TextDocument.URI)
+		if err != nil {
+			return nil, err
+		}
+		return nil, s.publish(ctx, d, true)
+	case "textDocument/didClose":
+		var params DidCloseTextDocumentParams
+		if err := decodeParams(msg, &params); err != nil {
+			return nil, err
+		}
+		delete(s.docs, uriToPath(params.TextDocument.URI))
+		return nil, s.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{
+			URI:         params.TextDocument.URI,
+			Diagnostics: []Diagnostic{},
+		})
+	case "textDocument/completion":
+		var params TextDocumentPositionParams
+		if err := decodeParams(msg, &params); err != nil {
+			return nil, err
+		}
+		d, err := s.document(params.TextDocument.URI)
+		if err != nil {
+			return nil, err
+		}
+		return s.completion(d, params.Position), nil
+	case "textDocument/hover":
+		var params TextDocumentPositionParams
+		if err := decodeParams(msg, &params); err != nil {
+			return nil, err
+		}
+		d, err := s.document(params.TextDocument.URI)
+		if err != nil {
+			return nil, err
+		}
+		return s.hover(d, params.Position), nil
+	case "textDocument/definition":
+		var params TextDocumentPositionParams
+		if err := decodeParams(msg, &params); err != nil {
+			return nil, err
+		}
+		d, err := s.document(params.TextDocument.URI)
+		if err != nil {
+			return nil, err
+		}
+		return s.definition(d, params.Position), nil
+	}
+
+	if msg.ID == nil {
+		// Notifications we don't support, such as initialized, may be ignored
+		return nil, nil
+	}
+	return nil, &responseError{Code: codeMethodNotFound, Message: fmt.Sprintf("method %s not found", msg.Method)}
+}
+
+func decodeParams(msg message, v any) error {
+	if err := json.Unmarshal(msg.Params, v); err != nil {
+		return &responseError{Code: codeInvalidParams, Message: fmt.Sprintf("invalid params for %s: %v", msg.Method, err)}
+	}
+	return nil
+}
+
+func (s *Server) document(uri string) (*document, error) {
+	d, ok := s.docs[uriToPath(uri)]
+	if !ok {
+		return nil, &responseError{Code: codeInvalidParams, Message: fmt.Sprintf("document %s is not open", uri)}
+	}
+	return d, nil
+}
+
+func (s *Server) publish(ctx context.Context, d *document, full bool) error {
+	return s.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{
+		URI:         d.URI,
+		Diagnostics: s.diagnose(ctx, d, full),
+	})
+}
+
+// builtins returns the SDK symbols available to a document.
+func (s *Server) builtins(d *document) map[string]*symbol {
+	version := d.sdkVersion()
+	if symbols, ok := s.symbols[version]; ok {
+		return symbols
+	}
+	symbols, err := sdkSymbols(version)
+	if err != nil {
+		log.Error("Failed to load SDK symbols", "version", version, "err", err)
+	}
+	s.symbols[version] = symbols
+	return symbols
+}
+
+func (s *Server) completion(d *document, pos Position) CompletionList {
+	out := CompletionList{Items: []CompletionItem{}}
+	prefix := d.prefixAt(pos)
+	builtins := s.builtins(d)
+
+	candidates := map[string]*symbol{}
+	if i := strings.LastIndex(prefix, "."); i >= 0 {
+		parent, ok := builtins[prefix[:i]]
+		if !ok {
+			return out
+		}
+		candidates = parent.Members
+		prefix = prefix[i+1:]
+	} else {
+		for name, sym := range builtins {
+			candidates[name] = sym
+		}
+		if f, err := d.parse(); err == nil {
+			for name, sym := range definitions(d.Text, f, d.URI) {
+				candidates[name] = sym
+			}
+		}
+	}
+
+	for name, sym := range candidates {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		item := CompletionItem{
+			Label:  name,
+			Kind:   sym.kind(),
+			Detail: sym.Signature,
+		}
+		if sym.Doc != "" {
+			item.Documentation = &MarkupContent{Kind: "markdown", Value: sym.Doc}
+		}
+		out.Items = append(out.Items, item)
+	}
+	sort.Slice(out.Items, func(i, j int) bool {
+		return out.Items[i].Label < out.Items[j].Label
+	})
+	return out
+}
+
+func (s *Server) hover(d *document, pos Position) *Hover {
+	word, r := d.wordAt(pos)
+	if word == "" {
+		return nil
+	}
+	sym := s.lookup(d, word)
+	if sym == nil {
+		return nil
+	}
+	return &Hover{
+		Contents: MarkupContent{Kind: "markdown", Value: sym.markdown()},
+		Range:    &r,
+	}
+}
+
+// lookup finds the symbol for a name used in a document. Names defined in
+// the document or loaded from other files shadow the SDK builtins.
+func (s *Server) lookup(d *document, name string) *symbol {
+	if f, err := d.parse(); err == nil && !strings.Contains(name, ".") {
+		if sym, ok := s.loadedSymbol(d, f, name); ok {
+			return sym
+		}
+		if sym, ok := definitions(d.Text, f, d.URI)[name]; ok {
+			return sym
+		}
+	}
+
+	builtins := s.builtins(d)
+	parts := strings.Split(name, ".")
+	sym := builtins[parts[0]]
+	for _, part := range parts[1:] {
+		if sym == nil {
+			return nil
+		}
+		sym = sym.Members[part]
+	}
+	return sym
+}
+
+// loadedSymbol finds the definition of a name loaded from another file.
+func (s *Server) loadedSymbol(d *document, f *syntax.File, name string) (*symbol, bool) {
+	load, from, ok := loadedName(f, name)
+	if !ok {
+		return nil, false
+	}
+	module, err := s.resolveModule(d, load.ModuleName())
+	if err != nil {
+		return nil, false
+	}
+	mf, err := module.parse()
+	if err != nil {
+		return nil, false
+	}
+	sym, ok := definitions(module.Text, mf, module.URI)[from]
+	return sym, ok
+}
+
+// resolveModule reads a module loaded by a document.
+func (s *Server) resolveModule(d *document, module string) (*document, error) {
+	r := (&resolver{server: s, root: d.Root}).Child(d.Path)
+	filename, data, err := r.Resolve(module)
+	if err != nil {
+		return nil, err
+	}
+	abs := filepath.Join(d.Root, filename)
+	return &document{
+		URI:  pathToURI(abs),
+		Text: string(data),
+		Root: d.Root,
+		Path: filename,
+	}, nil
+}
+
+func (s *Server) definition(d *document, pos Position) *Location {
+	f, err := d.parse()
+	if err != nil {
+		return nil
+	}
+
+	if module, ok := loadAt(f, pos); ok {
+		m, err := s.resolveModule(d, module)
+		if err != nil {
+			return nil
+		}
+		return &Location{URI: m.URI}
+	}
+
+	word, _ := d.wordAt(pos)
+	name, _, _ := strings.Cut(word, ".")
+	if name == "" {
+		return nil
+	}
+	if sym, ok := s.loadedSymbol(d, f, name); ok {
+		return sym.Location
+	}
+	if sym, ok := definitions(d.Text, f, d.URI)[name]; ok {
+		return sym.Location
+	}
+	return nil
+}
@@ -0,0 +1,343 @@
+package lsp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ocuroot/ocuroot/refs"
+)
+
+type testClient struct {
+	t        *testing.T
+	in       *io.PipeWriter
+	messages chan []byte
+	nextID   int
+
+	// diagnostics holds the latest diagnostics published for each URI
+	diagnostics map[string][]Diagnostic
+}
+
+func newTestClient(t *testing.T, checker RefChecker) *testClient {
+	clientIn, serverOut := io.Pipe()
+	serverIn, clientOut := io.Pipe()
+
+	server := NewServer(serverIn, serverOut, checker)
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Serve(context.Background())
+		serverOut.Close()
+	}()
+
+	// Read continuously so the server is never blocked writing
+	messages := make(chan []byte, 100)
+	go func() {
+		defer close(messages)
+		out := bufio.NewReader(clientIn)
+		for {
+			body, err := readMessage(out)
+			if err != nil {
+				return
+			}
+			messages <- body
+		}
+	}()
+
+	c := &testClient{
+		t:           t,
+		in:          clientOut,
+		messages:    messages,
+		diagnostics: map[string][]Diagnostic{},
+	}
+	t.Cleanup(func() {
+		clientOut.Close()
+		if err := <-done; err != nil {
+			t.Errorf("server failed: %v", err)
+		}
+	})
+	return c
+}
+
+func (c *testClient) notify(method string, params any) {
+	c.t.Helper()
+	if err := writeMessage(c.in, notification{JSONRPC: "2.0", Method: method, Params: params}); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// call sends a request and returns its response, recording any
+// notifications received before it.
+func (c *testClient) call(method string, params any) response {
+	c.t.Helper()
+	c.nextID++
+	id, _ := json.Marshal(c.nextID)
+	if err := writeMessage(c.in, map[string]any{
+		"jsonrpc": "2.0",
+		"id":      json.RawMessage(id),
+		"method":  method,
+		"params":  params,
+	}); err != nil {
+		c.t.Fatal(err)
+	}
+
+	for body := range c.messages {
+		var msg struct {
+			response
+			Method string          `json:"method"`
+			Params json.RawMessage `json:"params"`
+		}
+		if err := json.Unmarshal(body, &msg); err != nil {
+			c.t.Fatal(err)
+		}
+		if msg.Method == "textDocument/publishDiagnostics" {
+			var params PublishDiagnosticsParams
+			if err := json.Unmarshal(msg.Params, &params); err != nil {
+				c.t.Fatal(err)
+			}
+			c.diagnostics[params.URI] = params.Diagnostics
+			continue
+		}
+		if string(msg.ID) != string(id) {
+			c.t.Fatalf("unexpected message: %s", body)
+		}
+		return msg.response
+	}
+	c.t.Fatalf("server closed before responding to %s", method)
+	return response{}
+}
+
+// request sends a request and decodes its result into v.
+func (c *testClient) request(method string, params any, v any) {
+	c.t.Helper()
+	resp := c.call(method, params)
+	if resp.Error != nil {
+		c.t.Fatalf("%s failed: %v", method, resp.Error)
+	}
+	if v != nil {
+		if err := json.Unmarshal(resp.Result, v); err != nil {
+			c.t.Fatal(err)
+		}
+	}
+}
+
+// sync waits for the server to handle all previous notifications, by
+// sending a request it doesn't support.
+func (c *testClient) sync() {
+	c.t.Helper()
+	if resp := c.call("sync", nil); resp.Error == nil || resp.Error.Code != codeMethodNotFound {
+		c.t.Fatalf("expected sync to be unsupported, got %+v", resp)
+	}
+}
+
+func (c *testClient) open(uri string, text string) {
+	c.t.Helper()
+	c.notify("textDocument/didOpen", DidOpenTextDocumentParams{
+		TextDocument: TextDocumentItem{URI: uri, Version: 1, Text: text},
+	})
+}
+
+func (c *testClient) change(uri string, text string) {
+	c.t.Helper()
+	c.notify("textDocument/didChange", map[string]any{
+		"textDocument":   map[string]any{"uri": uri, "version": 2},
+		"contentChanges": []map[string]any{{"text": text}},
+	})
+}
+
+func position(uri string, line int, character int) TextDocumentPositionParams {
+	return TextDocumentPositionParams{
+		TextDocument: TextDocumentIdentifier{URI: uri},
+		Position:     Position{Line: line, Character: character},
+	}
+}
+
+const testTasks = `ocuroot("0.3.0")
+
+def build(ctx):
+    """build compiles the package."""
+    return done()
+`
+
+const testRelease = `ocuroot("0.3.0")
+
+load("./tasks.ocu.star", "build")
+
+task(build, name="build")
+`
+
+func writeTestRepo(t *testing.T) string {
+	dir := t.TempDir()
+	files := map[string]string{
+		"repo.ocu.star":        "ocuroot(\"0.3.0\")\n\nrepo_alias(\"example\")\n",
+		"pkg/tasks.ocu.star":   testTasks,
+		"pkg/release.ocu.star": testRelease,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestServer(t *testing.T) {
+	dir := writeTestRepo(t)
+	uri := pathToURI(filepath.Join(dir, "pkg", "release.ocu.star"))
+	tasksURI := pathToURI(filepath.Join(dir, "pkg", "tasks.ocu.star"))
+
+	c := newTestClient(t, nil)
+
+	var initResult struct {
+		Capabilities map[string]any `json:"capabilities"`
+	}
+	c.request("initialize", map[string]any{"rootUri": pathToURI(dir)}, &initResult)
+	if initResult.Capabilities["hoverProvider"] != true {
+		t.Errorf("unexpected capabilities: %v", initResult.Capabilities)
+	}
+	c.notify("initialized", map[string]any{})
+
+	c.open(uri, testRelease)
+	c.sync()
+	if diags, ok := c.diagnostics[uri]; !ok || len(diags) != 0 {
+		t.Errorf("expected no diagnostics, got %v", diags)
+	}
+
+	t.Run("completion", func(t *testing.T) {
+		var list CompletionList
+		c.request("textDocument/completion", position(uri, 4, 2), &list)
+		labels := map[string]CompletionItem{}
+		for _, item := range list.Items {
+			labels[item.Label] = item
+		}
+		if item, ok := labels["task"]; !ok || !strings.HasPrefix(item.Detail, "task(fn, name") {
+			t.Errorf("unexpected detail for task: %q", item.Detail)
+		}
+
+		c.change(uri, testRelease+"host.sh")
+		c.request("textDocument/completion", position(uri, 5, 7), &list)
+		if len(list.Items) != 1 || list.Items[0].Label != "shell" || list.Items[0].Documentation == nil {
+			t.Errorf("expected shell to be completed, got %v", list.Items)
+		}
+		c.change(uri, testRelease)
+	})
+
+	t.Run("hover", func(t *testing.T) {
+		var hover Hover
+		c.request("textDocument/hover", position(uri, 4, 1), &hover)
+		if !strings.Contains(hover.Contents.Value, "task defines a standalone task") {
+			t.Errorf("unexpected hover for task: %q", hover.Contents.Value)
+		}
+
+		c.request("textDocument/hover", position(uri, 4, 6), &hover)
+		if !strings.Contains(hover.Contents.Value, "build compiles the package.") {
+			t.Errorf("unexpected hover for build: %q", hover.Contents.Value)
+		}
+	})
+
+	t.Run("definition", func(t *testing.T) {
+		var loc Location
+		c.request("textDocument/definition", position(uri, 4, 6), &loc)
+		want := Location{
+			URI:   tasksURI,
+			Range: Range{Start: Position{Line: 2, Character: 4}, End: Position{Line: 2, Character: 9}},
+		}
+		if loc != want {
+			t.Errorf("expected definition at %v, got %v", want, loc)
+		}
+
+		c.request("textDocument/definition", position(uri, 2, 10), &loc)
+		if loc.URI != tasksURI {
+			t.Errorf("expected load to go to %s, got %v", tasksURI, loc)
+		}
+	})
+
+	t.Run("diagnostics", func(t *testing.T) {
+		c.change(uri, testRelease+"def broken(:\n")
+		c.sync()
+		diags := c.diagnostics[uri]
+		if len(diags) != 1 || diags[0].Range.Start.Line != 5 {
+			t.Errorf("expected a syntax error on line 5, got %v", diags)
+		}
+
+		c.change(uri, testRelease+"task(missing, name=\"other\")\n")
+		c.notify("textDocument/didSave", DidSaveTextDocumentParams{TextDocument: TextDocumentIdentifier{URI: uri}})
+		c.sync()
+		diags = c.diagnostics[uri]
+		if len(diags) != 1 || diags[0].Range.Start.Line != 5 || !strings.Contains(diags[0].Message, "missing") {
+			t.Errorf("expected an undefined name on line 5, got %v", diags)
+		}
+
+		c.change(uri, testRelease+"task(build, name=\"build\")\n")
+		c.notify("textDocument/didSave", DidSaveTextDocumentParams{TextDocument: TextDocumentIdentifier{URI: uri}})
+		c.sync()
+		diags = c.diagnostics[uri]
+		if len(diags) != 1 || !strings.Contains(diags[0].Message, "Task 'build'") {
+			t.Errorf("expected a validation error, got %v", diags)
+		}
+
+		c.notify("textDocument/didClose", DidCloseTextDocumentParams{TextDocument: TextDocumentIdentifier{URI: uri}})
+		c.sync()
+		if diags := c.diagnostics[uri]; len(diags) != 0 {
+			t.Errorf("expected diagnostics to be cleared, got %v", diags)
+		}
+	})
+
+	t.Run("repo", func(t *testing.T) {
+		repoURI := pathToURI(filepath.Join(dir, "repo.ocu.star"))
+		c.open(repoURI, "ocuroot(\"0.3.0\")\n\nrepo_alias(\"example\")\n")
+		c.sync()
+		if diags := c.diagnostics[repoURI]; len(diags) != 0 {
+			t.Errorf("expected no diagnostics, got %v", diags)
+		}
+	})
+}
+
+type fakeRefChecker map[string]bool
+
+func (f fakeRefChecker) RefExists(ctx context.Context, pkg string, ref refs.Ref) (bool, error) {
+	ref, err := ref.RelativeTo(refs.Ref{Repo: "example", Filename: pkg})
+	if err != nil {
+		return false, err
+	}
+	return f[ref.SetFragment("").String()], nil
+}
+
+func TestServerRefs(t *testing.T) {
+	dir := writeTestRepo(t)
+	uri := pathToURI(filepath.Join(dir, "pkg", "release.ocu.star"))
+
+	c := newTestClient(t, fakeRefChecker{
+		"example/-/backend/package.ocu.star/@/deploy/production": true,
+	})
+	c.request("initialize", map[string]any{}, nil)
+
+	c.open(uri, testRelease+`
+inputs = {
+    "backend": input(ref="example/-/backend/package.ocu.star/@/deploy/production#output/url"),
+    "frontend": input("example/-/frontend/package.ocu.star/@/deploy/production#output/url"),
+    "image": ref("./@/task/build#output/image"),
+    "broken": ref("./@/task/build#output/@r1"),
+}
+`)
+	c.sync()
+
+	diags := c.diagnostics[uri]
+	if len(diags) != 2 {
+		t.Fatalf("expected 2 diagnostics, got %v", diags)
+	}
+	if diags[0].Severity != SeverityWarning || diags[0].Range.Start.Line != 8 || !strings.Contains(diags[0].Message, "not found") {
+		t.Errorf("expected a warning for the missing frontend ref, got %v", diags[0])
+	}
+	if diags[1].Severity != SeverityError || diags[1].Range.Start.Line != 10 || !strings.Contains(diags[1].Message, "invalid ref") {
+		t.Errorf("expected an error for the invalid ref, got %v", diags[1])
+	}
+}
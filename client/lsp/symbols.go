@@ -0,0 +1,253 @@
+package lsp
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ocuroot/ocuroot/sdk"
+	"go.starlark.net/syntax"
+)
+
+// symbol is a function or value that may be completed and documented.
+type symbol struct {
+	Name      string
+	Signature string
+	Doc       string
+	// Members holds the fields of a struct, such as host.shell
+	Members map[string]*symbol
+
+	// Location is set for symbols defined in a source file
+	Location *Location
+}
+
+func (s *symbol) kind() CompletionItemKind {
+	switch {
+	case len(s.Members) > 0:
+		return CompletionKindModule
+	case s.Signature != "":
+		return CompletionKindFunction
+	}
+	return CompletionKindVariable
+}
+
+// markdown renders the signature and docs of a symbol for hover and
+// completion.
+func (s *symbol) markdown() string {
+	var sb strings.Builder
+	sb.WriteString("```python\n")
+	if s.Signature != "" {
+		sb.WriteString("def " + s.Signature)
+	} else {
+		sb.WriteString(s.Name)
+	}
+	sb.WriteString("\n```")
+	if s.Doc != "" {
+		sb.WriteString("\n\n")
+		sb.WriteString(s.Doc)
+	}
+	return sb.String()
+}
+
+// hiddenBuiltins are used internally by the SDK and not meant to be called
+// from config files.
+var hiddenBuiltins = map[string]struct{}{
+	"after":   {},
+	"do_work": {},
+}
+
+// sdkSymbols returns the builtins for an SDK version, documented from its
+// stubs.
+func sdkSymbols(version string) (map[string]*symbol, error) {
+	stubs := sdk.GetVersionStubs(version)
+	if stubs == nil {
+		return nil, fmt.Errorf("SDK version %s not found", version)
+	}
+
+	defs := map[string]*symbol{}
+	var files []*syntax.File
+	sources := map[*syntax.File]string{}
+	for name, src := range stubs {
+		f, err := syntax.LegacyFileOptions().Parse(name, src, syntax.RetainComments)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse stub %s: %w", name, err)
+		}
+		files = append(files, f)
+		sources[f] = src
+		for _, stmt := range f.Stmts {
+			if def, ok := stmt.(*syntax.DefStmt); ok {
+				defs[def.Name.Name] = defSymbol(src, def)
+			}
+		}
+	}
+
+	out := map[string]*symbol{}
+	for _, f := range files {
+		for _, stmt := range f.Stmts {
+			switch stmt := stmt.(type) {
+			case *syntax.DefStmt:
+				out[stmt.Name.Name] = defs[stmt.Name.Name]
+			case *syntax.AssignStmt:
+				name, ok := stmt.LHS.(*syntax.Ident)
+				if !ok {
+					continue
+				}
+				out[name.Name] = assignSymbol(sources[f], name.Name, stmt.RHS, defs)
+			}
+		}
+	}
+
+	for name := range out {
+		if _, hidden := hiddenBuiltins[name]; hidden || strings.HasPrefix(name, "_") {
+			delete(out, name)
+		}
+	}
+
+	out["ocuroot"] = &symbol{
+		Name:      "ocuroot",
+		Signature: "ocuroot(version)",
+		Doc:       "ocuroot sets the version of the SDK used by this file. It must be called once, at the top of the file.",
+	}
+	if _, exists := out["struct"]; !exists {
+		out["struct"] = &symbol{
+			Name:      "struct",
+			Signature: "struct(**kwargs)",
+			Doc:       "struct creates an immutable value with the given fields.",
+		}
+	}
+	return out, nil
+}
+
+// assignSymbol documents a top-level assignment. Structs of functions, such
+// as host = struct(shell=shell, ...), take their members' docs from the
+// functions they refer to.
+func assignSymbol(src string, name string, value syntax.Expr, defs map[string]*symbol) *symbol {
+	out := &symbol{Name: name}
+	switch value := value.(type) {
+	case *syntax.Ident:
+		if def, ok := defs[value.Name]; ok {
+			return renamed(def, name)
+		}
+	case *syntax.CallExpr:
+		if fn, ok := value.Fn.(*syntax.Ident); !ok || fn.Name != "struct" {
+			break
+		}
+		out.Members = map[string]*symbol{}
+		for _, arg := range value.Args {
+			kw, ok := arg.(*syntax.BinaryExpr)
+			if !ok || kw.Op != syntax.EQ {
+				continue
+			}
+			field, ok := kw.X.(*syntax.Ident)
+			if !ok {
+				continue
+			}
+			member := &symbol{Name: field.Name}
+			if ident, ok := kw.Y.(*syntax.Ident); ok && defs[ident.Name] != nil {
+				member = renamed(defs[ident.Name], field.Name)
+			} else {
+				member.Doc = "```python\n" + exprSource(src, kw.Y) + "\n```"
+			}
+			out.Members[field.Name] = member
+		}
+	}
+	return out
+}
+
+// renamed returns a copy of a function symbol under another name.
+func renamed(def *symbol, name string) *symbol {
+	out := *def
+	out.Name = name
+	if i := strings.Index(out.Signature, "("); i >= 0 {
+		out.Signature = name + out.Signature[i:]
+	}
+	return &out
+}
+
+// defSymbol documents a function from its parameters and docstring.
+func defSymbol(src string, def *syntax.DefStmt) *symbol {
+	var params []string
+	for _, param := range def.Params {
+		params = append(params, exprSource(src, param))
+	}
+	return &symbol{
+		Name:      def.Name.Name,
+		Signature: fmt.Sprintf("%s(%s)", def.Name.Name, strings.Join(params, ", ")),
+		Doc:       docstring(def.Body),
+	}
+}
+
+// docstring returns the dedented docstring at the start of a function body.
+func docstring(body []syntax.Stmt) string {
+	if len(body) == 0 {
+		return ""
+	}
+	expr, ok := body[0].(*syntax.ExprStmt)
+	if !ok {
+		return ""
+	}
+	lit, ok := expr.X.(*syntax.Literal)
+	if !ok || lit.Token != syntax.STRING {
+		return ""
+	}
+	doc, ok := lit.Value.(string)
+	if !ok {
+		return ""
+	}
+	return dedent(doc)
+}
+
+// dedent removes the indentation shared by every non-blank line after the
+// first, as Python does for docstrings.
+func dedent(doc string) string {
+	lines := strings.Split(doc, "\n")
+	indent := -1
+	for _, line := range lines[1:] {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed == "" {
+			continue
+		}
+		if n := len(line) - len(trimmed); indent < 0 || n < indent {
+			indent = n
+		}
+	}
+	lines[0] = strings.TrimSpace(lines[0])
+	for i := 1; i < len(lines); i++ {
+		if len(lines[i]) >= indent && indent > 0 {
+			lines[i] = lines[i][indent:]
+		} else {
+			lines[i] = strings.TrimLeft(lines[i], " \t")
+		}
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// exprSource returns the source text of an expression.
+func exprSource(src string, expr syntax.Expr) string {
+	start, end := expr.Span()
+	lines := strings.SplitAfter(src, "\n")
+	from := offset(lines, int(start.Line), int(start.Col))
+	to := offset(lines, int(end.Line), int(end.Col))
+	if from < 0 || to < from || to > len(src) {
+		return ""
+	}
+	return strings.Join(strings.Fields(src[from:to]), " ")
+}
+
+// offset converts a 1-based line and rune column into a byte offset within
+// the source split into lines.
+func offset(lines []string, line int, col int) int {
+	if line < 1 || line > len(lines) {
+		return -1
+	}
+	out := 0
+	for _, l := range lines[:line-1] {
+		out += len(l)
+	}
+	for i := range lines[line-1] {
+		if col <= 1 {
+			return out + i
+		}
+		col--
+	}
+	return out + len(lines[line-1])
+}
@@ -0,0 +1,55 @@
+package modules
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// Lock is the contents of an ocuroot.lock file.
+type Lock struct {
+	// Modules holds the pinned repos, keyed by remote and version
+	Modules map[string]LockedModule `json:"modules"`
+}
+
+// LockedModule pins a version of a remote repo to a commit.
+type LockedModule struct {
+	Commit string `json:"commit"`
+	// Hashes holds the content hash of each file loaded from the repo
+	Hashes map[string]string `json:"hashes"`
+}
+
+// ReadLock reads a lock file, returning an empty lock if it doesn't exist.
+func ReadLock(path string) (*Lock, error) {
+	lock := &Lock{Modules: map[string]LockedModule{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return lock, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, lock); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if lock.Modules == nil {
+		lock.Modules = map[string]LockedModule{}
+	}
+	return lock, nil
+}
+
+// Write saves a lock file.
+func (l *Lock) Write(path string) error {
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+func hashContent(data []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+}
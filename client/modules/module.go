@@ -0,0 +1,126 @@
+// Package modules loads Starlark modules from remote git repos, pinning them
+// to commits in an ocuroot.lock file at the repo root.
+//
+// Remote modules are loaded with a URL of the form:
+//
+//	load("git+https://host/org/lib//helpers.ocu.star@v1.2.0", "deploy")
+//
+// where the path after // is the file within the remote repo, and the version
+// after @ is a tag, branch or commit.
+package modules
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"path"
+	"strings"
+)
+
+const (
+	// LockFile is the name of the file pinning remote modules, at the repo
+	// root
+	LockFile = "ocuroot.lock"
+
+	// VendorDir is the directory at the repo root that remote modules are
+	// vendored into
+	VendorDir = "ocuroot_vendor"
+
+	remotePrefix = "git+"
+)
+
+// IsRemote returns true if a module is loaded from a remote git repo.
+func IsRemote(module string) bool {
+	return strings.HasPrefix(module, remotePrefix)
+}
+
+// Module identifies a file in a remote git repo at a version.
+type Module struct {
+	Remote  string
+	Path    string
+	Version string
+}
+
+// ParseModule parses a remote module of the form git+<remote>//<path>@<version>.
+func ParseModule(module string) (Module, error) {
+	if !IsRemote(module) {
+		return Module{}, fmt.Errorf("%s is not a remote module", module)
+	}
+	rest := strings.TrimPrefix(module, remotePrefix)
+
+	// The path starts at the first // after the scheme
+	start := 0
+	if i := strings.Index(rest, "://"); i >= 0 {
+		start = i + len("://")
+	}
+	sep := strings.Index(rest[start:], "//")
+	if sep < 0 {
+		return Module{}, fmt.Errorf("%s must include a path within the repo, as git+<remote>//<path>@<version>", module)
+	}
+	m := Module{Remote: rest[:start+sep]}
+	rest = rest[start+sep+2:]
+
+	at := strings.LastIndex(rest, "@")
+	if at < 0 || at == len(rest)-1 {
+		return Module{}, fmt.Errorf("%s must specify a version, as git+<remote>//<path>@<version>", module)
+	}
+	m.Version = rest[at+1:]
+	if err := checkVersion(m.Version); err != nil {
+		return Module{}, fmt.Errorf("%s: %w", module, err)
+	}
+
+	var err error
+	m.Path, err = cleanPath(rest[:at])
+	if err != nil {
+		return Module{}, fmt.Errorf("%s: %w", module, err)
+	}
+	return m, nil
+}
+
+// checkVersion rejects versions that could escape the directory they are
+// stored under.
+func checkVersion(version string) error {
+	if strings.Contains(version, "..") || strings.ContainsAny(version, `/\`) {
+		return fmt.Errorf("invalid version %q", version)
+	}
+	return nil
+}
+
+func cleanPath(p string) (string, error) {
+	p = path.Clean(strings.TrimPrefix(p, "/"))
+	if p == "." || p == ".." || strings.HasPrefix(p, "../") {
+		return "", fmt.Errorf("invalid path %q", p)
+	}
+	return p, nil
+}
+
+func (m Module) String() string {
+	return fmt.Sprintf("%s%s//%s@%s", remotePrefix, m.Remote, m.Path, m.Version)
+}
+
+// Key identifies the repo and version of a module in the lock file.
+func (m Module) Key() string {
+	return fmt.Sprintf("%s%s@%s", remotePrefix, m.Remote, m.Version)
+}
+
+// Relative returns the module loaded by a relative path from this one, in
+// the same repo and at the same version.
+func (m Module) Relative(p string) (Module, error) {
+	joined, err := cleanPath(path.Join(path.Dir(m.Path), p))
+	if err != nil {
+		return Module{}, fmt.Errorf("%s from %s: %w", p, m, err)
+	}
+	out := m
+	out.Path = joined
+	return out, nil
+}
+
+// vendorPath returns the path of a module within the vendor directory.
+func (m Module) vendorPath() string {
+	return path.Join(remoteDir(m.Remote)+"@"+m.Version, m.Path)
+}
+
+// remoteDir returns a directory name for a remote, which is safe to use
+// whatever the remote URL contains.
+func remoteDir(remote string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(remote)))[:16]
+}
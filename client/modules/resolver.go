@@ -0,0 +1,231 @@
+package modules
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/charmbracelet/log"
+	"github.com/ocuroot/gittools"
+	"github.com/ocuroot/ocuroot/client"
+	"github.com/ocuroot/ocuroot/sdk"
+)
+
+// lockMu guards reading and updating lock files, and the module cache
+var lockMu sync.Mutex
+
+// Resolver resolves remote modules, and passes all other modules to a local
+// resolver. Relative loads from within a remote module are resolved in the
+// same repo, at the same version.
+type Resolver struct {
+	local  sdk.ModuleResolver
+	shared *resolverState
+
+	// remote is the module being loaded from, if it is remote
+	remote *Module
+}
+
+var _ sdk.ModuleResolver = (*Resolver)(nil)
+
+type resolverState struct {
+	root     string
+	cacheDir string
+
+	// ignoreVendor causes modules to be fetched even if they are vendored
+	ignoreVendor bool
+	// loaded records the remote modules that have been resolved
+	loaded map[string]loadedModule
+}
+
+type loadedModule struct {
+	module Module
+	data   []byte
+}
+
+// NewResolver creates a resolver for the repo at root. Remote modules are
+// pinned in the lock file at the root, and loaded from the vendor directory
+// if present. Other modules are resolved by local.
+func NewResolver(root string, local sdk.ModuleResolver) *Resolver {
+	return &Resolver{
+		local: local,
+		shared: &resolverState{
+			root:     root,
+			cacheDir: filepath.Join(client.HomeDir(), "modules"),
+			loaded:   map[string]loadedModule{},
+		},
+	}
+}
+
+// NewRepoResolver creates a resolver for the repo at root, resolving local
+// modules from the filesystem.
+func NewRepoResolver(root string) *Resolver {
+	return NewResolver(root, sdk.NewFSResolver(os.DirFS(root)))
+}
+
+func (r *Resolver) Resolve(module string) (string, []byte, error) {
+	m, ok, err := r.remoteModule(module)
+	if err != nil {
+		return "", nil, err
+	}
+	if !ok {
+		return r.local.Resolve(module)
+	}
+	data, err := r.resolveRemote(m)
+	if err != nil {
+		return "", nil, err
+	}
+	return m.String(), data, nil
+}
+
+func (r *Resolver) Child(module string) sdk.ModuleResolver {
+	m, ok, err := r.remoteModule(module)
+	if err != nil || !ok {
+		var local sdk.ModuleResolver
+		if r.local != nil {
+			local = r.local.Child(module)
+		}
+		return &Resolver{local: local, shared: r.shared, remote: r.remote}
+	}
+	return &Resolver{local: r.local, shared: r.shared, remote: &m}
+}
+
+// remoteModule returns the remote module for a load, if it is remote or
+// relative to a remote module.
+func (r *Resolver) remoteModule(module string) (Module, bool, error) {
+	if IsRemote(module) {
+		m, err := ParseModule(module)
+		return m, true, err
+	}
+	if r.remote != nil {
+		m, err := r.remote.Relative(module)
+		return m, true, err
+	}
+	return Module{}, false, nil
+}
+
+func (r *Resolver) lockPath() string {
+	return filepath.Join(r.shared.root, LockFile)
+}
+
+// resolveRemote loads a remote module from the vendor directory, or the
+// remote repo at the commit in the lock file. Modules not yet in the lock
+// file are added to it.
+func (r *Resolver) resolveRemote(m Module) ([]byte, error) {
+	lockMu.Lock()
+	defer lockMu.Unlock()
+
+	lock, err := ReadLock(r.lockPath())
+	if err != nil {
+		return nil, err
+	}
+	locked, isLocked := lock.Modules[m.Key()]
+
+	if !r.shared.ignoreVendor {
+		data, err := os.ReadFile(filepath.Join(r.shared.root, VendorDir, m.vendorPath()))
+		if err == nil {
+			if hash, ok := locked.Hashes[m.Path]; !ok || hash != hashContent(data) {
+				return nil, fmt.Errorf("vendored module %s does not match %s, run ocuroot mod vendor to update it", m, LockFile)
+			}
+			r.shared.loaded[m.String()] = loadedModule{module: m, data: data}
+			return data, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	data, commit, err := r.fetch(m, locked.Commit)
+	if err != nil {
+		return nil, err
+	}
+	hash := hashContent(data)
+	if expected, ok := locked.Hashes[m.Path]; ok && expected != hash {
+		return nil, fmt.Errorf("module %s has hash %s, but %s expects %s", m, hash, LockFile, expected)
+	}
+
+	if !isLocked || locked.Hashes[m.Path] == "" {
+		log.Info("Locking module", "module", m.String(), "commit", commit)
+		if locked.Hashes == nil {
+			locked.Hashes = map[string]string{}
+		}
+		locked.Commit = commit
+		locked.Hashes[m.Path] = hash
+		lock.Modules[m.Key()] = locked
+		if err := lock.Write(r.lockPath()); err != nil {
+			return nil, fmt.Errorf("failed to update %s: %w", LockFile, err)
+		}
+	}
+
+	r.shared.loaded[m.String()] = loadedModule{module: m, data: data}
+	return data, nil
+}
+
+// fetch reads a module from a cached clone of its repo, at the given commit
+// or, if empty, its version. The repo is fetched if the commit or version
+// isn't available.
+func (r *Resolver) fetch(m Module, commit string) ([]byte, string, error) {
+	repo, err := r.cachedRepo(m.Remote)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if commit == "" {
+		if err := fetchRepo(repo, m.Remote); err != nil {
+			return nil, "", err
+		}
+		commit, err = repo.RevParse("--verify", "--quiet", m.Version+"^{commit}")
+		if err != nil {
+			return nil, "", fmt.Errorf("version %s of %s not found", m.Version, m.Remote)
+		}
+	} else if !hasCommit(repo, commit) {
+		if err := fetchRepo(repo, m.Remote); err != nil {
+			return nil, "", err
+		}
+		if !hasCommit(repo, commit) {
+			return nil, "", fmt.Errorf("commit %s of %s, pinned in %s, not found", commit, m.Remote, LockFile)
+		}
+	}
+
+	data, stderr, err := repo.Client.Exec("show", commit+":"+m.Path)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read %s: %s", m, strings.TrimSpace(string(stderr)))
+	}
+	return data, commit, nil
+}
+
+// cachedRepo returns a bare repo caching the contents of a remote, creating
+// it if needed.
+func (r *Resolver) cachedRepo(remote string) (*gittools.Repo, error) {
+	dir := filepath.Join(r.shared.cacheDir, remoteDir(remote))
+	if _, err := os.Stat(dir); errors.Is(err, fs.ErrNotExist) {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+		if _, err := gittools.NewClient().InitBare(dir, "main"); err != nil {
+			os.RemoveAll(dir)
+			return nil, fmt.Errorf("failed to create module cache: %w", err)
+		}
+	}
+	return &gittools.Repo{
+		Client:   &gittools.Client{WorkDir: dir},
+		RepoPath: dir,
+	}, nil
+}
+
+func fetchRepo(repo *gittools.Repo, remote string) error {
+	log.Info("Fetching module repo", "remote", remote)
+	_, stderr, err := repo.Client.Exec("fetch", "--force", "--tags", remote, "+refs/heads/*:refs/heads/*")
+	if err != nil {
+		return fmt.Errorf("failed to fetch %s: %s", remote, strings.TrimSpace(string(stderr)))
+	}
+	return nil
+}
+
+func hasCommit(repo *gittools.Repo, commit string) bool {
+	_, _, err := repo.Client.Exec("cat-file", "-e", commit+"^{commit}")
+	return err == nil
+}
@@ -0,0 +1,341 @@
+package modules
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ocuroot/gittools"
+)
+
+// createRemote creates a git repo with the given files, tagged as version,
+// and returns a file:// URL for it.
+func createRemote(t *testing.T, files map[string]string, version string) (string, *gittools.Repo) {
+	t.Helper()
+
+	dir := t.TempDir()
+	c := gittools.NewClient()
+	c.SetUser("test", "test@example.com")
+	repo, err := c.Init(dir, "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	commitFiles(t, repo, files, version)
+	return "file://" + dir, repo
+}
+
+func commitFiles(t *testing.T, repo *gittools.Repo, files map[string]string, version string) {
+	t.Helper()
+
+	for name, content := range files {
+		path := filepath.Join(repo.RepoPath, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, args := range [][]string{
+		{"add", "-A"},
+		{"commit", "-m", version},
+		{"tag", "-f", version},
+	} {
+		if _, stderr, err := repo.Client.Exec(args...); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, stderr)
+		}
+	}
+}
+
+func TestParseModule(t *testing.T) {
+	var tests = []struct {
+		name     string
+		module   string
+		expected Module
+		err      bool
+	}{
+		{
+			name:     "https",
+			module:   "git+https://github.com/org/lib//helpers.ocu.star@v1.2.0",
+			expected: Module{Remote: "https://github.com/org/lib", Path: "helpers.ocu.star", Version: "v1.2.0"},
+		},
+		{
+			name:     "nested path",
+			module:   "git+https://github.com/org/lib//deploy/k8s.ocu.star@main",
+			expected: Module{Remote: "https://github.com/org/lib", Path: "deploy/k8s.ocu.star", Version: "main"},
+		},
+		{
+			name:     "file",
+			module:   "git+file:///tmp/lib//helpers.ocu.star@v1",
+			expected: Module{Remote: "file:///tmp/lib", Path: "helpers.ocu.star", Version: "v1"},
+		},
+		{
+			name:     "ssh user",
+			module:   "git+ssh://git@github.com/org/lib//helpers.ocu.star@v1",
+			expected: Module{Remote: "ssh://git@github.com/org/lib", Path: "helpers.ocu.star", Version: "v1"},
+		},
+		{
+			name:   "no path",
+			module: "git+https://github.com/org/lib@v1",
+			err:    true,
+		},
+		{
+			name:   "no version",
+			module: "git+https://github.com/org/lib//helpers.ocu.star",
+			err:    true,
+		},
+		{
+			name:   "escapes repo",
+			module: "git+https://github.com/org/lib//../helpers.ocu.star@v1",
+			err:    true,
+		},
+		{
+			name:   "version with parent segments",
+			module: "git+https://github.com/org/lib//helpers.ocu.star@v1/../../..",
+			err:    true,
+		},
+		{
+			name:   "version with separator",
+			module: `git+https://github.com/org/lib//helpers.ocu.star@release\v1`,
+			err:    true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ParseModule(test.module)
+			if test.err {
+				if err == nil {
+					t.Fatalf("expected an error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.expected {
+				t.Errorf("expected %+v, got %+v", test.expected, got)
+			}
+			if got.String() != test.module {
+				t.Errorf("expected %q to round trip, got %q", test.module, got.String())
+			}
+		})
+	}
+}
+
+func TestVendorPath(t *testing.T) {
+	for _, module := range []string{
+		"git+https://github.com/org/lib//helpers.ocu.star@v1.2.0",
+		"git+file://../../x//a.star@v1",
+		"git+file:///..//a.star@v1",
+		"git+ssh://git@github.com:22/org/lib//deploy/k8s.ocu.star@main",
+	} {
+		t.Run(module, func(t *testing.T) {
+			m, err := ParseModule(module)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := m.vendorPath()
+			if !filepath.IsLocal(filepath.FromSlash(p)) {
+				t.Errorf("expected vendor path to stay within %s, got %s", VendorDir, p)
+			}
+			if !strings.HasSuffix(p, "@"+m.Version+"/"+m.Path) {
+				t.Errorf("expected vendor path to end with the version and path, got %s", p)
+			}
+		})
+	}
+
+	// Remotes that only differ in characters that were once replaced are
+	// vendored separately
+	a := Module{Remote: "https://host:8080/lib", Path: "a.star", Version: "v1"}
+	b := Module{Remote: "https://host_8080/lib", Path: "a.star", Version: "v1"}
+	if a.vendorPath() == b.vendorPath() {
+		t.Errorf("expected distinct vendor paths, got %s", a.vendorPath())
+	}
+}
+
+func TestResolveRemote(t *testing.T) {
+	t.Setenv("OCUROOT_HOME", t.TempDir())
+
+	remote, repo := createRemote(t, map[string]string{
+		"lib/helpers.ocu.star": `load("./common.ocu.star", "name")`,
+		"lib/common.ocu.star":  `name = "remote"`,
+	}, "v1.0.0")
+	commit, err := repo.RevParse("HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "common.ocu.star"), []byte(`name = "local"`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r := NewRepoResolver(root)
+
+	module := "git+" + remote + "//lib/helpers.ocu.star@v1.0.0"
+	filename, data, err := r.Resolve(module)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filename != module {
+		t.Errorf("expected filename %q, got %q", module, filename)
+	}
+	if string(data) != `load("./common.ocu.star", "name")` {
+		t.Errorf("unexpected content: %s", data)
+	}
+
+	// Relative loads resolve within the remote repo
+	_, data, err = r.Child(module).Resolve("./common.ocu.star")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `name = "remote"` {
+		t.Errorf("expected remote common.ocu.star, got %s", data)
+	}
+	// Local loads are unaffected
+	_, data, err = r.Child("other.ocu.star").Resolve("common.ocu.star")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `name = "local"` {
+		t.Errorf("expected local common.ocu.star, got %s", data)
+	}
+
+	lock, err := ReadLock(filepath.Join(root, LockFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	locked, ok := lock.Modules["git+"+remote+"@v1.0.0"]
+	if !ok {
+		t.Fatalf("module not locked: %+v", lock)
+	}
+	if locked.Commit != commit {
+		t.Errorf("expected commit %s, got %s", commit, locked.Commit)
+	}
+	if len(locked.Hashes) != 2 {
+		t.Errorf("expected 2 hashes, got %v", locked.Hashes)
+	}
+
+	// Moving the tag doesn't change the locked content
+	commitFiles(t, repo, map[string]string{
+		"lib/common.ocu.star": `name = "moved"`,
+	}, "v1.0.0")
+	_, data, err = NewRepoResolver(root).Child(module).Resolve("./common.ocu.star")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `name = "remote"` {
+		t.Errorf("expected locked content, got %s", data)
+	}
+}
+
+func TestResolveRemoteHashMismatch(t *testing.T) {
+	t.Setenv("OCUROOT_HOME", t.TempDir())
+
+	remote, repo := createRemote(t, map[string]string{
+		"helpers.ocu.star": `name = "remote"`,
+	}, "v1.0.0")
+	commit, err := repo.RevParse("HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := t.TempDir()
+	lock := &Lock{Modules: map[string]LockedModule{
+		"git+" + remote + "@v1.0.0": {
+			Commit: commit,
+			Hashes: map[string]string{"helpers.ocu.star": hashContent([]byte("tampered"))},
+		},
+	}}
+	if err := lock.Write(filepath.Join(root, LockFile)); err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = NewRepoResolver(root).Resolve("git+" + remote + "//helpers.ocu.star@v1.0.0")
+	if err == nil || !strings.Contains(err.Error(), "expects") {
+		t.Fatalf("expected a hash mismatch error, got %v", err)
+	}
+}
+
+func TestVendor(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("OCUROOT_HOME", home)
+
+	remote, _ := createRemote(t, map[string]string{
+		"helpers.ocu.star": `load("./common.ocu.star", "name")`,
+		"common.ocu.star":  `name = "remote"`,
+		"unused.ocu.star":  `name = "unused"`,
+	}, "v1.0.0")
+
+	root := t.TempDir()
+	module := "git+" + remote + "//helpers.ocu.star@v1.0.0"
+	files := map[string]string{
+		"repo.ocu.star":         `ocuroot("0.3.0")`,
+		"app/release.ocu.star":  `load("` + module + `", "name")`,
+		".hidden/skip.ocu.star": `load("missing.ocu.star", "name")`,
+	}
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Lock a module that is no longer used
+	if _, _, err := NewRepoResolver(root).Resolve("git+" + remote + "//unused.ocu.star@v1.0.0"); err != nil {
+		t.Fatal(err)
+	}
+
+	vendored, err := Vendor(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vendored) != 2 {
+		t.Fatalf("expected 2 vendored modules, got %v", vendored)
+	}
+
+	lock, err := ReadLock(filepath.Join(root, LockFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashes := lock.Modules["git+"+remote+"@v1.0.0"].Hashes
+	if _, ok := hashes["unused.ocu.star"]; ok || len(hashes) != 2 {
+		t.Errorf("expected unused module to be pruned, got %v", hashes)
+	}
+	for _, m := range vendored {
+		if _, err := os.Stat(filepath.Join(root, VendorDir, m.vendorPath())); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// Vendored modules load without the remote or cache
+	if err := os.RemoveAll(strings.TrimPrefix(remote, "file://")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(filepath.Join(home, "modules")); err != nil {
+		t.Fatal(err)
+	}
+	r := NewRepoResolver(root)
+	if _, _, err := r.Resolve(module); err != nil {
+		t.Fatal(err)
+	}
+	_, data, err := r.Child(module).Resolve("./common.ocu.star")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `name = "remote"` {
+		t.Errorf("unexpected vendored content: %s", data)
+	}
+
+	// Tampered vendored modules are rejected
+	path := filepath.Join(root, VendorDir, vendored[0].vendorPath())
+	if err := os.WriteFile(path, []byte("tampered"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := NewRepoResolver(root).Resolve(vendored[0].String()); err == nil {
+		t.Error("expected tampered vendored module to fail")
+	}
+}
@@ -0,0 +1,168 @@
+package modules
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ocuroot/ocuroot/sdk"
+	"go.starlark.net/syntax"
+)
+
+// Vendor fetches the remote modules loaded by the .ocu.star files in a repo,
+// directly or through other modules, into the vendor directory. The lock file
+// is updated to contain only these modules.
+func Vendor(root string) ([]Module, error) {
+	r := NewRepoResolver(root)
+	r.shared.ignoreVendor = true
+
+	files, err := configFiles(root)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]struct{}{}
+	for _, file := range files {
+		filename, data, err := r.Resolve(file)
+		if err != nil {
+			return nil, err
+		}
+		if err := walkLoads(r.Child(file), filename, data, seen); err != nil {
+			return nil, err
+		}
+	}
+
+	var out []Module
+	for _, loaded := range r.shared.loaded {
+		out = append(out, loaded.module)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].String() < out[j].String()
+	})
+
+	if err := writeVendorDir(root, r.shared.loaded); err != nil {
+		return nil, fmt.Errorf("failed to write %s: %w", VendorDir, err)
+	}
+	if err := pruneLock(filepath.Join(root, LockFile), out); err != nil {
+		return nil, fmt.Errorf("failed to update %s: %w", LockFile, err)
+	}
+	return out, nil
+}
+
+// walkLoads resolves the modules loaded by a file, and those they load, in
+// the same way as the SDK module loader.
+func walkLoads(r sdk.ModuleResolver, filename string, data []byte, seen map[string]struct{}) error {
+	f, err := syntax.LegacyFileOptions().Parse(filename, data, 0)
+	if err != nil {
+		return err
+	}
+	for _, stmt := range f.Stmts {
+		load, ok := stmt.(*syntax.LoadStmt)
+		if !ok {
+			continue
+		}
+		module := load.ModuleName()
+		loadedName, loadedData, err := r.Resolve(module)
+		if err != nil {
+			return fmt.Errorf("%s: failed to load %s: %w", filename, module, err)
+		}
+		if _, ok := seen[loadedName]; ok {
+			continue
+		}
+		seen[loadedName] = struct{}{}
+		if err := walkLoads(r.Child(module), loadedName, loadedData, seen); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// configFiles returns all .ocu.star files in a repo, relative to its root,
+// skipping hidden directories and the vendor directory.
+func configFiles(root string) ([]string, error) {
+	var out []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != root && (strings.HasPrefix(d.Name(), ".") || d.Name() == VendorDir) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasSuffix(d.Name(), ".ocu.star") {
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+			out = append(out, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	return out, err
+}
+
+// writeVendorDir replaces the vendor directory with the loaded modules.
+func writeVendorDir(root string, loaded map[string]loadedModule) error {
+	dir := filepath.Join(root, VendorDir)
+	if len(loaded) == 0 {
+		return os.RemoveAll(dir)
+	}
+
+	tmp, err := os.MkdirTemp(root, "."+VendorDir+"-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	for _, l := range loaded {
+		path := filepath.Join(tmp, filepath.FromSlash(l.module.vendorPath()))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, l.data, 0644); err != nil {
+			return err
+		}
+	}
+	if err := os.Chmod(tmp, 0755); err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	return os.Rename(tmp, dir)
+}
+
+// pruneLock removes repos and files that aren't used from a lock file.
+func pruneLock(path string, used []Module) error {
+	lockMu.Lock()
+	defer lockMu.Unlock()
+
+	lock, err := ReadLock(path)
+	if err != nil {
+		return err
+	}
+	pruned := &Lock{Modules: map[string]LockedModule{}}
+	for _, m := range used {
+		locked, ok := lock.Modules[m.Key()]
+		if !ok {
+			return fmt.Errorf("%s is missing from the lock", m)
+		}
+		p, ok := pruned.Modules[m.Key()]
+		if !ok {
+			p = LockedModule{Commit: locked.Commit, Hashes: map[string]string{}}
+		}
+		p.Hashes[m.Path] = locked.Hashes[m.Path]
+		pruned.Modules[m.Key()] = p
+	}
+
+	if len(pruned.Modules) == 0 {
+		if _, err := os.Stat(path); err != nil {
+			return nil
+		}
+	}
+	return pruned.Write(path)
+}
@@ -2,10 +2,15 @@ package release
 
 import (
 	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 
+	"filippo.io/age"
 	"github.com/ocuroot/ocuroot/client"
+	librelease "github.com/ocuroot/ocuroot/lib/release"
 	"github.com/ocuroot/ocuroot/refs/refstore"
 	"github.com/ocuroot/ocuroot/sdk"
 )
@@ -47,20 +52,30 @@ func NewRefStore(
 		}
 	}
 
-	stateStore, err := newRefStoreFromBackend(&storeConfig.State, stateTags, repoURL, repoPath, statePrefix)
+	encryption, err := encryptionConfig(storeConfig.Encryption)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load encryption config: %w", err)
+	}
+
+	stateSchemas, intentSchemas, err := SchemaSets(storeConfig)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load schemas: %w", err)
+	}
+
+	stateStore, err := newRefStoreFromBackend(&storeConfig.State, stateTags, repoURL, repoPath, statePrefix, encryption, stateSchemas)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to create state store: %w", err)
 	}
 
 	if storeConfig.Intent != nil {
-		intentStore, err := newRefStoreFromBackend(storeConfig.Intent, intentTags, repoURL, repoPath, intentPrefix)
+		intentStore, err := newRefStoreFromBackend(storeConfig.Intent, intentTags, repoURL, repoPath, intentPrefix, encryption, intentSchemas)
 		if err != nil {
 			return nil, nil, fmt.Errorf("failed to create intent store: %w", err)
 		}
 		return stateStore, intentStore, nil
 	}
 
-	intentStore, err := newRefStoreFromBackend(&storeConfig.State, intentTags, repoURL, repoPath, intentPrefix)
+	intentStore, err := newRefStoreFromBackend(&storeConfig.State, intentTags, repoURL, repoPath, intentPrefix, encryption, intentSchemas)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to create intent store: %w", err)
 	}
@@ -73,6 +88,8 @@ func newRefStoreFromBackend(
 	repoURL string,
 	repoPath string,
 	pathPrefix string,
+	encryption refstore.EncryptionConfig,
+	schemas *refstore.SchemaSet,
 ) (refstore.Store, error) {
 	var (
 		store refstore.Store
@@ -80,7 +97,42 @@ func newRefStoreFromBackend(
 	)
 	if storeConfig.Fs != nil {
 		statePath := filepath.Join(repoPath, storeConfig.Fs.Path, pathPrefix)
-		store, err = refstore.NewFSRefStore(statePath, tags)
+		store, err = refstore.NewFSRefStoreWithConfig(statePath, tags, refstore.FSRefStoreConfig{
+			History: storeConfig.Fs.History,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create state store: %w", err)
+		}
+	}
+	if storeConfig.Sqlite != nil {
+		dbPath := sqlitePathWithPrefix(filepath.Join(repoPath, storeConfig.Sqlite.Path), pathPrefix)
+		store, err = refstore.NewSQLiteRefStore(dbPath, tags)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create state store: %w", err)
+		}
+	}
+	if storeConfig.Plugin != nil {
+		store, err = refstore.NewPluginStore(tags, refstore.PluginConfig{
+			Command:    storeConfig.Plugin.Command,
+			Dir:        repoPath,
+			PathPrefix: pathPrefix,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create state store: %w", err)
+		}
+	}
+	if storeConfig.Http != nil {
+		// The server keeps state and intent apart, so the path prefix is not
+		// needed
+		name := "state"
+		if _, ok := tags["intent"]; ok {
+			name = "intent"
+		}
+		store, err = refstore.NewHTTPStore(tags, refstore.HTTPStoreConfig{
+			URL:   storeConfig.Http.URL,
+			Store: name,
+			Token: os.Getenv("OCUROOT_STORE_TOKEN"),
+		})
 		if err != nil {
 			return nil, fmt.Errorf("failed to create state store: %w", err)
 		}
@@ -101,7 +153,8 @@ func newRefStoreFromBackend(
 			storeConfig.Git.RemoteURL,
 			storeConfig.Git.Branch,
 			refstore.GitRefStoreConfig{
-				PathPrefix: pathPrefix,
+				PathPrefix:   pathPrefix,
+				PushAttempts: storeConfig.Git.PushAttempts,
 				GitRepoConfig: refstore.GitRepoConfig{
 					CreateBranch: storeConfig.Git.CreateBranch,
 					GitUserName:  gitUserName,
@@ -113,7 +166,99 @@ func newRefStoreFromBackend(
 			return nil, fmt.Errorf("failed to create state store: %w", err)
 		}
 	}
+	if os.Getenv("OCUROOT_DISABLE_STORE_CACHE") == "" {
+		store = refstore.NewCachingStore(store, refstore.CacheConfig{
+			Uncached: librelease.CoordinationGlobs,
+		})
+	}
+	store, err = refstore.NewEncryptedStore(store, encryption)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create encrypted store: %w", err)
+	}
+	store = refstore.NewValidatingStore(store, schemas)
+	store = refstore.NewSelectorStore(store)
 	store = refstore.StoreWithOtel(store)
 
 	return store, nil
 }
+
+// SchemaSets returns the schemas that values written to the state and intent
+// stores are checked against. Schemas declared for custom state in the store
+// config apply to both stores, alongside the built-in schemas for each.
+func SchemaSets(storeConfig *sdk.Store) (state *refstore.SchemaSet, intent *refstore.SchemaSet, err error) {
+	var custom []refstore.SchemaRule
+	if storeConfig != nil {
+		for _, name := range slices.Sorted(maps.Keys(storeConfig.Schemas)) {
+			schema, err := refstore.ParseSchema(storeConfig.Schemas[name])
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid schema for custom state %q: %w", name, err)
+			}
+			custom = append(custom, librelease.CustomSchemaRule(name, schema)...)
+		}
+	}
+
+	state, err = refstore.NewSchemaSet(append(slices.Clone(custom), librelease.StateSchemas()...)...)
+	if err != nil {
+		return nil, nil, err
+	}
+	intent, err = refstore.NewSchemaSet(append(slices.Clone(custom), librelease.IntentSchemas()...)...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return state, intent, nil
+}
+
+// encryptionConfig loads the keys for encrypting sensitive values.
+// Keys to decrypt values are read from OCUROOT_ENCRYPTION_KEY or the file
+// named by OCUROOT_ENCRYPTION_KEY_FILE. If no recipients are configured,
+// values are encrypted for those keys.
+func encryptionConfig(config *sdk.Encryption) (refstore.EncryptionConfig, error) {
+	var out refstore.EncryptionConfig
+
+	keys := os.Getenv("OCUROOT_ENCRYPTION_KEY")
+	if keyFile := os.Getenv("OCUROOT_ENCRYPTION_KEY_FILE"); keyFile != "" {
+		content, err := os.ReadFile(keyFile)
+		if err != nil {
+			return out, fmt.Errorf("failed to read encryption key file: %w", err)
+		}
+		keys += "\n" + string(content)
+	}
+	if strings.TrimSpace(keys) != "" {
+		identities, err := age.ParseIdentities(strings.NewReader(keys))
+		if err != nil {
+			return out, fmt.Errorf("failed to parse encryption keys: %w", err)
+		}
+		out.Identities = identities
+	}
+
+	if config != nil {
+		out.Sensitive = config.Sensitive
+		for _, recipient := range config.Recipients {
+			parsed, err := age.ParseX25519Recipient(recipient)
+			if err != nil {
+				return out, fmt.Errorf("failed to parse encryption recipient %q: %w", recipient, err)
+			}
+			out.Recipients = append(out.Recipients, parsed)
+		}
+	}
+
+	if len(out.Recipients) == 0 {
+		for _, identity := range out.Identities {
+			if x25519, ok := identity.(*age.X25519Identity); ok {
+				out.Recipients = append(out.Recipients, x25519.Recipient())
+			}
+		}
+	}
+
+	return out, nil
+}
+
+// sqlitePathWithPrefix adds a prefix to the name of a database file so that
+// state and intent can be kept in separate databases alongside each other.
+func sqlitePathWithPrefix(path string, prefix string) string {
+	if prefix == "" {
+		return path
+	}
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "-" + prefix + ext
+}
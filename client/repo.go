@@ -101,7 +101,8 @@ type RepoInfo struct {
 }
 
 // GetReleaseConfigFiles returns a list of all *.ocu.star files under the repo
-// root, with the exception of /repo.ocu.star.
+// root, with the exception of /repo.ocu.star, *_test.ocu.star test files and
+// vendored modules.
 // All file paths are relative to the repo root.
 func (r RepoInfo) GetReleaseConfigFiles() ([]string, error) {
 	files := []string{}
@@ -109,7 +110,11 @@ func (r RepoInfo) GetReleaseConfigFiles() ([]string, error) {
 		if err != nil {
 			return err
 		}
-		if !info.IsDir() && strings.HasSuffix(info.Name(), "ocu.star") && info.Name() != "repo.ocu.star" {
+		// Modules vendored by ocuroot mod vendor are loaded, not released
+		if info.IsDir() && path == filepath.Join(r.Root, "ocuroot_vendor") {
+			return filepath.SkipDir
+		}
+		if !info.IsDir() && strings.HasSuffix(info.Name(), "ocu.star") && info.Name() != "repo.ocu.star" && !strings.HasSuffix(info.Name(), "_test.ocu.star") {
 			fp := strings.TrimPrefix(path, r.Root)
 			fp = strings.TrimPrefix(fp, "/")
 			files = append(files, fp)
@@ -0,0 +1,468 @@
+package startest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/ocuroot/ocuroot/client/modules"
+	librelease "github.com/ocuroot/ocuroot/lib/release"
+	"github.com/ocuroot/ocuroot/refs"
+	"github.com/ocuroot/ocuroot/sdk"
+	starlarkjson "go.starlark.net/lib/json"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"go.starlark.net/syntax"
+)
+
+// globals returns the globals available to test files, in addition to the
+// SDK builtins.
+func (s *testState) globals() starlark.StringDict {
+	return starlark.StringDict{
+		"assert": starlarkstruct.FromStringDict(starlark.String("assert"), starlark.StringDict{
+			"eq":       starlark.NewBuiltin("assert.eq", assertEq),
+			"ne":       starlark.NewBuiltin("assert.ne", assertNe),
+			"true":     starlark.NewBuiltin("assert.true", assertTrue),
+			"false":    starlark.NewBuiltin("assert.false", assertFalse),
+			"contains": starlark.NewBuiltin("assert.contains", assertContains),
+			"fails":    starlark.NewBuiltin("assert.fails", assertFails),
+		}),
+		"mock": starlarkstruct.FromStringDict(starlark.String("mock"), starlark.StringDict{
+			"shell":       starlark.NewBuiltin("mock.shell", s.mockShell),
+			"http":        starlark.NewBuiltin("mock.http", s.mockHTTP),
+			"environment": starlark.NewBuiltin("mock.environment", s.mockEnvironment),
+			"ref":         starlark.NewBuiltin("mock.ref", s.mockRef),
+			"shell_calls": starlark.NewBuiltin("mock.shell_calls", s.shellCalls),
+			"http_calls":  starlark.NewBuiltin("mock.http_calls", s.httpCalls),
+		}),
+		"run": starlark.NewBuiltin("run", s.run),
+	}
+}
+
+func failure(msg starlark.Value, format string, args ...any) error {
+	out := fmt.Sprintf(format, args...)
+	if s, ok := msg.(starlark.String); ok {
+		out = fmt.Sprintf("%s: %s", s.GoString(), out)
+	}
+	return fmt.Errorf("%s", out)
+}
+
+func assertEq(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var actual, expected starlark.Value
+	var msg starlark.Value = starlark.None
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "actual", &actual, "expected", &expected, "msg?", &msg); err != nil {
+		return nil, err
+	}
+	eq, err := starlark.Equal(actual, expected)
+	if err != nil {
+		return nil, err
+	}
+	if !eq {
+		return nil, failure(msg, "expected %s, got %s", expected, actual)
+	}
+	return starlark.None, nil
+}
+
+func assertNe(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var actual, unexpected starlark.Value
+	var msg starlark.Value = starlark.None
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "actual", &actual, "unexpected", &unexpected, "msg?", &msg); err != nil {
+		return nil, err
+	}
+	eq, err := starlark.Equal(actual, unexpected)
+	if err != nil {
+		return nil, err
+	}
+	if eq {
+		return nil, failure(msg, "expected a value other than %s", unexpected)
+	}
+	return starlark.None, nil
+}
+
+func assertTrue(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var cond starlark.Value
+	var msg starlark.Value = starlark.None
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "cond", &cond, "msg?", &msg); err != nil {
+		return nil, err
+	}
+	if !cond.Truth() {
+		return nil, failure(msg, "expected %s to be true", cond)
+	}
+	return starlark.None, nil
+}
+
+func assertFalse(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var cond starlark.Value
+	var msg starlark.Value = starlark.None
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "cond", &cond, "msg?", &msg); err != nil {
+		return nil, err
+	}
+	if cond.Truth() {
+		return nil, failure(msg, "expected %s to be false", cond)
+	}
+	return starlark.None, nil
+}
+
+func assertContains(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var container, item starlark.Value
+	var msg starlark.Value = starlark.None
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "container", &container, "item", &item, "msg?", &msg); err != nil {
+		return nil, err
+	}
+	found, err := starlark.Binary(syntax.IN, item, container)
+	if err != nil {
+		return nil, err
+	}
+	if !found.Truth() {
+		return nil, failure(msg, "expected %s to contain %s", container, item)
+	}
+	return starlark.None, nil
+}
+
+// assertFails calls a function, which must fail. The error message is
+// returned, and must contain match if set.
+func assertFails(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var f starlark.Callable
+	var match string
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "fn", &f, "match?", &match); err != nil {
+		return nil, err
+	}
+	_, err := starlark.Call(thread, f, nil, nil)
+	if err == nil {
+		return nil, fmt.Errorf("expected %s to fail", f.Name())
+	}
+	msg := err.Error()
+	if evalErr, ok := err.(*starlark.EvalError); ok {
+		msg = evalErr.Msg
+	}
+	if !strings.Contains(msg, match) {
+		return nil, fmt.Errorf("expected %s to fail with %q, got %q", f.Name(), match, msg)
+	}
+	return starlark.String(msg), nil
+}
+
+func (s *testState) mockShell(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var mock sdk.MockShell
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs,
+		"command", &mock.Command,
+		"stdout?", &mock.Response.Stdout,
+		"stderr?", &mock.Response.Stderr,
+		"exit_code?", &mock.Response.ExitCode,
+	); err != nil {
+		return nil, err
+	}
+	s.mocks.Shell = append(s.mocks.Shell, mock)
+	return starlark.None, nil
+}
+
+func (s *testState) mockHTTP(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	mock := sdk.MockHTTP{
+		Response: sdk.HTTPResponse{StatusCode: 200},
+	}
+	var headers *starlark.Dict
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs,
+		"url", &mock.URL,
+		"method?", &mock.Method,
+		"status_code?", &mock.Response.StatusCode,
+		"body?", &mock.Response.Body,
+		"headers?", &headers,
+	); err != nil {
+		return nil, err
+	}
+	if headers != nil {
+		mock.Response.Headers = map[string][]string{}
+		for _, item := range headers.Items() {
+			k, ok := starlark.AsString(item[0])
+			if !ok {
+				return nil, fmt.Errorf("%s: header names must be strings", fn.Name())
+			}
+			v, err := toGo(thread, item[1])
+			if err != nil {
+				return nil, err
+			}
+			switch v := v.(type) {
+			case string:
+				mock.Response.Headers[k] = []string{v}
+			case []any:
+				for _, value := range v {
+					mock.Response.Headers[k] = append(mock.Response.Headers[k], fmt.Sprint(value))
+				}
+			default:
+				return nil, fmt.Errorf("%s: header %s must be a string or list", fn.Name(), k)
+			}
+		}
+	}
+	s.mocks.HTTP = append(s.mocks.HTTP, mock)
+	return starlark.None, nil
+}
+
+func (s *testState) mockEnvironment(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name string
+	var attributes *starlark.Dict
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "name", &name, "attributes?", &attributes); err != nil {
+		return nil, err
+	}
+	env := sdk.Environment{
+		Name:       sdk.EnvironmentName(name),
+		Attributes: map[string]string{},
+	}
+	if attributes != nil {
+		for _, item := range attributes.Items() {
+			k, ok := starlark.AsString(item[0])
+			v, ok2 := starlark.AsString(item[1])
+			if !ok || !ok2 {
+				return nil, fmt.Errorf("%s: attributes must be strings", fn.Name())
+			}
+			env.Attributes[k] = v
+		}
+	}
+	s.mocks.Environments = append(s.mocks.Environments, env)
+	return starlark.None, nil
+}
+
+// mockRef sets a ref in the mock state, for use as an input. Relative refs
+// are relative to the package under test.
+func (s *testState) mockRef(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var refStr string
+	var value starlark.Value
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "ref", &refStr, "value", &value); err != nil {
+		return nil, err
+	}
+	ref, err := refs.Parse(refStr)
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid ref %q: %w", fn.Name(), refStr, err)
+	}
+	ref, err = ref.RelativeTo(s.packageRef(s.defaultPackage()))
+	if err != nil {
+		return nil, err
+	}
+	v, err := toGo(thread, value)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.setRef(contextFromThread(thread), ref, v); err != nil {
+		return nil, fmt.Errorf("%s: %w", fn.Name(), err)
+	}
+	return starlark.None, nil
+}
+
+func (s *testState) shellCalls(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs); err != nil {
+		return nil, err
+	}
+	var out []starlark.Value
+	for _, call := range s.mocks.ShellCalls {
+		out = append(out, starlark.String(call.Cmd))
+	}
+	return starlark.NewList(out), nil
+}
+
+func (s *testState) httpCalls(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs); err != nil {
+		return nil, err
+	}
+	var out []starlark.Value
+	for _, call := range s.mocks.HTTPCalls {
+		out = append(out, starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+			"method": starlark.String(call.Method),
+			"url":    starlark.String(call.URL),
+			"body":   starlark.String(call.Body),
+		}))
+	}
+	return starlark.NewList(out), nil
+}
+
+// run runs a task or deployment of a package, returning its outputs and
+// tags. Inputs are taken from the inputs argument, then mocked refs, then
+// their defaults.
+func (s *testState) run(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name, pkg string
+	var inputs *starlark.Dict
+	var down bool
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "name", &name, "inputs?", &inputs, "package?", &pkg, "down?", &down); err != nil {
+		return nil, err
+	}
+
+	explicit := map[string]any{}
+	if inputs != nil {
+		v, err := toGo(thread, inputs)
+		if err != nil {
+			return nil, err
+		}
+		explicit = v.(map[string]any)
+	}
+
+	if pkg == "" {
+		pkg = s.defaultPackage()
+	} else {
+		pkg = path.Join(path.Dir(s.file), pkg)
+	}
+
+	done, err := s.runWork(contextFromThread(thread), name, pkg, explicit, down)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w", fn.Name(), name, err)
+	}
+
+	outputs, err := toStarlark(thread, done.Outputs)
+	if err != nil {
+		return nil, err
+	}
+	var tags []starlark.Value
+	for _, tag := range done.Tags {
+		tags = append(tags, starlark.String(tag))
+	}
+	return starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+		"outputs": outputs,
+		"tags":    starlark.NewList(tags),
+	}), nil
+}
+
+func (s *testState) runWork(ctx context.Context, name string, pkg string, explicit map[string]any, down bool) (*sdk.Done, error) {
+	backend := sdk.NewMockBackendWithMocks(s.mocks)
+	backend.Refs = sdk.NewRefBackend(s.packageRef(pkg))
+
+	config, err := sdk.LoadConfig(ctx, modules.NewRepoResolver(s.runner.Root), pkg, backend, s.print)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s: %w", pkg, err)
+	}
+	if config.Package == nil {
+		return nil, fmt.Errorf("%s does not define a package", pkg)
+	}
+
+	fnDef, inputs, err := findWork(config.Package, name, down)
+	if err != nil {
+		return nil, err
+	}
+	for k := range explicit {
+		if _, ok := inputs[k]; !ok {
+			return nil, fmt.Errorf("unknown input %q", k)
+		}
+	}
+
+	logger := func(log sdk.Log) {
+		s.print(nil, log.Message)
+	}
+	for {
+		inputs, err = s.populateInputs(ctx, inputs, explicit)
+		if err != nil {
+			return nil, err
+		}
+
+		fnCtx := sdk.FunctionContext{Inputs: map[string]any{}}
+		for k, v := range inputs {
+			if v.Value == nil {
+				fnCtx.Inputs[k] = v.Default
+			} else {
+				fnCtx.Inputs[k] = v.Value
+			}
+		}
+
+		result, err := config.Run(ctx, fnDef, logger, fnCtx)
+		if err != nil {
+			return nil, err
+		}
+		if result.Err != nil {
+			return nil, result.Err
+		}
+		if result.Next == nil {
+			if result.Done == nil {
+				return &sdk.Done{}, nil
+			}
+			return result.Done, nil
+		}
+		fnDef = result.Next.Fn
+		inputs = result.Next.Inputs
+	}
+}
+
+// populateInputs retrieves inputs from the mock state, with explicit values
+// taking precedence.
+func (s *testState) populateInputs(ctx context.Context, inputs map[string]sdk.InputDescriptor, explicit map[string]any) (map[string]sdk.InputDescriptor, error) {
+	store, err := s.refStore()
+	if err != nil {
+		return nil, err
+	}
+	inputs, err = librelease.PopulateInputs(ctx, store, inputs)
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []string
+	for k, v := range inputs {
+		if e, ok := explicit[k]; ok {
+			v.Value = e
+			inputs[k] = v
+		}
+		if v.Value == nil && v.Default == nil {
+			missing = append(missing, fmt.Sprintf("%s (%s)", k, v.Ref))
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, fmt.Errorf("missing inputs: %s", strings.Join(missing, ", "))
+	}
+	return inputs, nil
+}
+
+// findWork finds the first function and inputs of a task or deployment,
+// named as task/<name> or deploy/<environment>.
+func findWork(pkg *sdk.Package, name string, down bool) (sdk.FunctionDef, map[string]sdk.InputDescriptor, error) {
+	kind, id, ok := strings.Cut(name, "/")
+	if !ok || (kind != "task" && kind != "deploy") {
+		return sdk.FunctionDef{}, nil, fmt.Errorf("expected task/<name> or deploy/<environment>")
+	}
+	for _, phase := range pkg.Phases {
+		for _, t := range phase.Tasks {
+			if kind == "task" && t.Task != nil && t.Task.Name == id {
+				return t.Task.Fn, copyInputs(t.Task.Inputs), nil
+			}
+			if kind == "deploy" && t.Deployment != nil && string(t.Deployment.Environment) == id {
+				if down {
+					return t.Deployment.Down, copyInputs(t.Deployment.Inputs), nil
+				}
+				return t.Deployment.Up, copyInputs(t.Deployment.Inputs), nil
+			}
+		}
+	}
+	return sdk.FunctionDef{}, nil, fmt.Errorf("not found")
+}
+
+func copyInputs(in map[string]sdk.InputDescriptor) map[string]sdk.InputDescriptor {
+	out := make(map[string]sdk.InputDescriptor, len(in))
+	for k, v := range in {
+		out[k] = v
+	}
+	return out
+}
+
+func contextFromThread(thread *starlark.Thread) context.Context {
+	if ctx, ok := thread.Local("ctx").(context.Context); ok {
+		return ctx
+	}
+	return context.Background()
+}
+
+// toGo converts a Starlark value to its JSON equivalent in Go.
+func toGo(thread *starlark.Thread, v starlark.Value) (any, error) {
+	encoded, err := starlark.Call(thread, starlarkjson.Module.Members["encode"], starlark.Tuple{v}, nil)
+	if err != nil {
+		return nil, err
+	}
+	var out any
+	if err := json.Unmarshal([]byte(encoded.(starlark.String).GoString()), &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// toStarlark converts a JSON compatible Go value to Starlark.
+func toStarlark(thread *starlark.Thread, v any) (starlark.Value, error) {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return starlark.Call(thread, starlarkjson.Module.Members["decode"], starlark.Tuple{starlark.String(encoded)}, nil)
+}
@@ -0,0 +1,104 @@
+package startest
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"time"
+)
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Body    string `xml:",chardata"`
+}
+
+// WriteJUnit writes results as a JUnit XML report, with a test suite for
+// each file.
+func WriteJUnit(w io.Writer, results []Result) error {
+	out := junitTestSuites{}
+	var total time.Duration
+	suites := map[string]int{}
+	for _, r := range results {
+		i, ok := suites[r.File]
+		if !ok {
+			i = len(out.Suites)
+			suites[r.File] = i
+			out.Suites = append(out.Suites, junitTestSuite{Name: r.File})
+		}
+		suite := &out.Suites[i]
+
+		tc := junitTestCase{
+			Name:      r.Name,
+			Classname: r.File,
+			Time:      seconds(r.Duration),
+			SystemOut: r.Output,
+		}
+		if r.Err != nil {
+			tc.Failure = &junitFailure{Message: firstLine(r.Err.Error()), Body: FormatError(r.Err)}
+			suite.Failures++
+			out.Failures++
+		}
+		suite.Cases = append(suite.Cases, tc)
+		suite.Tests++
+		out.Tests++
+		total += r.Duration
+	}
+	for i := range out.Suites {
+		var d time.Duration
+		for _, r := range results {
+			if r.File == out.Suites[i].Name {
+				d += r.Duration
+			}
+		}
+		out.Suites[i].Time = seconds(d)
+	}
+	out.Time = seconds(total)
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(out); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+func firstLine(s string) string {
+	for i, c := range s {
+		if c == '\n' {
+			return s[:i]
+		}
+	}
+	return s
+}
@@ -0,0 +1,333 @@
+// Package startest runs unit tests for .ocu.star files.
+//
+// Tests are functions named test_* in files named *_test.ocu.star. They are
+// run against a mock backend, with globals for asserting on values, mocking
+// shell commands, HTTP requests, environments and state, and running the
+// tasks and deployments of the package under test.
+package startest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ocuroot/ocuroot/client/local"
+	"github.com/ocuroot/ocuroot/client/modules"
+	"github.com/ocuroot/ocuroot/refs"
+	"github.com/ocuroot/ocuroot/refs/refstore"
+	"github.com/ocuroot/ocuroot/sdk"
+	"go.starlark.net/starlark"
+)
+
+const testFileSuffix = "_test.ocu.star"
+
+// IsTestFile returns true if a file contains tests rather than a package.
+func IsTestFile(path string) bool {
+	return strings.HasSuffix(path, testFileSuffix)
+}
+
+// FindTestFiles returns the test files among a set of paths. Directories are
+// searched recursively, skipping hidden directories.
+func FindTestFiles(paths []string) ([]string, error) {
+	var out []string
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			out = append(out, p)
+			continue
+		}
+		err = filepath.WalkDir(p, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() && path != p && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			if !d.IsDir() && IsTestFile(d.Name()) {
+				out = append(out, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// Result is the outcome of a single test.
+type Result struct {
+	// File is the path of the test file, relative to the repo root
+	File     string
+	Name     string
+	Duration time.Duration
+	// Output holds anything printed by the test
+	Output string
+	// Err is set if the test failed
+	Err error
+}
+
+func (r Result) Passed() bool {
+	return r.Err == nil
+}
+
+// Runner runs the tests in files within a single repo.
+type Runner struct {
+	// Root is the repo root, which files and packages are loaded relative to
+	Root string
+	// Repo is the repo name used for absolute refs
+	Repo string
+	// Filter selects the tests to run by name. All tests are run if nil.
+	Filter *regexp.Regexp
+}
+
+// NewRunner creates a runner for the repo containing path. The repo name is
+// taken from repo.ocu.star, falling back to the name of the root directory.
+func NewRunner(ctx context.Context, root string) (*Runner, error) {
+	r := &Runner{Root: root, Repo: filepath.Base(root)}
+
+	if _, err := os.Stat(filepath.Join(root, "repo.ocu.star")); err != nil {
+		return r, nil
+	}
+	backend, be := local.BackendForRepo()
+	_, _, err := sdk.LoadRepo(
+		ctx,
+		modules.NewRepoResolver(root),
+		"repo.ocu.star",
+		backend,
+		func(thread *starlark.Thread, msg string) {},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load repo: %w", err)
+	}
+	if be.RepoAlias != "" {
+		r.Repo = be.RepoAlias
+	}
+	return r, nil
+}
+
+// RunFile runs the tests in a file, in the order they are defined. If the
+// file can't be loaded, a single failed result named "load" is returned.
+func (r *Runner) RunFile(ctx context.Context, path string) []Result {
+	rel, err := r.relative(path)
+	if err != nil {
+		return []Result{{File: path, Name: "load", Err: err}}
+	}
+
+	state := &testState{
+		runner: r,
+		file:   rel,
+		mocks:  &sdk.Mocks{Strict: true},
+	}
+	defer state.reset()
+
+	backend := sdk.NewMockBackendWithMocks(state.mocks)
+	backend.Refs = sdk.NewRefBackend(state.packageRef(state.defaultPackage()))
+
+	start := time.Now()
+	config, err := sdk.LoadConfigWithGlobals(
+		ctx,
+		modules.NewRepoResolver(r.Root),
+		rel,
+		backend,
+		state.print,
+		state.globals(),
+	)
+	if err != nil {
+		return []Result{{
+			File:     rel,
+			Name:     "load",
+			Duration: time.Since(start),
+			Output:   state.output.String(),
+			Err:      err,
+		}}
+	}
+
+	var out []Result
+	for _, fn := range testFunctions(config.AllGlobals()) {
+		if r.Filter != nil && !r.Filter.MatchString(fn.Name()) {
+			continue
+		}
+		out = append(out, state.runTest(ctx, fn))
+	}
+	return out
+}
+
+func (r *Runner) relative(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(r.Root, abs)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(rel, "..") {
+		return "", fmt.Errorf("%s is outside the repo at %s", path, r.Root)
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// testFunctions returns the test functions from a file's globals, in the
+// order they were defined.
+func testFunctions(globals starlark.StringDict) []*starlark.Function {
+	var out []*starlark.Function
+	for name, v := range globals {
+		fn, ok := v.(*starlark.Function)
+		if ok && strings.HasPrefix(name, "test_") {
+			out = append(out, fn)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Position().Line < out[j].Position().Line
+	})
+	return out
+}
+
+// testState holds the mocks and state for the test currently running in a
+// file. It is reset between tests.
+type testState struct {
+	runner *Runner
+	file   string
+	mocks  *sdk.Mocks
+	output bytes.Buffer
+
+	// store holds mocked refs, and is created on first use
+	store    refstore.Store
+	storeDir string
+}
+
+func (s *testState) runTest(ctx context.Context, fn *starlark.Function) Result {
+	s.reset()
+	result := Result{File: s.file, Name: fn.Name()}
+
+	thread := &starlark.Thread{
+		Name:  fn.Name(),
+		Print: s.print,
+	}
+	thread.SetLocal("ctx", ctx)
+
+	start := time.Now()
+	if fn.NumParams() > 0 {
+		result.Err = fmt.Errorf("%s must not take any arguments", fn.Name())
+	} else if _, err := starlark.Call(thread, fn, nil, nil); err != nil {
+		result.Err = err
+	}
+	result.Duration = time.Since(start)
+	result.Output = s.output.String()
+	return result
+}
+
+func (s *testState) reset() {
+	*s.mocks = sdk.Mocks{Strict: true}
+	s.output.Reset()
+	if s.store != nil {
+		s.store.Close()
+		s.store = nil
+	}
+	if s.storeDir != "" {
+		os.RemoveAll(s.storeDir)
+		s.storeDir = ""
+	}
+}
+
+func (s *testState) print(thread *starlark.Thread, msg string) {
+	s.output.WriteString(msg)
+	s.output.WriteString("\n")
+}
+
+// defaultPackage returns the package tested by the test file, the file with
+// the _test suffix removed.
+func (s *testState) defaultPackage() string {
+	return strings.TrimSuffix(s.file, testFileSuffix) + ".ocu.star"
+}
+
+// packageRef returns the ref that relative refs within a package are
+// resolved against.
+func (s *testState) packageRef(pkg string) refs.Ref {
+	return refs.Ref{Repo: s.runner.Repo, Filename: pkg}
+}
+
+// refStore returns the store holding mocked refs for the current test.
+func (s *testState) refStore() (refstore.Store, error) {
+	if s.store != nil {
+		return s.store, nil
+	}
+	dir, err := os.MkdirTemp("", "ocuroot-test-")
+	if err != nil {
+		return nil, err
+	}
+	store, err := refstore.NewFSRefStore(dir, nil)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	s.store = store
+	s.storeDir = dir
+	return store, nil
+}
+
+// setRef sets the value of a ref in the mock state. Where the ref has a
+// fragment, the value is set within the existing document.
+func (s *testState) setRef(ctx context.Context, ref refs.Ref, value any) error {
+	store, err := s.refStore()
+	if err != nil {
+		return err
+	}
+
+	doc := ref.SetFragment("")
+	if ref.Fragment == "" {
+		return store.Set(ctx, doc.String(), value)
+	}
+
+	var existing any
+	if err := store.Get(ctx, doc.String(), &existing); err != nil && !errors.Is(err, refstore.ErrRefNotFound) {
+		return err
+	}
+	updated, err := setFragment(existing, strings.Split(ref.Fragment, "/"), value)
+	if err != nil {
+		return fmt.Errorf("failed to set %s: %w", ref.String(), err)
+	}
+	return store.Set(ctx, doc.String(), updated)
+}
+
+// setFragment sets a value at a path of map keys within a document,
+// creating maps as needed.
+func setFragment(doc any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	m, ok := doc.(map[string]any)
+	if doc == nil {
+		m = map[string]any{}
+	} else if !ok {
+		return nil, fmt.Errorf("%s is not within a map", path[0])
+	}
+	child, err := setFragment(m[path[0]], path[1:], value)
+	if err != nil {
+		return nil, err
+	}
+	m[path[0]] = child
+	return m, nil
+}
+
+// FormatError formats a test failure, including the Starlark backtrace where
+// there is one.
+func FormatError(err error) string {
+	var evalErr *starlark.EvalError
+	if errors.As(err, &evalErr) {
+		return evalErr.Backtrace()
+	}
+	return err.Error()
+}
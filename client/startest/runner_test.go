@@ -0,0 +1,194 @@
+package startest
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+const testPackage = `ocuroot("0.3.0")
+
+def version():
+    return host.shell("git describe --tags").stdout.strip()
+
+def build(version):
+    resp = http.get("https://registry.example.com/v2/app/tags/" + version)
+    if resp.status_code != 404:
+        fail("image already exists for " + version)
+    host.shell("docker build -t app:" + version + " .")
+    return done(outputs={"image": "app:" + version}, tags=[version])
+
+def up(image, environment):
+    print("deploying", image, "to", environment["name"])
+    return done(outputs={"url": "https://{}.example.com".format(environment["name"])})
+
+def down(image, environment):
+    return done()
+
+phase(
+    name="build",
+    tasks=[task(build, name="build", inputs={"version": input(ref="./@/task/version#output/version")})],
+)
+
+phase(
+    name="deploy",
+    tasks=[deploy(
+        up=up,
+        down=down,
+        environment=environment("staging", {}),
+        inputs={"image": input(ref="./@/task/build#output/image")},
+    )],
+)
+`
+
+const testTests = `ocuroot("0.3.0")
+
+load("./app.ocu.star", "version")
+
+def test_version():
+    mock.shell("git describe *", stdout="v1.2.0\n")
+    assert.eq(version(), "v1.2.0")
+    assert.eq(mock.shell_calls(), ["git describe --tags"])
+
+def test_unmocked_shell():
+    assert.fails(version, match="no mock for shell command")
+
+def test_build():
+    mock.ref("./@/task/version#output/version", "v1.2.0")
+    mock.http("https://registry.example.com/*", status_code=404)
+    mock.shell("docker build *")
+
+    result = run("task/build")
+    assert.eq(result.outputs, {"image": "app:v1.2.0"})
+    assert.contains(result.tags, "v1.2.0")
+    assert.eq(len(mock.http_calls()), 1)
+
+def test_build_failure():
+    mock.http("https://registry.example.com/*", status_code=200)
+    assert.fails(lambda: run("task/build", inputs={"version": "v1.2.0"}), match="image already exists")
+
+def test_missing_input():
+    assert.fails(lambda: run("task/build"), match="missing inputs: version")
+
+def test_deploy():
+    mock.environment("staging")
+    mock.ref("./@/task/build#output/image", "app:v1.2.0")
+    mock.ref("@/environment/staging", {"name": "staging", "attributes": {}})
+
+    result = run("deploy/staging")
+    assert.eq(result.outputs["url"], "https://staging.example.com")
+
+def test_failing():
+    assert.eq(1 + 1, 3)
+`
+
+func writeTestRepo(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestRunFile(t *testing.T) {
+	dir := writeTestRepo(t, map[string]string{
+		"repo.ocu.star":         "ocuroot(\"0.3.0\")\n\nrepo_alias(\"example\")\n",
+		"app/app.ocu.star":      testPackage,
+		"app/app_test.ocu.star": testTests,
+	})
+
+	files, err := FindTestFiles([]string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("expected 1 test file, got %v", files)
+	}
+
+	r, err := NewRunner(context.Background(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Repo != "example" {
+		t.Errorf("expected repo alias to be loaded, got %q", r.Repo)
+	}
+
+	results := r.RunFile(context.Background(), files[0])
+	var names []string
+	for _, result := range results {
+		names = append(names, result.Name)
+		if result.File != "app/app_test.ocu.star" {
+			t.Errorf("unexpected file %q", result.File)
+		}
+		if result.Name == "test_failing" {
+			if result.Passed() || !strings.Contains(FormatError(result.Err), "expected 3, got 2") {
+				t.Errorf("expected test_failing to fail, got %v", result.Err)
+			}
+			continue
+		}
+		if !result.Passed() {
+			t.Errorf("%s failed: %s", result.Name, FormatError(result.Err))
+		}
+		if result.Name == "test_deploy" && result.Output != "deploying app:v1.2.0 to staging\n" {
+			t.Errorf("unexpected output from test_deploy: %q", result.Output)
+		}
+	}
+
+	want := "test_version,test_unmocked_shell,test_build,test_build_failure,test_missing_input,test_deploy,test_failing"
+	if got := strings.Join(names, ","); got != want {
+		t.Errorf("expected tests to run in order %s, got %s", want, got)
+	}
+
+	r.Filter = regexp.MustCompile("^test_build")
+	if results := r.RunFile(context.Background(), files[0]); len(results) != 2 {
+		t.Errorf("expected 2 tests to match the filter, got %d", len(results))
+	}
+}
+
+func TestRunFileLoadError(t *testing.T) {
+	dir := writeTestRepo(t, map[string]string{
+		"broken_test.ocu.star": "ocuroot(\"0.3.0\")\n\ndef test_broken(:\n",
+	})
+	r, err := NewRunner(context.Background(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := r.RunFile(context.Background(), filepath.Join(dir, "broken_test.ocu.star"))
+	if len(results) != 1 || results[0].Name != "load" || results[0].Passed() {
+		t.Errorf("expected a failed load, got %+v", results)
+	}
+}
+
+func TestWriteJUnit(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteJUnit(&buf, []Result{
+		{File: "a_test.ocu.star", Name: "test_pass"},
+		{File: "a_test.ocu.star", Name: "test_fail", Err: os.ErrNotExist, Output: "printed"},
+		{File: "b_test.ocu.star", Name: "test_pass"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		`<testsuites tests="3" failures="1"`,
+		`<testsuite name="a_test.ocu.star" tests="2" failures="1"`,
+		`<failure message="file does not exist">`,
+		`<system-out>printed</system-out>`,
+		`<testsuite name="b_test.ocu.star" tests="1" failures="0"`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected report to contain %s, got:\n%s", want, out)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package state
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
@@ -77,6 +78,7 @@ func StartViewServer(ctx context.Context, store refstore.Store, port int) error
 		index.Render(ctx, w)
 	})
 	http.HandleFunc("/match/", s.handleMatch)
+	http.HandleFunc("/watch", s.handleWatch)
 	http.HandleFunc("/ref/", func(w http.ResponseWriter, r *http.Request) {
 		refStr := strings.TrimPrefix(r.URL.Path, "/ref/")
 		resolvedRef, err := store.ResolveLink(ctx, refStr)
@@ -102,12 +104,18 @@ func StartViewServer(ctx context.Context, store refstore.Store, port int) error
 			return
 		}
 
+		var metadata *refstore.ObjectMetadata
+		if m, err := refstore.GetMetadata(ctx, store, resolvedRef); err == nil {
+			metadata = &m
+		}
+
 		if r.URL.Query().Get("partial") == "true" {
 			content := StateContent(RefPageProps{
 				Ref:         refStr,
 				ResolvedRef: resolvedRef,
 				Content:     doc,
 				ChildRefs:   childRefs,
+				Metadata:    metadata,
 			})
 			content.Render(ctx, w)
 			return
@@ -118,6 +126,7 @@ func StartViewServer(ctx context.Context, store refstore.Store, port int) error
 			ResolvedRef: resolvedRef,
 			Content:     doc,
 			ChildRefs:   childRefs,
+			Metadata:    metadata,
 		})
 		content.Render(ctx, w)
 	})
@@ -172,6 +181,33 @@ func StartViewServer(ctx context.Context, store refstore.Store, port int) error
 	return nil
 }
 
+// handleWatch notifies the page over SSE to reload when state changes, so the
+// view stays current as other workers update the store.
+func (s *server) handleWatch(w http.ResponseWriter, r *http.Request) {
+	events, err := refstore.Watch(r.Context(), s.store)
+	if errors.Is(err, refstore.ErrWatchUnsupported) {
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprintf(w, "data: connected\n\n")
+	w.(http.Flusher).Flush()
+
+	for range events {
+		fmt.Fprintf(w, "data: reload\n\n")
+		w.(http.Flusher).Flush()
+	}
+}
+
 // findAvailablePort tries to find an available port within the given range
 func findAvailablePort(start, end int) (int, error) {
 	for port := start; port <= end; port++ {
@@ -3,6 +3,7 @@ package tui
 import (
 	"fmt"
 	"os"
+	"sync"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/mattn/go-isatty"
@@ -42,15 +43,21 @@ func (w *WorkTui) Cleanup() error {
 }
 
 type NonTTYTui struct {
+	mu    sync.Mutex
 	tasks map[string]Task
 }
 
 func (n *NonTTYTui) GetTaskByID(id string) (Task, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
 	t, found := n.tasks[id]
 	return t, found
 }
 
 func (n *NonTTYTui) UpdateTask(ev TaskEvent) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
 	description, show := ev.Description()
 	if show {
 		fmt.Fprintln(os.Stderr, description)
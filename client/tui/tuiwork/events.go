@@ -2,6 +2,7 @@ package tuiwork
 
 import (
 	"context"
+	"sync"
 
 	"github.com/charmbracelet/log"
 	"github.com/ocuroot/ocuroot/client/tui"
@@ -57,7 +58,12 @@ func WatchForStateUpdates(ctx context.Context, store refstore.Store, tuiWork tui
 }
 
 func TuiLogger(tuiWork tui.Tui) func(fnRef refs.Ref, msg sdk.Log) {
+	// Runs may log concurrently, serialize updates so no lines are dropped
+	var mu sync.Mutex
 	return func(fnRef refs.Ref, msg sdk.Log) {
+		mu.Lock()
+		defer mu.Unlock()
+
 		wr, err := librelease.ReduceToTaskRef(fnRef)
 		if err != nil {
 			log.Error("failed to get work ref", "error", err)
@@ -2,6 +2,7 @@ package tuiwork
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path"
@@ -93,6 +94,13 @@ func tuiRunStatusChange(ctx context.Context, store refstore.Store, tuiWork tui.T
 	return func(ref refs.Ref) {
 		runRef := librelease.ReduceToRunRef(ref)
 
+		// Runs that have been removed, such as by garbage collection, have
+		// no status to show
+		var run models.Run
+		if err := store.Get(ctx, runRef.String(), &run); errors.Is(err, refstore.ErrRefNotFound) {
+			return
+		}
+
 		out := initRunStateEvent(runRef, tuiWork, store)
 		updateStatus(ctx, store, runRef, out)
 
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/charmbracelet/bubbles/spinner"
@@ -38,6 +39,8 @@ type Task interface {
 }
 
 type WorkModel struct {
+	// mu guards Tasks, which may be read from outside the program loop
+	mu    sync.RWMutex
 	Tasks []Task
 
 	// Shared Spinner
@@ -47,6 +50,8 @@ type WorkModel struct {
 }
 
 func (w *WorkModel) GetTaskByID(id string) (Task, bool) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
 	for _, task := range w.Tasks {
 		if task.ID() == id {
 			return task, true
@@ -73,6 +78,9 @@ func (m *WorkModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return m, tea.Quit
 		}
 	case TaskEvent:
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
 		// Replace existing task with new value if exists
 		for index, task := range m.Tasks {
 			if task.ID() == msg.Task().ID() {
@@ -109,6 +117,9 @@ func (m *WorkModel) view(finished bool) string {
 		return ""
 	}
 
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	// Sort tasks by sort key
 	sort.Slice(m.Tasks, func(i, j int) bool {
 		return m.Tasks[i].SortKey() < m.Tasks[j].SortKey()
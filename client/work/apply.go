@@ -306,8 +306,15 @@ func applyDeployIntent(ctx context.Context, ref refs.Ref, state, intent refstore
 	if err != nil {
 		return fmt.Errorf("failed to increment path: %w", err)
 	}
+	// Remove the reservation if the run is not created
+	cancelReservation := func() {
+		if err := refstore.CancelReservation(ctx, state, runRefString); err != nil {
+			log.Error("failed to cancel run reservation", "ref", runRefString, "error", err)
+		}
+	}
 	runRef, err := refs.Parse(runRefString)
 	if err != nil {
+		cancelReservation()
 		return fmt.Errorf("failed to parse run ref: %w", err)
 	}
 	err = rs.InitializeFunction(
@@ -322,6 +329,7 @@ func applyDeployIntent(ctx context.Context, ref refs.Ref, state, intent refstore
 		},
 	)
 	if err != nil {
+		cancelReservation()
 		return fmt.Errorf("failed to initialize function: %w", err)
 	}
 
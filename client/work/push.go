@@ -12,6 +12,7 @@ import (
 	"github.com/charmbracelet/log"
 	libglob "github.com/gobwas/glob"
 	"github.com/ocuroot/ocuroot/client"
+	"github.com/ocuroot/ocuroot/client/modules"
 	"github.com/ocuroot/ocuroot/client/tui/tuiwork"
 	"github.com/ocuroot/ocuroot/refs"
 	"github.com/ocuroot/ocuroot/store/models"
@@ -120,7 +121,7 @@ func (w *Worker) pushWorkFromSourceRepo(ctx context.Context) ([]Work, error) {
 			changeIndex[f] = struct{}{}
 
 			// Release any changed config files
-			if strings.HasSuffix(f, ".ocu.star") {
+			if strings.HasSuffix(f, ".ocu.star") && !strings.HasSuffix(f, "_test.ocu.star") && !strings.HasPrefix(f, modules.VendorDir+"/") {
 				fileSet[f] = struct{}{}
 			}
 		}
@@ -1,12 +1,14 @@
 package work
 
 import (
+	"encoding/json"
 	"fmt"
 	"reflect"
 	"strconv"
 	"strings"
 
 	"github.com/ocuroot/ocuroot/client/local"
+	librelease "github.com/ocuroot/ocuroot/lib/release"
 	"github.com/ocuroot/ocuroot/sdk"
 	"go.starlark.net/starlark"
 	"go.starlark.net/starlarkstruct"
@@ -17,8 +19,13 @@ type Settings struct {
 	RepoRemotes []string            `starlark:"repo_remotes" env:"OCU_CFG_repo_remotes"`
 	State       *sdk.StorageBackend `starlark:"state_store"`
 	Intent      *sdk.StorageBackend `starlark:"intent_store"`
+	Encryption  *sdk.Encryption     `starlark:"encryption"`
+	// Schemas can only be declared with store.set
+	Schemas map[string]json.RawMessage
 
 	ReleaseIgnore []string `starlark:"release_ignore" env:"OCU_CFG_release_ignore"`
+
+	Retention *librelease.RetentionPolicy `starlark:"retention"`
 }
 
 func LoadSettings(be *local.BackendOutputs, globals starlark.StringDict, envVars []string) (Settings, error) {
@@ -30,6 +37,8 @@ func LoadSettings(be *local.BackendOutputs, globals starlark.StringDict, envVars
 	if be.Store != nil {
 		s.State = &be.Store.State
 		s.Intent = be.Store.Intent
+		s.Encryption = be.Store.Encryption
+		s.Schemas = be.Store.Schemas
 	}
 
 	err := UnmarshalFromStringDict(globals, &s)
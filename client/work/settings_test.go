@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
+	librelease "github.com/ocuroot/ocuroot/lib/release"
 	"github.com/ocuroot/ocuroot/sdk"
 	"go.starlark.net/starlark"
 )
@@ -374,6 +375,7 @@ func TestUnmarshalFromValueStorageBackend(t *testing.T) {
 					SupportFiles map[string]string `json:"support_files,omitempty" starlark:"support_files,omitempty"`
 					PathPrefix   string            `json:"path_prefix,omitempty" starlark:"path_prefix,omitempty"`
 					CreateBranch bool              `json:"create_branch,omitempty" starlark:"create_branch,omitempty"`
+					PushAttempts int               `json:"push_attempts,omitempty" starlark:"push_attempts,omitempty"`
 				}{
 					RemoteURL: "https://github.com/example/repo.git",
 					Branch:    "main",
@@ -396,13 +398,36 @@ func TestUnmarshalFromValueStorageBackend(t *testing.T) {
 			expected: func() *sdk.StorageBackend {
 				sb := &sdk.StorageBackend{}
 				sb.Fs = &struct {
-					Path string `json:"path" starlark:"path"`
+					Path    string `json:"path" starlark:"path"`
+					History bool   `json:"history,omitempty" starlark:"history,omitempty"`
 				}{
 					Path: "/tmp/store",
 				}
 				return sb
 			}(),
 		},
+		{
+			name: "sqlite_storage_backend",
+			in: func() starlark.Value {
+				sqliteDict := starlark.NewDict(1)
+				sqliteDict.SetKey(starlark.String("path"), starlark.String(".store/state.db"))
+				
+				stateDict := starlark.NewDict(1)
+				stateDict.SetKey(starlark.String("sqlite"), sqliteDict)
+				
+				return stateDict
+			}(),
+			ptr: new(sdk.StorageBackend),
+			expected: func() *sdk.StorageBackend {
+				sb := &sdk.StorageBackend{}
+				sb.Sqlite = &struct {
+					Path string `json:"path" starlark:"path"`
+				}{
+					Path: ".store/state.db",
+				}
+				return sb
+			}(),
+		},
 		{
 			name: "git_with_create_branch",
 			in: func() starlark.Value {
@@ -425,6 +450,7 @@ func TestUnmarshalFromValueStorageBackend(t *testing.T) {
 					SupportFiles map[string]string `json:"support_files,omitempty" starlark:"support_files,omitempty"`
 					PathPrefix   string            `json:"path_prefix,omitempty" starlark:"path_prefix,omitempty"`
 					CreateBranch bool              `json:"create_branch,omitempty" starlark:"create_branch,omitempty"`
+					PushAttempts int               `json:"push_attempts,omitempty" starlark:"push_attempts,omitempty"`
 				}{
 					RemoteURL:    "https://github.com/example/repo.git",
 					Branch:       "state",
@@ -455,6 +481,7 @@ func TestUnmarshalFromValueStorageBackend(t *testing.T) {
 					SupportFiles map[string]string `json:"support_files,omitempty" starlark:"support_files,omitempty"`
 					PathPrefix   string            `json:"path_prefix,omitempty" starlark:"path_prefix,omitempty"`
 					CreateBranch bool              `json:"create_branch,omitempty" starlark:"create_branch,omitempty"`
+					PushAttempts int               `json:"push_attempts,omitempty" starlark:"push_attempts,omitempty"`
 				}{
 					RemoteURL: "https://github.com/example/repo.git",
 					Branch:    "state",
@@ -463,6 +490,37 @@ func TestUnmarshalFromValueStorageBackend(t *testing.T) {
 				return sb
 			}(),
 		},
+		{
+			name: "git_with_push_attempts",
+			in: func() starlark.Value {
+				gitDict := starlark.NewDict(3)
+				gitDict.SetKey(starlark.String("remote_url"), starlark.String("https://github.com/example/repo.git"))
+				gitDict.SetKey(starlark.String("branch"), starlark.String("state"))
+				gitDict.SetKey(starlark.String("push_attempts"), starlark.MakeInt(3))
+				
+				stateDict := starlark.NewDict(1)
+				stateDict.SetKey(starlark.String("git"), gitDict)
+				
+				return stateDict
+			}(),
+			ptr: new(sdk.StorageBackend),
+			expected: func() *sdk.StorageBackend {
+				sb := &sdk.StorageBackend{}
+				sb.Git = &struct {
+					RemoteURL    string            `json:"remote_url" starlark:"remote_url"`
+					Branch       string            `json:"branch" starlark:"branch"`
+					SupportFiles map[string]string `json:"support_files,omitempty" starlark:"support_files,omitempty"`
+					PathPrefix   string            `json:"path_prefix,omitempty" starlark:"path_prefix,omitempty"`
+					CreateBranch bool              `json:"create_branch,omitempty" starlark:"create_branch,omitempty"`
+					PushAttempts int               `json:"push_attempts,omitempty" starlark:"push_attempts,omitempty"`
+				}{
+					RemoteURL:    "https://github.com/example/repo.git",
+					Branch:       "state",
+					PushAttempts: 3,
+				}
+				return sb
+			}(),
+		},
 	}
 
 	for _, test := range tests {
@@ -507,6 +565,7 @@ func TestUnmarshalStringDictWithStorageBackends(t *testing.T) {
 					SupportFiles map[string]string `json:"support_files,omitempty" starlark:"support_files,omitempty"`
 					PathPrefix   string            `json:"path_prefix,omitempty" starlark:"path_prefix,omitempty"`
 					CreateBranch bool              `json:"create_branch,omitempty" starlark:"create_branch,omitempty"`
+					PushAttempts int               `json:"push_attempts,omitempty" starlark:"push_attempts,omitempty"`
 				}{
 					RemoteURL: "https://github.com/example/repo.git",
 					Branch:    "state",
@@ -550,6 +609,7 @@ func TestUnmarshalStringDictWithStorageBackends(t *testing.T) {
 					SupportFiles map[string]string `json:"support_files,omitempty" starlark:"support_files,omitempty"`
 					PathPrefix   string            `json:"path_prefix,omitempty" starlark:"path_prefix,omitempty"`
 					CreateBranch bool              `json:"create_branch,omitempty" starlark:"create_branch,omitempty"`
+					PushAttempts int               `json:"push_attempts,omitempty" starlark:"push_attempts,omitempty"`
 				}{
 					RemoteURL: "https://github.com/example/repo.git",
 					Branch:    "state",
@@ -561,6 +621,7 @@ func TestUnmarshalStringDictWithStorageBackends(t *testing.T) {
 					SupportFiles map[string]string `json:"support_files,omitempty" starlark:"support_files,omitempty"`
 					PathPrefix   string            `json:"path_prefix,omitempty" starlark:"path_prefix,omitempty"`
 					CreateBranch bool              `json:"create_branch,omitempty" starlark:"create_branch,omitempty"`
+					PushAttempts int               `json:"push_attempts,omitempty" starlark:"push_attempts,omitempty"`
 				}{
 					RemoteURL: "https://github.com/example/repo.git",
 					Branch:    "intent",
@@ -581,6 +642,23 @@ func TestUnmarshalStringDictWithStorageBackends(t *testing.T) {
 				RepoAlias: "my-repo",
 			},
 		},
+		{
+			name: "settings_with_retention",
+			in: starlark.StringDict{
+				"retention": func() starlark.Value {
+					retentionDict := starlark.NewDict(2)
+					retentionDict.SetKey(starlark.String("keep_releases"), starlark.MakeInt(10))
+					retentionDict.SetKey(starlark.String("log_days"), starlark.MakeInt(30))
+					return retentionDict
+				}(),
+			},
+			expected: Settings{
+				Retention: &librelease.RetentionPolicy{
+					KeepReleases: 10,
+					LogDays:      30,
+				},
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -1,5 +1,17 @@
 package work
 
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/charmbracelet/log"
+	"github.com/ocuroot/ocuroot/client/local"
+	"github.com/ocuroot/ocuroot/sdk"
+	"go.starlark.net/starlark"
+)
+
 var (
 	intentTags = map[string]struct{}{
 		"intent": {},
@@ -8,3 +20,36 @@ var (
 		"state": {},
 	}
 )
+
+// LoadStoreConfig loads the store configuration set by a repo config file,
+// such as repo.ocu.star.
+func LoadStoreConfig(ctx context.Context, configPath string) (*sdk.Store, error) {
+	backend, be := local.BackendForRepo()
+	globals, _, err := sdk.LoadRepo(
+		ctx,
+		sdk.NewFSResolver(os.DirFS(filepath.Dir(configPath))),
+		filepath.Base(configPath),
+		backend,
+		func(thread *starlark.Thread, msg string) {
+			log.Info(msg)
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s: %w", configPath, err)
+	}
+
+	settings, err := LoadSettings(be, globals, os.Environ())
+	if err != nil {
+		return nil, fmt.Errorf("failed to load settings: %w", err)
+	}
+	if settings.State == nil {
+		return nil, fmt.Errorf("no store was set in %s", configPath)
+	}
+
+	return &sdk.Store{
+		State:      *settings.State,
+		Intent:     settings.Intent,
+		Encryption: settings.Encryption,
+		Schemas:    settings.Schemas,
+	}, nil
+}
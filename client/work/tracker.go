@@ -12,6 +12,7 @@ import (
 	"github.com/ocuroot/gittools"
 	"github.com/ocuroot/ocuroot/client"
 	"github.com/ocuroot/ocuroot/client/local"
+	"github.com/ocuroot/ocuroot/client/modules"
 	"github.com/ocuroot/ocuroot/client/release"
 	"github.com/ocuroot/ocuroot/client/tui/tuiwork"
 	"github.com/ocuroot/ocuroot/refs"
@@ -69,8 +70,10 @@ func (w *Worker) InitTrackerFromStateRepo(ctx context.Context, ref refs.Ref, wd,
 		}
 
 		storeConfig := &sdk.Store{
-			State:  *w.Settings.State,
-			Intent: w.Settings.Intent,
+			State:      *w.Settings.State,
+			Intent:     w.Settings.Intent,
+			Encryption: w.Settings.Encryption,
+			Schemas:    w.Settings.Schemas,
 		}
 
 		state, intent, err := release.NewRefStore(
@@ -119,7 +122,7 @@ func (w *Worker) InitTrackerFromSourceRepo(ctx context.Context, ref refs.Ref, wd
 	backend, be := local.BackendForRepo()
 	globals, data, err := sdk.LoadRepo(
 		ctx,
-		sdk.NewFSResolver(os.DirFS(repoRootPath)),
+		modules.NewRepoResolver(repoRootPath),
 		"repo.ocu.star",
 		backend,
 		func(thread *starlark.Thread, msg string) {
@@ -183,8 +186,10 @@ func (w *Worker) InitTrackerFromSourceRepo(ctx context.Context, ref refs.Ref, wd
 	}
 
 	storeConfig := &sdk.Store{
-		State:  *w.Settings.State,
-		Intent: w.Settings.Intent,
+		State:      *w.Settings.State,
+		Intent:     w.Settings.Intent,
+		Encryption: w.Settings.Encryption,
+		Schemas:    w.Settings.Schemas,
 	}
 
 	state, intent, err := release.NewRefStore(
@@ -229,9 +234,7 @@ func (w *Worker) InitTrackerFromSourceRepo(ctx context.Context, ref refs.Ref, wd
 	return nil
 }
 
-func (w *Worker) TrackerForNewRelease(ctx context.Context) (*librelease.ReleaseTracker, []sdk.Environment, error) {
-	var err error
-
+func (w *Worker) TrackerForNewRelease(ctx context.Context) (tracker *librelease.ReleaseTracker, envs []sdk.Environment, err error) {
 	tc := w.Tracker
 
 	if tc.Ref.HasRelease() {
@@ -242,6 +245,16 @@ func (w *Worker) TrackerForNewRelease(ctx context.Context) (*librelease.ReleaseT
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to get next release ID: %w", err)
 	}
+	// The release ID is reserved until the release is initialized, so remove
+	// the reservation if this file turns out not to be a package or fails.
+	defer func() {
+		if tracker != nil && err == nil {
+			return
+		}
+		if cancelErr := refstore.CancelReservation(ctx, tc.State, tc.Ref.String()); cancelErr != nil {
+			log.Error("failed to cancel release reservation", "ref", tc.Ref.String(), "error", cancelErr)
+		}
+	}()
 
 	backend, outputs := release.NewBackend(tc)
 
@@ -281,10 +294,11 @@ func (w *Worker) TrackerForNewRelease(ctx context.Context) (*librelease.ReleaseT
 		return nil, nil, nil
 	}
 
-	tracker, err := librelease.NewReleaseTracker(ctx, config, config.Package, tc.Ref, tc.Intent, tc.State)
+	tracker, err = librelease.NewReleaseTracker(ctx, config, config.Package, tc.Ref, tc.Intent, tc.State)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to create release tracker: %w", err)
 	}
+	tracker.Parallelism = w.Parallelism
 
 	err = tracker.InitRelease(ctx, tc.Commit)
 	if err != nil {
@@ -336,6 +350,7 @@ func (w *Worker) TrackerForExistingRelease(ctx context.Context) (*librelease.Rel
 	if err != nil {
 		return nil, fmt.Errorf("failed to create release tracker: %w", err)
 	}
+	tracker.Parallelism = w.Parallelism
 
 	releaseSummary, err := tracker.GetReleaseInfo(ctx)
 	if err != nil {
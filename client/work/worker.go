@@ -56,7 +56,9 @@ func NewWorker(ctx context.Context, ref refs.Ref) (w *Worker, err error) {
 		}
 	}
 
-	w.Tracker.State = tuiwork.WatchForStateUpdates(ctx, w.Tracker.State, workTui)
+	// Runs may execute concurrently, so the stores must be safe to share
+	w.Tracker.State = tuiwork.WatchForStateUpdates(ctx, refstore.NewSyncStore(w.Tracker.State), workTui)
+	w.Tracker.Intent = refstore.NewSyncStore(w.Tracker.Intent)
 	w.RecordStateUpdates(ctx)
 
 	return w, nil
@@ -75,6 +77,10 @@ type Worker struct {
 	Index *models.PushIndex
 
 	Settings Settings
+
+	// Parallelism is the maximum number of runs to execute at once
+	// for each release.
+	Parallelism int
 }
 
 type GitFilter int
@@ -298,8 +304,15 @@ func (w *Worker) addRunForDeployment(ctx context.Context, ref string) error {
 		return fmt.Errorf("failed to increment path %q: %w", ref, err)
 	}
 	log.Info("Incremented path", "ref", ref, "newRef", newRunRefString)
+	// Remove the reservation if the new run is not created
+	cancelReservation := func() {
+		if err := refstore.CancelReservation(ctx, state, newRunRefString); err != nil {
+			log.Error("failed to cancel run reservation", "ref", newRunRefString, "error", err)
+		}
+	}
 	newRunRef, err := refs.Parse(newRunRefString)
 	if err != nil {
+		cancelReservation()
 		return fmt.Errorf("failed to parse path %q: %w", ref, err)
 	}
 	err = librelease.InitializeRun(ctx, state, newRunRef, &models.Function{
@@ -307,6 +320,7 @@ func (w *Worker) addRunForDeployment(ctx context.Context, ref string) error {
 		Inputs: inputs,
 	})
 	if err != nil {
+		cancelReservation()
 		return fmt.Errorf("failed to initialize run %q: %w", ref, err)
 	}
 
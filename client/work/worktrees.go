@@ -162,8 +162,9 @@ func (w *Worker) CopyInWorktree(ctx context.Context, todo Work) (*Worker, func()
 	}
 
 	newWorker := &Worker{
-		Tracker: w.Tracker,
-		Tui:     w.Tui,
+		Tracker:     w.Tracker,
+		Tui:         w.Tui,
+		Parallelism: w.Parallelism,
 	}
 	newWorker.Tracker.Commit = todo.Commit
 	newWorker.Tracker.RepoPath = workTreePath
@@ -0,0 +1,40 @@
+// ocuroot-store-fs-plugin is a reference implementation of a store plugin,
+// keeping state in a directory in the same way as store.fs.
+//
+// Usage in repo.ocu.star:
+//
+//	store.set(store.plugin(command=["ocuroot-store-fs-plugin", ".store"]))
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/charmbracelet/log"
+	"github.com/ocuroot/ocuroot/refs/refstore"
+)
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: ocuroot-store-fs-plugin <path>")
+		os.Exit(2)
+	}
+	basePath := os.Args[1]
+
+	// Stdout is reserved for the protocol
+	log.SetOutput(os.Stderr)
+
+	err := refstore.ServePlugin(context.Background(), os.Stdin, os.Stdout, func(ctx context.Context, params refstore.PluginInitializeParams) (refstore.Store, error) {
+		tags := make(map[string]struct{})
+		for _, tag := range params.Tags {
+			tags[tag] = struct{}{}
+		}
+		return refstore.NewFSRefStore(filepath.Join(basePath, params.PathPrefix), tags)
+	})
+	if err != nil {
+		log.Error("Plugin failed", "error", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,194 @@
+package release
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/ocuroot/ocuroot/refs/refstore"
+	"github.com/ocuroot/ocuroot/sdk"
+	"github.com/ocuroot/ocuroot/store/models"
+)
+
+const (
+	// IssueMultipleStatuses is a run with more than one status marker.
+	IssueMultipleStatuses refstore.IssueKind = "multiple_statuses"
+	// IssueMissingFunction is a release whose package or runs reference
+	// functions that are not defined.
+	IssueMissingFunction refstore.IssueKind = "missing_function"
+)
+
+// Fsck checks a state store for inconsistencies, returning an issue for each
+// one found.
+func Fsck(ctx context.Context, store refstore.Store) ([]refstore.Issue, error) {
+	checks := []func(context.Context, refstore.Store) ([]refstore.Issue, error){
+		refstore.CheckLinks,
+		refstore.CheckDependencies,
+		CheckRunStatuses,
+		CheckReleaseFunctions,
+	}
+
+	var issues []refstore.Issue
+	for _, check := range checks {
+		found, err := check(ctx, store)
+		if err != nil {
+			return nil, err
+		}
+		issues = append(issues, found...)
+	}
+	return issues, nil
+}
+
+// CheckRunStatuses finds runs with more than one status marker.
+// These are repaired by keeping only the most recently written marker.
+func CheckRunStatuses(ctx context.Context, store refstore.Store) ([]refstore.Issue, error) {
+	matches, err := store.Match(ctx, "**/@*/{task,deploy}/*/*/"+statusPathSegment+"/*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to match run statuses: %w", err)
+	}
+
+	markersByRun := make(map[string][]string)
+	for _, marker := range matches {
+		runRef := path.Dir(path.Dir(marker))
+		markersByRun[runRef] = append(markersByRun[runRef], marker)
+	}
+
+	var runRefs []string
+	for runRef, markers := range markersByRun {
+		if len(markers) > 1 {
+			runRefs = append(runRefs, runRef)
+		}
+	}
+	sort.Strings(runRefs)
+
+	var issues []refstore.Issue
+	for _, runRef := range runRefs {
+		markers := markersByRun[runRef]
+
+		latest := -1
+		var latestMarker models.Marker
+		var statuses []string
+		for i, markerRef := range markers {
+			statuses = append(statuses, path.Base(markerRef))
+
+			var marker models.Marker
+			if err := store.Get(ctx, markerRef, &marker); err != nil {
+				return nil, fmt.Errorf("failed to get status marker %s: %w", markerRef, err)
+			}
+			if latest < 0 || marker.Time.After(latestMarker.Time) {
+				latest = i
+				latestMarker = marker
+			}
+		}
+
+		keep := markers[latest]
+		stale := append(append([]string{}, markers[:latest]...), markers[latest+1:]...)
+		issues = append(issues, refstore.Issue{
+			Kind:   IssueMultipleStatuses,
+			Ref:    runRef,
+			Detail: fmt.Sprintf("has statuses %s, latest is %s", strings.Join(statuses, ", "), path.Base(keep)),
+			Repair: func(ctx context.Context, store refstore.Store) error {
+				for _, markerRef := range stale {
+					if err := store.Delete(ctx, markerRef); err != nil && !errors.Is(err, refstore.ErrRefNotFound) {
+						return fmt.Errorf("failed to delete status marker %s: %w", markerRef, err)
+					}
+				}
+				return nil
+			},
+		})
+	}
+	return issues, nil
+}
+
+// CheckReleaseFunctions finds releases whose package has tasks without
+// functions, or whose runs were started from functions that are not in the
+// package. These cannot be repaired automatically.
+func CheckReleaseFunctions(ctx context.Context, store refstore.Store) ([]refstore.Issue, error) {
+	releaseRefs, err := store.MatchOptions(ctx, refstore.MatchOptions{NoLinks: true}, "**/@*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to match releases: %w", err)
+	}
+
+	var issues []refstore.Issue
+	report := func(ref string, format string, args ...any) {
+		issues = append(issues, refstore.Issue{
+			Kind:   IssueMissingFunction,
+			Ref:    ref,
+			Detail: fmt.Sprintf(format, args...),
+		})
+	}
+
+	for _, releaseRef := range releaseRefs {
+		if GlobRepoConfig.Match(releaseRef) {
+			continue
+		}
+
+		var info ReleaseInfo
+		if err := store.Get(ctx, releaseRef, &info); err != nil {
+			return nil, fmt.Errorf("failed to get release %s: %w", releaseRef, err)
+		}
+		if info.Package == nil {
+			// Reserved releases, such as those for environment-only
+			// packages, have no package to check
+			continue
+		}
+
+		defined := make(map[string]struct{})
+		for _, fn := range info.Package.Functions {
+			defined[fn.Function.String()] = struct{}{}
+		}
+
+		referenced := make(map[string]struct{})
+		checkDef := func(fn sdk.FunctionDef, description string) {
+			if fn.Name == "" {
+				report(releaseRef, "%s has no function", description)
+				return
+			}
+			referenced[fn.String()] = struct{}{}
+			if len(defined) == 0 {
+				return
+			}
+			if _, ok := defined[fn.String()]; !ok {
+				report(releaseRef, "%s references undefined function %s", description, fn)
+			}
+		}
+
+		for _, phase := range info.Package.Phases {
+			for _, task := range phase.Tasks {
+				if task.Task != nil {
+					checkDef(task.Task.Fn, fmt.Sprintf("task %s", task.Task.Name))
+				}
+				if task.Deployment != nil {
+					checkDef(task.Deployment.Up, fmt.Sprintf("deployment to %s", task.Deployment.Environment))
+					checkDef(task.Deployment.Down, fmt.Sprintf("removal from %s", task.Deployment.Environment))
+				}
+			}
+		}
+
+		runRefs, err := store.Match(ctx, releaseRef+"/{task,deploy}/*/*")
+		if err != nil {
+			return nil, fmt.Errorf("failed to match runs for %s: %w", releaseRef, err)
+		}
+		for _, runRef := range runRefs {
+			var run models.Run
+			if err := store.Get(ctx, runRef, &run); err != nil {
+				return nil, fmt.Errorf("failed to get run %s: %w", runRef, err)
+			}
+			if len(run.Functions) == 0 {
+				report(runRef, "run has no functions")
+				continue
+			}
+
+			// Only the entrypoint comes from the package, later functions are
+			// returned by the functions before them
+			entrypoint := run.Functions[0].Fn
+			if _, ok := referenced[entrypoint.String()]; !ok {
+				report(runRef, "run was started from %s, which is not in the release package", entrypoint)
+			}
+		}
+	}
+	return issues, nil
+}
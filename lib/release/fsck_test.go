@@ -0,0 +1,162 @@
+package release
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ocuroot/ocuroot/refs"
+	"github.com/ocuroot/ocuroot/refs/refstore"
+	"github.com/ocuroot/ocuroot/store/models"
+)
+
+func TestCheckRunStatuses(t *testing.T) {
+	ctx := context.Background()
+	state := newTestStore(t)
+	tracker := newTestTracker(t, singleTaskPackage, state)
+	runRef := taskRun(tracker.ReleaseRef, "build")
+
+	issues, err := CheckRunStatuses(ctx, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(issues) != 0 {
+		t.Fatalf("expected no issues after init, got %v", issues)
+	}
+
+	// Leave markers behind as if earlier status changes were not cleaned up
+	now := time.Now()
+	for status, age := range map[models.Status]time.Duration{
+		models.StatusPending:  2 * time.Hour,
+		models.StatusComplete: 0,
+		models.StatusRunning:  time.Hour,
+	} {
+		markerRef := runRef.JoinSubPath(statusPathSegment, string(status)).String()
+		if err := state.Set(ctx, markerRef, models.Marker{Time: now.Add(-age)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	issues, err = CheckRunStatuses(ctx, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(issues) != 1 {
+		t.Fatalf("expected 1 issue, got %v", issues)
+	}
+	issue := issues[0]
+	if issue.Kind != IssueMultipleStatuses || issue.Ref != runRef.String() {
+		t.Errorf("unexpected issue: %v", issue)
+	}
+	if !strings.HasSuffix(issue.Detail, "latest is complete") {
+		t.Errorf("expected complete to be kept, got %v", issue)
+	}
+
+	repaired, err := refstore.Repair(ctx, state, issues)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repaired != 1 {
+		t.Errorf("expected 1 repair, got %d", repaired)
+	}
+
+	markers, err := state.Match(ctx, runRef.JoinSubPath(statusPathSegment).String()+"/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(markers) != 1 {
+		t.Errorf("expected a single status marker after repair, got %v", markers)
+	}
+	if status := runStatus(t, tracker, "build"); status != models.StatusComplete {
+		t.Errorf("expected run to be complete, got %s", status)
+	}
+	issues, err = CheckRunStatuses(ctx, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(issues) != 0 {
+		t.Errorf("expected no issues after repair, got %v", issues)
+	}
+}
+
+func TestCheckReleaseFunctions(t *testing.T) {
+	ctx := context.Background()
+	state := newTestStore(t)
+	tracker := newTestTracker(t, singleTaskPackage, state)
+	releaseRef := tracker.ReleaseRef.String()
+
+	issues, err := CheckReleaseFunctions(ctx, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(issues) != 0 {
+		t.Fatalf("expected no issues in a new release, got %v", issues)
+	}
+
+	// Point the task at a function the package does not define, and add a
+	// run with no functions
+	var info ReleaseInfo
+	if err := state.Get(ctx, releaseRef, &info); err != nil {
+		t.Fatal(err)
+	}
+	info.Package.Phases[0].Tasks[0].Task.Fn.Name = "missing"
+	if err := state.Set(ctx, releaseRef, info); err != nil {
+		t.Fatal(err)
+	}
+	emptyRun := tracker.ReleaseRef.SetSubPathType(refs.SubPathTypeTask).SetSubPath("build/2")
+	if err := state.Set(ctx, emptyRun.String(), models.Run{Type: models.RunTypeTask, Release: tracker.ReleaseRef}); err != nil {
+		t.Fatal(err)
+	}
+
+	issues, err = CheckReleaseFunctions(ctx, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]string)
+	for _, issue := range issues {
+		if issue.Kind != IssueMissingFunction {
+			t.Errorf("unexpected issue kind: %v", issue)
+		}
+		if issue.Repair != nil {
+			t.Errorf("expected %v not to be repairable", issue)
+		}
+		got[issue.Ref] = issue.Detail
+	}
+	if len(issues) != 3 {
+		t.Fatalf("expected 3 issues, got %v", issues)
+	}
+	if !strings.Contains(got[releaseRef], "references undefined function missing") {
+		t.Errorf("undefined task function not reported: %v", issues)
+	}
+	if !strings.Contains(got[taskRun(tracker.ReleaseRef, "build").String()], "not in the release package") {
+		t.Errorf("run entrypoint not reported: %v", issues)
+	}
+	if got[emptyRun.String()] != "run has no functions" {
+		t.Errorf("run without functions not reported: %v", issues)
+	}
+
+	// Nothing is changed when repairing
+	repaired, err := refstore.Repair(ctx, state, issues)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repaired != 0 {
+		t.Errorf("expected no repairs, got %d", repaired)
+	}
+	var after ReleaseInfo
+	if err := state.Get(ctx, releaseRef, &after); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(after, info) {
+		t.Errorf("expected release to be unchanged, got %+v", after)
+	}
+	stillReported, err := CheckReleaseFunctions(ctx, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stillReported) != 3 {
+		t.Errorf("expected issues to remain after repair, got %v", stillReported)
+	}
+}
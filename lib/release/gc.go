@@ -0,0 +1,301 @@
+package release
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ocuroot/ocuroot/refs"
+	"github.com/ocuroot/ocuroot/refs/refstore"
+	"github.com/ocuroot/ocuroot/sdk"
+)
+
+// DefaultGCBatchSize is the number of refs deleted in each transaction when
+// collecting garbage.
+const DefaultGCBatchSize = 500
+
+// RetentionPolicy controls which releases and logs are kept in state.
+// A zero value for any field disables that form of cleanup.
+type RetentionPolicy struct {
+	// KeepReleases is the number of most recent releases kept for each
+	// package. Releases that are linked, such as those currently deployed or
+	// tagged, and releases with unfinished runs are always kept.
+	KeepReleases int `json:"keep_releases"`
+	// LogDays is the number of days logs are kept for in releases that are
+	// not removed.
+	LogDays int `json:"log_days"`
+}
+
+// GCPlan lists the refs to be removed from state.
+type GCPlan struct {
+	// Releases are the release refs to be removed along with everything
+	// under them.
+	Releases []string
+	// Logs are the log refs in kept releases to be removed.
+	Logs []string
+	// Refs is every ref to be deleted, including those under each release.
+	Refs []string
+	// Dependencies are the dependency edges involving any of Refs, which are
+	// removed along with them.
+	Dependencies []GCDependency
+}
+
+// GCDependency is a dependency edge to be removed from state.
+type GCDependency struct {
+	Ref        string
+	Dependency string
+}
+
+// PlanGC works out which refs in a state store are no longer needed under a
+// retention policy.
+func PlanGC(ctx context.Context, store refstore.Store, policy RetentionPolicy, now time.Time) (*GCPlan, error) {
+	plan := &GCPlan{}
+	if policy.KeepReleases > 0 {
+		releases, err := releasesToRemove(ctx, store, policy.KeepReleases)
+		if err != nil {
+			return nil, err
+		}
+		for _, releaseRef := range releases {
+			contents, err := store.MatchOptions(ctx, refstore.MatchOptions{NoLinks: true}, releaseRef+"/**")
+			if err != nil {
+				return nil, fmt.Errorf("failed to match refs in %s: %w", releaseRef, err)
+			}
+			plan.Releases = append(plan.Releases, releaseRef)
+			plan.Refs = append(plan.Refs, contents...)
+			plan.Refs = append(plan.Refs, releaseRef)
+		}
+	}
+
+	if policy.LogDays > 0 {
+		cutoff := now.AddDate(0, 0, -policy.LogDays)
+		logs, err := logsToRemove(ctx, store, cutoff, plan.Releases)
+		if err != nil {
+			return nil, err
+		}
+		plan.Logs = logs
+		plan.Refs = append(plan.Refs, logs...)
+	}
+
+	dependencies, err := dependenciesToRemove(ctx, store, plan.Refs)
+	if err != nil {
+		return nil, err
+	}
+	plan.Dependencies = dependencies
+
+	return plan, nil
+}
+
+// dependenciesToRemove returns every dependency edge in which one of the
+// given refs is either the dependant or the dependency.
+func dependenciesToRemove(ctx context.Context, store refstore.Store, removed []string) ([]GCDependency, error) {
+	var out []GCDependency
+	seen := make(map[GCDependency]struct{})
+	add := func(edge GCDependency) {
+		if _, ok := seen[edge]; ok {
+			return
+		}
+		seen[edge] = struct{}{}
+		out = append(out, edge)
+	}
+
+	for _, ref := range removed {
+		dependencies, err := store.GetDependencies(ctx, ref)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get dependencies of %s: %w", ref, err)
+		}
+		for _, dependency := range dependencies {
+			add(GCDependency{Ref: ref, Dependency: dependency})
+		}
+
+		dependants, err := store.GetDependants(ctx, ref)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get dependants of %s: %w", ref, err)
+		}
+		for _, dependant := range dependants {
+			add(GCDependency{Ref: dependant, Dependency: ref})
+		}
+	}
+	return out, nil
+}
+
+// releasesToRemove returns the releases beyond the most recent keep for each
+// package that are not linked and have no unfinished runs.
+func releasesToRemove(ctx context.Context, store refstore.Store, keep int) ([]string, error) {
+	releaseRefs, err := store.MatchOptions(ctx, refstore.MatchOptions{NoLinks: true}, "**/@*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to match releases: %w", err)
+	}
+	linked, err := linkedReleases(ctx, store)
+	if err != nil {
+		return nil, err
+	}
+
+	type numberedRelease struct {
+		ref    string
+		number int
+	}
+	byPackage := make(map[string][]numberedRelease)
+	for _, releaseRef := range releaseRefs {
+		if GlobRepoConfig.Match(releaseRef) {
+			continue
+		}
+		// Only numbered releases are removed
+		number, err := strconv.Atoi(strings.TrimPrefix(path.Base(releaseRef), "@r"))
+		if err != nil {
+			continue
+		}
+		pkg := ReduceToReleaseConfig(releaseRef)
+		byPackage[pkg] = append(byPackage[pkg], numberedRelease{ref: releaseRef, number: number})
+	}
+
+	var out []string
+	for _, releases := range byPackage {
+		sort.Slice(releases, func(i, j int) bool {
+			return releases[i].number > releases[j].number
+		})
+		if len(releases) <= keep {
+			continue
+		}
+
+		for _, release := range releases[keep:] {
+			if _, ok := linked[release.ref]; ok {
+				continue
+			}
+			unfinished, err := store.Match(ctx, release.ref+"/{task,deploy}/*/*/"+statusPathSegment+"/{pending,running,paused}")
+			if err != nil {
+				return nil, fmt.Errorf("failed to match statuses in %s: %w", release.ref, err)
+			}
+			if len(unfinished) > 0 {
+				continue
+			}
+			out = append(out, release.ref)
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// linkedReleases returns the set of releases that contain the target of any
+// link, such as current deployments and tags.
+func linkedReleases(ctx context.Context, store refstore.Store) (map[string]struct{}, error) {
+	allRefs, err := store.Match(ctx, "**")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list refs: %w", err)
+	}
+	nonLinks, err := refstore.NonLinks(ctx, store)
+	if err != nil {
+		return nil, err
+	}
+
+	linked := make(map[string]struct{})
+	for _, ref := range allRefs {
+		if _, ok := nonLinks[ref]; ok {
+			continue
+		}
+		target, err := store.ResolveLink(ctx, ref)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve link %s: %w", ref, err)
+		}
+		targetRef, err := refs.Parse(target)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse link target %s: %w", target, err)
+		}
+		if !targetRef.HasRelease() {
+			continue
+		}
+		releaseRef := targetRef.
+			SetSubPathType(refs.SubPathTypeNone).
+			SetSubPath("").
+			SetFragment("")
+		linked[releaseRef.String()] = struct{}{}
+	}
+	return linked, nil
+}
+
+// logsToRemove returns the logs whose last entry was written before cutoff,
+// excluding those in releases that are already being removed.
+func logsToRemove(ctx context.Context, store refstore.Store, cutoff time.Time, removedReleases []string) ([]string, error) {
+	logRefs, err := store.MatchOptions(ctx, refstore.MatchOptions{NoLinks: true}, "**/@*/{task,deploy}/*/*/logs")
+	if err != nil {
+		return nil, fmt.Errorf("failed to match logs: %w", err)
+	}
+
+	var out []string
+	for _, logRef := range logRefs {
+		if slices.ContainsFunc(removedReleases, func(releaseRef string) bool {
+			return strings.HasPrefix(logRef, releaseRef+"/")
+		}) {
+			continue
+		}
+
+		var logs []sdk.Log
+		if err := store.Get(ctx, logRef, &logs); err != nil {
+			return nil, fmt.Errorf("failed to get logs %s: %w", logRef, err)
+		}
+		if len(logs) == 0 {
+			continue
+		}
+		if logs[len(logs)-1].Timestamp.Before(cutoff) {
+			out = append(out, logRef)
+		}
+	}
+	return out, nil
+}
+
+// ApplyGC removes the dependency edges and refs in a plan, using a separate
+// transaction for each batch of up to batchSize changes. Edges are removed
+// before the refs they involve.
+// If a change fails, its batch is rolled back, so each batch is either
+// applied in full or not at all.
+func ApplyGC(ctx context.Context, store refstore.Store, plan *GCPlan, batchSize int) error {
+	if batchSize <= 0 {
+		batchSize = DefaultGCBatchSize
+	}
+
+	var changes []func() error
+	for _, edge := range plan.Dependencies {
+		changes = append(changes, func() error {
+			if err := store.RemoveDependency(ctx, edge.Ref, edge.Dependency); err != nil {
+				return fmt.Errorf("failed to remove dependency of %s on %s: %w", edge.Ref, edge.Dependency, err)
+			}
+			return nil
+		})
+	}
+	for _, ref := range plan.Refs {
+		changes = append(changes, func() error {
+			if err := store.Delete(ctx, ref); err != nil && !errors.Is(err, refstore.ErrRefNotFound) {
+				return fmt.Errorf("failed to delete %s: %w", ref, err)
+			}
+			return nil
+		})
+	}
+
+	batches := (len(changes) + batchSize - 1) / batchSize
+	for batch := 0; batch < batches; batch++ {
+		start := batch * batchSize
+		end := min(start+batchSize, len(changes))
+
+		message := fmt.Sprintf("garbage collection (%d/%d)", batch+1, batches)
+		if err := store.StartTransaction(ctx, message); err != nil {
+			return fmt.Errorf("failed to start transaction: %w", err)
+		}
+		for _, change := range changes[start:end] {
+			if err := change(); err != nil {
+				if rollbackErr := refstore.RollbackTransaction(ctx, store); rollbackErr != nil {
+					return errors.Join(err, fmt.Errorf("failed to roll back transaction: %w", rollbackErr))
+				}
+				return err
+			}
+		}
+		if err := store.CommitTransaction(ctx); err != nil {
+			return fmt.Errorf("failed to commit transaction: %w", err)
+		}
+	}
+	return nil
+}
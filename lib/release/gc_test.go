@@ -0,0 +1,304 @@
+package release
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ocuroot/ocuroot/refs/refstore"
+	"github.com/ocuroot/ocuroot/sdk"
+	"github.com/ocuroot/ocuroot/store/models"
+)
+
+// gcStore records the deletes made in each transaction, and fails deletes
+// of a configured ref.
+type gcStore struct {
+	refstore.Store
+
+	open       bool
+	batches    [][]string
+	failRef    string
+	rolledBack int
+}
+
+func (s *gcStore) StartTransaction(ctx context.Context, message string) error {
+	s.open = true
+	s.batches = append(s.batches, nil)
+	return s.Store.StartTransaction(ctx, message)
+}
+
+func (s *gcStore) CommitTransaction(ctx context.Context) error {
+	s.open = false
+	return s.Store.CommitTransaction(ctx)
+}
+
+func (s *gcStore) RollbackTransaction(ctx context.Context) error {
+	s.open = false
+	s.rolledBack++
+	return refstore.RollbackTransaction(ctx, s.Store)
+}
+
+func (s *gcStore) Delete(ctx context.Context, ref string) error {
+	if ref == s.failRef {
+		return fmt.Errorf("injected failure deleting %s", ref)
+	}
+	if s.open {
+		s.batches[len(s.batches)-1] = append(s.batches[len(s.batches)-1], ref)
+	}
+	return s.Store.Delete(ctx, ref)
+}
+
+// gcFixture populates a store with releases of two packages, and returns the
+// time logs were written relative to.
+func gcFixture(t *testing.T, store refstore.Store) time.Time {
+	t.Helper()
+	ctx := context.Background()
+	now := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	set := func(ref string, v any) {
+		t.Helper()
+		if err := store.Set(ctx, ref, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	link := func(ref, target string) {
+		t.Helper()
+		if err := store.Link(ctx, ref, target); err != nil {
+			t.Fatal(err)
+		}
+	}
+	run := func(runRef string, status models.Status, lastLog time.Time) {
+		t.Helper()
+		set(runRef, models.Run{Type: models.RunTypeTask})
+		set(runRef+"/"+statusPathSegment+"/"+string(status), models.NewMarker())
+		set(runRef+"/logs", []sdk.Log{
+			{Timestamp: lastLog.Add(-time.Minute), Message: "start"},
+			{Timestamp: lastLog, Message: "end"},
+		})
+	}
+
+	for i := 1; i <= 6; i++ {
+		release := fmt.Sprintf("repo/-/pkg.ocu.star/@r%d", i)
+		set(release, ReleaseInfo{Commit: fmt.Sprintf("commit%d", i)})
+		status := models.StatusComplete
+		if i == 4 {
+			status = models.StatusPending
+		}
+		// Logs in the newest release are recent, older releases have
+		// logs past the cutoff
+		lastLog := now.AddDate(0, 0, -10)
+		if i == 5 {
+			lastLog = now.Add(-time.Hour)
+		}
+		run(release+"/task/build/1", status, lastLog)
+	}
+	link("repo/-/pkg.ocu.star/@v1.0.0", "repo/-/pkg.ocu.star/@r2")
+	link("repo/-/pkg.ocu.star/@/deploy/staging", "repo/-/pkg.ocu.star/@r3/task/build/1")
+
+	set("repo/-/other.ocu.star/@r1", ReleaseInfo{Commit: "other"})
+	run("repo/-/other.ocu.star/@r1/task/build/1", models.StatusComplete, now.Add(-time.Hour))
+	set("repo/-/other.ocu.star/@r1/task/build/1/logs", []sdk.Log{})
+
+	// The oldest release depends on, and is depended on by, refs that are kept
+	for _, edge := range gcFixtureDependencies {
+		if err := store.AddDependency(ctx, edge.Ref, edge.Dependency); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return now
+}
+
+var gcFixtureDependencies = []GCDependency{
+	{Ref: "repo/-/pkg.ocu.star/@r1/task/build/1", Dependency: "repo/-/other.ocu.star/@r1/task/build/1"},
+	{Ref: "repo/-/other.ocu.star/@r1", Dependency: "repo/-/pkg.ocu.star/@r1"},
+}
+
+func allRefs(t *testing.T, store refstore.Store) []string {
+	t.Helper()
+	out, err := store.Match(context.Background(), "**")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func TestPlanGC(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+	now := gcFixture(t, store)
+
+	plan, err := PlanGC(ctx, store, RetentionPolicy{KeepReleases: 2, LogDays: 7}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// r5 and r6 are the newest, r4 has a pending run, r3 is deployed and r2
+	// is tagged
+	if want := []string{"repo/-/pkg.ocu.star/@r1"}; !slices.Equal(plan.Releases, want) {
+		t.Errorf("expected releases %v to be removed, got %v", want, plan.Releases)
+	}
+	// Logs in r1 are removed with it, and the empty log in other is kept
+	wantLogs := []string{
+		"repo/-/pkg.ocu.star/@r2/task/build/1/logs",
+		"repo/-/pkg.ocu.star/@r3/task/build/1/logs",
+		"repo/-/pkg.ocu.star/@r4/task/build/1/logs",
+		"repo/-/pkg.ocu.star/@r6/task/build/1/logs",
+	}
+	logs := slices.Clone(plan.Logs)
+	sort.Strings(logs)
+	if !slices.Equal(logs, wantLogs) {
+		t.Errorf("expected logs %v to be removed, got %v", wantLogs, logs)
+	}
+
+	var wantRefs []string
+	for _, ref := range allRefs(t, store) {
+		if ref == "repo/-/pkg.ocu.star/@r1" || strings.HasPrefix(ref, "repo/-/pkg.ocu.star/@r1/") {
+			wantRefs = append(wantRefs, ref)
+		}
+	}
+	wantRefs = append(wantRefs, wantLogs...)
+	sort.Strings(wantRefs)
+	gotRefs := slices.Clone(plan.Refs)
+	sort.Strings(gotRefs)
+	if !slices.Equal(gotRefs, wantRefs) {
+		t.Errorf("expected refs %v to be deleted, got %v", wantRefs, gotRefs)
+	}
+
+	gotDeps := slices.Clone(plan.Dependencies)
+	sort.Slice(gotDeps, func(i, j int) bool {
+		return gotDeps[i].Ref < gotDeps[j].Ref
+	})
+	wantDeps := slices.Clone(gcFixtureDependencies)
+	sort.Slice(wantDeps, func(i, j int) bool {
+		return wantDeps[i].Ref < wantDeps[j].Ref
+	})
+	if !slices.Equal(gotDeps, wantDeps) {
+		t.Errorf("expected dependencies %v to be removed, got %v", wantDeps, gotDeps)
+	}
+}
+
+func TestPlanGCDisabled(t *testing.T) {
+	store := newTestStore(t)
+	now := gcFixture(t, store)
+
+	plan, err := PlanGC(context.Background(), store, RetentionPolicy{}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Refs) != 0 || len(plan.Dependencies) != 0 {
+		t.Errorf("expected nothing to be removed, got %v and %v", plan.Refs, plan.Dependencies)
+	}
+}
+
+func TestApplyGC(t *testing.T) {
+	ctx := context.Background()
+	store := &gcStore{Store: newTestStore(t)}
+	now := gcFixture(t, store)
+
+	plan, err := PlanGC(ctx, store, RetentionPolicy{KeepReleases: 2, LogDays: 7}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := allRefs(t, store)
+
+	if err := ApplyGC(ctx, store, plan, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := (len(plan.Dependencies) + len(plan.Refs) + 2) / 3; len(store.batches) != want {
+		t.Errorf("expected %d transactions, got %d", want, len(store.batches))
+	}
+	var deleted []string
+	for i, batch := range store.batches {
+		if len(batch) > 3 {
+			t.Errorf("expected at most 3 deletes in batch %d, got %v", i, batch)
+		}
+		deleted = append(deleted, batch...)
+	}
+	if !slices.Equal(deleted, plan.Refs) {
+		t.Errorf("expected refs to be deleted in plan order, got %v", deleted)
+	}
+
+	var want []string
+	for _, ref := range before {
+		if !slices.Contains(plan.Refs, ref) {
+			want = append(want, ref)
+		}
+	}
+	if got := allRefs(t, store); !slices.Equal(got, want) {
+		t.Errorf("expected remaining refs %v, got %v", want, got)
+	}
+
+	// No dependency edge is left referring to a removed ref
+	for _, ref := range allRefs(t, store) {
+		for _, get := range []func(context.Context, string) ([]string, error){store.GetDependencies, store.GetDependants} {
+			edges, err := get(ctx, ref)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(edges) != 0 {
+				t.Errorf("expected no dependency edges on %s, got %v", ref, edges)
+			}
+		}
+	}
+	for _, check := range []func(context.Context, refstore.Store) ([]refstore.Issue, error){
+		refstore.CheckLinks,
+		refstore.CheckDependencies,
+		CheckRunStatuses,
+	} {
+		issues, err := check(ctx, store)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(issues) != 0 {
+			t.Errorf("expected no issues after garbage collection, got %v", issues)
+		}
+	}
+}
+
+func TestApplyGCDeleteFailure(t *testing.T) {
+	ctx := context.Background()
+	store := &gcStore{Store: newTestStore(t)}
+	now := gcFixture(t, store)
+
+	plan, err := PlanGC(ctx, store, RetentionPolicy{KeepReleases: 2, LogDays: 7}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Refs) < 6 {
+		t.Fatalf("expected at least 6 refs to delete, got %v", plan.Refs)
+	}
+
+	// Fail part way through a batch, after the dependencies are removed
+	const failIndex = 5
+	failChange := len(plan.Dependencies) + failIndex
+	if failChange%3 == 0 {
+		t.Fatalf("expected the failure not to be first in its batch")
+	}
+	store.failRef = plan.Refs[failIndex]
+	err = ApplyGC(ctx, store, plan, 3)
+	if err == nil || !strings.Contains(err.Error(), "injected failure") {
+		t.Fatalf("expected the delete failure, got %v", err)
+	}
+	if store.open || store.rolledBack != 1 {
+		t.Error("expected the transaction to be rolled back")
+	}
+	if want := failChange/3 + 1; len(store.batches) != want {
+		t.Errorf("expected %d batches, with none after the failure, got %d", want, len(store.batches))
+	}
+
+	// Only batches before the failure are applied
+	applied := failChange / 3 * 3
+	remaining := allRefs(t, store)
+	for i, ref := range plan.Refs {
+		if got, want := slices.Contains(remaining, ref), len(plan.Dependencies)+i >= applied; got != want {
+			t.Errorf("expected %s to remain: %v, got %v", ref, want, got)
+		}
+	}
+}
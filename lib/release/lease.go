@@ -0,0 +1,229 @@
+package release
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/ocuroot/ocuroot/refs"
+	"github.com/ocuroot/ocuroot/refs/refstore"
+	"github.com/ocuroot/ocuroot/store/models"
+)
+
+// Before a run is executed, it is claimed by writing a lease ref under the
+// run. The lease is renewed while the run is in progress and removed when it
+// finishes. A run that is still marked as running after its lease has expired
+// is assumed to have been abandoned by a worker that exited, and is treated
+// as failed so it can be retried.
+//
+// Claiming a lease is only atomic on stores that support conditional writes.
+// Other stores, such as plugin stores, fall back to checking the lease before
+// writing it, so two workers claiming a run at the same moment may both
+// succeed. A warning is logged when this happens.
+const (
+	leasePathSegment = "lease"
+
+	// DefaultLeaseDuration is the length of time a lease is valid for if not
+	// renewed.
+	DefaultLeaseDuration = 5 * time.Minute
+)
+
+// ErrRunLeased is returned when attempting to execute a run that has been
+// claimed by another worker, or that another worker has already executed.
+var ErrRunLeased = errors.New("run is leased by another worker")
+
+// leaseHolder identifies this process as the holder of a lease.
+var leaseHolder = newLeaseHolder()
+
+// warnUnconditionalLease logs once per process that leases are not atomic.
+var warnUnconditionalLease sync.Once
+
+func newLeaseHolder() string {
+	hostname, _ := os.Hostname()
+	var token [4]byte
+	_, _ = rand.Read(token[:])
+	return fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), hex.EncodeToString(token[:]))
+}
+
+func leaseRef(runRef refs.Ref) refs.Ref {
+	return runRef.JoinSubPath(leasePathSegment)
+}
+
+// GetLease returns the lease on a run, or nil if the run has not been claimed.
+func GetLease(ctx context.Context, store refstore.Store, runRef refs.Ref) (*models.Lease, error) {
+	var lease models.Lease
+	if err := store.Get(ctx, leaseRef(runRef).String(), &lease); err != nil {
+		if errors.Is(err, refstore.ErrRefNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get lease: %w", err)
+	}
+	return &lease, nil
+}
+
+// leasedElsewhere reports whether a run is held by an unexpired lease that
+// belongs to another worker.
+func leasedElsewhere(ctx context.Context, store refstore.Store, runRef refs.Ref) (bool, error) {
+	lease, err := GetLease(ctx, store, runRef)
+	if err != nil {
+		return false, err
+	}
+	if lease == nil {
+		return false, nil
+	}
+	return lease.Holder != leaseHolder && !lease.Expired(time.Now()), nil
+}
+
+// leaseAbandoned reports whether a run has a lease that has expired.
+func leaseAbandoned(ctx context.Context, store refstore.Store, runRef refs.Ref) (bool, error) {
+	lease, err := GetLease(ctx, store, runRef)
+	if err != nil {
+		return false, err
+	}
+	return lease != nil && lease.Expired(time.Now()), nil
+}
+
+// claimLease takes the lease on a run for this worker.
+// ErrRunLeased is returned if another worker holds an unexpired lease, or
+// claims the run at the same time.
+func claimLease(ctx context.Context, store refstore.Store, runRef refs.Ref, duration time.Duration) error {
+	return writeLease(ctx, store, runRef, duration, false)
+}
+
+// renewLease extends the lease on a run held by this worker.
+// ErrRunLeased is returned if the lease has since been taken by another
+// worker.
+func renewLease(ctx context.Context, store refstore.Store, runRef refs.Ref, duration time.Duration) error {
+	return writeLease(ctx, store, runRef, duration, true)
+}
+
+func writeLease(ctx context.Context, store refstore.Store, runRef refs.Ref, duration time.Duration, renew bool) error {
+	ref := leaseRef(runRef).String()
+
+	hostname, _ := os.Hostname()
+	lease := models.Lease{
+		Holder:   leaseHolder,
+		Hostname: hostname,
+		Expires:  time.Now().Add(duration),
+	}
+
+	var existing models.Lease
+	revision, err := refstore.GetWithRevision(ctx, store, ref, &existing)
+	if errors.Is(err, refstore.ErrConditionalWritesUnsupported) {
+		// Without conditional writes, the lease can only be checked before
+		// it is written.
+		err = store.Get(ctx, ref, &existing)
+	}
+	exists := err == nil
+	if err != nil && !errors.Is(err, refstore.ErrRefNotFound) {
+		return fmt.Errorf("failed to get lease: %w", err)
+	}
+
+	if exists && existing.Holder != leaseHolder && !existing.Expired(time.Now()) {
+		return fmt.Errorf("%w: held by %s on %s until %s", ErrRunLeased, existing.Holder, existing.Hostname, existing.Expires.Format(time.RFC3339))
+	}
+	if renew && (!exists || existing.Holder != leaseHolder) {
+		return fmt.Errorf("%w: lease was lost", ErrRunLeased)
+	}
+
+	if exists {
+		err = refstore.SetIfRevision(ctx, store, ref, lease, revision)
+	} else {
+		err = refstore.SetIfAbsent(ctx, store, ref, lease)
+	}
+	if errors.Is(err, refstore.ErrConditionalWritesUnsupported) {
+		warnUnconditionalLease.Do(func() {
+			log.Warn("State store does not support conditional writes, a run may be claimed by more than one worker at a time")
+		})
+		err = store.Set(ctx, ref, lease)
+	}
+	if errors.Is(err, refstore.ErrConflict) {
+		return fmt.Errorf("%w: claimed concurrently", ErrRunLeased)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write lease: %w", err)
+	}
+	return nil
+}
+
+// releaseLease removes the lease on a run if it is held by this worker.
+func releaseLease(ctx context.Context, store refstore.Store, runRef refs.Ref) error {
+	lease, err := GetLease(ctx, store, runRef)
+	if err != nil {
+		return err
+	}
+	if lease == nil || lease.Holder != leaseHolder {
+		return nil
+	}
+	if err := store.Delete(ctx, leaseRef(runRef).String()); err != nil && !errors.Is(err, refstore.ErrRefNotFound) {
+		return fmt.Errorf("failed to delete lease: %w", err)
+	}
+	return nil
+}
+
+// leaseDuration returns the duration of leases taken by this tracker.
+func (r *ReleaseTracker) leaseDuration() time.Duration {
+	if r.LeaseDuration <= 0 {
+		return DefaultLeaseDuration
+	}
+	return r.LeaseDuration
+}
+
+// claimRun takes the lease on a run and renews it in the background until
+// the returned function is called, which also releases the lease.
+// Lease writes are made outside of any transaction, so they are visible to
+// other workers immediately.
+func (r *ReleaseTracker) claimRun(ctx context.Context, runRef refs.Ref) (func(), error) {
+	duration := r.leaseDuration()
+
+	r.txMu.Lock()
+	err := claimLease(ctx, r.stateStore.Store, runRef, duration)
+	r.txMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	renewCtx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		ticker := time.NewTicker(duration / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-renewCtx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			r.txMu.Lock()
+			err := renewLease(renewCtx, r.stateStore.Store, runRef, duration)
+			r.txMu.Unlock()
+			if err != nil {
+				log.Error("failed to renew lease", "run", runRef.String(), "error", err)
+				if errors.Is(err, ErrRunLeased) {
+					return
+				}
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		wg.Wait()
+
+		r.txMu.Lock()
+		defer r.txMu.Unlock()
+		if err := releaseLease(ctx, r.stateStore.Store, runRef); err != nil {
+			log.Error("failed to release lease", "run", runRef.String(), "error", err)
+		}
+	}, nil
+}
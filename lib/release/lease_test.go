@@ -0,0 +1,289 @@
+package release
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ocuroot/ocuroot/refs"
+	"github.com/ocuroot/ocuroot/refs/refstore"
+	"github.com/ocuroot/ocuroot/sdk"
+	"github.com/ocuroot/ocuroot/store/models"
+)
+
+const singleTaskPackage = `ocuroot("0.3.0")
+
+def build():
+    return done()
+
+phase(name="build", tasks=[task(build, name="build")])
+`
+
+// withLeaseHolder makes this process hold leases as holder for the rest of
+// the test.
+func withLeaseHolder(t *testing.T, holder string) {
+	t.Helper()
+	original := leaseHolder
+	leaseHolder = holder
+	t.Cleanup(func() {
+		leaseHolder = original
+	})
+}
+
+func setLease(t *testing.T, store refstore.Store, runRef refs.Ref, holder string, expires time.Time) {
+	t.Helper()
+	lease := models.Lease{Holder: holder, Hostname: "host", Expires: expires}
+	if err := store.Set(context.Background(), leaseRef(runRef).String(), lease); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func getLease(t *testing.T, store refstore.Store, runRef refs.Ref) *models.Lease {
+	t.Helper()
+	lease, err := GetLease(context.Background(), store, runRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lease
+}
+
+func testRunRef(t *testing.T) refs.Ref {
+	t.Helper()
+	runRef, err := refs.Parse("repo/-/pkg.ocu.star/@r1/task/build/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return runRef
+}
+
+func TestClaimLeaseHeldElsewhere(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+	runRef := testRunRef(t)
+
+	withLeaseHolder(t, "first")
+	if err := claimLease(ctx, store, runRef, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	// Claiming again as the same holder succeeds
+	if err := claimLease(ctx, store, runRef, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	withLeaseHolder(t, "second")
+	if err := claimLease(ctx, store, runRef, time.Minute); !errors.Is(err, ErrRunLeased) {
+		t.Fatalf("expected ErrRunLeased, got %v", err)
+	}
+	if lease := getLease(t, store, runRef); lease == nil || lease.Holder != "first" {
+		t.Errorf("expected lease to still be held by first, got %+v", lease)
+	}
+
+	// Once expired, the lease may be taken over
+	setLease(t, store, runRef, "first", time.Now().Add(-time.Second))
+	if err := claimLease(ctx, store, runRef, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if lease := getLease(t, store, runRef); lease == nil || lease.Holder != "second" {
+		t.Errorf("expected lease to be held by second, got %+v", lease)
+	}
+}
+
+func TestRenewLease(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+	runRef := testRunRef(t)
+
+	withLeaseHolder(t, "first")
+	if err := renewLease(ctx, store, runRef, time.Minute); !errors.Is(err, ErrRunLeased) {
+		t.Errorf("expected renewing an unclaimed lease to fail, got %v", err)
+	}
+
+	if err := claimLease(ctx, store, runRef, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	claimed := getLease(t, store, runRef)
+
+	if err := renewLease(ctx, store, runRef, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	renewed := getLease(t, store, runRef)
+	if !renewed.Expires.After(claimed.Expires.Add(50 * time.Minute)) {
+		t.Errorf("expected renewal to extend expiry from %v, got %v", claimed.Expires, renewed.Expires)
+	}
+
+	withLeaseHolder(t, "second")
+	if err := renewLease(ctx, store, runRef, time.Hour); !errors.Is(err, ErrRunLeased) {
+		t.Errorf("expected renewing another holder's lease to fail, got %v", err)
+	}
+}
+
+func TestReleaseLease(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+	runRef := testRunRef(t)
+
+	withLeaseHolder(t, "first")
+	if err := claimLease(ctx, store, runRef, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	// Leases held by other workers are left in place
+	withLeaseHolder(t, "second")
+	if err := releaseLease(ctx, store, runRef); err != nil {
+		t.Fatal(err)
+	}
+	if getLease(t, store, runRef) == nil {
+		t.Fatal("expected lease held by another worker to remain")
+	}
+
+	withLeaseHolder(t, "first")
+	if err := releaseLease(ctx, store, runRef); err != nil {
+		t.Fatal(err)
+	}
+	if lease := getLease(t, store, runRef); lease != nil {
+		t.Errorf("expected lease to be removed, got %+v", lease)
+	}
+}
+
+func TestClaimLeaseWithoutConditionalWrites(t *testing.T) {
+	ctx := context.Background()
+	// Embedding only Store hides the conditional writes of the FS store
+	store := struct{ refstore.Store }{newTestStore(t)}
+	runRef := testRunRef(t)
+
+	withLeaseHolder(t, "first")
+	if err := claimLease(ctx, store, runRef, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	withLeaseHolder(t, "second")
+	if err := claimLease(ctx, store, runRef, time.Minute); !errors.Is(err, ErrRunLeased) {
+		t.Errorf("expected ErrRunLeased, got %v", err)
+	}
+}
+
+func TestAbandonedJobs(t *testing.T) {
+	ctx := context.Background()
+	state := newTestStore(t)
+	tracker := newTestTracker(t, singleTaskPackage, state)
+	runRef := taskRun(tracker.ReleaseRef, "build")
+
+	if err := saveStatus(ctx, state, runRef, models.StatusRunning); err != nil {
+		t.Fatal(err)
+	}
+
+	// A running job with a live lease is not abandoned
+	setLease(t, state, runRef, "other", time.Now().Add(time.Minute))
+	abandoned, err := tracker.stateStore.AbandonedJobs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(abandoned) != 0 {
+		t.Errorf("expected no abandoned jobs, got %v", abandoned)
+	}
+
+	setLease(t, state, runRef, "other", time.Now().Add(-time.Minute))
+	abandoned, err = tracker.stateStore.AbandonedJobs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	statusRef := runRef.JoinSubPath(statusPathSegment, string(models.StatusRunning)).String()
+	if len(abandoned) != 1 || abandoned[0] != statusRef {
+		t.Fatalf("expected %s to be abandoned, got %v", statusRef, abandoned)
+	}
+
+	failed, err := tracker.stateStore.FailedJobs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := failed[runRef]; !ok || len(failed) != 1 {
+		t.Errorf("expected %s to be failed, got %v", runRef.String(), failed)
+	}
+}
+
+func TestPendingJobsSkipsLeasedRuns(t *testing.T) {
+	ctx := context.Background()
+	state := newTestStore(t)
+	tracker := newTestTracker(t, singleTaskPackage, state)
+	runRef := taskRun(tracker.ReleaseRef, "build")
+	withLeaseHolder(t, "self")
+
+	var tests = []struct {
+		name    string
+		holder  string
+		expires time.Duration
+		pending bool
+	}{
+		{name: "leased elsewhere", holder: "other", expires: time.Minute, pending: false},
+		{name: "expired lease", holder: "other", expires: -time.Minute, pending: true},
+		{name: "leased here", holder: "self", expires: time.Minute, pending: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setLease(t, state, runRef, test.holder, time.Now().Add(test.expires))
+
+			pending, err := tracker.stateStore.PendingJobs(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := pending[runRef]; ok != test.pending {
+				t.Errorf("expected pending to be %v, got %v", test.pending, pending)
+			}
+		})
+	}
+}
+
+func TestRunSkipsFinishedRun(t *testing.T) {
+	ctx := context.Background()
+	state := newTestStore(t)
+	tracker := newTestTracker(t, singleTaskPackage, state)
+	runRef := taskRun(tracker.ReleaseRef, "build")
+
+	pending, err := tracker.stateStore.PendingJobs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	run, ok := pending[runRef]
+	if !ok {
+		t.Fatalf("expected %s to be pending, got %v", runRef, pending)
+	}
+
+	// Another worker executes the run and releases its lease before this
+	// worker claims it
+	if err := saveStatus(ctx, state, runRef, models.StatusComplete); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = tracker.Run(ctx, func(sdk.Log) {
+		t.Error("expected the run not to be executed")
+	}, runRef, run)
+	if !errors.Is(err, ErrRunLeased) {
+		t.Fatalf("expected ErrRunLeased, got %v", err)
+	}
+	if status := runStatus(t, tracker, "build"); status != models.StatusComplete {
+		t.Errorf("expected the run to remain complete, got %s", status)
+	}
+	if lease := getLease(t, state, runRef); lease != nil {
+		t.Errorf("expected the lease to be released, got %+v", lease)
+	}
+}
+
+func TestCoordinationGlobs(t *testing.T) {
+	runRef := testRunRef(t)
+	for ref, want := range map[string]bool{
+		leaseRef(runRef).String():                                 true,
+		runRef.JoinSubPath(statusPathSegment, "running").String(): true,
+		runRef.String():                                             false,
+		runRef.JoinSubPath("logs").String():                         false,
+		"repo/-/pkg.ocu.star/@r1/custom/lease":                      false,
+		"repo/-/pkg.ocu.star/@r1/task/build/1/outputs/status/value": false,
+	} {
+		matched := false
+		for _, g := range CoordinationGlobs {
+			matched = matched || g.Match(ref)
+		}
+		if matched != want {
+			t.Errorf("expected %s to match coordination globs: %v", ref, want)
+		}
+	}
+}
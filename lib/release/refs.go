@@ -20,10 +20,17 @@ var (
 	GlobDeployment  = libglob.MustCompile("**/@*/deploy/*", '/')
 	GlobRun         = libglob.MustCompile("**/@*/{task,deploy}/*/*", '/')
 	GlobLog         = libglob.MustCompile("**/@*/{task,deploy}/*/*/logs", '/')
+	GlobRunStatus   = libglob.MustCompile("**/@*/{task,deploy}/*/*/status/*", '/')
+	GlobLease       = libglob.MustCompile("**/@*/{task,deploy}/*/*/lease", '/')
 	GlobCustom      = libglob.MustCompile("**/@*/custom/*", '/')
 	GlobEnvironment = libglob.MustCompile("@*/environment/*", '/')
 )
 
+// CoordinationGlobs match the refs used to coordinate runs between workers.
+// These change as other workers claim and complete runs, so should always be
+// read from the store rather than a cache.
+var CoordinationGlobs = []libglob.Glob{GlobRunStatus, GlobLease}
+
 func ReduceToReleaseConfig(ref string) string {
 	return strings.Split(ref, "@")[0]
 }
@@ -154,6 +154,9 @@ func (w *releaseStore) InitDeploymentDown(ctx context.Context, env string) error
 
 	err = w.InitializeFunction(ctx, fnRun, ref, fs)
 	if err != nil {
+		if cancelErr := refstore.CancelReservation(ctx, w.Store, ref.String()); cancelErr != nil {
+			log.Error("failed to cancel run reservation", "ref", ref.String(), "error", cancelErr)
+		}
 		return fmt.Errorf("failed to initialize function: %w", err)
 	}
 	return nil
@@ -216,6 +219,8 @@ func CheckDependencies(ctx context.Context, store refstore.Store, fn *models.Fun
 	return true, nil
 }
 
+// FailedJobs returns all runs that have failed, including runs that were
+// abandoned while running.
 func (w *releaseStore) FailedJobs(ctx context.Context) (map[refs.Ref]*models.Run, error) {
 	matchRef := w.ReleaseRef.String() + "/{task,deploy}/*/*/status/failed"
 	failedJobs, err := w.Store.Match(ctx, matchRef)
@@ -223,12 +228,21 @@ func (w *releaseStore) FailedJobs(ctx context.Context) (map[refs.Ref]*models.Run
 		return nil, err
 	}
 
+	abandonedJobs, err := w.AbandonedJobs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	failedJobs = append(failedJobs, abandonedJobs...)
+
 	out := make(map[refs.Ref]*models.Run)
 	for _, fn := range failedJobs {
-		jobRef := strings.TrimSuffix(fn, "/status/failed")
+		jobRef, err := refs.Reduce(fn, GlobRun)
+		if err != nil {
+			return nil, err
+		}
 
 		var run models.Run
-		err := w.Store.Get(ctx, jobRef, &run)
+		err = w.Store.Get(ctx, jobRef, &run)
 		if err != nil {
 			return nil, err
 		}
@@ -242,6 +256,33 @@ func (w *releaseStore) FailedJobs(ctx context.Context) (map[refs.Ref]*models.Run
 	return out, nil
 }
 
+// AbandonedJobs returns the status refs of runs that are marked as running,
+// but whose lease has expired.
+func (w *releaseStore) AbandonedJobs(ctx context.Context) ([]string, error) {
+	matchRef := w.ReleaseRef.String() + "/{task,deploy}/*/*/status/running"
+	runningJobs, err := w.Store.Match(ctx, matchRef)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []string
+	for _, fn := range runningJobs {
+		runRef, err := refs.Parse(strings.TrimSuffix(fn, "/status/running"))
+		if err != nil {
+			return nil, err
+		}
+		abandoned, err := leaseAbandoned(ctx, w.Store, runRef)
+		if err != nil {
+			return nil, err
+		}
+		if abandoned {
+			log.Warn("run lease has expired, treating as failed", "run", runRef.String())
+			out = append(out, fn)
+		}
+	}
+	return out, nil
+}
+
 func (w *releaseStore) PendingJobs(ctx context.Context) (map[refs.Ref]*models.Run, error) {
 	matchRefPending := w.ReleaseRef.String() + "/{task,deploy}/*/*/status/pending"
 	matchRefPaused := w.ReleaseRef.String() + "/{task,deploy}/*/*/status/paused"
@@ -285,6 +326,16 @@ func (w *releaseStore) PendingJobs(ctx context.Context) (map[refs.Ref]*models.Ru
 			return nil, err
 		}
 
+		// Skip runs that another worker is about to execute
+		leased, err := leasedElsewhere(ctx, w.Store, runRefParsed)
+		if err != nil {
+			return nil, err
+		}
+		if leased {
+			log.Info("run is leased by another worker", "run", runRef)
+			continue
+		}
+
 		out[runRefParsed] = &run
 	}
 	return out, nil
@@ -0,0 +1,68 @@
+package release
+
+import (
+	"github.com/ocuroot/ocuroot/refs/refstore"
+	"github.com/ocuroot/ocuroot/sdk"
+	"github.com/ocuroot/ocuroot/store/models"
+)
+
+// StateSchemas returns schemas for the documents Ocuroot writes to the state
+// store, by the kind of ref they are written to. Custom state has no built-in
+// schema.
+func StateSchemas() []refstore.SchemaRule {
+	var (
+		marker      = refstore.SchemaFor(models.Marker{})
+		pushIndex   = refstore.SchemaFor(models.PushIndex{})
+		environment = refstore.SchemaFor(models.Environment{})
+		run         = refstore.SchemaFor(models.Run{})
+		task        = refstore.SchemaFor(models.Task{})
+		logs        = refstore.SchemaFor([]sdk.Log{})
+		lease       = refstore.SchemaFor(models.Lease{})
+		repoConfig  = refstore.SchemaFor(models.RepoConfig{})
+		releaseInfo = refstore.SchemaFor(ReleaseInfo{})
+	)
+
+	return schemaRules(
+		schemaRule(repoConfig, "**/-/repo.ocu.star/@*"),
+		schemaRule(pushIndex, "**/@*/push/*", "@*/push/*"),
+		schemaRule(marker, "**/@*/commit/*", "**/@*/op/*", "**/@*/{task,deploy}/*/*/"+statusPathSegment+"/*"),
+		schemaRule(logs, "**/@*/{task,deploy}/*/*/logs"),
+		schemaRule(lease, "**/@*/{task,deploy}/*/*/"+leasePathSegment),
+		schemaRule(run, "**/@*/{task,deploy}/*/*"),
+		schemaRule(task, "**/@*/{task,deploy}/*"),
+		schemaRule(environment, "**/@*/environment/*", "@*/environment/*"),
+		schemaRule(releaseInfo, "**/@*"),
+	)
+}
+
+// IntentSchemas returns schemas for the documents in the intent store, by the
+// kind of ref they are written to. Custom intent has no built-in schema.
+func IntentSchemas() []refstore.SchemaRule {
+	return schemaRules(
+		schemaRule(refstore.SchemaFor(models.RepoConfig{}), "**/-/repo.ocu.star/@*"),
+		schemaRule(refstore.SchemaFor(models.Intent{}), "**/@*/deploy/*"),
+		schemaRule(refstore.SchemaFor(models.Environment{}), "**/@*/environment/*", "@*/environment/*"),
+	)
+}
+
+// CustomSchemaRule applies a schema to custom state and intent with a given
+// name, which may be a glob.
+func CustomSchemaRule(name string, schema *refstore.Schema) []refstore.SchemaRule {
+	return schemaRule(schema, "**/@*/custom/"+name, "@*/custom/"+name)
+}
+
+func schemaRule(schema *refstore.Schema, globs ...string) []refstore.SchemaRule {
+	var out []refstore.SchemaRule
+	for _, glob := range globs {
+		out = append(out, refstore.SchemaRule{Glob: glob, Schema: schema})
+	}
+	return out
+}
+
+func schemaRules(rules ...[]refstore.SchemaRule) []refstore.SchemaRule {
+	var out []refstore.SchemaRule
+	for _, r := range rules {
+		out = append(out, r...)
+	}
+	return out
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"path"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/charmbracelet/log"
@@ -56,6 +57,37 @@ type ReleaseTracker struct {
 	intent     refstore.Store
 	stateStore *releaseStore
 	ReleaseRef refs.Ref
+
+	// Parallelism is the maximum number of runs that RunToPause will execute
+	// at the same time. Values less than 1 are treated as 1.
+	Parallelism int
+
+	// LeaseDuration is the length of time a claimed run is held for before
+	// it must be renewed. Values less than or equal to zero use
+	// DefaultLeaseDuration.
+	LeaseDuration time.Duration
+
+	// txMu prevents transactions from concurrent runs from interleaving
+	txMu sync.Mutex
+}
+
+// transaction runs f inside a state store transaction.
+// Only one transaction may be open for a tracker at any time, so runs executing
+// concurrently will not have their changes mixed together.
+func (r *ReleaseTracker) transaction(ctx context.Context, message string, f func() error) error {
+	r.txMu.Lock()
+	defer r.txMu.Unlock()
+
+	if err := r.stateStore.Store.StartTransaction(ctx, message); err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+
+	err := f()
+
+	if commitErr := r.stateStore.Store.CommitTransaction(ctx); commitErr != nil {
+		log.Error("failed to commit transaction", "error", commitErr)
+	}
+	return err
 }
 
 func (r *ReleaseTracker) ReleaseStatus(ctx context.Context) (models.Status, error) {
@@ -108,9 +140,7 @@ func (r *ReleaseTracker) GetReleaseInfo(ctx context.Context) (*ReleaseInfo, erro
 	return r.stateStore.GetReleaseInfo(ctx)
 }
 
-func (r *ReleaseTracker) InitRelease(ctx context.Context, commit string) error {
-	var err error
-
+func (r *ReleaseTracker) InitRelease(ctx context.Context, commit string) (err error) {
 	err = r.stateStore.Store.StartTransaction(ctx, "initializing release")
 	if err != nil {
 		return fmt.Errorf("failed to start transaction: %w", err)
@@ -129,6 +159,17 @@ func (r *ReleaseTracker) InitRelease(ctx context.Context, commit string) error {
 	if err != nil {
 		return fmt.Errorf("failed to create runs: %w", err)
 	}
+	// Remove the reservations of any runs that were not created
+	defer func() {
+		if err == nil {
+			return
+		}
+		for jobRef := range jobs {
+			if cancelErr := refstore.CancelReservation(ctx, r.stateStore.Store, jobRef.String()); cancelErr != nil {
+				log.Error("failed to cancel run reservation", "ref", jobRef.String(), "error", cancelErr)
+			}
+		}
+	}()
 	for jobRef, fn := range jobs {
 		var t models.RunType
 		if jobRef.SubPathType == refs.SubPathTypeTask {
@@ -189,35 +230,32 @@ func (r *ReleaseTracker) UnfilteredNextRun(ctx context.Context) (map[refs.Ref]*m
 // FilteredNextRun returns any runs that are pending execution,
 // but only those that have all their inputs available.
 func (r *ReleaseTracker) FilteredNextRun(ctx context.Context) (map[refs.Ref]*models.Run, error) {
-	if err := r.stateStore.Store.StartTransaction(ctx, "populating inputs"); err != nil {
-		return nil, err
-	}
-	defer func() {
-		if err := r.stateStore.Store.CommitTransaction(ctx); err != nil {
-			log.Error("failed to commit transaction", "error", err)
-		}
-	}()
-
-	nr, err := r.UnfilteredNextRun(ctx)
-	if err != nil {
-		return nil, err
-	}
-
-	log.Info("Filtering pending runs", "runs", nr)
-
 	out := make(map[refs.Ref]*models.Run)
 
-	for rr, run := range nr {
-		missing, err := r.PopulateInputs(ctx, rr, run.Functions[len(run.Functions)-1])
+	err := r.transaction(ctx, "populating inputs", func() error {
+		nr, err := r.UnfilteredNextRun(ctx)
 		if err != nil {
-			log.Error("failed to populate inputs", "function", rr.String(), "error", err)
-			return nil, err
+			return err
 		}
-		if len(missing) == 0 {
-			out[rr] = run
-		} else {
-			log.Info("function missing inputs", "function", rr.String(), "missing", missing)
+
+		log.Info("Filtering pending runs", "runs", nr)
+
+		for rr, run := range nr {
+			missing, err := r.PopulateInputs(ctx, rr, run.Functions[len(run.Functions)-1])
+			if err != nil {
+				log.Error("failed to populate inputs", "function", rr.String(), "error", err)
+				return err
+			}
+			if len(missing) == 0 {
+				out[rr] = run
+			} else {
+				log.Info("function missing inputs", "function", rr.String(), "missing", missing)
+			}
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	log.Info("Filtered runs", "count", len(out))
@@ -241,11 +279,67 @@ func (r *ReleaseTracker) RunToPause(ctx context.Context, logger Logger) error {
 		attribute.String(AttributeCICDPipelineRunID, r.stateStore.ReleaseRef.String()),
 	)
 
+	parallelism := r.Parallelism
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
 	var (
+		spanMu  sync.Mutex
 		runCtx  map[string]context.Context = make(map[string]context.Context)
 		runSpan map[string]trace.Span      = make(map[string]trace.Span)
 	)
 
+	taskContext := func(taskName string) context.Context {
+		spanMu.Lock()
+		defer spanMu.Unlock()
+		if _, ok := runSpan[taskName]; !ok {
+			runCtx[taskName], runSpan[taskName] = tracer.Start(ctx, fmt.Sprintf("RUN %s", taskName))
+		}
+		return runCtx[taskName]
+	}
+
+	endTaskSpan := func(taskName string) {
+		spanMu.Lock()
+		defer spanMu.Unlock()
+		runSpan[taskName].End()
+	}
+
+	execute := func(runRef refs.Ref, run *models.Run) error {
+		taskName := path.Join(string(runRef.SubPathType), strings.Split(runRef.SubPath, "/")[0])
+
+		result, err := r.Run(taskContext(taskName), func(log sdk.Log) {
+			logger(runRef, log)
+		}, runRef, run)
+		if errors.Is(err, ErrRunLeased) {
+			log.Info("skipping run claimed by another worker", "run", runRef.String(), "reason", err)
+			return nil
+		}
+		if err != nil {
+			log.Error("failed to execute run", "run", runRef.String(), "error", err)
+			return fmt.Errorf("failed to execute run %s: %w", runRef.String(), err)
+		}
+
+		if result.Err != nil {
+			logger(runRef, sdk.Log{
+				Timestamp: time.Now(),
+				Message:   result.Err.Error(),
+			})
+		}
+
+		log.Info("finished executing run", "run", runRef.String())
+
+		// Check if the run or phase is now complete
+		runStatus, err := r.stateStore.GetRunStatus(ctx, runRef)
+		if err != nil {
+			return fmt.Errorf("failed to get run status: %w", err)
+		}
+		if runStatus != models.StatusRunning && runStatus != models.StatusPending {
+			endTaskSpan(taskName)
+		}
+		return nil
+	}
+
 	var (
 		nr  map[refs.Ref]*models.Run
 		err error
@@ -255,39 +349,8 @@ func (r *ReleaseTracker) RunToPause(ctx context.Context, logger Logger) error {
 			return fmt.Errorf("failed to get next functions: %w", err)
 		}
 
-		for runRef, run := range nr {
-			taskName := path.Join(string(runRef.SubPathType), strings.Split(runRef.SubPath, "/")[0])
-
-			if _, ok := runSpan[taskName]; !ok {
-				runCtx[taskName], runSpan[taskName] = tracer.Start(ctx, fmt.Sprintf("RUN %s", taskName))
-			}
-
-			var result sdk.Result
-			result, err = r.Run(runCtx[taskName], func(log sdk.Log) {
-				logger(runRef, log)
-			}, runRef, run)
-			if err != nil {
-				log.Error("failed to execute run", "run", runRef.String(), "error", err)
-				return fmt.Errorf("failed to execute run %s: %w", runRef.String(), err)
-			}
-
-			if result.Err != nil {
-				logger(runRef, sdk.Log{
-					Timestamp: time.Now(),
-					Message:   result.Err.Error(),
-				})
-			}
-
-			log.Info("finished executing run", "run", runRef.String())
-
-			// Check if the run or phase is now complete
-			runStatus, err := r.stateStore.GetRunStatus(ctx, runRef)
-			if err != nil {
-				return fmt.Errorf("failed to get run status: %w", err)
-			}
-			if runStatus != models.StatusRunning && runStatus != models.StatusPending {
-				runSpan[taskName].End()
-			}
+		if err := runConcurrently(nr, parallelism, execute); err != nil {
+			return err
 		}
 	}
 	if err != nil {
@@ -307,6 +370,53 @@ func (r *ReleaseTracker) RunToPause(ctx context.Context, logger Logger) error {
 	return nil
 }
 
+// runConcurrently calls f for each run, with at most parallelism calls in progress
+// at any time. Once a call has failed, no further runs will be started.
+// Errors from all failed calls are returned together.
+func runConcurrently(
+	runs map[refs.Ref]*models.Run,
+	parallelism int,
+	f func(runRef refs.Ref, run *models.Run) error,
+) error {
+	var (
+		wg     sync.WaitGroup
+		errMu  sync.Mutex
+		errs   []error
+		tokens = make(chan struct{}, parallelism)
+	)
+
+	failed := func() bool {
+		errMu.Lock()
+		defer errMu.Unlock()
+		return len(errs) > 0
+	}
+
+	for runRef, run := range runs {
+		tokens <- struct{}{}
+		if failed() {
+			<-tokens
+			break
+		}
+
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-tokens
+				wg.Done()
+			}()
+
+			if err := f(runRef, run); err != nil {
+				errMu.Lock()
+				errs = append(errs, err)
+				errMu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
 func (r *ReleaseTracker) Retry(ctx context.Context, logger Logger) error {
 	failedJobs, err := r.stateStore.FailedJobs(ctx)
 	if err != nil {
@@ -333,15 +443,23 @@ func (r *ReleaseTracker) Retry(ctx context.Context, logger Logger) error {
 		if err != nil {
 			return fmt.Errorf("failed to increment run ref: %w", err)
 		}
+		// Remove the reservation if the retry run is not created
+		cancelReservation := func() {
+			if err := refstore.CancelReservation(ctx, r.stateStore.Store, incrementedRunPath); err != nil {
+				log.Error("failed to cancel retry run reservation", "ref", incrementedRunPath, "error", err)
+			}
+		}
 
 		incrementedRunRef, err := refs.Parse(incrementedRunPath)
 		if err != nil {
+			cancelReservation()
 			return fmt.Errorf("failed to parse incremented run ref: %w", err)
 		}
 
 		// Update the parent run status to failed_retried as well
 		originalRunRef := ReduceToRunRef(jobRef)
 		if err := saveStatus(ctx, r.stateStore.Store, originalRunRef, models.StatusFailedRetried); err != nil {
+			cancelReservation()
 			return fmt.Errorf("failed to update original run status: %w", err)
 		}
 
@@ -356,6 +474,7 @@ func (r *ReleaseTracker) Retry(ctx context.Context, logger Logger) error {
 
 		// Initialize the state for the new run using the standalone function
 		if err := InitializeRun(ctx, r.stateStore.Store, incrementedRunRef, &retryFn); err != nil {
+			cancelReservation()
 			return fmt.Errorf("failed to initialize retry run: %w", err)
 		}
 
@@ -565,31 +684,38 @@ func (r *ReleaseTracker) Run(
 
 	log.Info("executing run", "run", runRef.String())
 
-	if err := r.stateStore.Store.StartTransaction(ctx, "execution started\n\n"+runRef.String()); err != nil {
-		return sdk.Result{}, fmt.Errorf("failed to start transaction: %w", err)
+	release, err := r.claimRun(ctx, runRef)
+	if err != nil {
+		return sdk.Result{}, fmt.Errorf("failed to claim run: %w", err)
 	}
+	defer release()
 
-	// Set status of work
-	if err := saveStatus(ctx, r.stateStore.Store, runRef, models.StatusRunning); err != nil {
-		return sdk.Result{}, fmt.Errorf("failed to save status: %w", err)
-	}
+	err = r.transaction(ctx, "execution started\n\n"+runRef.String(), func() error {
+		// Another worker may have executed the run and released its lease
+		// since the run was found to be pending. Starting a transaction
+		// clears any cache, so the status read here is current.
+		status, err := GetRunStatus(ctx, r.stateStore.Store, runRef)
+		if err != nil {
+			return fmt.Errorf("failed to get run status: %w", err)
+		}
+		if status != models.StatusPending && status != models.StatusPaused {
+			return fmt.Errorf("%w: run is already %s", ErrRunLeased, status)
+		}
 
-	// Set intent if appropriate
-	if err := r.updateIntent(ctx, runRef, run); err != nil {
-		return sdk.Result{}, fmt.Errorf("failed to update intent state: %w", err)
-	}
-	if err := r.stateStore.Store.CommitTransaction(ctx); err != nil {
-		log.Error("failed to commit transaction", "error", err)
-	}
+		// Set status of work
+		if err := saveStatus(ctx, r.stateStore.Store, runRef, models.StatusRunning); err != nil {
+			return fmt.Errorf("failed to save status: %w", err)
+		}
 
-	if err := r.stateStore.Store.StartTransaction(ctx, "execution finished\n\n"+runRef.String()); err != nil {
-		return sdk.Result{}, fmt.Errorf("failed to start transaction: %w", err)
-	}
-	defer func() {
-		if err := r.stateStore.Store.CommitTransaction(ctx); err != nil {
-			log.Error("failed to commit transaction", "error", err)
+		// Set intent if appropriate
+		if err := r.updateIntent(ctx, runRef, run); err != nil {
+			return fmt.Errorf("failed to update intent state: %w", err)
 		}
-	}()
+		return nil
+	})
+	if err != nil {
+		return sdk.Result{}, err
+	}
 
 	// Start from the final function in the run
 	fn := run.Functions[len(run.Functions)-1]
@@ -612,6 +738,8 @@ func (r *ReleaseTracker) Run(
 			logger(log)
 		}
 
+		// The function is executed outside of a transaction so that concurrent
+		// runs are not blocked while it is in progress.
 		result, err := r.config.Run(
 			ctx,
 			fn.Fn,
@@ -628,9 +756,14 @@ func (r *ReleaseTracker) Run(
 			result.Done = &sdk.Done{}
 		}
 
-		// Record the result of this function to the state store
-		if err := r.saveRunState(ctx, runRef, run, result, logs); err != nil {
-			return result, fmt.Errorf("failed to save work state: %w", err)
+		var next *models.Function
+		err = r.transaction(ctx, "execution finished\n\n"+runRef.String(), func() error {
+			var err error
+			next, err = r.recordResult(ctx, runRef, run, result, logs)
+			return err
+		})
+		if err != nil {
+			return result, err
 		}
 
 		if result.Err != nil {
@@ -647,39 +780,63 @@ func (r *ReleaseTracker) Run(
 			attribute.String(AttributeCICDPipelineTaskRunResult, "success"),
 		)
 
-		if result.Done != nil {
+		if next == nil {
 			return result, nil
 		}
+		fn = next
+	}
+	return sdk.Result{}, errors.New("next or done was not called")
+}
 
-		if result.Next != nil {
-			nextFunction := &models.Function{
-				Fn:     result.Next.Fn,
-				Inputs: result.Next.Inputs,
-			}
-			if err := validateFunction(nextFunction); err != nil {
-				return sdk.Result{}, fmt.Errorf("failed to validate function: %w", err)
-			}
+// recordResult saves the result of a function to the state store.
+// If the function called next() and all inputs for the next function are available,
+// the next function is returned so it may be executed immediately.
+func (r *ReleaseTracker) recordResult(
+	ctx context.Context,
+	runRef refs.Ref,
+	run *models.Run,
+	result sdk.Result,
+	logs []sdk.Log,
+) (*models.Function, error) {
+	// Record the result of this function to the state store
+	if err := r.saveRunState(ctx, runRef, run, result, logs); err != nil {
+		return nil, fmt.Errorf("failed to save work state: %w", err)
+	}
 
-			nextFunction.Inputs, err = PopulateInputs(ctx, r.stateStore.Store, nextFunction.Inputs)
-			if err != nil {
-				return sdk.Result{}, fmt.Errorf("failed to populate inputs for %s: %w", nextFunction.Fn.Name, err)
-			}
-			run.Functions = append(run.Functions, nextFunction)
-
-			missing := GetMissing(nextFunction.Inputs)
-			if len(missing) > 0 {
-				log.Info("Next function was missing inputs", "missing", missing)
-				// Update state to ensure we capture the next function
-				if err := r.saveRunState(ctx, runRef, run, result, logs); err != nil {
-					return result, fmt.Errorf("failed to save run state: %w", err)
-				}
-				return result, nil
-			}
+	if result.Err != nil || result.Done != nil {
+		return nil, nil
+	}
+
+	if result.Next == nil {
+		return nil, errors.New("next or done was not called")
+	}
+
+	nextFunction := &models.Function{
+		Fn:     result.Next.Fn,
+		Inputs: result.Next.Inputs,
+	}
+	if err := validateFunction(nextFunction); err != nil {
+		return nil, fmt.Errorf("failed to validate function: %w", err)
+	}
+
+	var err error
+	nextFunction.Inputs, err = PopulateInputs(ctx, r.stateStore.Store, nextFunction.Inputs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to populate inputs for %s: %w", nextFunction.Fn.Name, err)
+	}
+	run.Functions = append(run.Functions, nextFunction)
 
-			fn = nextFunction
+	missing := GetMissing(nextFunction.Inputs)
+	if len(missing) > 0 {
+		log.Info("Next function was missing inputs", "missing", missing)
+		// Update state to ensure we capture the next function
+		if err := r.saveRunState(ctx, runRef, run, result, logs); err != nil {
+			return nil, fmt.Errorf("failed to save run state: %w", err)
 		}
+		return nil, nil
 	}
-	return sdk.Result{}, errors.New("next or done was not called")
+
+	return nextFunction, nil
 }
 
 func ResultToStatus(result sdk.Result) models.Status {
@@ -724,7 +881,7 @@ func (r *ReleaseTracker) saveRunState(ctx context.Context, runRef refs.Ref, run
 
 	if result.Done != nil {
 		run.WatchFiles = result.Done.Watch
-		run.Outputs = result.Done.Outputs
+		run.Outputs = refstore.MarkSensitive(result.Done.Outputs, result.Done.Sensitive)
 	}
 
 	if err := r.stateStore.Store.Set(ctx, runRef.String(), run); err != nil {
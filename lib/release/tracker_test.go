@@ -0,0 +1,238 @@
+package release
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/ocuroot/ocuroot/refs"
+	"github.com/ocuroot/ocuroot/refs/refstore"
+	"github.com/ocuroot/ocuroot/sdk"
+	"github.com/ocuroot/ocuroot/store/models"
+	"go.starlark.net/starlark"
+)
+
+const parallelPackage = `ocuroot("0.3.0")
+
+def a():
+    print("a")
+    return done()
+
+def b():
+    print("b")
+    return done()
+
+def c():
+    print("c")
+    fail("c failed")
+
+def d():
+    print("d")
+    return done()
+
+phase(name="first", tasks=[task(a, name="a"), task(b, name="b"), task(%s, name="c")])
+phase(name="second", tasks=[task(d, name="d")])
+`
+
+// checkedStore records a violation if transactions overlap, and fails Sets
+// to refs with a configured suffix.
+type checkedStore struct {
+	refstore.ConditionalStore
+
+	open       atomic.Int32
+	overlapped atomic.Bool
+
+	failSuffixes []string
+}
+
+func (s *checkedStore) StartTransaction(ctx context.Context, message string) error {
+	if s.open.Add(1) > 1 {
+		s.overlapped.Store(true)
+	}
+	return s.ConditionalStore.StartTransaction(ctx, message)
+}
+
+func (s *checkedStore) CommitTransaction(ctx context.Context) error {
+	s.open.Add(-1)
+	return s.ConditionalStore.CommitTransaction(ctx)
+}
+
+func (s *checkedStore) Set(ctx context.Context, ref string, v any) error {
+	for _, suffix := range s.failSuffixes {
+		if strings.HasSuffix(ref, suffix) {
+			return fmt.Errorf("injected failure setting %s", ref)
+		}
+	}
+	return s.ConditionalStore.Set(ctx, ref, v)
+}
+
+func newTestStore(t *testing.T) *refstore.FSStateStore {
+	t.Helper()
+	store, err := refstore.NewFSRefStore(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		store.Close()
+	})
+	return store
+}
+
+func newTestTracker(t *testing.T, source string, state refstore.Store) *ReleaseTracker {
+	t.Helper()
+	ctx := context.Background()
+
+	config, err := sdk.LoadConfig(
+		ctx,
+		sdk.NewFSResolver(fstest.MapFS{
+			"pkg.ocu.star": &fstest.MapFile{Data: []byte(source)},
+		}),
+		"pkg.ocu.star",
+		sdk.NewMockBackend(),
+		func(thread *starlark.Thread, msg string) {},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	releaseRef, err := refs.Parse("repo/-/pkg.ocu.star/@r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker, err := NewReleaseTracker(ctx, config, config.Package, releaseRef, newTestStore(t), state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tracker.InitRelease(ctx, "abc123"); err != nil {
+		t.Fatal(err)
+	}
+	return tracker
+}
+
+// firstPhaseBarrier returns a logger that records the order tasks first log
+// in, and holds each task in the first phase until all of them have started.
+func firstPhaseBarrier(t *testing.T) (Logger, func() []string) {
+	var (
+		mu      sync.Mutex
+		order   []string
+		started sync.WaitGroup
+		all     = make(chan struct{})
+	)
+	started.Add(3)
+	go func() {
+		started.Wait()
+		close(all)
+	}()
+
+	logger := func(fnRef refs.Ref, l sdk.Log) {
+		task := strings.Split(fnRef.SubPath, "/")[0]
+		mu.Lock()
+		first := !slices.Contains(order, task)
+		if first {
+			order = append(order, task)
+		}
+		mu.Unlock()
+
+		if !first || task == "d" {
+			return
+		}
+		started.Done()
+		select {
+		case <-all:
+		case <-time.After(10 * time.Second):
+			t.Errorf("task %s was not run concurrently with the rest of its phase", task)
+		}
+	}
+	return logger, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Clone(order)
+	}
+}
+
+// taskRun returns the ref of the first run of a task in a release.
+func taskRun(releaseRef refs.Ref, task string) refs.Ref {
+	return releaseRef.SetSubPathType(refs.SubPathTypeTask).SetSubPath(task + "/1")
+}
+
+func runStatus(t *testing.T, tracker *ReleaseTracker, task string) models.Status {
+	t.Helper()
+	status, err := tracker.stateStore.GetRunStatus(context.Background(), taskRun(tracker.ReleaseRef, task))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return status
+}
+
+func TestRunToPauseParallel(t *testing.T) {
+	store := &checkedStore{ConditionalStore: newTestStore(t)}
+	tracker := newTestTracker(t, fmt.Sprintf(parallelPackage, "b"), store)
+	tracker.Parallelism = 3
+
+	logger, order := firstPhaseBarrier(t)
+	if err := tracker.RunToPause(context.Background(), logger); err != nil {
+		t.Fatal(err)
+	}
+
+	got := order()
+	if len(got) != 4 || got[3] != "d" {
+		t.Errorf("expected the second phase to run after the first, got %v", got)
+	}
+	for _, task := range []string{"a", "b", "c", "d"} {
+		if status := runStatus(t, tracker, task); status != models.StatusComplete {
+			t.Errorf("expected %s to be complete, got %s", task, status)
+		}
+	}
+	if store.overlapped.Load() {
+		t.Error("transactions from concurrent runs overlapped")
+	}
+}
+
+func TestRunToPauseParallelFailures(t *testing.T) {
+	store := &checkedStore{ConditionalStore: newTestStore(t)}
+	tracker := newTestTracker(t, fmt.Sprintf(parallelPackage, "c"), store)
+	tracker.Parallelism = 3
+
+	// Recording the results of a and b fails, while c fails itself
+	aRun := taskRun(tracker.ReleaseRef, "a")
+	bRun := taskRun(tracker.ReleaseRef, "b")
+	store.failSuffixes = []string{
+		aRun.JoinSubPath(statusPathSegment, string(models.StatusComplete)).String(),
+		bRun.JoinSubPath(statusPathSegment, string(models.StatusComplete)).String(),
+	}
+
+	logger, order := firstPhaseBarrier(t)
+	err := tracker.RunToPause(context.Background(), logger)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	var joined interface{ Unwrap() []error }
+	if !errors.As(err, &joined) || len(joined.Unwrap()) != 2 {
+		t.Fatalf("expected errors from both failed runs, got %v", err)
+	}
+	for _, runRef := range []refs.Ref{aRun, bRun} {
+		if !strings.Contains(err.Error(), runRef.String()) {
+			t.Errorf("expected error to include %s, got %v", runRef.String(), err)
+		}
+	}
+
+	if slices.Contains(order(), "d") {
+		t.Error("expected the second phase not to run")
+	}
+	if status := runStatus(t, tracker, "c"); status != models.StatusFailed {
+		t.Errorf("expected c to have failed, got %s", status)
+	}
+	if status := runStatus(t, tracker, "d"); status != models.StatusPending {
+		t.Errorf("expected d to be pending, got %s", status)
+	}
+	if store.overlapped.Load() {
+		t.Error("transactions from concurrent runs overlapped")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"path"
+	"strconv"
 	"strings"
 )
 
@@ -107,6 +108,10 @@ func (r Ref) Valid() error {
 		}
 	}
 
+	if err := r.Release.Valid(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -152,6 +157,93 @@ func (r Ref) CurrentRelease() bool {
 	return r.Release.CurrentRelease()
 }
 
+const (
+	selectorPrevious = "~"
+	selectorDeployed = "deployed:"
+	selectorTag      = "tag:"
+)
+
+// ReleaseSelector picks a release relative to others rather than by its ID.
+// Selectors are written in place of a release:
+//
+//	@~1                   the release before the latest
+//	@r5~2                 the second release before r5
+//	@deployed:production  the release currently deployed to production
+//	@tag:v1.*             the newest release with a tag matching v1.*
+//
+// An offset may follow any selector, such as @deployed:production~1. Within a
+// release, ./@~1 is the release before that one.
+//
+// Selectors are resolved to a release ID by the store.
+type ReleaseSelector struct {
+	// Base is the release to start from, the latest if empty.
+	Base Release
+	// Deployed is the environment to find the deployed release in.
+	Deployed string
+	// Tag is a glob matching tags to find the newest release for.
+	Tag string
+	// Previous is the number of releases to step back from the selected one.
+	Previous int
+}
+
+// Valid returns an error if the release is a selector without an environment
+// or tag to select from.
+func (r Release) Valid() error {
+	s := string(r)
+	if i := strings.LastIndex(s, selectorPrevious); i >= 0 {
+		s = s[:i]
+	}
+	if s == selectorDeployed {
+		return fmt.Errorf("release selector %q must specify an environment", r)
+	}
+	if s == selectorTag {
+		return fmt.Errorf("release selector %q must specify a tag", r)
+	}
+	return nil
+}
+
+// Selector parses a release selector, returning false if the release is a
+// plain ID or tag.
+func (r Release) Selector() (ReleaseSelector, bool) {
+	var (
+		out      ReleaseSelector
+		selector bool
+	)
+
+	s := string(r)
+	if i := strings.LastIndex(s, selectorPrevious); i >= 0 {
+		count := s[i+len(selectorPrevious):]
+		out.Previous = 1
+		if count != "" {
+			n, err := strconv.Atoi(count)
+			if err != nil || n < 0 {
+				return ReleaseSelector{}, false
+			}
+			out.Previous = n
+		}
+		s = s[:i]
+		selector = true
+	}
+
+	switch {
+	case strings.HasPrefix(s, selectorDeployed):
+		out.Deployed = strings.TrimPrefix(s, selectorDeployed)
+		if out.Deployed == "" {
+			return ReleaseSelector{}, false
+		}
+		selector = true
+	case strings.HasPrefix(s, selectorTag):
+		out.Tag = strings.TrimPrefix(s, selectorTag)
+		if out.Tag == "" {
+			return ReleaseSelector{}, false
+		}
+		selector = true
+	default:
+		out.Base = Release(s)
+	}
+	return out, selector
+}
+
 // RelativeTo will return a ref based on this ref, but
 // with the repo, package and release of the input ref if empty.
 func (r Ref) RelativeTo(ref Ref) (Ref, error) {
@@ -179,7 +271,12 @@ func (r Ref) RelativeTo(ref Ref) (Ref, error) {
 	}
 
 	if r.hasRelease && ref.hasRelease {
-		if r.Release != "" {
+		if selector, ok := r.Release.Selector(); ok {
+			// Offsets within a release are from that release
+			if selector.Base == "" && selector.Deployed == "" && selector.Tag == "" {
+				out.Release = ref.Release + r.Release
+			}
+		} else if r.Release != "" {
 			out.Release = ref.Release
 		}
 	}
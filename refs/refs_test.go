@@ -82,6 +82,16 @@ func TestRelativeTo(t *testing.T) {
 			relativeTo: "minimal/repo.git/-/",
 			expected:   "minimal/repo.git/-/@v3",
 		},
+		{
+			ref:        "./@~1/task/build#output/image",
+			relativeTo: "github.com/org/repo/-/path/to/package/@r5",
+			expected:   "github.com/org/repo/-/path/to/package/@r5~1/task/build#output/image",
+		},
+		{
+			ref:        "./@deployed:production/deploy/production",
+			relativeTo: "github.com/org/repo/-/path/to/package/@r5",
+			expected:   "github.com/org/repo/-/path/to/package/@deployed:production/deploy/production",
+		},
 	}
 
 	for _, test := range tests {
@@ -111,6 +121,48 @@ func TestRelativeTo(t *testing.T) {
 	}
 }
 
+func TestReleaseSelector(t *testing.T) {
+	var tests = []struct {
+		release  Release
+		expected ReleaseSelector
+		selector bool
+	}{
+		{release: "", selector: false},
+		{release: "r5", expected: ReleaseSelector{Base: "r5"}, selector: false},
+		{release: "v1.0.0", expected: ReleaseSelector{Base: "v1.0.0"}, selector: false},
+		{release: "~", expected: ReleaseSelector{Previous: 1}, selector: true},
+		{release: "~2", expected: ReleaseSelector{Previous: 2}, selector: true},
+		{release: "r5~1", expected: ReleaseSelector{Base: "r5", Previous: 1}, selector: true},
+		{release: "deployed:production", expected: ReleaseSelector{Deployed: "production"}, selector: true},
+		{release: "deployed:production~1", expected: ReleaseSelector{Deployed: "production", Previous: 1}, selector: true},
+		{release: "tag:v1.*", expected: ReleaseSelector{Tag: "v1.*"}, selector: true},
+		{release: "deployed:", selector: false},
+		{release: "deployed:~1", selector: false},
+		{release: "tag:~1", selector: false},
+		{release: "r5~x", selector: false},
+	}
+
+	for _, test := range tests {
+		t.Run(string(test.release), func(t *testing.T) {
+			got, ok := test.release.Selector()
+			if ok != test.selector {
+				t.Fatalf("expected selector to be %v, got %v", test.selector, ok)
+			}
+			if ok && got != test.expected {
+				t.Errorf("expected %+v, got %+v", test.expected, got)
+			}
+		})
+	}
+
+	ref, err := Parse("github.com/org/repo/-/path/to/package/@tag:v1.*/deploy/production#output/host")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref.Release != "tag:v1.*" || ref.SubPath != "production" || ref.Fragment != "output/host" {
+		t.Errorf("unexpected ref: %s", ref.DebugString())
+	}
+}
+
 func TestRefStructure(t *testing.T) {
 	var refs = []struct {
 		ref      string
@@ -399,6 +451,9 @@ func TestInvalidRefs(t *testing.T) {
 	var refs = []string{
 		"repo.git/package/@/invalid/sub/path",
 		"repo.git/package@/deploy/hello",
+		"repo.git/-/package/@deployed:/deploy/hello",
+		"repo.git/-/package/@deployed:~1/deploy/hello",
+		"repo.git/-/package/@tag:~1",
 	}
 	for _, ref := range refs {
 		t.Run(ref, func(t *testing.T) {
@@ -0,0 +1,542 @@
+package refstore
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// ArchiveVersion is the version of the archive format written by
+// WriteArchive. Archives with a later version cannot be read.
+const ArchiveVersion = 1
+
+const (
+	archiveManifestFile     = "manifest.json"
+	archiveRefsFile         = "refs.jsonl"
+	archiveLinksFile        = "links.jsonl"
+	archiveDependenciesFile = "dependencies.jsonl"
+)
+
+// ErrUnsupportedArchive is returned when reading an archive written in a
+// newer format.
+var ErrUnsupportedArchive = errors.New("unsupported archive version")
+
+// An archive is a zstd compressed tarball holding a manifest and, for each
+// store, a directory of JSON Lines files:
+//
+//	manifest.json
+//	state/refs.jsonl
+//	state/links.jsonl
+//	state/dependencies.jsonl
+//	intent/...
+//
+// Each line of refs.jsonl is an ArchiveRef, links.jsonl an ArchiveLink and
+// dependencies.jsonl an ArchiveDependency. Lines are sorted by ref, so that
+// archives of the same contents are identical.
+
+// ArchiveManifest describes the contents of an archive.
+type ArchiveManifest struct {
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+	// Globs are the patterns used to select refs for export.
+	Globs []string `json:"globs"`
+	// Stores are the names of the stores in the archive, in order.
+	Stores []string `json:"stores"`
+}
+
+// ArchiveRef is a ref and its value.
+type ArchiveRef struct {
+	Ref      string          `json:"ref"`
+	Body     json.RawMessage `json:"body"`
+	Metadata *ObjectMetadata `json:"metadata,omitempty"`
+}
+
+// ArchiveLink is a link from one ref to another.
+type ArchiveLink struct {
+	Ref    string `json:"ref"`
+	Target string `json:"target"`
+}
+
+// ArchiveDependency is a dependency of one ref on another.
+type ArchiveDependency struct {
+	Ref        string `json:"ref"`
+	Dependency string `json:"dependency"`
+}
+
+// ArchiveContents is everything exported from a single store.
+type ArchiveContents struct {
+	StoreContents
+	// Metadata maps refs to the metadata recorded for them, where the store
+	// supports it.
+	Metadata map[string]ObjectMetadata
+}
+
+// Archive is the decoded content of an archive.
+type Archive struct {
+	Manifest ArchiveManifest
+	Stores   map[string]*ArchiveContents
+}
+
+// NamedStore is a store to be included in an archive.
+type NamedStore struct {
+	Name  string
+	Store Store
+}
+
+// ExportArchive reads the refs matching the globs from each store and
+// returns them as an archive.
+func ExportArchive(ctx context.Context, stores []NamedStore, glob ...string) (*Archive, error) {
+	if len(glob) == 0 {
+		glob = []string{"**"}
+	}
+
+	archive := &Archive{
+		Manifest: ArchiveManifest{
+			Version: ArchiveVersion,
+			Created: time.Now().UTC(),
+			Globs:   glob,
+		},
+		Stores: make(map[string]*ArchiveContents),
+	}
+	for _, s := range stores {
+		contents, err := ReadContentsMatching(ctx, s.Store, glob...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", s.Name, err)
+		}
+
+		metadata := make(map[string]ObjectMetadata)
+		for ref := range contents.Values {
+			meta, err := GetMetadata(ctx, s.Store, ref)
+			if errors.Is(err, ErrMetadataUnsupported) {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to get metadata for %s: %w", ref, err)
+			}
+			if !meta.IsEmpty() {
+				metadata[ref] = meta
+			}
+		}
+
+		archive.Manifest.Stores = append(archive.Manifest.Stores, s.Name)
+		archive.Stores[s.Name] = &ArchiveContents{
+			StoreContents: *contents,
+			Metadata:      metadata,
+		}
+	}
+	return archive, nil
+}
+
+// WriteTo writes the archive to w.
+func (a *Archive) WriteTo(w io.Writer) (int64, error) {
+	counter := &countingWriter{w: w}
+	zw, err := zstd.NewWriter(counter)
+	if err != nil {
+		return counter.n, err
+	}
+	tw := tar.NewWriter(zw)
+
+	manifest, err := json.MarshalIndent(a.Manifest, "", "  ")
+	if err != nil {
+		return counter.n, err
+	}
+	if err := writeTarFile(tw, archiveManifestFile, manifest, a.Manifest.Created); err != nil {
+		return counter.n, err
+	}
+
+	for _, name := range a.Manifest.Stores {
+		contents := a.Stores[name]
+
+		var refLines []any
+		for _, ref := range sortedKeys(contents.Values) {
+			line := ArchiveRef{Ref: ref, Body: contents.Values[ref]}
+			if meta, ok := contents.Metadata[ref]; ok {
+				line.Metadata = &meta
+			}
+			refLines = append(refLines, line)
+		}
+		var linkLines []any
+		for _, link := range sortedKeys(contents.Links) {
+			linkLines = append(linkLines, ArchiveLink{Ref: link, Target: contents.Links[link]})
+		}
+		var dependencyLines []any
+		for _, ref := range sortedKeys(contents.Dependencies) {
+			for _, dep := range contents.Dependencies[ref] {
+				dependencyLines = append(dependencyLines, ArchiveDependency{Ref: ref, Dependency: dep})
+			}
+		}
+
+		files := []struct {
+			name  string
+			lines []any
+		}{
+			{archiveRefsFile, refLines},
+			{archiveLinksFile, linkLines},
+			{archiveDependenciesFile, dependencyLines},
+		}
+		for _, file := range files {
+			content, err := encodeJSONLines(file.lines)
+			if err != nil {
+				return counter.n, fmt.Errorf("failed to encode %s/%s: %w", name, file.name, err)
+			}
+			if err := writeTarFile(tw, path.Join(name, file.name), content, a.Manifest.Created); err != nil {
+				return counter.n, err
+			}
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return counter.n, err
+	}
+	if err := zw.Close(); err != nil {
+		return counter.n, err
+	}
+	return counter.n, nil
+}
+
+// ReadArchive reads an archive written by Archive.WriteTo.
+func ReadArchive(r io.Reader) (*Archive, error) {
+	zr, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress archive: %w", err)
+	}
+	defer zr.Close()
+
+	archive := &Archive{
+		Stores: make(map[string]*ArchiveContents),
+	}
+	var foundManifest bool
+
+	tr := tar.NewReader(zr)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		if header.Name == archiveManifestFile {
+			if err := json.NewDecoder(tr).Decode(&archive.Manifest); err != nil {
+				return nil, fmt.Errorf("failed to decode manifest: %w", err)
+			}
+			if archive.Manifest.Version > ArchiveVersion {
+				return nil, fmt.Errorf("%w: %d", ErrUnsupportedArchive, archive.Manifest.Version)
+			}
+			foundManifest = true
+			continue
+		}
+
+		storeName, file := path.Split(header.Name)
+		storeName = strings.TrimSuffix(storeName, "/")
+		if storeName == "" {
+			continue
+		}
+		contents := archive.Stores[storeName]
+		if contents == nil {
+			contents = newArchiveContents()
+			archive.Stores[storeName] = contents
+		}
+		if err := contents.readFile(file, tr); err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", header.Name, err)
+		}
+	}
+
+	if !foundManifest {
+		return nil, fmt.Errorf("archive has no %s", archiveManifestFile)
+	}
+	for _, name := range archive.Manifest.Stores {
+		if archive.Stores[name] == nil {
+			archive.Stores[name] = newArchiveContents()
+		}
+	}
+	return archive, nil
+}
+
+func newArchiveContents() *ArchiveContents {
+	return &ArchiveContents{
+		StoreContents: StoreContents{
+			Values:       make(map[string]json.RawMessage),
+			Links:        make(map[string]string),
+			Dependencies: make(map[string][]string),
+		},
+		Metadata: make(map[string]ObjectMetadata),
+	}
+}
+
+// readFile adds the lines of a file from an archive to the contents.
+// Unknown files are ignored.
+func (c *ArchiveContents) readFile(name string, r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	// Values may be much larger than the default limit of a line
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<30)
+
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		switch name {
+		case archiveRefsFile:
+			var ref ArchiveRef
+			if err := json.Unmarshal(line, &ref); err != nil {
+				return err
+			}
+			c.Values[ref.Ref] = ref.Body
+			if ref.Metadata != nil {
+				c.Metadata[ref.Ref] = *ref.Metadata
+			}
+		case archiveLinksFile:
+			var link ArchiveLink
+			if err := json.Unmarshal(line, &link); err != nil {
+				return err
+			}
+			c.Links[link.Ref] = link.Target
+		case archiveDependenciesFile:
+			var dep ArchiveDependency
+			if err := json.Unmarshal(line, &dep); err != nil {
+				return err
+			}
+			c.Dependencies[dep.Ref] = append(c.Dependencies[dep.Ref], dep.Dependency)
+		default:
+			return nil
+		}
+	}
+	return scanner.Err()
+}
+
+type ImportMode string
+
+const (
+	// ImportModeMerge adds the contents of an archive to a store, replacing
+	// refs that exist in both.
+	ImportModeMerge ImportMode = "merge"
+	// ImportModeReplace removes refs matching the globs the archive was
+	// exported with before adding the contents of the archive, so that the
+	// matching refs in the store are exactly those in the archive.
+	ImportModeReplace ImportMode = "replace"
+)
+
+type ImportOptions struct {
+	// Mode defaults to ImportModeMerge.
+	Mode ImportMode
+	// RenameRepos maps repo names or aliases in the archive to the repo
+	// names they are imported as. Refs in values, links and dependencies
+	// are renamed, including refs in strings within values.
+	RenameRepos map[string]string
+}
+
+// ImportArchive writes the contents of a store in an archive to another
+// store in a single transaction.
+func ImportArchive(ctx context.Context, store Store, archive *Archive, storeName string, options ImportOptions) (*StoreContents, error) {
+	contents, ok := archive.Stores[storeName]
+	if !ok {
+		return nil, fmt.Errorf("archive does not contain %s", storeName)
+	}
+	if options.Mode == "" {
+		options.Mode = ImportModeMerge
+	}
+
+	renamed, err := renameContents(&contents.StoreContents, options.RenameRepos)
+	if err != nil {
+		return nil, err
+	}
+
+	var existing *StoreContents
+	switch options.Mode {
+	case ImportModeMerge:
+	case ImportModeReplace:
+		var globs []string
+		for _, glob := range archive.Manifest.Globs {
+			globs = append(globs, renameRepo(glob, options.RenameRepos))
+		}
+		existing, err = ReadContentsMatching(ctx, store, globs...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read existing refs: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown import mode: %s", options.Mode)
+	}
+
+	if err := store.StartTransaction(ctx, "import state"); err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+
+	if existing != nil {
+		if err := removeContents(ctx, store, existing); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, ref := range sortedKeys(renamed.Values) {
+		if err := store.Set(ctx, ref, renamed.Values[ref]); err != nil {
+			return nil, fmt.Errorf("failed to set %s: %w", ref, err)
+		}
+	}
+	for _, link := range sortedKeys(renamed.Links) {
+		target := renamed.Links[link]
+		if err := store.Link(ctx, link, target); err != nil {
+			return nil, fmt.Errorf("failed to link %s to %s: %w", link, target, err)
+		}
+	}
+	for _, ref := range sortedKeys(renamed.Dependencies) {
+		for _, dep := range renamed.Dependencies[ref] {
+			if err := store.AddDependency(ctx, ref, dep); err != nil {
+				return nil, fmt.Errorf("failed to add dependency of %s on %s: %w", ref, dep, err)
+			}
+		}
+	}
+
+	if err := store.CommitTransaction(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return renamed, nil
+}
+
+// removeContents removes links, dependencies and refs from a store.
+// Links are removed first, so deleting refs does not follow them.
+func removeContents(ctx context.Context, store Store, contents *StoreContents) error {
+	for _, link := range sortedKeys(contents.Links) {
+		if err := store.Unlink(ctx, link); err != nil {
+			return fmt.Errorf("failed to unlink %s: %w", link, err)
+		}
+	}
+	for _, ref := range sortedKeys(contents.Dependencies) {
+		for _, dep := range contents.Dependencies[ref] {
+			if err := store.RemoveDependency(ctx, ref, dep); err != nil {
+				return fmt.Errorf("failed to remove dependency of %s on %s: %w", ref, dep, err)
+			}
+		}
+	}
+	for _, ref := range sortedKeys(contents.Values) {
+		if err := store.Delete(ctx, ref); err != nil && !errors.Is(err, ErrRefNotFound) {
+			return fmt.Errorf("failed to delete %s: %w", ref, err)
+		}
+	}
+	return nil
+}
+
+// renameContents returns a copy of the contents with repos renamed.
+func renameContents(contents *StoreContents, repos map[string]string) (*StoreContents, error) {
+	out := &StoreContents{
+		Values:       make(map[string]json.RawMessage),
+		Links:        make(map[string]string),
+		Dependencies: make(map[string][]string),
+	}
+	for ref, value := range contents.Values {
+		if len(repos) > 0 {
+			var err error
+			value, err = renameReposInJSON(value, repos)
+			if err != nil {
+				return nil, fmt.Errorf("failed to rename repos in %s: %w", ref, err)
+			}
+		}
+		out.Values[renameRepo(ref, repos)] = value
+	}
+	for link, target := range contents.Links {
+		out.Links[renameRepo(link, repos)] = renameRepo(target, repos)
+	}
+	for ref, deps := range contents.Dependencies {
+		renamedRef := renameRepo(ref, repos)
+		for _, dep := range deps {
+			out.Dependencies[renamedRef] = append(out.Dependencies[renamedRef], renameRepo(dep, repos))
+		}
+	}
+	return out, nil
+}
+
+// renameRepo replaces the repo of a ref, or a glob that begins with a repo.
+// Strings without a repo, or with a repo not in the map, are unchanged.
+func renameRepo(ref string, repos map[string]string) string {
+	repo, rest, ok := strings.Cut(ref, "/-/")
+	if !ok {
+		return ref
+	}
+	if renamed, ok := repos[repo]; ok {
+		return renamed + "/-/" + rest
+	}
+	return ref
+}
+
+// renameReposInJSON renames repos in every string in a JSON document.
+func renameReposInJSON(value json.RawMessage, repos map[string]string) (json.RawMessage, error) {
+	var v any
+	decoder := json.NewDecoder(bytes.NewReader(value))
+	decoder.UseNumber()
+	if err := decoder.Decode(&v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(renameReposInValue(v, repos))
+}
+
+func renameReposInValue(v any, repos map[string]string) any {
+	switch v := v.(type) {
+	case string:
+		return renameRepo(v, repos)
+	case []any:
+		for i, item := range v {
+			v[i] = renameReposInValue(item, repos)
+		}
+		return v
+	case map[string]any:
+		for key, item := range v {
+			v[key] = renameReposInValue(item, repos)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+func encodeJSONLines(lines []any) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, line := range lines {
+		if err := encoder.Encode(line); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func writeTarFile(tw *tar.Writer, name string, content []byte, modTime time.Time) error {
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     int64(len(content)),
+		Mode:     0644,
+		ModTime:  modTime,
+	}); err != nil {
+		return fmt.Errorf("failed to write header for %s: %w", name, err)
+	}
+	if _, err := tw.Write(content); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,118 @@
+package refstore
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestArchiveRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	tempDir := t.TempDir()
+
+	from, err := NewFSRefStore(filepath.Join(tempDir, "fs"), map[string]struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, err := NewSQLiteRefStore(filepath.Join(tempDir, "state.db"), map[string]struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = to.Close()
+	})
+
+	release := "github.com/example/repo.git/-/path/to/package/@r1"
+	deploy := release + "/deploy/staging"
+	other := "github.com/example/other.git/-/package/@r1"
+	if err := from.Set(ctx, release, map[string]any{"commit": "abc"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := from.Set(ctx, deploy, map[string]any{"release": release}); err != nil {
+		t.Fatal(err)
+	}
+	if err := from.Set(ctx, other, map[string]any{"commit": "def"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := from.Link(ctx, "github.com/example/repo.git/-/path/to/package/@", release); err != nil {
+		t.Fatal(err)
+	}
+	if err := from.AddDependency(ctx, deploy, release); err != nil {
+		t.Fatal(err)
+	}
+
+	exported, err := ExportArchive(ctx, []NamedStore{{Name: "state", Store: from}}, "github.com/example/repo.git/-/**")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := exported.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	archive, err := ReadArchive(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents := archive.Stores["state"]
+	if len(contents.Values) != 2 || len(contents.Links) != 1 || contents.DependencyCount() != 1 {
+		t.Fatalf("unexpected contents: %+v", contents.StoreContents)
+	}
+	if contents.Metadata[release].BodyType != "map[string]interface {}" {
+		t.Errorf("unexpected metadata: %+v", contents.Metadata[release])
+	}
+
+	// A ref that is not in the archive, which is removed when replacing
+	stale := "renamed/-/path/to/package/@r0"
+	if err := to.Set(ctx, stale, map[string]any{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := to.Set(ctx, other, map[string]any{}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ImportArchive(ctx, to, archive, "state", ImportOptions{
+		Mode:        ImportModeReplace,
+		RenameRepos: map[string]string{"github.com/example/repo.git": "renamed"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := to.Match(ctx, "**")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		other,
+		"renamed/-/path/to/package/@",
+		"renamed/-/path/to/package/@r1",
+		"renamed/-/path/to/package/@r1/deploy/staging",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected refs %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("expected refs %v, got %v", want, got)
+			break
+		}
+	}
+
+	var deployValue map[string]any
+	if err := to.Get(ctx, "renamed/-/path/to/package/@r1/deploy/staging", &deployValue); err != nil {
+		t.Fatal(err)
+	}
+	if deployValue["release"] != "renamed/-/path/to/package/@r1" {
+		t.Errorf("expected ref in value to be renamed, got %v", deployValue["release"])
+	}
+
+	deps, err := to.GetDependencies(ctx, "renamed/-/path/to/package/@r1/deploy/staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deps) != 1 || deps[0] != "renamed/-/path/to/package/@r1" {
+		t.Errorf("unexpected dependencies: %v", deps)
+	}
+}
@@ -0,0 +1,143 @@
+package refstore
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ocuroot/ocuroot/about"
+)
+
+// Audit records who made a change to a ref, and how.
+type Audit struct {
+	// Actor identifies the person or system making the change.
+	Actor   string    `json:"actor,omitempty"`
+	Version string    `json:"version,omitempty"`
+	Command []string  `json:"command,omitempty"`
+	JobURL  string    `json:"job_url,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// String summarizes the audit for logs and commit messages.
+func (a Audit) String() string {
+	lines := []string{
+		fmt.Sprintf("Actor: %s", a.Actor),
+		fmt.Sprintf("Ocuroot-Version: %s", a.Version),
+		fmt.Sprintf("Command: %s", strings.Join(a.Command, " ")),
+	}
+	if a.JobURL != "" {
+		lines = append(lines, fmt.Sprintf("Job: %s", a.JobURL))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// actorEnvVars are checked in order to identify the actor making a change.
+// OCUROOT_ACTOR allows the actor to be set explicitly, the rest are set by
+// CI platforms.
+var actorEnvVars = []string{
+	"OCUROOT_ACTOR",
+	"GITHUB_ACTOR",
+	"GITLAB_USER_LOGIN",
+	"BUILDKITE_BUILD_CREATOR_EMAIL",
+	"CIRCLE_USERNAME",
+	"BUILD_USER_ID",
+}
+
+// jobURLEnvVars are checked in order to find a link to the CI job making a
+// change, unless OCUROOT_JOB_URL is set or the job is on GitHub Actions.
+var jobURLEnvVars = []string{
+	"CI_JOB_URL",
+	"BUILDKITE_BUILD_URL",
+	"CIRCLE_BUILD_URL",
+	"BUILD_URL",
+}
+
+var (
+	processAuditOnce sync.Once
+	processAudit     Audit
+)
+
+// CurrentAudit describes a change made now by this process.
+// The actor is read from CI environment variables, falling back to the git
+// user configured for the working directory.
+func CurrentAudit() *Audit {
+	processAuditOnce.Do(func() {
+		processAudit = Audit{
+			Actor:   auditActor(),
+			Version: about.Version,
+			Command: os.Args,
+			JobURL:  auditJobURL(),
+		}
+	})
+
+	out := processAudit
+	out.Time = time.Now().UTC()
+	return &out
+}
+
+type auditContextKey struct{}
+
+// ContextWithAudit attributes writes made with the returned context to the
+// given audit rather than the current process, such as when a server writes
+// on behalf of its clients.
+func ContextWithAudit(ctx context.Context, audit *Audit) context.Context {
+	return context.WithValue(ctx, auditContextKey{}, audit)
+}
+
+// auditFromContext returns the audit for a write made with ctx.
+func auditFromContext(ctx context.Context) *Audit {
+	if audit, ok := ctx.Value(auditContextKey{}).(*Audit); ok && audit != nil {
+		out := *audit
+		if out.Time.IsZero() {
+			out.Time = time.Now().UTC()
+		}
+		return &out
+	}
+	return CurrentAudit()
+}
+
+func auditActor() string {
+	for _, name := range actorEnvVars {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+
+	name := gitConfig("user.name")
+	email := gitConfig("user.email")
+	switch {
+	case name != "" && email != "":
+		return fmt.Sprintf("%s <%s>", name, email)
+	case email != "":
+		return email
+	default:
+		return name
+	}
+}
+
+func auditJobURL() string {
+	if jobURL := os.Getenv("OCUROOT_JOB_URL"); jobURL != "" {
+		return jobURL
+	}
+	if runID := os.Getenv("GITHUB_RUN_ID"); runID != "" {
+		return fmt.Sprintf("%s/%s/actions/runs/%s", os.Getenv("GITHUB_SERVER_URL"), os.Getenv("GITHUB_REPOSITORY"), runID)
+	}
+	for _, name := range jobURLEnvVars {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func gitConfig(key string) string {
+	out, err := exec.Command("git", "config", "--get", key).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
@@ -0,0 +1,128 @@
+package refstore
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestAudit(t *testing.T) {
+	tempDir := t.TempDir()
+
+	fsStore, err := NewFSRefStore(filepath.Join(tempDir, "fs"), map[string]struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqliteStore, err := NewSQLiteRefStore(filepath.Join(tempDir, "state.db"), map[string]struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = sqliteStore.Close()
+	})
+
+	for name, store := range map[string]Store{"fs": fsStore, "sqlite": sqliteStore} {
+		t.Run(name, func(t *testing.T) {
+			testAudit(t, store)
+		})
+	}
+}
+
+func testAudit(t *testing.T, store Store) {
+	ctx := context.Background()
+	ref := "github.com/example/repo.git/-/package/@r1/custom/audited"
+
+	getMetadata := func() ObjectMetadata {
+		t.Helper()
+		metadata, err := GetMetadata(ctx, store, ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if metadata.CreateAudit == nil || metadata.SetAudit == nil {
+			t.Fatalf("expected audit to be recorded, got %+v", metadata)
+		}
+		return metadata
+	}
+
+	if err := store.Set(ctx, ref, "first"); err != nil {
+		t.Fatal(err)
+	}
+	created := getMetadata()
+	if !slices.Equal(created.SetAudit.Command, CurrentAudit().Command) {
+		t.Errorf("expected command %v, got %v", CurrentAudit().Command, created.SetAudit.Command)
+	}
+	if created.SetAudit.Time.IsZero() {
+		t.Errorf("expected audit time to be set")
+	}
+
+	// Writing the same value keeps the previous audit
+	if err := store.Set(ctx, ref, "first"); err != nil {
+		t.Fatal(err)
+	}
+	if unchanged := getMetadata(); !unchanged.SetAudit.Time.Equal(created.SetAudit.Time) {
+		t.Errorf("expected audit to be unchanged, got %v, want %v", unchanged.SetAudit.Time, created.SetAudit.Time)
+	}
+
+	if err := store.Set(ctx, ref, "second"); err != nil {
+		t.Fatal(err)
+	}
+	changed := getMetadata()
+	if changed.SetAudit.Time.Before(created.SetAudit.Time) || changed.SetAudit.Time.Equal(created.SetAudit.Time) {
+		t.Errorf("expected a new audit after changing the value, got %v", changed.SetAudit.Time)
+	}
+	if !changed.CreateAudit.Time.Equal(created.CreateAudit.Time) {
+		t.Errorf("expected create audit to be kept, got %v, want %v", changed.CreateAudit.Time, created.CreateAudit.Time)
+	}
+}
+
+func TestFSDeleteAudit(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewFSRefStoreWithConfig(t.TempDir(), map[string]struct{}{}, FSRefStoreConfig{
+		History: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	direct := "github.com/example/repo.git/-/package/@r1/custom/direct"
+	staged := "github.com/example/repo.git/-/package/@r1/custom/staged"
+	for _, ref := range []string{direct, staged} {
+		if err := store.Set(ctx, ref, "value"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := store.Delete(ContextWithAudit(ctx, &Audit{Actor: "direct-deleter"}), direct); err != nil {
+		t.Fatal(err)
+	}
+
+	// Deletes in a transaction are audited as they were made, not as the
+	// transaction was committed
+	if err := store.StartTransaction(ctx, "delete"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(ContextWithAudit(ctx, &Audit{Actor: "staged-deleter"}), staged); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.CommitTransaction(ContextWithAudit(ctx, &Audit{Actor: "committer"})); err != nil {
+		t.Fatal(err)
+	}
+
+	for ref, actor := range map[string]string{direct: "direct-deleter", staged: "staged-deleter"} {
+		history, err := store.History(ctx, ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(history) == 0 {
+			t.Fatalf("expected history for %s", ref)
+		}
+		latest := history[0]
+		if !latest.Deleted {
+			t.Fatalf("expected %s to be deleted, got %+v", ref, latest)
+		}
+		if latest.Audit == nil || latest.Audit.Actor != actor {
+			t.Errorf("expected %s to be deleted by %s, got %+v", ref, actor, latest.Audit)
+		}
+	}
+}
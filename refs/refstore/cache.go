@@ -0,0 +1,425 @@
+package refstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	libglob "github.com/gobwas/glob"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// DefaultCacheMaxAge is how long a cached value is used for, unless
+// otherwise configured. It matches the interval between pulls of a git store.
+const DefaultCacheMaxAge = 5 * time.Second
+
+// ChangeNotifier is implemented by stores whose contents may change other
+// than through their own methods, such as by pulling from a remote.
+type ChangeNotifier interface {
+	// OnExternalChange registers a function to be called after each such
+	// change.
+	OnExternalChange(fn func())
+}
+
+type CacheConfig struct {
+	// MaxAge is how long a value may be cached before being read from the
+	// store again. Defaults to DefaultCacheMaxAge.
+	MaxAge time.Duration
+
+	// Uncached matches refs that are always read from the store, such as
+	// those used to coordinate between workers that must not see stale
+	// values.
+	Uncached []libglob.Glob
+}
+
+// CacheStats reports how many reads were served by a CachingStore.
+type CacheStats struct {
+	Hits   int64
+	Misses int64
+}
+
+// HitRate returns the fraction of reads served from the cache.
+func (s CacheStats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// NewCachingStore wraps a store with an in-memory cache of ref values and
+// links.
+//
+// Cached values are invalidated by writes made through the caching store,
+// by changes reported by the wrapped store if it implements ChangeNotifier,
+// and by changes observed through Watch. Writes made by other processes are
+// seen once the cached value is older than the configured max age.
+//
+// The cache is cleared when a transaction is started, so every transaction
+// begins with the latest state of the store. Reads within a transaction
+// include the transaction's own writes. Conditional reads with
+// GetWithRevision, and reads of refs matching the configured Uncached globs,
+// always bypass the cache.
+func NewCachingStore(store Store, config CacheConfig) *CachingStore {
+	if config.MaxAge <= 0 {
+		config.MaxAge = DefaultCacheMaxAge
+	}
+
+	c := &CachingStore{
+		store:    store,
+		maxAge:   config.MaxAge,
+		uncached: config.Uncached,
+		values:   make(map[string]cachedValue),
+		links:    make(map[string]cachedLink),
+	}
+	if notifier, ok := store.(ChangeNotifier); ok {
+		notifier.OnExternalChange(c.Invalidate)
+	}
+	return c
+}
+
+var _ Store = (*CachingStore)(nil)
+var _ ConditionalStore = (*CachingStore)(nil)
+var _ RollbackStore = (*CachingStore)(nil)
+var _ HistoryStore = (*CachingStore)(nil)
+var _ WatchableStore = (*CachingStore)(nil)
+var _ MetadataStore = (*CachingStore)(nil)
+var _ GitSupportFileWriter = (*CachingStore)(nil)
+
+type CachingStore struct {
+	store    Store
+	maxAge   time.Duration
+	uncached []libglob.Glob
+
+	mu    sync.Mutex
+	stats CacheStats
+
+	// generation is incremented whenever values are invalidated, so that
+	// values read before a write are not cached after it
+	generation uint64
+
+	// values maps a ref, with links resolved, to its value
+	values map[string]cachedValue
+	// links maps a ref to the ref it resolves to
+	links map[string]cachedLink
+}
+
+type cachedValue struct {
+	value    json.RawMessage
+	notFound bool
+	cached   time.Time
+}
+
+type cachedLink struct {
+	target string
+	cached time.Time
+}
+
+var (
+	cacheHits, _   = meter.Int64Counter("refstore.cache.hits", metric.WithDescription("Reads served from the store cache"))
+	cacheMisses, _ = meter.Int64Counter("refstore.cache.misses", metric.WithDescription("Reads not served from the store cache"))
+)
+
+// Stats returns the number of cache hits and misses so far.
+func (c *CachingStore) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// Invalidate removes all values from the cache.
+func (c *CachingStore) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	c.values = make(map[string]cachedValue)
+	c.links = make(map[string]cachedLink)
+}
+
+func (c *CachingStore) Info() StoreInfo {
+	return c.store.Info()
+}
+
+// StartTransaction implements Store.
+func (c *CachingStore) StartTransaction(ctx context.Context, message string) error {
+	c.Invalidate()
+	return c.store.StartTransaction(ctx, message)
+}
+
+// CommitTransaction implements Store.
+func (c *CachingStore) CommitTransaction(ctx context.Context) error {
+	return c.store.CommitTransaction(ctx)
+}
+
+// RollbackTransaction implements RollbackStore.
+// Values read during the transaction may have been staged, so the cache is
+// invalidated.
+func (c *CachingStore) RollbackTransaction(ctx context.Context) error {
+	defer c.Invalidate()
+	return RollbackTransaction(ctx, c.store)
+}
+
+// Get implements Store.
+func (c *CachingStore) Get(ctx context.Context, ref string, v any) error {
+	ref, fragment, _ := strings.Cut(ref, "#")
+
+	target, err := c.ResolveLink(ctx, ref)
+	if err != nil {
+		return err
+	}
+	if c.isUncached(target) {
+		c.record(ctx, false)
+		if fragment != "" {
+			target += "#" + fragment
+		}
+		return c.store.Get(ctx, target, v)
+	}
+
+	c.mu.Lock()
+	cached, ok := c.values[target]
+	fresh := ok && time.Since(cached.cached) < c.maxAge
+	generation := c.generation
+	c.mu.Unlock()
+
+	if fresh {
+		c.record(ctx, true)
+	} else {
+		c.record(ctx, false)
+
+		var value json.RawMessage
+		err := c.store.Get(ctx, target, &value)
+		if err != nil && !errors.Is(err, ErrRefNotFound) {
+			return err
+		}
+		cached = cachedValue{
+			value:    value,
+			notFound: err != nil,
+			cached:   time.Now(),
+		}
+
+		c.mu.Lock()
+		if c.generation == generation {
+			c.values[target] = cached
+		}
+		c.mu.Unlock()
+	}
+
+	if cached.notFound {
+		return ErrRefNotFound
+	}
+	return unmarshalFragment(cached.value, fragment, v)
+}
+
+func (c *CachingStore) isUncached(ref string) bool {
+	for _, g := range c.uncached {
+		if g.Match(ref) {
+			return true
+		}
+	}
+	return false
+}
+
+// Set implements Store.
+func (c *CachingStore) Set(ctx context.Context, ref string, v any) error {
+	defer c.invalidateValue(ctx, ref)
+	return c.store.Set(ctx, ref, v)
+}
+
+// Delete implements Store.
+func (c *CachingStore) Delete(ctx context.Context, ref string) error {
+	defer c.invalidateValue(ctx, ref)
+	return c.store.Delete(ctx, ref)
+}
+
+// Match implements Store.
+func (c *CachingStore) Match(ctx context.Context, glob ...string) ([]string, error) {
+	return c.store.Match(ctx, glob...)
+}
+
+// MatchOptions implements Store.
+func (c *CachingStore) MatchOptions(ctx context.Context, options MatchOptions, glob ...string) ([]string, error) {
+	return c.store.MatchOptions(ctx, options, glob...)
+}
+
+// Link implements Store.
+func (c *CachingStore) Link(ctx context.Context, ref string, target string) error {
+	defer c.invalidateLinks()
+	return c.store.Link(ctx, ref, target)
+}
+
+// Unlink implements Store.
+func (c *CachingStore) Unlink(ctx context.Context, ref string) error {
+	defer c.invalidateLinks()
+	return c.store.Unlink(ctx, ref)
+}
+
+// GetLinks implements Store.
+func (c *CachingStore) GetLinks(ctx context.Context, ref string) ([]string, error) {
+	return c.store.GetLinks(ctx, ref)
+}
+
+// ResolveLink implements Store.
+func (c *CachingStore) ResolveLink(ctx context.Context, ref string) (string, error) {
+	c.mu.Lock()
+	cached, ok := c.links[ref]
+	generation := c.generation
+	c.mu.Unlock()
+	if ok && time.Since(cached.cached) < c.maxAge {
+		return cached.target, nil
+	}
+
+	target, err := c.store.ResolveLink(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	if c.generation == generation {
+		c.links[ref] = cachedLink{
+			target: target,
+			cached: time.Now(),
+		}
+	}
+	c.mu.Unlock()
+	return target, nil
+}
+
+// AddDependency implements Store.
+func (c *CachingStore) AddDependency(ctx context.Context, ref string, dependency string) error {
+	return c.store.AddDependency(ctx, ref, dependency)
+}
+
+// RemoveDependency implements Store.
+func (c *CachingStore) RemoveDependency(ctx context.Context, ref string, dependency string) error {
+	return c.store.RemoveDependency(ctx, ref, dependency)
+}
+
+// GetDependencies implements Store.
+func (c *CachingStore) GetDependencies(ctx context.Context, ref string) ([]string, error) {
+	return c.store.GetDependencies(ctx, ref)
+}
+
+// GetDependants implements Store.
+func (c *CachingStore) GetDependants(ctx context.Context, ref string) ([]string, error) {
+	return c.store.GetDependants(ctx, ref)
+}
+
+// Close implements Store.
+func (c *CachingStore) Close() error {
+	stats := c.Stats()
+	log.Debug("Store cache statistics", "hits", stats.Hits, "misses", stats.Misses, "hitRate", stats.HitRate())
+	return c.store.Close()
+}
+
+// GetWithRevision implements ConditionalStore.
+// The value is always read from the wrapped store.
+func (c *CachingStore) GetWithRevision(ctx context.Context, ref string, v any) (string, error) {
+	return GetWithRevision(ctx, c.store, ref, v)
+}
+
+// SetIfAbsent implements ConditionalStore.
+func (c *CachingStore) SetIfAbsent(ctx context.Context, ref string, v any) error {
+	defer c.invalidateValue(ctx, ref)
+	return SetIfAbsent(ctx, c.store, ref, v)
+}
+
+// SetIfRevision implements ConditionalStore.
+func (c *CachingStore) SetIfRevision(ctx context.Context, ref string, v any, revision string) error {
+	defer c.invalidateValue(ctx, ref)
+	return SetIfRevision(ctx, c.store, ref, v, revision)
+}
+
+// History implements HistoryStore.
+func (c *CachingStore) History(ctx context.Context, ref string) ([]HistoryEntry, error) {
+	return History(ctx, c.store, ref)
+}
+
+// RevisionAt implements HistoryStore.
+func (c *CachingStore) RevisionAt(ctx context.Context, t time.Time) (string, error) {
+	return RevisionAt(ctx, c.store, t)
+}
+
+// GetAt implements HistoryStore.
+func (c *CachingStore) GetAt(ctx context.Context, ref string, revision string, v any) error {
+	return GetAt(ctx, c.store, ref, revision, v)
+}
+
+// GetMetadata implements MetadataStore.
+func (c *CachingStore) GetMetadata(ctx context.Context, ref string) (ObjectMetadata, error) {
+	return GetMetadata(ctx, c.store, ref)
+}
+
+// Watch implements WatchableStore.
+// Cached values are invalidated for each change observed.
+func (c *CachingStore) Watch(ctx context.Context, globs ...string) (<-chan ChangeEvent, error) {
+	events, err := Watch(ctx, c.store, globs...)
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan ChangeEvent)
+	go func() {
+		defer close(ch)
+		for event := range events {
+			c.mu.Lock()
+			c.generation++
+			delete(c.values, event.Ref)
+			c.links = make(map[string]cachedLink)
+			c.mu.Unlock()
+
+			select {
+			case ch <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// AddSupportFiles implements GitSupportFileWriter.
+func (c *CachingStore) AddSupportFiles(ctx context.Context, files map[string]string) error {
+	if gitSupportFileWriter, ok := c.store.(GitSupportFileWriter); ok {
+		return gitSupportFileWriter.AddSupportFiles(ctx, files)
+	}
+	return nil
+}
+
+// invalidateValue removes the cached value for a ref, following links.
+func (c *CachingStore) invalidateValue(ctx context.Context, ref string) {
+	target, err := c.ResolveLink(ctx, ref)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	delete(c.values, ref)
+	if err == nil {
+		delete(c.values, target)
+	}
+}
+
+func (c *CachingStore) invalidateLinks() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	c.links = make(map[string]cachedLink)
+}
+
+func (c *CachingStore) record(ctx context.Context, hit bool) {
+	c.mu.Lock()
+	if hit {
+		c.stats.Hits++
+	} else {
+		c.stats.Misses++
+	}
+	c.mu.Unlock()
+
+	if hit {
+		cacheHits.Add(ctx, 1)
+	} else {
+		cacheMisses.Add(ctx, 1)
+	}
+}
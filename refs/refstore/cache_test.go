@@ -0,0 +1,174 @@
+package refstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	libglob "github.com/gobwas/glob"
+)
+
+func TestCachingStore(t *testing.T) {
+	inner, err := NewFSRefStore(t.TempDir(), map[string]struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewCachingStore(inner, CacheConfig{})
+
+	DoTestStore(t, store)
+	DoTestConditionalStore(t, store)
+}
+
+type notifyingStore struct {
+	Store
+	fns []func()
+}
+
+func (n *notifyingStore) OnExternalChange(fn func()) {
+	n.fns = append(n.fns, fn)
+}
+
+func TestCachingStoreInvalidation(t *testing.T) {
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	fsStore, err := NewFSRefStore(dir, map[string]struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	inner := &notifyingStore{Store: fsStore}
+	store := NewCachingStore(inner, CacheConfig{MaxAge: time.Hour})
+
+	// A separate instance for the same directory, as another process would use
+	writer, err := NewFSRefStore(dir, map[string]struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	release := "repo.git/-/path/@r1"
+	if err := store.Set(ctx, release, map[string]any{"commit": "abc"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Link(ctx, "repo.git/-/path/@", release); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(ref string) string {
+		t.Helper()
+		var value string
+		if err := store.Get(ctx, ref, &value); err != nil {
+			t.Fatal(err)
+		}
+		return value
+	}
+
+	if got := get("repo.git/-/path/@#commit"); got != "abc" {
+		t.Errorf("unexpected value: %q", got)
+	}
+	if got := get(release + "#commit"); got != "abc" {
+		t.Errorf("unexpected value: %q", got)
+	}
+	if stats := store.Stats(); stats.Hits != 1 || stats.Misses != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	// Writes through a link invalidate the target
+	if err := store.Set(ctx, "repo.git/-/path/@", map[string]any{"commit": "def"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := get(release + "#commit"); got != "def" {
+		t.Errorf("expected value to be invalidated by write, got %q", got)
+	}
+
+	// Writes from elsewhere are only seen after a change is reported
+	if err := writer.Set(ctx, release, map[string]any{"commit": "ghi"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := get(release + "#commit"); got != "def" {
+		t.Errorf("expected cached value, got %q", got)
+	}
+	for _, fn := range inner.fns {
+		fn()
+	}
+	if got := get(release + "#commit"); got != "ghi" {
+		t.Errorf("expected value to be invalidated by external change, got %q", got)
+	}
+
+	// Each transaction starts with the latest state
+	if err := writer.Set(ctx, release, map[string]any{"commit": "jkl"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.StartTransaction(ctx, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if got := get(release + "#commit"); got != "jkl" {
+		t.Errorf("expected transaction to read latest value, got %q", got)
+	}
+	if err := store.Delete(ctx, release); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Get(ctx, release, new(any)); !errors.Is(err, ErrRefNotFound) {
+		t.Errorf("expected ErrRefNotFound within transaction, got %v", err)
+	}
+	if err := store.CommitTransaction(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Values expire after the max age
+	expiring := NewCachingStore(fsStore, CacheConfig{MaxAge: time.Millisecond})
+	if err := expiring.Get(ctx, release, new(any)); !errors.Is(err, ErrRefNotFound) {
+		t.Errorf("expected ErrRefNotFound, got %v", err)
+	}
+	if err := writer.Set(ctx, release, map[string]any{"commit": "mno"}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	var commit string
+	if err := expiring.Get(ctx, release+"#commit", &commit); err != nil || commit != "mno" {
+		t.Errorf("expected expired value to be read again, got %q, %v", commit, err)
+	}
+}
+
+func TestCachingStoreUncached(t *testing.T) {
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	fsStore, err := NewFSRefStore(dir, map[string]struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewCachingStore(fsStore, CacheConfig{
+		MaxAge:   time.Hour,
+		Uncached: []libglob.Glob{libglob.MustCompile("**/lease", '/')},
+	})
+
+	// A separate instance for the same directory, as another worker would use
+	writer, err := NewFSRefStore(dir, map[string]struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lease := "repo.git/-/path/@r1/task/build/1/lease"
+	other := "repo.git/-/path/@r1/task/build/1"
+	for _, ref := range []string{lease, other} {
+		if err := store.Set(ctx, ref, map[string]any{"owner": "a"}); err != nil {
+			t.Fatal(err)
+		}
+		var value string
+		if err := store.Get(ctx, ref+"#owner", &value); err != nil {
+			t.Fatal(err)
+		}
+		if err := writer.Set(ctx, ref, map[string]any{"owner": "b"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var value string
+	if err := store.Get(ctx, lease+"#owner", &value); err != nil || value != "b" {
+		t.Errorf("expected uncached ref to be read from the store, got %q, %v", value, err)
+	}
+	if err := store.Get(ctx, other+"#owner", &value); err != nil || value != "a" {
+		t.Errorf("expected other refs to be cached, got %q, %v", value, err)
+	}
+}
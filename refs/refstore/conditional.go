@@ -0,0 +1,73 @@
+package refstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+)
+
+var (
+	// ErrConflict is returned by a conditional write when the ref was not in
+	// the expected state.
+	ErrConflict = errors.New("ref was modified by another writer")
+
+	// ErrConditionalWritesUnsupported is returned when a conditional write is
+	// attempted against a store that does not implement ConditionalStore.
+	ErrConditionalWritesUnsupported = errors.New("store does not support conditional writes")
+)
+
+// ConditionalStore is implemented by stores that can write a ref only if it
+// is in an expected state. This allows concurrent writers to safely create
+// refs and perform read-modify-write updates.
+type ConditionalStore interface {
+	Store
+
+	// GetWithRevision behaves like Get, and also returns the current revision
+	// of the ref. Revisions are opaque and only meaningful to the store that
+	// returned them.
+	GetWithRevision(ctx context.Context, ref string, v any) (string, error)
+
+	// SetIfAbsent sets the value of a ref only if it does not already exist.
+	// ErrConflict is returned if it does.
+	SetIfAbsent(ctx context.Context, ref string, v any) error
+
+	// SetIfRevision sets the value of a ref only if its current revision
+	// matches the one provided.
+	// ErrConflict is returned if the ref has since been changed or deleted.
+	SetIfRevision(ctx context.Context, ref string, v any, revision string) error
+}
+
+// GetWithRevision gets the value and revision of a ref from a store that
+// supports conditional writes.
+func GetWithRevision(ctx context.Context, store Store, ref string, v any) (string, error) {
+	cs, ok := store.(ConditionalStore)
+	if !ok {
+		return "", ErrConditionalWritesUnsupported
+	}
+	return cs.GetWithRevision(ctx, ref, v)
+}
+
+// SetIfAbsent creates a ref in a store that supports conditional writes.
+func SetIfAbsent(ctx context.Context, store Store, ref string, v any) error {
+	cs, ok := store.(ConditionalStore)
+	if !ok {
+		return ErrConditionalWritesUnsupported
+	}
+	return cs.SetIfAbsent(ctx, ref, v)
+}
+
+// SetIfRevision updates a ref in a store that supports conditional writes.
+func SetIfRevision(ctx context.Context, store Store, ref string, v any, revision string) error {
+	cs, ok := store.(ConditionalStore)
+	if !ok {
+		return ErrConditionalWritesUnsupported
+	}
+	return cs.SetIfRevision(ctx, ref, v, revision)
+}
+
+// revisionOf calculates a revision from the stored body of a ref.
+func revisionOf(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,323 @@
+package refstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+)
+
+func DoTestConditionalStore(t *testing.T, store ConditionalStore) {
+	t.Run("set if absent", func(t *testing.T) {
+		testStoreSetIfAbsent(t, store)
+	})
+	t.Run("set if revision", func(t *testing.T) {
+		testStoreSetIfRevision(t, store)
+	})
+	t.Run("increment path", func(t *testing.T) {
+		testStoreIncrementPath(t, store)
+	})
+	t.Run("cancel reservation", func(t *testing.T) {
+		testStoreCancelReservation(t, store)
+	})
+}
+
+func testStoreSetIfAbsent(t *testing.T, store ConditionalStore) {
+	ctx := context.Background()
+	ref := "github.com/example/repo.git/path/to/package/@/custom/absent"
+	t.Cleanup(func() {
+		_ = store.Delete(ctx, ref)
+	})
+
+	if err := store.SetIfAbsent(ctx, ref, "first"); err != nil {
+		t.Fatalf("failed to create ref: %v", err)
+	}
+	if err := store.SetIfAbsent(ctx, ref, "second"); !errors.Is(err, ErrConflict) {
+		t.Errorf("expected ErrConflict creating existing ref, got %v", err)
+	}
+	assertValue(t, store, ref, "first")
+}
+
+func testStoreSetIfRevision(t *testing.T, store ConditionalStore) {
+	ctx := context.Background()
+	ref := "github.com/example/repo.git/path/to/package/@/custom/revision"
+	t.Cleanup(func() {
+		_ = store.Delete(ctx, ref)
+	})
+
+	if err := store.SetIfRevision(ctx, ref, "value", "missing"); !errors.Is(err, ErrConflict) {
+		t.Errorf("expected ErrConflict updating missing ref, got %v", err)
+	}
+
+	if err := store.Set(ctx, ref, "first"); err != nil {
+		t.Fatal(err)
+	}
+
+	var got string
+	revision, err := store.GetWithRevision(ctx, ref, &got)
+	if err != nil {
+		t.Fatalf("failed to get revision: %v", err)
+	}
+	if got != "first" {
+		t.Errorf("unexpected value: got %q, want %q", got, "first")
+	}
+
+	if err := store.SetIfRevision(ctx, ref, "second", revision); err != nil {
+		t.Fatalf("failed to update at revision: %v", err)
+	}
+	assertValue(t, store, ref, "second")
+
+	// The original revision is now stale
+	if err := store.SetIfRevision(ctx, ref, "third", revision); !errors.Is(err, ErrConflict) {
+		t.Errorf("expected ErrConflict updating stale revision, got %v", err)
+	}
+	assertValue(t, store, ref, "second")
+}
+
+func testStoreIncrementPath(t *testing.T, store ConditionalStore) {
+	ctx := context.Background()
+	prefix := "github.com/example/repo.git/path/to/package/@r"
+
+	var created []string
+	t.Cleanup(func() {
+		for _, ref := range created {
+			_ = store.Delete(ctx, ref)
+		}
+	})
+
+	for i := 1; i <= 3; i++ {
+		ref, err := IncrementPath(ctx, store, prefix)
+		if err != nil {
+			t.Fatalf("failed to increment path: %v", err)
+		}
+		created = append(created, ref)
+		if want := fmt.Sprintf("%s%d", prefix, i); ref != want {
+			t.Errorf("unexpected ref: got %q, want %q", ref, want)
+		}
+	}
+}
+
+func testStoreCancelReservation(t *testing.T, store ConditionalStore) {
+	ctx := context.Background()
+	prefix := "github.com/example/repo.git/path/to/package/@/custom/reserved"
+
+	var created []string
+	t.Cleanup(func() {
+		for _, ref := range created {
+			_ = store.Delete(ctx, ref)
+		}
+	})
+
+	// A reservation that was never written to is removed, so the ref can be
+	// reserved again
+	abandoned, err := IncrementPath(ctx, store, prefix)
+	if err != nil {
+		t.Fatalf("failed to increment path: %v", err)
+	}
+	created = append(created, abandoned)
+	if err := CancelReservation(ctx, store, abandoned); err != nil {
+		t.Fatalf("failed to cancel reservation: %v", err)
+	}
+	var got any
+	if err := store.Get(ctx, abandoned, &got); !errors.Is(err, ErrRefNotFound) {
+		t.Errorf("expected reservation to be removed, got %v (%v)", got, err)
+	}
+	again, err := IncrementPath(ctx, store, prefix)
+	if err != nil {
+		t.Fatalf("failed to increment path: %v", err)
+	}
+	created = append(created, again)
+	if again != abandoned {
+		t.Errorf("expected %s to be reserved again, got %s", abandoned, again)
+	}
+
+	// Refs that have been written to are left in place
+	if err := store.Set(ctx, again, "value"); err != nil {
+		t.Fatal(err)
+	}
+	if err := CancelReservation(ctx, store, again); err != nil {
+		t.Fatalf("failed to cancel reservation: %v", err)
+	}
+	assertValue(t, store, again, "value")
+
+	// Cancelling a missing ref does nothing
+	if err := CancelReservation(ctx, store, prefix+"missing"); err != nil {
+		t.Errorf("expected no error cancelling a missing ref, got %v", err)
+	}
+}
+
+func TestIncrementPathConcurrent(t *testing.T) {
+	tempDir := "./testdata/increment_testdata"
+	_ = os.RemoveAll(tempDir)
+	if err := os.MkdirAll(tempDir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	// Separate store instances share the same directory, as separate
+	// processes would.
+	var stores []Store
+	for i := 0; i < 4; i++ {
+		store, err := NewFSRefStore(tempDir, map[string]struct{}{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		stores = append(stores, store)
+	}
+
+	checkUniqueIncrements(t, stores, 10)
+}
+
+func TestIncrementPathConcurrentTransactions(t *testing.T) {
+	tempDir := "./testdata/increment_tx_testdata"
+	_ = os.RemoveAll(tempDir)
+	if err := os.MkdirAll(tempDir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	var stores []Store
+	for i := 0; i < 4; i++ {
+		store, err := NewFSRefStore(tempDir, map[string]struct{}{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		stores = append(stores, store)
+	}
+
+	// Each store reserves and writes refs inside its own transaction, as
+	// separate processes would.
+	var (
+		mu      sync.Mutex
+		written []string
+	)
+	checkUniqueIncrementsWith(t, stores, 10, func(ctx context.Context, store Store, prefix string) (string, error) {
+		if err := store.StartTransaction(ctx, "reserve"); err != nil {
+			return "", err
+		}
+		ref, err := IncrementPath(ctx, store, prefix)
+		if err == nil {
+			err = store.Set(ctx, ref, ref)
+		}
+		if commitErr := store.CommitTransaction(ctx); commitErr != nil {
+			return "", errors.Join(err, commitErr)
+		}
+		if err != nil {
+			return "", err
+		}
+		mu.Lock()
+		written = append(written, ref)
+		mu.Unlock()
+		return ref, nil
+	})
+
+	// No committed write is lost to the other transactions
+	store, err := NewFSRefStore(tempDir, map[string]struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ref := range written {
+		assertValue(t, store, ref, ref)
+	}
+}
+
+func TestSetIfRevisionConcurrent(t *testing.T) {
+	ctx := context.Background()
+	tempDir := t.TempDir()
+	ref := "github.com/example/repo.git/-/package/@r1/custom/counter"
+
+	// Separate store instances share the same directory, as separate
+	// processes would.
+	var stores []*FSStateStore
+	for i := 0; i < 4; i++ {
+		store, err := NewFSRefStore(tempDir, map[string]struct{}{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		stores = append(stores, store)
+	}
+	if err := stores[0].Set(ctx, ref, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each store increments the counter with read-modify-write updates,
+	// retrying on conflict, so no increment may be lost
+	const perStore = 20
+	var wg sync.WaitGroup
+	for _, store := range stores {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perStore; {
+				var count int
+				revision, err := store.GetWithRevision(ctx, ref, &count)
+				if err != nil {
+					t.Errorf("failed to get counter: %v", err)
+					return
+				}
+				err = store.SetIfRevision(ctx, ref, count+1, revision)
+				if errors.Is(err, ErrConflict) {
+					continue
+				}
+				if err != nil {
+					t.Errorf("failed to set counter: %v", err)
+					return
+				}
+				i++
+			}
+		}()
+	}
+	wg.Wait()
+
+	var count int
+	if err := stores[0].Get(ctx, ref, &count); err != nil {
+		t.Fatal(err)
+	}
+	if want := len(stores) * perStore; count != want {
+		t.Errorf("expected counter to be %d, got %d", want, count)
+	}
+}
+
+// checkUniqueIncrements calls IncrementPath concurrently from each store and
+// confirms that every returned ref is unique.
+func checkUniqueIncrements(t *testing.T, stores []Store, perStore int) {
+	checkUniqueIncrementsWith(t, stores, perStore, IncrementPath)
+}
+
+// checkUniqueIncrementsWith behaves as checkUniqueIncrements, calling
+// increment in place of IncrementPath.
+func checkUniqueIncrementsWith(t *testing.T, stores []Store, perStore int, increment func(ctx context.Context, store Store, prefix string) (string, error)) {
+	ctx := context.Background()
+	prefix := "github.com/example/repo.git/path/to/package/@r"
+
+	var (
+		mu   sync.Mutex
+		seen = make(map[string]struct{})
+		wg   sync.WaitGroup
+	)
+	for _, store := range stores {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perStore; i++ {
+				ref, err := increment(ctx, store, prefix)
+				if err != nil {
+					t.Errorf("failed to increment path: %v", err)
+					return
+				}
+
+				mu.Lock()
+				if _, exists := seen[ref]; exists {
+					t.Errorf("ref %s was returned more than once", ref)
+				}
+				seen[ref] = struct{}{}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if want := len(stores) * perStore; len(seen) != want {
+		t.Errorf("unexpected number of refs: got %d, want %d", len(seen), want)
+	}
+}
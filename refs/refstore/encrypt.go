@@ -0,0 +1,687 @@
+package refstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"filippo.io/age"
+	libglob "github.com/gobwas/glob"
+)
+
+// ErrNoEncryptionRecipients is returned when writing a sensitive value to an
+// EncryptedStore that has no recipients to encrypt it for.
+var ErrNoEncryptionRecipients = errors.New("sensitive values cannot be written without encryption recipients")
+
+// ErrNoDecryptionIdentity is returned when reading an encrypted value from an
+// EncryptedStore that has no identity able to decrypt it.
+var ErrNoDecryptionIdentity = errors.New("no identity can decrypt the value")
+
+const (
+	// encryptedKey is the only key of an object holding an encrypted value.
+	encryptedKey = "$encrypted"
+	// sensitiveKey is the only key of an object marking a value to be
+	// encrypted.
+	sensitiveKey = "$sensitive"
+)
+
+// Sensitive marks a value to be encrypted when written to an EncryptedStore.
+type Sensitive struct {
+	Value any
+}
+
+func (s Sensitive) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]any{sensitiveKey: s.Value})
+}
+
+// MarkSensitive returns a copy of values with each of the given keys marked
+// as sensitive.
+func MarkSensitive(values map[string]any, keys []string) map[string]any {
+	if len(keys) == 0 {
+		return values
+	}
+	out := make(map[string]any, len(values))
+	for k, v := range values {
+		out[k] = v
+	}
+	for _, k := range keys {
+		if v, ok := out[k]; ok {
+			out[k] = Sensitive{Value: v}
+		}
+	}
+	return out
+}
+
+type EncryptionConfig struct {
+	// Recipients are the keys values are encrypted for.
+	Recipients []age.Recipient
+	// Identities are the keys used to decrypt values. Reading a value that
+	// cannot be decrypted with these fails with ErrNoDecryptionIdentity.
+	Identities []age.Identity
+	// Sensitive are globs matching refs whose entire value is encrypted.
+	Sensitive []string
+}
+
+// NewEncryptedStore wraps a store to encrypt sensitive values before they are
+// written and decrypt them when read.
+//
+// Values are sensitive if they are written to a ref matching one of the
+// Sensitive globs, or marked with Sensitive. Values that were decrypted are
+// also encrypted again if written back, so sensitive values are not exposed
+// by copying them into other refs.
+func NewEncryptedStore(store Store, config EncryptionConfig) (*EncryptedStore, error) {
+	var sensitive []libglob.Glob
+	for _, g := range config.Sensitive {
+		compiled, err := libglob.Compile(g, '/')
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile sensitive glob %q: %w", g, err)
+		}
+		sensitive = append(sensitive, compiled)
+	}
+
+	return &EncryptedStore{
+		store:      store,
+		recipients: config.Recipients,
+		identities: config.Identities,
+		sensitive:  sensitive,
+		decrypted:  make(map[string]struct{}),
+	}, nil
+}
+
+var _ Store = (*EncryptedStore)(nil)
+var _ ConditionalStore = (*EncryptedStore)(nil)
+var _ RollbackStore = (*EncryptedStore)(nil)
+var _ HistoryStore = (*EncryptedStore)(nil)
+var _ WatchableStore = (*EncryptedStore)(nil)
+var _ MetadataStore = (*EncryptedStore)(nil)
+var _ GitSupportFileWriter = (*EncryptedStore)(nil)
+
+type EncryptedStore struct {
+	store      Store
+	recipients []age.Recipient
+	identities []age.Identity
+	sensitive  []libglob.Glob
+
+	mu sync.Mutex
+	// decrypted holds the canonical JSON of values that have been decrypted
+	decrypted map[string]struct{}
+}
+
+func (e *EncryptedStore) Info() StoreInfo {
+	return e.store.Info()
+}
+
+// StartTransaction implements Store.
+func (e *EncryptedStore) StartTransaction(ctx context.Context, message string) error {
+	return e.store.StartTransaction(ctx, message)
+}
+
+// CommitTransaction implements Store.
+func (e *EncryptedStore) CommitTransaction(ctx context.Context) error {
+	return e.store.CommitTransaction(ctx)
+}
+
+// RollbackTransaction implements RollbackStore.
+func (e *EncryptedStore) RollbackTransaction(ctx context.Context) error {
+	return RollbackTransaction(ctx, e.store)
+}
+
+// Get implements Store.
+func (e *EncryptedStore) Get(ctx context.Context, ref string, v any) error {
+	_, err := e.get(ctx, ref, v, func(ref string, raw *json.RawMessage) (string, error) {
+		return "", e.store.Get(ctx, ref, raw)
+	})
+	return err
+}
+
+// Set implements Store.
+func (e *EncryptedStore) Set(ctx context.Context, ref string, v any) error {
+	content, err := e.encryptValue(ctx, ref, v)
+	if err != nil {
+		return err
+	}
+	return e.store.Set(ctx, ref, content)
+}
+
+// Delete implements Store.
+func (e *EncryptedStore) Delete(ctx context.Context, ref string) error {
+	return e.store.Delete(ctx, ref)
+}
+
+// Match implements Store.
+func (e *EncryptedStore) Match(ctx context.Context, glob ...string) ([]string, error) {
+	return e.store.Match(ctx, glob...)
+}
+
+// MatchOptions implements Store.
+func (e *EncryptedStore) MatchOptions(ctx context.Context, options MatchOptions, glob ...string) ([]string, error) {
+	return e.store.MatchOptions(ctx, options, glob...)
+}
+
+// Link implements Store.
+func (e *EncryptedStore) Link(ctx context.Context, ref string, target string) error {
+	return e.store.Link(ctx, ref, target)
+}
+
+// Unlink implements Store.
+func (e *EncryptedStore) Unlink(ctx context.Context, ref string) error {
+	return e.store.Unlink(ctx, ref)
+}
+
+// GetLinks implements Store.
+func (e *EncryptedStore) GetLinks(ctx context.Context, ref string) ([]string, error) {
+	return e.store.GetLinks(ctx, ref)
+}
+
+// ResolveLink implements Store.
+func (e *EncryptedStore) ResolveLink(ctx context.Context, ref string) (string, error) {
+	return e.store.ResolveLink(ctx, ref)
+}
+
+// AddDependency implements Store.
+func (e *EncryptedStore) AddDependency(ctx context.Context, ref string, dependency string) error {
+	return e.store.AddDependency(ctx, ref, dependency)
+}
+
+// RemoveDependency implements Store.
+func (e *EncryptedStore) RemoveDependency(ctx context.Context, ref string, dependency string) error {
+	return e.store.RemoveDependency(ctx, ref, dependency)
+}
+
+// GetDependencies implements Store.
+func (e *EncryptedStore) GetDependencies(ctx context.Context, ref string) ([]string, error) {
+	return e.store.GetDependencies(ctx, ref)
+}
+
+// GetDependants implements Store.
+func (e *EncryptedStore) GetDependants(ctx context.Context, ref string) ([]string, error) {
+	return e.store.GetDependants(ctx, ref)
+}
+
+// Close implements Store.
+func (e *EncryptedStore) Close() error {
+	return e.store.Close()
+}
+
+// GetWithRevision implements ConditionalStore.
+func (e *EncryptedStore) GetWithRevision(ctx context.Context, ref string, v any) (string, error) {
+	return e.get(ctx, ref, v, func(ref string, raw *json.RawMessage) (string, error) {
+		return GetWithRevision(ctx, e.store, ref, raw)
+	})
+}
+
+// SetIfAbsent implements ConditionalStore.
+func (e *EncryptedStore) SetIfAbsent(ctx context.Context, ref string, v any) error {
+	content, err := e.encryptValue(ctx, ref, v)
+	if err != nil {
+		return err
+	}
+	return SetIfAbsent(ctx, e.store, ref, content)
+}
+
+// SetIfRevision implements ConditionalStore.
+func (e *EncryptedStore) SetIfRevision(ctx context.Context, ref string, v any, revision string) error {
+	content, err := e.encryptValue(ctx, ref, v)
+	if err != nil {
+		return err
+	}
+	return SetIfRevision(ctx, e.store, ref, content, revision)
+}
+
+// History implements HistoryStore.
+func (e *EncryptedStore) History(ctx context.Context, ref string) ([]HistoryEntry, error) {
+	entries, err := History(ctx, e.store, ref)
+	if err != nil {
+		return nil, err
+	}
+	for i, entry := range entries {
+		if len(entry.Body) == 0 {
+			continue
+		}
+		body, err := e.decryptJSON(entry.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt %s at %s: %w", ref, entry.Revision, err)
+		}
+		entries[i].Body = body
+	}
+	return entries, nil
+}
+
+// RevisionAt implements HistoryStore.
+func (e *EncryptedStore) RevisionAt(ctx context.Context, t time.Time) (string, error) {
+	return RevisionAt(ctx, e.store, t)
+}
+
+// GetAt implements HistoryStore.
+func (e *EncryptedStore) GetAt(ctx context.Context, ref string, revision string, v any) error {
+	_, err := e.get(ctx, ref, v, func(ref string, raw *json.RawMessage) (string, error) {
+		return "", GetAt(ctx, e.store, ref, revision, raw)
+	})
+	return err
+}
+
+// GetMetadata implements MetadataStore.
+func (e *EncryptedStore) GetMetadata(ctx context.Context, ref string) (ObjectMetadata, error) {
+	return GetMetadata(ctx, e.store, ref)
+}
+
+// Watch implements WatchableStore.
+func (e *EncryptedStore) Watch(ctx context.Context, globs ...string) (<-chan ChangeEvent, error) {
+	return Watch(ctx, e.store, globs...)
+}
+
+// AddSupportFiles implements GitSupportFileWriter.
+func (e *EncryptedStore) AddSupportFiles(ctx context.Context, files map[string]string) error {
+	if gitSupportFileWriter, ok := e.store.(GitSupportFileWriter); ok {
+		return gitSupportFileWriter.AddSupportFiles(ctx, files)
+	}
+	return nil
+}
+
+// get reads the whole value of a ref with read, decrypts it and then applies
+// any fragment. Fragments are applied here as they may refer to values that
+// are only readable once decrypted.
+func (e *EncryptedStore) get(
+	ctx context.Context,
+	ref string,
+	v any,
+	read func(ref string, raw *json.RawMessage) (string, error),
+) (string, error) {
+	wholeRef, fragment, _ := strings.Cut(ref, "#")
+
+	var raw json.RawMessage
+	revision, err := read(wholeRef, &raw)
+	if err != nil {
+		return "", err
+	}
+
+	body, err := e.decryptJSON(raw)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt %s: %w", ref, err)
+	}
+	return revision, unmarshalFragment(body, fragment, v)
+}
+
+// encryptValue prepares a value to be written to a ref, encrypting it if the
+// ref is sensitive and encrypting any parts of it that are sensitive. Values
+// with nothing to encrypt are returned unchanged.
+func (e *EncryptedStore) encryptValue(ctx context.Context, ref string, v any) (any, error) {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var value any
+	if err := decodeJSON(content, &value); err != nil {
+		return nil, err
+	}
+
+	wholeRef := e.isSensitiveRef(ref)
+	if !wholeRef && !e.needsEncryption(value) {
+		return v, nil
+	}
+	if len(e.recipients) == 0 {
+		return nil, fmt.Errorf("failed to set %s: %w", ref, ErrNoEncryptionRecipients)
+	}
+
+	// Reuse the existing ciphertext for values that are unchanged, so writing
+	// the same value does not create a change
+	existing, err := e.existingCiphertexts(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	if wholeRef {
+		value, err = e.encryptWithCache(unwrapSensitive(value), existing)
+	} else {
+		value, err = e.encryptSensitive(value, existing)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt %s: %w", ref, err)
+	}
+	encrypted, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return encodedValue{content: encrypted, typeName: bodyType(v)}, nil
+}
+
+func (e *EncryptedStore) isSensitiveRef(ref string) bool {
+	ref, _, _ = strings.Cut(ref, "#")
+	for _, g := range e.sensitive {
+		if g.Match(ref) {
+			return true
+		}
+	}
+	return false
+}
+
+// needsEncryption returns true if a value contains anything that must be
+// encrypted.
+func (e *EncryptedStore) needsEncryption(value any) bool {
+	found := false
+	walkJSON(value, func(v any) bool {
+		if found {
+			return false
+		}
+		if _, ok := sensitiveValue(v); ok {
+			found = true
+			return false
+		}
+		if _, ok := encryptedValue(v); ok {
+			return false
+		}
+		if e.wasDecrypted(v) {
+			found = true
+			return false
+		}
+		return true
+	})
+	return found
+}
+
+// encryptSensitive replaces marked and previously decrypted values within a
+// value with their encrypted form.
+func (e *EncryptedStore) encryptSensitive(value any, existing map[string]string) (any, error) {
+	if inner, ok := sensitiveValue(value); ok {
+		return e.encryptWithCache(unwrapSensitive(inner), existing)
+	}
+	if _, ok := encryptedValue(value); ok {
+		return value, nil
+	}
+	if e.wasDecrypted(value) {
+		return e.encryptWithCache(value, existing)
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, item := range v {
+			encrypted, err := e.encryptSensitive(item, existing)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = encrypted
+		}
+		return out, nil
+	case []any:
+		out := make([]any, len(v))
+		for i, item := range v {
+			encrypted, err := e.encryptSensitive(item, existing)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = encrypted
+		}
+		return out, nil
+	}
+	return value, nil
+}
+
+// encryptWithCache encrypts a value, reusing a ciphertext from existing if
+// one holds the same value.
+func (e *EncryptedStore) encryptWithCache(value any, existing map[string]string) (any, error) {
+	plaintext, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	if ciphertext, ok := existing[string(plaintext)]; ok {
+		return map[string]any{encryptedKey: ciphertext}, nil
+	}
+
+	var buf bytes.Buffer
+	w, err := age.Encrypt(&buf, e.recipients...)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return map[string]any{encryptedKey: base64.StdEncoding.EncodeToString(buf.Bytes())}, nil
+}
+
+// existingCiphertexts returns the ciphertexts in the current value of a ref,
+// keyed by their plaintext.
+func (e *EncryptedStore) existingCiphertexts(ctx context.Context, ref string) (map[string]string, error) {
+	out := make(map[string]string)
+	if len(e.identities) == 0 {
+		return out, nil
+	}
+
+	var raw json.RawMessage
+	if err := e.store.Get(ctx, ref, &raw); err != nil {
+		if errors.Is(err, ErrRefNotFound) {
+			return out, nil
+		}
+		return nil, err
+	}
+	var value any
+	if err := decodeJSON(raw, &value); err != nil {
+		return nil, err
+	}
+
+	var decryptErr error
+	walkJSON(value, func(v any) bool {
+		ciphertext, ok := encryptedValue(v)
+		if !ok {
+			return true
+		}
+		plaintext, err := e.decrypt(ciphertext)
+		if errors.Is(err, ErrNoDecryptionIdentity) {
+			return false
+		}
+		if err != nil {
+			decryptErr = err
+			return false
+		}
+		out[string(plaintext)] = ciphertext
+		return false
+	})
+	return out, decryptErr
+}
+
+// decryptJSON replaces each encrypted value in a JSON document with its
+// plaintext.
+func (e *EncryptedStore) decryptJSON(content json.RawMessage) (json.RawMessage, error) {
+	if !bytes.Contains(content, []byte(encryptedKey)) {
+		return content, nil
+	}
+
+	var value any
+	if err := decodeJSON(content, &value); err != nil {
+		return nil, err
+	}
+	value, err := e.decryptValue(value)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(value)
+}
+
+func (e *EncryptedStore) decryptValue(value any) (any, error) {
+	if ciphertext, ok := encryptedValue(value); ok {
+		plaintext, err := e.decrypt(ciphertext)
+		if err != nil {
+			return nil, err
+		}
+		var decrypted any
+		if err := decodeJSON(plaintext, &decrypted); err != nil {
+			return nil, err
+		}
+		e.recordDecrypted(decrypted)
+		return decrypted, nil
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, item := range v {
+			decrypted, err := e.decryptValue(item)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = decrypted
+		}
+		return out, nil
+	case []any:
+		out := make([]any, len(v))
+		for i, item := range v {
+			decrypted, err := e.decryptValue(item)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = decrypted
+		}
+		return out, nil
+	}
+	return value, nil
+}
+
+// decrypt returns the plaintext of a ciphertext, or ErrNoDecryptionIdentity
+// if none of the identities can decrypt it.
+func (e *EncryptedStore) decrypt(ciphertext string) ([]byte, error) {
+	if len(e.identities) == 0 {
+		return nil, ErrNoDecryptionIdentity
+	}
+	data, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode encrypted value: %w", err)
+	}
+	r, err := age.Decrypt(bytes.NewReader(data), e.identities...)
+	if err != nil {
+		var noMatch *age.NoIdentityMatchError
+		if errors.As(err, &noMatch) {
+			return nil, ErrNoDecryptionIdentity
+		}
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// recordDecrypted remembers a decrypted value, along with any strings within
+// it, so they are encrypted again if written back.
+func (e *EncryptedStore) recordDecrypted(value any) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	walkJSON(value, func(v any) bool {
+		if key, ok := decryptedKey(v); ok {
+			e.decrypted[key] = struct{}{}
+		}
+		return true
+	})
+}
+
+func (e *EncryptedStore) wasDecrypted(value any) bool {
+	key, ok := decryptedKey(value)
+	if !ok {
+		return false
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, found := e.decrypted[key]
+	return found
+}
+
+// decryptedKey returns the key used to remember a decrypted value. Only
+// non-empty strings, maps and lists are remembered, as other values are too
+// common to treat as sensitive wherever they appear.
+func decryptedKey(value any) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		if v == "" {
+			return "", false
+		}
+	case map[string]any:
+		if len(v) == 0 {
+			return "", false
+		}
+	case []any:
+		if len(v) == 0 {
+			return "", false
+		}
+	default:
+		return "", false
+	}
+	content, err := json.Marshal(value)
+	if err != nil {
+		return "", false
+	}
+	return string(content), true
+}
+
+// walkJSON calls fn for a decoded JSON value and each value within it,
+// descending into a value only if fn returns true.
+func walkJSON(value any, fn func(v any) bool) {
+	if !fn(value) {
+		return
+	}
+	switch v := value.(type) {
+	case map[string]any:
+		for _, item := range v {
+			walkJSON(item, fn)
+		}
+	case []any:
+		for _, item := range v {
+			walkJSON(item, fn)
+		}
+	}
+}
+
+func encryptedValue(value any) (string, bool) {
+	m, ok := value.(map[string]any)
+	if !ok || len(m) != 1 {
+		return "", false
+	}
+	ciphertext, ok := m[encryptedKey].(string)
+	return ciphertext, ok
+}
+
+func sensitiveValue(value any) (any, bool) {
+	m, ok := value.(map[string]any)
+	if !ok || len(m) != 1 {
+		return nil, false
+	}
+	inner, ok := m[sensitiveKey]
+	return inner, ok
+}
+
+// unwrapSensitive removes any sensitive markers within a value.
+func unwrapSensitive(value any) any {
+	if inner, ok := sensitiveValue(value); ok {
+		return unwrapSensitive(inner)
+	}
+	switch v := value.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, item := range v {
+			out[k] = unwrapSensitive(item)
+		}
+		return out
+	case []any:
+		out := make([]any, len(v))
+		for i, item := range v {
+			out[i] = unwrapSensitive(item)
+		}
+		return out
+	}
+	return value
+}
+
+// decodeJSON decodes JSON keeping numbers as written.
+func decodeJSON(content []byte, v any) error {
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
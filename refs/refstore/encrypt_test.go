@@ -0,0 +1,154 @@
+package refstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+)
+
+func TestEncryptedStore(t *testing.T) {
+	ctx := context.Background()
+
+	inner, err := NewFSRefStore(filepath.Join(t.TempDir(), "fs"), map[string]struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NewEncryptedStore(inner, EncryptionConfig{
+		Recipients: []age.Recipient{identity.Recipient()},
+		Identities: []age.Identity{identity},
+		Sensitive:  []string{"**/@*/custom/credentials"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without an identity, encrypted values cannot be read
+	locked, err := NewEncryptedStore(inner, EncryptionConfig{
+		Recipients: []age.Recipient{identity.Recipient()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rawValue := func(ref string) string {
+		t.Helper()
+		var raw json.RawMessage
+		if err := inner.Get(ctx, ref, &raw); err != nil {
+			t.Fatal(err)
+		}
+		return string(raw)
+	}
+
+	credentials := "github.com/example/repo.git/path/to/package/@r1/custom/credentials"
+	if err := store.Set(ctx, credentials, map[string]any{"password": "hunter2"}); err != nil {
+		t.Fatal(err)
+	}
+	if raw := rawValue(credentials); strings.Contains(raw, "hunter2") || !strings.Contains(raw, encryptedKey) {
+		t.Errorf("sensitive ref was not encrypted: %s", raw)
+	}
+
+	var password string
+	if err := store.Get(ctx, credentials+"#password", &password); err != nil {
+		t.Fatal(err)
+	}
+	if password != "hunter2" {
+		t.Errorf("unexpected decrypted value: %q", password)
+	}
+
+	var lockedValue map[string]any
+	if err := locked.Get(ctx, credentials, &lockedValue); !errors.Is(err, ErrNoDecryptionIdentity) {
+		t.Errorf("expected ErrNoDecryptionIdentity without an identity, got %v, %v", lockedValue, err)
+	}
+	other, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrongKey, err := NewEncryptedStore(inner, EncryptionConfig{Identities: []age.Identity{other}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wrongKey.Get(ctx, credentials, &lockedValue); !errors.Is(err, ErrNoDecryptionIdentity) {
+		t.Errorf("expected ErrNoDecryptionIdentity with the wrong identity, got %v, %v", lockedValue, err)
+	}
+
+	// The metadata records the type of the value written, not its encoding
+	metadata, err := GetMetadata(ctx, inner, credentials)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.BodyType != "map[string]interface {}" {
+		t.Errorf("unexpected body type of an encrypted value: %q", metadata.BodyType)
+	}
+
+	// Writing the same value keeps the same ciphertext
+	before := rawValue(credentials)
+	if err := store.Set(ctx, credentials, map[string]any{"password": "hunter2"}); err != nil {
+		t.Fatal(err)
+	}
+	if after := rawValue(credentials); after != before {
+		t.Error("ciphertext changed when writing an unchanged value")
+	}
+
+	// Marked values are encrypted individually
+	run := "github.com/example/repo.git/path/to/package/@r1/deploy/staging/1"
+	outputs := MarkSensitive(map[string]any{"token": "secret-token", "count": 1}, []string{"token"})
+	if err := store.Set(ctx, run, map[string]any{"outputs": outputs}); err != nil {
+		t.Fatal(err)
+	}
+	if raw := rawValue(run); strings.Contains(raw, "secret-token") || !strings.Contains(raw, "count") {
+		t.Errorf("unexpected stored run: %s", raw)
+	}
+	var token string
+	if err := store.Get(ctx, run+"#outputs/token", &token); err != nil {
+		t.Fatal(err)
+	}
+	if token != "secret-token" {
+		t.Errorf("unexpected decrypted output: %q", token)
+	}
+
+	// Decrypted values stay encrypted when copied to another ref
+	next := "github.com/example/repo.git/path/to/package/@r1/deploy/production/1"
+	if err := store.Set(ctx, next, map[string]any{"inputs": map[string]any{"token": token}}); err != nil {
+		t.Fatal(err)
+	}
+	if raw := rawValue(next); strings.Contains(raw, "secret-token") {
+		t.Errorf("copied sensitive value was not encrypted: %s", raw)
+	}
+
+	// Sensitive values cannot be written without recipients
+	unconfigured, err := NewEncryptedStore(inner, EncryptionConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := unconfigured.Set(ctx, run, map[string]any{"outputs": outputs}); !errors.Is(err, ErrNoEncryptionRecipients) {
+		t.Errorf("expected ErrNoEncryptionRecipients, got %v", err)
+	}
+	if err := unconfigured.Set(ctx, run, map[string]any{"count": 1}); err != nil {
+		t.Errorf("failed to set a value that is not sensitive: %v", err)
+	}
+
+	// Values that are not sensitive are written unchanged
+	type count struct {
+		Count int `json:"count"`
+	}
+	if err := store.Set(ctx, run, count{Count: 2}); err != nil {
+		t.Fatal(err)
+	}
+	metadata, err = GetMetadata(ctx, inner, run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.BodyType != "refstore.count" {
+		t.Errorf("unexpected body type of a value that is not sensitive: %q", metadata.BodyType)
+	}
+}
@@ -0,0 +1,108 @@
+package refstore
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// unmarshalFragment unmarshals the value at the given fragment of a
+// JSON-encoded body into v. The fragment is a path as described by
+// lookupFragment. If the fragment is empty, the whole body is unmarshalled.
+func unmarshalFragment(body json.RawMessage, fragment string, v any) error {
+	if fragment == "" {
+		return json.Unmarshal(body, v)
+	}
+
+	var content any
+	if err := json.Unmarshal(body, &content); err != nil {
+		return err
+	}
+
+	content, ok := lookupFragment(content, fragment)
+	if !ok {
+		return ErrRefNotFound
+	}
+	jsonContent, err := json.Marshal(content)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(jsonContent, v)
+}
+
+// lookupFragment finds the value at a fragment within a decoded JSON value,
+// returning false if there is no value there.
+//
+// The fragment is a '/'-separated path. Each element is a map key or, within
+// a list, an index. Negative indices count back from the end of the list, so
+// output/digests/-1 is the last digest. A * element matches every item of a
+// list or value of a map, in key order, and the rest of the path is looked up
+// in each of them, returning a list of the values found.
+func lookupFragment(content any, fragment string) (any, bool) {
+	if fragment == "" {
+		return content, true
+	}
+	return lookupFragmentPath(content, strings.Split(fragment, "/"))
+}
+
+func lookupFragmentPath(content any, path []string) (any, bool) {
+	for i, element := range path {
+		if element == "*" {
+			return lookupFragmentWildcard(content, path[i+1:])
+		}
+
+		switch c := content.(type) {
+		case map[string]any:
+			content = c[element]
+		case []any:
+			index, err := strconv.Atoi(element)
+			if err != nil {
+				return nil, false
+			}
+			if index < 0 {
+				index += len(c)
+			}
+			if index < 0 || index >= len(c) {
+				return nil, false
+			}
+			content = c[index]
+		default:
+			return nil, false
+		}
+		if content == nil {
+			return nil, false
+		}
+	}
+	return content, true
+}
+
+// lookupFragmentWildcard looks up the remaining path in every item of a list
+// or map. Items without a value at that path are skipped.
+func lookupFragmentWildcard(content any, path []string) (any, bool) {
+	var items []any
+	switch c := content.(type) {
+	case []any:
+		items = c
+	case map[string]any:
+		keys := make([]string, 0, len(c))
+		for key := range c {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			items = append(items, c[key])
+		}
+	default:
+		return nil, false
+	}
+
+	out := []any{}
+	for _, item := range items {
+		if value, ok := lookupFragmentPath(item, path); ok && value != nil {
+			out = append(out, value)
+		}
+	}
+	return out, true
+}
@@ -0,0 +1,204 @@
+package refstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+type IssueKind string
+
+const (
+	// IssueDanglingLink is a link whose target does not exist.
+	IssueDanglingLink IssueKind = "dangling_link"
+	// IssueMissingDependant is a dependency that is not recorded as a
+	// dependant of the ref it depends on.
+	IssueMissingDependant IssueKind = "missing_dependant"
+	// IssueMissingDependency is a dependant that is not recorded as a
+	// dependency of the ref that depends on it.
+	IssueMissingDependency IssueKind = "missing_dependency"
+)
+
+// Issue describes an inconsistency found in a store.
+type Issue struct {
+	Kind   IssueKind `json:"kind"`
+	Ref    string    `json:"ref"`
+	Detail string    `json:"detail"`
+
+	// Repair fixes the issue, or is nil if the issue cannot be fixed safely
+	// without intervention.
+	Repair func(ctx context.Context, store Store) error `json:"-"`
+}
+
+func (i Issue) String() string {
+	return fmt.Sprintf("%s %s: %s", i.Kind, i.Ref, i.Detail)
+}
+
+// CheckLinks finds links in a store whose targets do not exist.
+// Dangling links are repaired by removing them.
+func CheckLinks(ctx context.Context, store Store) ([]Issue, error) {
+	allRefs, err := store.Match(ctx, "**")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list refs: %w", err)
+	}
+	nonLinks, err := NonLinks(ctx, store)
+	if err != nil {
+		return nil, err
+	}
+
+	var issues []Issue
+	for _, ref := range allRefs {
+		if _, ok := nonLinks[ref]; ok {
+			continue
+		}
+
+		target, err := store.ResolveLink(ctx, ref)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve link %s: %w", ref, err)
+		}
+		var v any
+		err = store.Get(ctx, ref, &v)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, ErrRefNotFound) {
+			return nil, fmt.Errorf("failed to get %s: %w", ref, err)
+		}
+
+		link := ref
+		issues = append(issues, Issue{
+			Kind:   IssueDanglingLink,
+			Ref:    link,
+			Detail: fmt.Sprintf("target %s does not exist", target),
+			Repair: func(ctx context.Context, store Store) error {
+				return store.Unlink(ctx, link)
+			},
+		})
+	}
+	return issues, nil
+}
+
+// CheckDependencies finds dependency edges that are only recorded for one of
+// the two refs involved. These are repaired by adding the edge again, which
+// records it for both.
+func CheckDependencies(ctx context.Context, store Store) ([]Issue, error) {
+	allRefs, err := store.Match(ctx, "**")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list refs: %w", err)
+	}
+
+	// Edges are loaded once for each ref, as sets for quick lookup
+	dependencies := make(map[string]map[string]struct{})
+	dependants := make(map[string]map[string]struct{})
+	edges := func(cache map[string]map[string]struct{}, get func(context.Context, string) ([]string, error), ref string) (map[string]struct{}, error) {
+		if set, ok := cache[ref]; ok {
+			return set, nil
+		}
+		list, err := get(ctx, ref)
+		if err != nil {
+			return nil, err
+		}
+		set := make(map[string]struct{}, len(list))
+		for _, r := range list {
+			set[r] = struct{}{}
+		}
+		cache[ref] = set
+		return set, nil
+	}
+
+	var issues []Issue
+	addEdge := func(kind IssueKind, ref, dependency, detail string) {
+		issues = append(issues, Issue{
+			Kind:   kind,
+			Ref:    ref,
+			Detail: detail,
+			Repair: func(ctx context.Context, store Store) error {
+				return store.AddDependency(ctx, ref, dependency)
+			},
+		})
+	}
+
+	for _, ref := range allRefs {
+		deps, err := edges(dependencies, store.GetDependencies, ref)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get dependencies of %s: %w", ref, err)
+		}
+		for _, dep := range sortedSet(deps) {
+			depDependants, err := edges(dependants, store.GetDependants, dep)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get dependants of %s: %w", dep, err)
+			}
+			if _, ok := depDependants[ref]; !ok {
+				addEdge(IssueMissingDependant, ref, dep, fmt.Sprintf("depends on %s, which does not list it as a dependant", dep))
+			}
+		}
+
+		refDependants, err := edges(dependants, store.GetDependants, ref)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get dependants of %s: %w", ref, err)
+		}
+		for _, dependant := range sortedSet(refDependants) {
+			dependantDeps, err := edges(dependencies, store.GetDependencies, dependant)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get dependencies of %s: %w", dependant, err)
+			}
+			if _, ok := dependantDeps[ref]; !ok {
+				addEdge(IssueMissingDependency, dependant, ref, fmt.Sprintf("listed as a dependant of %s, but does not depend on it", ref))
+			}
+		}
+	}
+	return issues, nil
+}
+
+// Repair fixes every repairable issue in a single transaction, returning the
+// number of issues repaired.
+// If a repair fails, the transaction is rolled back so that no repairs are
+// made.
+func Repair(ctx context.Context, store Store, issues []Issue) (int, error) {
+	if err := store.StartTransaction(ctx, "repair state"); err != nil {
+		return 0, fmt.Errorf("failed to start transaction: %w", err)
+	}
+
+	var repaired int
+	for _, issue := range issues {
+		if issue.Repair == nil {
+			continue
+		}
+		if err := issue.Repair(ctx, store); err != nil {
+			repairErr := fmt.Errorf("failed to repair %s: %w", issue, err)
+			if err := RollbackTransaction(ctx, store); err != nil {
+				return 0, errors.Join(repairErr, fmt.Errorf("failed to roll back transaction: %w", err))
+			}
+			return 0, repairErr
+		}
+		repaired++
+	}
+
+	if err := store.CommitTransaction(ctx); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return repaired, nil
+}
+
+// NonLinks returns the set of refs in a store that are not links.
+func NonLinks(ctx context.Context, store Store) (map[string]struct{}, error) {
+	nonLinks, err := store.MatchOptions(ctx, MatchOptions{NoLinks: true}, "**")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list refs: %w", err)
+	}
+	out := make(map[string]struct{}, len(nonLinks))
+	for _, ref := range nonLinks {
+		out[ref] = struct{}{}
+	}
+	return out, nil
+}
+
+func sortedSet(set map[string]struct{}) []string {
+	out := make([]string, 0, len(set))
+	for v := range set {
+		out = append(out, v)
+	}
+	sort.Strings(out)
+	return out
+}
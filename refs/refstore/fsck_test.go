@@ -0,0 +1,167 @@
+package refstore
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestFsck(t *testing.T) {
+	ctx := context.Background()
+
+	store, err := NewFSRefStore(t.TempDir(), map[string]struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	release := "github.com/example/repo.git/path/to/package/@r1"
+	previous := "github.com/example/repo.git/path/to/package/@r0"
+	deploy := release + "/deploy/staging"
+	other := release + "/deploy/production"
+	if err := store.Set(ctx, release, map[string]any{"commit": "abc"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set(ctx, deploy, map[string]any{"status": "complete"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set(ctx, other, map[string]any{"status": "complete"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Link(ctx, "github.com/example/repo.git/path/to/package/@", release); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set(ctx, previous, map[string]any{"commit": "def"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Link(ctx, "github.com/example/repo.git/path/to/package/@previous", previous); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddDependency(ctx, deploy, release); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddDependency(ctx, other, release); err != nil {
+		t.Fatal(err)
+	}
+
+	checkAll := func() []Issue {
+		t.Helper()
+		linkIssues, err := CheckLinks(ctx, store)
+		if err != nil {
+			t.Fatal(err)
+		}
+		depIssues, err := CheckDependencies(ctx, store)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return append(linkIssues, depIssues...)
+	}
+
+	if issues := checkAll(); len(issues) != 0 {
+		t.Fatalf("expected no issues in a consistent store, got %v", issues)
+	}
+
+	// Break one link and one side of each dependency edge
+	if err := store.Delete(ctx, previous); err != nil {
+		t.Fatal(err)
+	}
+	_, dependantMarker := store.ActualDependencyPaths(ctx, deploy, release)
+	if err := os.Remove(dependantMarker); err != nil {
+		t.Fatal(err)
+	}
+	dependencyMarker, _ := store.ActualDependencyPaths(ctx, other, release)
+	if err := os.Remove(dependencyMarker); err != nil {
+		t.Fatal(err)
+	}
+
+	issues := checkAll()
+	kinds := make(map[IssueKind]string)
+	for _, issue := range issues {
+		kinds[issue.Kind] = issue.Ref
+	}
+	if len(issues) != 3 {
+		t.Fatalf("expected 3 issues, got %v", issues)
+	}
+	if kinds[IssueDanglingLink] != "github.com/example/repo.git/path/to/package/@previous" {
+		t.Errorf("dangling link not reported: %v", issues)
+	}
+	if kinds[IssueMissingDependant] != deploy {
+		t.Errorf("missing dependant not reported: %v", issues)
+	}
+	if kinds[IssueMissingDependency] != other {
+		t.Errorf("missing dependency not reported: %v", issues)
+	}
+
+	repaired, err := Repair(ctx, store, issues)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repaired != 3 {
+		t.Errorf("expected 3 repairs, got %d", repaired)
+	}
+	if issues := checkAll(); len(issues) != 0 {
+		t.Errorf("expected no issues after repair, got %v", issues)
+	}
+
+	deps, err := store.GetDependencies(ctx, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deps) != 1 || deps[0] != release {
+		t.Errorf("unexpected dependencies after repair: %v", deps)
+	}
+}
+
+func TestRepairFailure(t *testing.T) {
+	ctx := context.Background()
+
+	store, err := NewFSRefStore(t.TempDir(), map[string]struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issues := []Issue{
+		{
+			Kind: IssueDanglingLink,
+			Ref:  "repaired",
+			Repair: func(ctx context.Context, store Store) error {
+				return store.Set(ctx, "repaired", "ok")
+			},
+		},
+		{Kind: IssueDanglingLink, Ref: "unrepairable"},
+		{
+			Kind: IssueDanglingLink,
+			Ref:  "failing",
+			Repair: func(ctx context.Context, store Store) error {
+				return errors.New("injected failure")
+			},
+		},
+		{
+			Kind: IssueDanglingLink,
+			Ref:  "skipped",
+			Repair: func(ctx context.Context, store Store) error {
+				return store.Set(ctx, "skipped", "ok")
+			},
+		},
+	}
+
+	repaired, err := Repair(ctx, store, issues)
+	if err == nil || !strings.Contains(err.Error(), "injected failure") {
+		t.Fatalf("expected the repair failure, got %v", err)
+	}
+	if repaired != 0 {
+		t.Errorf("expected no repairs, got %d", repaired)
+	}
+
+	// The transaction is rolled back, so no repairs are made
+	if store.journal != nil {
+		t.Error("expected the repair transaction to be closed")
+	}
+	var v string
+	for _, ref := range []string{"repaired", "skipped"} {
+		if err := store.Get(ctx, ref, &v); !errors.Is(err, ErrRefNotFound) {
+			t.Errorf("expected %s not to be repaired, got %q, %v", ref, v, err)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package refstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ocuroot/ocuroot/refs"
+)
+
+// When history is enabled for an FSStateStore, every change to a ref is
+// appended to a history file in the base path. Each record contains the full
+// stored object, so the state of any ref can be reconstructed at any point
+// after history was enabled.
+//
+// The revision of a record is the time it was written, in nanoseconds since
+// the Unix epoch.
+const historyFile = ".ocuroot-history.jsonl"
+
+type fsHistoryRecord struct {
+	Time    time.Time       `json:"time"`
+	Path    string          `json:"path"`
+	Message string          `json:"message,omitempty"`
+	Deleted bool            `json:"deleted,omitempty"`
+	Object  json.RawMessage `json:"object,omitempty"`
+	// Audit records who deleted the ref. Other changes are audited in the
+	// stored object.
+	Audit *Audit `json:"audit,omitempty"`
+}
+
+func (r fsHistoryRecord) revision() string {
+	return strconv.FormatInt(r.Time.UnixNano(), 10)
+}
+
+// isRefPath returns true if a path relative to the base path holds the
+// content of a ref.
+func isRefPath(rel string) bool {
+	return filepath.Base(rel) == contentFile && strings.HasPrefix(rel, refsDir+string(filepath.Separator))
+}
+
+// recordHistory appends a change to the file at rel to the history file.
+// Content should be nil if the file was removed, in which case the removal is
+// audited with the audit of ctx.
+func (f *FSStateStore) recordHistory(ctx context.Context, message string, rel string, content []byte) error {
+	if !f.history || !isRefPath(rel) {
+		return nil
+	}
+
+	record := fsHistoryRecord{
+		Time:    time.Now().UTC(),
+		Path:    filepath.ToSlash(rel),
+		Message: message,
+		Deleted: content == nil,
+		Object:  content,
+	}
+	if record.Deleted {
+		record.Audit = auditFromContext(ctx)
+	}
+	recordJSON, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal history record: %w", err)
+	}
+
+	file, err := os.OpenFile(filepath.Join(f.BasePath, historyFile), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open history file: %w", err)
+	}
+	if _, err := file.Write(append(recordJSON, '\n')); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("failed to write history: %w", err)
+	}
+	return file.Close()
+}
+
+// readHistory calls fn for each record in the history file, oldest first.
+func (f *FSStateStore) readHistory(fn func(record fsHistoryRecord)) error {
+	file, err := os.Open(filepath.Join(f.BasePath, historyFile))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("failed to open history file: %w", err)
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	for {
+		var record fsHistoryRecord
+		if err := decoder.Decode(&record); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("failed to read history: %w", err)
+		}
+		fn(record)
+	}
+}
+
+// History implements HistoryStore.
+func (f *FSStateStore) History(ctx context.Context, ref string) ([]HistoryEntry, error) {
+	if !f.history {
+		return nil, ErrHistoryUnsupported
+	}
+
+	parsedRef, err := refs.Parse(ref)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ref: %w", err)
+	}
+	rel, err := f.relPath(f.pathToRef(parsedRef.SetFragment("")))
+	if err != nil {
+		return nil, err
+	}
+	rel = filepath.ToSlash(rel)
+
+	var (
+		out     []HistoryEntry
+		readErr error
+	)
+	err = f.readHistory(func(record fsHistoryRecord) {
+		if record.Path != rel || readErr != nil {
+			return
+		}
+		var content []byte
+		if !record.Deleted {
+			content = record.Object
+		}
+		entry, err := historyEntryFromObject(HistoryEntry{
+			Revision: record.revision(),
+			Time:     record.Time,
+			Message:  record.Message,
+			Audit:    record.Audit,
+		}, content)
+		if err != nil {
+			readErr = fmt.Errorf("failed to read history entry: %w", err)
+			return
+		}
+		out = append(out, entry)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if readErr != nil {
+		return nil, readErr
+	}
+
+	// Most recent first
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return compactHistory(out), nil
+}
+
+// RevisionAt implements HistoryStore.
+func (f *FSStateStore) RevisionAt(ctx context.Context, t time.Time) (string, error) {
+	if !f.history {
+		return "", ErrHistoryUnsupported
+	}
+
+	var revision string
+	err := f.readHistory(func(record fsHistoryRecord) {
+		if !record.Time.After(t) {
+			revision = record.revision()
+		}
+	})
+	if err != nil {
+		return "", err
+	}
+	if revision == "" {
+		return "", ErrRefNotFound
+	}
+	return revision, nil
+}
+
+// GetAt implements HistoryStore.
+// Only changes made since history was enabled are available.
+func (f *FSStateStore) GetAt(ctx context.Context, ref string, revision string, v any) error {
+	if !f.history {
+		return ErrHistoryUnsupported
+	}
+
+	at, err := strconv.ParseInt(revision, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid revision %q", revision)
+	}
+
+	files := make(map[string][]byte)
+	err = f.readHistory(func(record fsHistoryRecord) {
+		if record.Time.UnixNano() > at {
+			return
+		}
+		if record.Deleted {
+			delete(files, record.Path)
+			return
+		}
+		files[record.Path] = record.Object
+	})
+	if err != nil {
+		return err
+	}
+
+	snapshot := &FSStateStore{
+		BasePath: f.BasePath,
+		info:     f.info,
+		snapshot: func(p string) ([]byte, error) {
+			rel, err := f.relPath(p)
+			if err != nil {
+				return nil, err
+			}
+			content, ok := files[filepath.ToSlash(rel)]
+			if !ok {
+				return nil, &fs.PathError{Op: "open", Path: p, Err: fs.ErrNotExist}
+			}
+			return content, nil
+		},
+	}
+	return snapshot.Get(ctx, ref, v)
+}
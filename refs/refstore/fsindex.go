@@ -0,0 +1,257 @@
+package refstore
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	libglob "github.com/gobwas/glob"
+)
+
+// fsIndexRacyWindow is how recently a directory may have been modified for
+// its cached entries to be distrusted. Changes made within the resolution of
+// the filesystem's timestamps may not change the modification time, so
+// recently modified directories are read again on every match.
+const fsIndexRacyWindow = 2 * time.Second
+
+// fsIndex is a lazily built index of the directories under the refs
+// directory of an FSStateStore, so that matching refs does not require
+// reading every directory in the store.
+//
+// Each directory in the index records its modification time when it was
+// read. Adding or removing a ref changes the modification time of its parent
+// directory, so a directory is only read again when it has changed. This
+// includes changes made by other processes, or by pulling a git store.
+type fsIndex struct {
+	mu   sync.Mutex
+	root *fsIndexNode
+}
+
+type fsIndexNode struct {
+	loaded  bool
+	modTime time.Time
+	racy    bool
+
+	// ref is true if the directory contains a ref or link
+	ref      bool
+	names    []string
+	children map[string]*fsIndexNode
+}
+
+// match calls fn with the path, relative to dir, of each ref that may match
+// one of the globs. Subtrees that cannot contain a match are not visited.
+func (i *fsIndex) match(dir string, globs []string, fn func(relPath string)) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.root == nil {
+		i.root = &fsIndexNode{}
+	}
+
+	var prefixes []globSegments
+	for _, glob := range globs {
+		prefixes = append(prefixes, splitGlobSegments(glob))
+	}
+
+	states := make([]int, len(prefixes))
+	exists, err := i.visit(i.root, dir, "", prefixes, states, fn)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		i.root = nil
+	}
+	return nil
+}
+
+// visit refreshes the node for the directory at p as needed, then visits its
+// children. It returns false if the directory no longer exists.
+func (i *fsIndex) visit(
+	node *fsIndexNode,
+	p string,
+	relPath string,
+	prefixes []globSegments,
+	states []int,
+	fn func(relPath string),
+) (bool, error) {
+	if err := node.refresh(p); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if node.ref && relPath != "" {
+		fn(relPath)
+	}
+
+	var removed bool
+	for _, name := range node.names {
+		next, ok := advanceGlobSegments(prefixes, states, name)
+		if !ok {
+			continue
+		}
+
+		childRelPath := name
+		if relPath != "" {
+			childRelPath = relPath + string(filepath.Separator) + name
+		}
+		exists, err := i.visit(node.children[name], p+string(filepath.Separator)+name, childRelPath, prefixes, next, fn)
+		if err != nil {
+			return false, err
+		}
+		if !exists {
+			delete(node.children, name)
+			removed = true
+		}
+	}
+	if removed {
+		node.names = sortedKeys(node.children)
+	}
+	return true, nil
+}
+
+// refresh reads the entries of the directory at p if it has changed since
+// it was last read.
+func (n *fsIndexNode) refresh(p string) error {
+	info, err := os.Stat(p)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return &fs.PathError{Op: "stat", Path: p, Err: fs.ErrNotExist}
+	}
+
+	if n.loaded && !n.racy && info.ModTime().Equal(n.modTime) {
+		return nil
+	}
+
+	entries, err := os.ReadDir(p)
+	if err != nil {
+		return err
+	}
+
+	children := make(map[string]*fsIndexNode)
+	n.ref = false
+	for _, entry := range entries {
+		if entry.IsDir() {
+			if existing, ok := n.children[entry.Name()]; ok {
+				children[entry.Name()] = existing
+			} else {
+				children[entry.Name()] = &fsIndexNode{}
+			}
+			continue
+		}
+		if entry.Name() == contentFile {
+			n.ref = true
+		}
+	}
+
+	n.children = children
+	n.names = sortedKeys(children)
+	n.loaded = true
+	n.modTime = info.ModTime()
+	n.racy = time.Since(info.ModTime()) < fsIndexRacyWindow
+	return nil
+}
+
+// globSegments is a glob split into the patterns for each path segment, up
+// to the first segment that may match across separators.
+type globSegments struct {
+	segments []libglob.Glob
+
+	// open is true if the glob may match any path below the segments
+	open bool
+}
+
+// splitGlobSegments splits a glob into its path segments. Segments that
+// include a "**" or a separator inside braces or brackets can match any
+// number of path segments, so the glob is treated as matching any path
+// beneath the segments before them.
+func splitGlobSegments(glob string) globSegments {
+	var (
+		out     globSegments
+		depth   int
+		escaped bool
+		start   int
+		raw     []string
+		open    bool
+	)
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case escaped:
+			escaped = false
+			if c == '/' {
+				open = true
+			}
+		case c == '\\':
+			escaped = true
+		case c == '{' || c == '[':
+			depth++
+		case (c == '}' || c == ']') && depth > 0:
+			depth--
+		case c == '/' && depth > 0:
+			open = true
+		case c == '/':
+			raw = append(raw, glob[start:i])
+			start = i + 1
+		}
+		if open {
+			break
+		}
+	}
+	if !open {
+		raw = append(raw, glob[start:])
+	}
+
+	for _, segment := range raw {
+		if strings.Contains(segment, "**") {
+			open = true
+			break
+		}
+		g, err := libglob.Compile(segment, '/')
+		if err != nil {
+			open = true
+			break
+		}
+		out.segments = append(out.segments, g)
+	}
+	out.open = open
+	return out
+}
+
+// globOpen marks a glob that may match any path below the current one.
+const globOpen = -1
+
+// advanceGlobSegments returns the state of each glob after descending into
+// the directory named name. A state is the number of segments matched so
+// far, or globOpen. If no glob can match below the directory, false is
+// returned.
+func advanceGlobSegments(prefixes []globSegments, states []int, name string) ([]int, bool) {
+	next := make([]int, len(states))
+	var any bool
+	for k, state := range states {
+		prefix := prefixes[k]
+		switch {
+		case state == globOpen:
+			next[k] = globOpen
+		case state < len(prefix.segments) && prefix.segments[state].Match(name):
+			next[k] = state + 1
+			if next[k] == len(prefix.segments) && prefix.open {
+				next[k] = globOpen
+			}
+		case state == len(prefix.segments) && prefix.open:
+			next[k] = globOpen
+		default:
+			next[k] = len(prefix.segments) + 1
+			continue
+		}
+		any = true
+	}
+	return next, any
+}
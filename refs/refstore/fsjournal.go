@@ -0,0 +1,592 @@
+package refstore
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// Transactions on an FSStateStore are journaled. Each transaction has its
+// own directory under the pending journal directory, where changed files are
+// staged while it is open, and reads are served from the staged files where
+// present. On commit, a manifest of the changes is written and the
+// transaction's directory is moved to the committed journal directory, which
+// is the point at which the transaction becomes durable. The committed
+// changes are then applied to the store and the journal removed.
+//
+// Several processes may use a store at once. Committing and applying
+// journals is done while holding an exclusive lock on the store's lock file,
+// so transactions are applied one at a time. Each open transaction also holds
+// a lock on its own lock file, next to its directory, so that other processes
+// can tell whether its owner is still running.
+//
+// If a process exits before a transaction is committed, its pending journal
+// is discarded the next time the store is opened. If it exits while applying
+// a committed journal, the journal is applied again by the next process to
+// open the store or commit a transaction.
+const (
+	journalDir          = ".ocuroot-journal"
+	journalLockFile     = "lock"
+	journalPendingDir   = "pending"
+	journalCommittedDir = "committed"
+	journalFilesDir     = "files"
+	journalManifestFile = "manifest.json"
+	journalOwnerSuffix  = ".lock"
+)
+
+// errLocked is returned when a lock is held by another transaction or
+// process.
+var errLocked = errors.New("file is locked")
+
+// fsJournal tracks the changes staged in an open transaction.
+type fsJournal struct {
+	id      string
+	message string
+
+	// staged maps paths relative to the store base path to whether the
+	// file at that path has been deleted.
+	staged map[string]bool
+	// audits records who deleted each deleted path
+	audits map[string]*Audit
+
+	// owner is locked for as long as the transaction is open
+	owner *os.File
+}
+
+type journalManifest struct {
+	Message string         `json:"message"`
+	Entries []journalEntry `json:"entries"`
+}
+
+type journalEntry struct {
+	Path    string `json:"path"`
+	Deleted bool   `json:"deleted,omitempty"`
+	Audit   *Audit `json:"audit,omitempty"`
+}
+
+func (f *FSStateStore) journalPath(elem ...string) string {
+	return filepath.Join(append([]string{f.BasePath, journalDir}, elem...)...)
+}
+
+// pendingPath returns a path within the pending journal of a transaction.
+func (f *FSStateStore) pendingPath(j *fsJournal, elem ...string) string {
+	return f.journalPath(append([]string{journalPendingDir, j.id}, elem...)...)
+}
+
+func (f *FSStateStore) relPath(p string) (string, error) {
+	rel, err := filepath.Rel(f.BasePath, p)
+	if err != nil {
+		return "", fmt.Errorf("failed to get relative path for %s: %w", p, err)
+	}
+	return rel, nil
+}
+
+// stagedState reports whether the file at p has been changed in the open
+// transaction, and if so whether it was deleted.
+func (f *FSStateStore) stagedState(p string) (staged bool, deleted bool, err error) {
+	if f.journal == nil {
+		return false, false, nil
+	}
+	rel, err := f.relPath(p)
+	if err != nil {
+		return false, false, err
+	}
+	deleted, staged = f.journal.staged[rel]
+	return staged, deleted, nil
+}
+
+// stagedFilesUnder returns the paths of files with the given name under dir
+// that have been written in the open transaction.
+func (f *FSStateStore) stagedFilesUnder(dir string, name string) []string {
+	if f.journal == nil {
+		return nil
+	}
+
+	var out []string
+	for rel, deleted := range f.journal.staged {
+		if deleted || filepath.Base(rel) != name {
+			continue
+		}
+		p := filepath.Join(f.BasePath, rel)
+		if strings.HasPrefix(p, dir+string(filepath.Separator)) {
+			out = append(out, p)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func (f *FSStateStore) readFile(p string) ([]byte, error) {
+	if f.snapshot != nil {
+		return f.snapshot(p)
+	}
+
+	staged, deleted, err := f.stagedState(p)
+	if err != nil {
+		return nil, err
+	}
+	if deleted {
+		return nil, &fs.PathError{Op: "open", Path: p, Err: fs.ErrNotExist}
+	}
+	if staged {
+		rel, err := f.relPath(p)
+		if err != nil {
+			return nil, err
+		}
+		return os.ReadFile(f.pendingPath(f.journal, journalFilesDir, rel))
+	}
+	return os.ReadFile(p)
+}
+
+func (f *FSStateStore) fileExists(p string) (bool, error) {
+	staged, deleted, err := f.stagedState(p)
+	if err != nil {
+		return false, err
+	}
+	if staged {
+		return !deleted, nil
+	}
+
+	if _, err := os.Stat(p); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// writeFile writes data to the file at p, creating parent directories as
+// needed. Inside a transaction, the write is staged in the journal.
+func (f *FSStateStore) writeFile(ctx context.Context, p string, data []byte) error {
+	rel, err := f.relPath(p)
+	if err != nil {
+		return err
+	}
+
+	if f.journal == nil {
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			return fmt.Errorf("failed to create directory %s: %v", filepath.Dir(p), err)
+		}
+		if err := os.WriteFile(p, data, 0644); err != nil {
+			return err
+		}
+		return f.recordHistory(ctx, "", rel, data)
+	}
+
+	stagedPath := f.pendingPath(f.journal, journalFilesDir, rel)
+	if err := os.MkdirAll(filepath.Dir(stagedPath), 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %v", filepath.Dir(stagedPath), err)
+	}
+	if err := writeFileSync(stagedPath, data); err != nil {
+		return fmt.Errorf("failed to stage %s: %w", rel, err)
+	}
+
+	f.journal.staged[rel] = false
+	delete(f.journal.audits, rel)
+	return nil
+}
+
+// removeFile removes the file at p. Inside a transaction, the removal is
+// staged in the journal.
+func (f *FSStateStore) removeFile(ctx context.Context, p string) error {
+	rel, err := f.relPath(p)
+	if err != nil {
+		return err
+	}
+
+	if f.journal == nil {
+		if err := os.Remove(p); err != nil {
+			return err
+		}
+		return f.recordHistory(ctx, "", rel, nil)
+	}
+
+	exists, err := f.fileExists(p)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return &fs.PathError{Op: "remove", Path: p, Err: fs.ErrNotExist}
+	}
+
+	stagedPath := f.pendingPath(f.journal, journalFilesDir, rel)
+	if err := os.Remove(stagedPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to remove staged file %s: %w", rel, err)
+	}
+
+	f.journal.staged[rel] = true
+	f.journal.audits[rel] = auditFromContext(ctx)
+	return nil
+}
+
+func (f *FSStateStore) startJournal(message string) error {
+	if f.journal != nil {
+		// Continue the open transaction
+		f.journal.message = message
+		return nil
+	}
+
+	var random [4]byte
+	if _, err := rand.Read(random[:]); err != nil {
+		return fmt.Errorf("failed to generate journal id: %w", err)
+	}
+	j := &fsJournal{
+		// Journals are named in the order they were started
+		id:      fmt.Sprintf("%020d-%d-%x", time.Now().UnixNano(), os.Getpid(), random),
+		message: message,
+		staged:  make(map[string]bool),
+		audits:  make(map[string]*Audit),
+	}
+
+	if err := os.MkdirAll(f.journalPath(journalPendingDir), 0755); err != nil {
+		return fmt.Errorf("failed to create pending journal: %w", err)
+	}
+
+	// The journal is created while holding the store lock, so recovery never
+	// sees a journal that is only partly created
+	unlock, err := f.lockStore()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	owner, err := os.OpenFile(f.journalPath(journalPendingDir, j.id+journalOwnerSuffix), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create journal lock: %w", err)
+	}
+	j.owner = owner
+	if err := lockFile(owner, false); err != nil {
+		f.releaseJournal(j)
+		return fmt.Errorf("failed to lock journal: %w", err)
+	}
+	if err := os.MkdirAll(f.pendingPath(j, journalFilesDir), 0755); err != nil {
+		f.releaseJournal(j)
+		return fmt.Errorf("failed to create pending journal: %w", err)
+	}
+
+	f.journal = j
+	return nil
+}
+
+func (f *FSStateStore) commitJournal() error {
+	if f.journal == nil {
+		return nil
+	}
+
+	j := f.journal
+	f.journal = nil
+	defer f.releaseJournal(j)
+
+	// Nothing to apply, as may happen when a transaction only reserved refs
+	if len(j.staged) == 0 {
+		return nil
+	}
+
+	manifest := journalManifest{
+		Message: j.message,
+	}
+	for rel, deleted := range j.staged {
+		manifest.Entries = append(manifest.Entries, journalEntry{
+			Path:    rel,
+			Deleted: deleted,
+			Audit:   j.audits[rel],
+		})
+	}
+	sort.Slice(manifest.Entries, func(i, k int) bool {
+		return manifest.Entries[i].Path < manifest.Entries[k].Path
+	})
+
+	manifestJSON, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal journal manifest: %w", err)
+	}
+	if err := writeFileSync(f.pendingPath(j, journalManifestFile), manifestJSON); err != nil {
+		return fmt.Errorf("failed to write journal manifest: %w", err)
+	}
+
+	unlock, err := f.lockStore()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Finish applying any transaction whose process exited before applying it
+	if err := f.applyCommittedJournals(); err != nil {
+		return err
+	}
+
+	committed := f.journalPath(journalCommittedDir, j.id)
+	if err := os.MkdirAll(filepath.Dir(committed), 0755); err != nil {
+		return fmt.Errorf("failed to create committed journal: %w", err)
+	}
+	if err := os.Rename(f.pendingPath(j), committed); err != nil {
+		return fmt.Errorf("failed to commit journal: %w", err)
+	}
+
+	return f.applyCommittedJournal(committed)
+}
+
+// rollbackJournal discards all changes staged in the open transaction.
+func (f *FSStateStore) rollbackJournal() error {
+	if f.journal == nil {
+		return nil
+	}
+	j := f.journal
+	f.journal = nil
+	return f.releaseJournal(j)
+}
+
+// releaseJournal removes a journal if it is still pending, then releases its
+// owner lock.
+func (f *FSStateStore) releaseJournal(j *fsJournal) error {
+	// The journal is removed before its lock is released, so that recovery
+	// in another process never sees a journal without an owner
+	err := os.RemoveAll(f.pendingPath(j))
+	if err != nil {
+		err = fmt.Errorf("failed to remove pending journal: %w", err)
+	}
+	if j.owner != nil {
+		_ = j.owner.Close()
+		if removeErr := os.Remove(j.owner.Name()); removeErr != nil && !errors.Is(removeErr, fs.ErrNotExist) && err == nil {
+			err = fmt.Errorf("failed to remove journal lock: %w", removeErr)
+		}
+	}
+	return err
+}
+
+// lockStore takes the lock used to commit and apply journals, waiting for
+// other processes to release it. The returned function releases the lock.
+func (f *FSStateStore) lockStore() (func(), error) {
+	if err := os.MkdirAll(f.journalPath(), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create journal directory: %w", err)
+	}
+	file, err := os.OpenFile(f.journalPath(journalLockFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open store lock: %w", err)
+	}
+	if err := lockFile(file, true); err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("failed to lock store: %w", err)
+	}
+	return func() {
+		_ = file.Close()
+	}, nil
+}
+
+// recoverJournal restores the store to a consistent state after a process
+// exited during a transaction.
+func (f *FSStateStore) recoverJournal() error {
+	pending := f.journalPath(journalPendingDir)
+	entries, err := os.ReadDir(pending)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to read pending journals: %w", err)
+	}
+	if len(entries) == 0 {
+		if _, err := os.Stat(f.journalPath(journalCommittedDir)); errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+	}
+
+	unlock, err := f.lockStore()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	for _, entry := range entries {
+		id := strings.TrimSuffix(entry.Name(), journalOwnerSuffix)
+		if !entry.IsDir() && id == entry.Name() {
+			continue
+		}
+		owned, err := journalOwned(filepath.Join(pending, id+journalOwnerSuffix))
+		if err != nil {
+			return err
+		}
+		if owned {
+			// Still open in a running process
+			continue
+		}
+
+		if entry.IsDir() {
+			log.Warn("Discarding uncommitted transaction", "basePath", f.BasePath, "journal", id)
+			if err := os.RemoveAll(filepath.Join(pending, id)); err != nil {
+				return fmt.Errorf("failed to remove pending journal: %w", err)
+			}
+		}
+		// Locks are left behind by processes that exited after committing
+		if err := os.Remove(filepath.Join(pending, id+journalOwnerSuffix)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to remove journal lock: %w", err)
+		}
+	}
+
+	return f.applyCommittedJournals()
+}
+
+// journalOwned returns true if the owner lock at p is held by an open
+// transaction.
+func journalOwned(p string) (bool, error) {
+	file, err := os.OpenFile(p, os.O_RDWR, 0)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to open journal lock: %w", err)
+	}
+	defer file.Close()
+
+	if err := lockFile(file, false); err != nil {
+		if errors.Is(err, errLocked) {
+			return true, nil
+		}
+		return false, fmt.Errorf("failed to check journal lock: %w", err)
+	}
+	return false, nil
+}
+
+// applyCommittedJournals applies any journals that were committed but not
+// applied, in the order they were started. The store lock must be held.
+func (f *FSStateStore) applyCommittedJournals() error {
+	entries, err := os.ReadDir(f.journalPath(journalCommittedDir))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("failed to read committed journals: %w", err)
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		log.Warn("Applying committed transaction", "basePath", f.BasePath, "journal", entry.Name())
+		if err := f.applyCommittedJournal(f.journalPath(journalCommittedDir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyCommittedJournal applies the committed journal in dir to the store,
+// then removes it. The store lock must be held.
+func (f *FSStateStore) applyCommittedJournal(committed string) error {
+	manifestJSON, err := os.ReadFile(filepath.Join(committed, journalManifestFile))
+	if err != nil {
+		return fmt.Errorf("failed to read journal manifest: %w", err)
+	}
+
+	var manifest journalManifest
+	if err := json.Unmarshal(manifestJSON, &manifest); err != nil {
+		return fmt.Errorf("failed to unmarshal journal manifest: %w", err)
+	}
+
+	for _, entry := range manifest.Entries {
+		target := filepath.Join(f.BasePath, entry.Path)
+
+		if entry.Deleted {
+			if err := os.Remove(target); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return fmt.Errorf("failed to remove %s: %w", entry.Path, err)
+			}
+			if err := removeDirIfEmpty(filepath.Dir(target)); err != nil {
+				return err
+			}
+			// Deletions are audited as they were staged
+			ctx := ContextWithAudit(context.Background(), entry.Audit)
+			if err := f.recordHistory(ctx, manifest.Message, entry.Path, nil); err != nil {
+				return err
+			}
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(committed, journalFilesDir, entry.Path))
+		if err != nil {
+			return fmt.Errorf("failed to read staged file %s: %w", entry.Path, err)
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return fmt.Errorf("failed to create directory %s: %v", filepath.Dir(target), err)
+		}
+		if err := writeFileAtomic(target, data); err != nil {
+			return fmt.Errorf("failed to write %s: %w", entry.Path, err)
+		}
+		if err := f.recordHistory(context.Background(), manifest.Message, entry.Path, data); err != nil {
+			return err
+		}
+	}
+
+	if err := os.RemoveAll(committed); err != nil {
+		return fmt.Errorf("failed to remove committed journal: %w", err)
+	}
+	return nil
+}
+
+func removeDirIfEmpty(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	if len(entries) > 0 {
+		return nil
+	}
+	if err := os.Remove(dir); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// writeFileSync writes a file and flushes it to disk before returning.
+func writeFileSync(p string, data []byte) error {
+	file, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// writeFileAtomic replaces the file at p so that readers will see either
+// the previous or the new content, never a partial write.
+func writeFileAtomic(p string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	cleanup := func(err error) error {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		return cleanup(err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return cleanup(err)
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		return cleanup(err)
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
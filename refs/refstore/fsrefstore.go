@@ -18,9 +18,20 @@ import (
 )
 
 func NewFSRefStore(basePath string, tags map[string]struct{}) (*FSStateStore, error) {
+	return NewFSRefStoreWithConfig(basePath, tags, FSRefStoreConfig{})
+}
+
+type FSRefStoreConfig struct {
+	// History enables recording every change to a ref in an append-only
+	// history file, so previous values may be retrieved.
+	History bool
+}
+
+func NewFSRefStoreWithConfig(basePath string, tags map[string]struct{}, cfg FSRefStoreConfig) (*FSStateStore, error) {
 	log.Info("Initializing FSRefStore", "basePath", basePath, "tags", tags)
 	f := &FSStateStore{
 		BasePath: basePath,
+		history:  cfg.History,
 	}
 
 	var info StoreInfo
@@ -52,10 +63,12 @@ func NewFSRefStore(basePath string, tags map[string]struct{}) (*FSStateStore, er
 			return nil, fmt.Errorf("failed to unmarshal store info: %v", err)
 		}
 
-		// Backwards compatibility: Update tags if not currently set
-		if info.Version == 2 {
-			info.Version = 3
-			info.Tags = tags
+		upgraded, err := f.fsUpgrades(tags).apply(&info)
+		if err != nil {
+			return nil, err
+		}
+		if upgraded {
+			log.Info("Upgraded store", "infoFile", infoFile, "version", info.Version)
 			infoBytes, err := json.Marshal(info)
 			if err != nil {
 				return nil, fmt.Errorf("failed to marshal store info: %v", err)
@@ -67,11 +80,12 @@ func NewFSRefStore(basePath string, tags map[string]struct{}) (*FSStateStore, er
 		}
 	}
 
-	if info.Version != stateVersion {
-		return nil, fmt.Errorf("incompatible store version: expected %d, got %d", stateVersion, info.Version)
+	f.info = info
+
+	if err := f.recoverJournal(); err != nil {
+		return nil, fmt.Errorf("failed to recover journal: %w", err)
 	}
 
-	f.info = info
 	return f, nil
 }
 
@@ -87,6 +101,11 @@ const (
 )
 
 var _ Store = (*FSStateStore)(nil)
+var _ ConditionalStore = (*FSStateStore)(nil)
+var _ RollbackStore = (*FSStateStore)(nil)
+var _ HistoryStore = (*FSStateStore)(nil)
+var _ WatchableStore = (*FSStateStore)(nil)
+var _ MetadataStore = (*FSStateStore)(nil)
 var _ PathResolver = (*FSStateStore)(nil)
 
 type PathResolver interface {
@@ -106,6 +125,8 @@ type StorageObject struct {
 	BodyType    string          `json:"body_type,omitempty"`
 	CreateStack []string        `json:"create_stack,omitempty"`
 	SetStack    []string        `json:"set_stack,omitempty"`
+	CreateAudit *Audit          `json:"create_audit,omitempty"`
+	SetAudit    *Audit          `json:"set_audit,omitempty"`
 	Links       []string        `json:"links,omitempty"`
 	Body        json.RawMessage `json:"body"`
 }
@@ -113,31 +134,81 @@ type StorageObject struct {
 type FSStateStore struct {
 	BasePath string
 
-	info StoreInfo
+	info    StoreInfo
+	journal *fsJournal
+	history bool
+	index   fsIndex
+
+	// snapshot, if set, serves all reads from a previous state of the store
+	snapshot func(p string) ([]byte, error)
 }
 
 func (f *FSStateStore) Info() StoreInfo {
 	return f.info
 }
 
+// StartTransaction implements RefStore.
+// Changes made until the transaction is committed are staged in a journal.
 func (f *FSStateStore) StartTransaction(ctx context.Context, message string) error {
-	return nil
+	return f.startJournal(message)
 }
 
+// CommitTransaction implements RefStore.
+// All changes staged since the transaction was started are applied together.
 func (f *FSStateStore) CommitTransaction(ctx context.Context) error {
-	return nil
+	return f.commitJournal()
+}
+
+// RollbackTransaction implements RollbackStore.
+// The journal of staged changes is discarded.
+func (f *FSStateStore) RollbackTransaction(ctx context.Context) error {
+	return f.rollbackJournal()
 }
 
 // Close implements RefStore.
+// Any uncommitted transaction is rolled back.
 func (f *FSStateStore) Close() error {
-	return nil
+	return f.rollbackJournal()
 }
 
 // Get implements RefStore.
 func (f *FSStateStore) Get(ctx context.Context, ref string, v any) error {
+	storageObject, fragment, err := f.getStorageObject(ref)
+	if err != nil {
+		return err
+	}
+
+	return unmarshalFragment(storageObject.Body, fragment, v)
+}
+
+// GetWithRevision implements ConditionalStore.
+func (f *FSStateStore) GetWithRevision(ctx context.Context, ref string, v any) (string, error) {
+	storageObject, fragment, err := f.getStorageObject(ref)
+	if err != nil {
+		return "", err
+	}
+
+	if err := unmarshalFragment(storageObject.Body, fragment, v); err != nil {
+		return "", err
+	}
+	return revisionOf(storageObject.Body), nil
+}
+
+// GetMetadata implements MetadataStore.
+func (f *FSStateStore) GetMetadata(ctx context.Context, ref string) (ObjectMetadata, error) {
+	storageObject, _, err := f.getStorageObject(ref)
+	if err != nil {
+		return ObjectMetadata{}, err
+	}
+	return metadataOf(storageObject), nil
+}
+
+// getStorageObject loads the object for a ref, following any links.
+// The fragment of the ref is returned separately.
+func (f *FSStateStore) getStorageObject(ref string) (StorageObject, string, error) {
 	parsedRef, err := refs.Parse(ref)
 	if err != nil {
-		return fmt.Errorf("failed to parse ref: %w", err)
+		return StorageObject{}, "", fmt.Errorf("failed to parse ref: %w", err)
 	}
 
 	refWithoutFragment := parsedRef
@@ -145,124 +216,282 @@ func (f *FSStateStore) Get(ctx context.Context, ref string, v any) error {
 
 	targetRef, err := f.resolveLink(refWithoutFragment.String())
 	if err != nil {
-		return fmt.Errorf("failed to resolve link: %w", err)
+		return StorageObject{}, "", fmt.Errorf("failed to resolve link: %w", err)
 	}
 
 	fp := f.pathToRef(targetRef)
-	jsonContent, err := os.ReadFile(fp)
+	jsonContent, err := f.readFile(fp)
 	if err != nil {
 		if errors.Is(err, fs.ErrNotExist) {
-			return ErrRefNotFound
+			return StorageObject{}, "", ErrRefNotFound
 		}
-		return err
+		return StorageObject{}, "", err
 	}
 
 	var storageObject StorageObject
 	if err := json.Unmarshal(jsonContent, &storageObject); err != nil {
-		return err
+		return StorageObject{}, "", err
 	}
 
 	if storageObject.Kind != StorageKindRef {
-		return fmt.Errorf("expected ref, got %s", storageObject.Kind)
+		return StorageObject{}, "", fmt.Errorf("expected ref, got %s", storageObject.Kind)
+	}
+
+	return storageObject, parsedRef.Fragment, nil
+}
+
+// Set implements RefStore.
+func (f *FSStateStore) Set(ctx context.Context, ref string, v any) error {
+	fp, err := f.pathForSet(ref)
+	if err != nil {
+		return err
 	}
 
-	if parsedRef.Fragment == "" {
-		return json.Unmarshal(storageObject.Body, v)
+	storageObjectJSON, err := f.encodeRef(ctx, fp, v)
+	if err != nil {
+		return err
+	}
+
+	return f.writeFile(ctx, fp, storageObjectJSON)
+}
+
+// SetIfAbsent implements ConditionalStore.
+// The ref is created atomically, so only one writer can create it even across
+// processes. Inside a transaction the ref is still created immediately rather
+// than staged, as a staged write could not be checked against other
+// transactions. Refs already changed in the transaction are checked against
+// the transaction's view of the store instead.
+func (f *FSStateStore) SetIfAbsent(ctx context.Context, ref string, v any) error {
+	fp, err := f.pathForSet(ref)
+	if err != nil {
+		return err
 	}
 
-	// Walk down the map with the fragment
-	var content any
-	if err := json.Unmarshal(storageObject.Body, &content); err != nil {
+	staged, deleted, err := f.stagedState(fp)
+	if err != nil {
 		return err
 	}
+	if staged && !deleted {
+		return ErrConflict
+	}
 
-	for _, fragment := range strings.Split(parsedRef.Fragment, "/") {
-		if contentMap, ok := content.(map[string]any); ok {
-			if contentMap[fragment] == nil {
-				return ErrRefNotFound
-			}
-			content = contentMap[fragment]
-		} else {
-			return ErrRefNotFound
+	storageObjectJSON, err := f.encodeRef(ctx, fp, v)
+	if err != nil {
+		return err
+	}
+
+	if staged {
+		// Deleted earlier in this transaction
+		return f.writeFile(ctx, fp, storageObjectJSON)
+	}
+
+	if err := createFileExclusive(fp, storageObjectJSON); err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return ErrConflict
 		}
+		return err
 	}
-	jsonContent, err = json.Marshal(content)
+
+	rel, err := f.relPath(fp)
 	if err != nil {
 		return err
 	}
+	return f.recordHistory(ctx, "", rel, storageObjectJSON)
+}
 
-	return json.Unmarshal(jsonContent, v)
+// SetIfRevision implements ConditionalStore.
+// The check and write are made while holding the store's lock, so they are
+// atomic with respect to other conditional writers and transaction commits,
+// even across processes. As with SetIfAbsent, inside a transaction the ref is
+// written immediately unless it has already been changed in the transaction,
+// in which case it is checked against the transaction's view of the store.
+func (f *FSStateStore) SetIfRevision(ctx context.Context, ref string, v any, revision string) error {
+	fp, err := f.pathForSet(ref)
+	if err != nil {
+		return err
+	}
+
+	staged, _, err := f.stagedState(fp)
+	if err != nil {
+		return err
+	}
+	if !staged {
+		unlock, err := f.lockStore()
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+
+	jsonContent, err := f.readFile(fp)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return ErrConflict
+		}
+		return err
+	}
+
+	var storageObject StorageObject
+	if err := json.Unmarshal(jsonContent, &storageObject); err != nil {
+		return err
+	}
+	if storageObject.Kind != StorageKindRef || revisionOf(storageObject.Body) != revision {
+		return ErrConflict
+	}
+
+	storageObjectJSON, err := f.encodeRef(ctx, fp, v)
+	if err != nil {
+		return err
+	}
+
+	if staged {
+		return f.writeFile(ctx, fp, storageObjectJSON)
+	}
+	if err := writeFileAtomic(fp, storageObjectJSON); err != nil {
+		return err
+	}
+
+	rel, err := f.relPath(fp)
+	if err != nil {
+		return err
+	}
+	return f.recordHistory(ctx, "", rel, storageObjectJSON)
 }
 
-// Set implements RefStore.
-func (f *FSStateStore) Set(ctx context.Context, ref string, v any) error {
+// pathForSet returns the path of the file to be written when setting a ref.
+func (f *FSStateStore) pathForSet(ref string) (string, error) {
 	parsedRef, err := f.resolveLink(ref)
 	if err != nil {
-		return fmt.Errorf("failed to resolve link: %v", err)
+		return "", fmt.Errorf("failed to resolve link: %v", err)
 	}
-	fp := f.pathToRef(parsedRef)
 
 	if parsedRef.Fragment != "" {
-		return fmt.Errorf("setting by fragment not supported")
+		return "", fmt.Errorf("setting by fragment not supported")
 	}
 
+	return f.pathToRef(parsedRef), nil
+}
+
+// encodeRef creates the stored content for a ref at path fp with value v.
+func (f *FSStateStore) encodeRef(ctx context.Context, fp string, v any) ([]byte, error) {
 	jsonBody, err := json.MarshalIndent(v, "", "  ")
 	if err != nil {
-		return err
-	}
-
-	// Create all necessary parent directories.
-	if err := os.MkdirAll(filepath.Dir(fp), 0755); err != nil {
-		return fmt.Errorf("failed to create directory %s: %v", filepath.Dir(fp), err)
+		return nil, err
 	}
 
 	storageObject := StorageObject{
 		Kind:     StorageKindRef,
-		BodyType: fmt.Sprintf("%T", v),
+		BodyType: bodyType(v),
 		Body:     jsonBody,
 	}
 
+	existing, err := f.existingStorageObject(fp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read storage object: %v", err)
+	}
+
+	if err := recordAudit(auditFromContext(ctx), existing, &storageObject); err != nil {
+		return nil, fmt.Errorf("failed to record audit: %v", err)
+	}
+
 	if os.Getenv("OCUROOT_DEBUG") != "" {
-		if err := recordStacks(fp, &storageObject); err != nil {
-			return fmt.Errorf("failed to record stacks: %v", err)
-		}
+		recordStacks(existing, &storageObject)
 	}
 
 	storageObject.Links, err = f.linksAtPath(fp)
 	if err != nil {
-		return fmt.Errorf("failed to get links: %v", err)
+		return nil, fmt.Errorf("failed to get links: %v", err)
 	}
 
 	storageObjectJSON, err := json.MarshalIndent(storageObject, "", "  ")
 	if err != nil {
-		return fmt.Errorf("failed to marshal storage object: %v", err)
+		return nil, fmt.Errorf("failed to marshal storage object: %v", err)
 	}
-
-	return os.WriteFile(fp, storageObjectJSON, 0644)
+	return storageObjectJSON, nil
 }
 
-func recordStacks(fp string, storageObject *StorageObject) error {
-	storageObject.SetStack = stackAsSlice()
+// createFileExclusive writes a new file at p, failing with fs.ErrExist if
+// the file already exists. The content is written to a temporary file first
+// so that readers never see a partial write.
+func createFileExclusive(p string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %v", filepath.Dir(p), err)
+	}
 
-	if _, err := os.Stat(fp); os.IsNotExist(err) {
-		storageObject.CreateStack = stackAsSlice()
-		return nil
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".tmp-*")
+	if err != nil {
+		return err
 	}
+	defer os.Remove(tmp.Name())
 
-	existingStorageObjectJSON, err := os.ReadFile(fp)
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	// Linking fails if the target exists, unlike renaming
+	return os.Link(tmp.Name(), p)
+}
+
+// existingStorageObject reads the object at fp, returning nil if there is
+// no object.
+func (f *FSStateStore) existingStorageObject(fp string) (*StorageObject, error) {
+	if exists, err := f.fileExists(fp); err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, nil
+	}
+
+	existingStorageObjectJSON, err := f.readFile(fp)
 	if err != nil {
-		return fmt.Errorf("failed to read storage object: %v", err)
+		return nil, err
 	}
 
 	var existingStorageObject StorageObject
 	if err := json.Unmarshal(existingStorageObjectJSON, &existingStorageObject); err != nil {
-		return fmt.Errorf("failed to unmarshal storage object: %v", err)
+		return nil, fmt.Errorf("failed to unmarshal storage object: %v", err)
 	}
-	storageObject.CreateStack = existingStorageObject.CreateStack
+	return &existingStorageObject, nil
+}
 
+// recordAudit records who wrote a storage object. If the value is unchanged,
+// the audit of the previous write is kept.
+func recordAudit(audit *Audit, existing *StorageObject, storageObject *StorageObject) error {
+	storageObject.SetAudit = audit
+	if existing == nil {
+		storageObject.CreateAudit = storageObject.SetAudit
+		return nil
+	}
+
+	storageObject.CreateAudit = existing.CreateAudit
+	if existing.Kind == storageObject.Kind && existing.SetAudit != nil {
+		unchanged, err := jsonEqual(existing.Body, storageObject.Body)
+		if err != nil {
+			return err
+		}
+		if unchanged {
+			storageObject.SetAudit = existing.SetAudit
+		}
+	}
 	return nil
 }
 
+func recordStacks(existing *StorageObject, storageObject *StorageObject) {
+	storageObject.SetStack = stackAsSlice()
+	if existing == nil {
+		storageObject.CreateStack = stackAsSlice()
+		return
+	}
+	storageObject.CreateStack = existing.CreateStack
+}
+
 func stackAsSlice() []string {
 	var out []string
 	for _, line := range strings.Split(string(debug.Stack()), "\n") {
@@ -289,13 +518,18 @@ func (f *FSStateStore) Delete(ctx context.Context, ref string) error {
 
 	rpath := f.pathToRef(parsedRef)
 
-	if err := os.Remove(rpath); err != nil {
+	if err := f.removeFile(ctx, rpath); err != nil {
 		if errors.Is(err, fs.ErrNotExist) {
 			return ErrRefNotFound
 		}
 		return err
 	}
 
+	if f.journal != nil {
+		// Empty directories are removed when the transaction is applied
+		return nil
+	}
+
 	// Delete the directory that contained this ref iff empty
 	dir := filepath.Dir(rpath)
 	if entries, err := os.ReadDir(dir); err != nil {
@@ -322,8 +556,10 @@ func (f *FSStateStore) Link(ctx context.Context, ref string, target string) erro
 
 	fp := f.pathToRef(parsedLinkRef)
 
-	if _, err := os.Stat(fp); err == nil {
-		storageObjectJSON, err := os.ReadFile(fp)
+	if exists, err := f.fileExists(fp); err != nil {
+		return err
+	} else if exists {
+		storageObjectJSON, err := f.readFile(fp)
 		if err != nil {
 			return fmt.Errorf("failed to read storage object: %v", err)
 		}
@@ -352,24 +588,20 @@ func (f *FSStateStore) Link(ctx context.Context, ref string, target string) erro
 			return err
 		}
 
-		if err := f.modifyRefList(oldTargetRef, ref, false); err != nil {
+		if err := f.modifyRefList(ctx, oldTargetRef, ref, false); err != nil {
 			return err
 		}
 	}
 
-	// Create all necessary parent directories.
-	if err := os.MkdirAll(filepath.Dir(fp), 0755); err != nil {
-		return fmt.Errorf("failed to create directory %s: %v", filepath.Dir(fp), err)
-	}
-
 	linkJSON, err := json.Marshal(parsedTargetRef.String())
 	if err != nil {
 		return err
 	}
 
 	storageObject := StorageObject{
-		Kind: StorageKindLink,
-		Body: linkJSON,
+		Kind:     StorageKindLink,
+		Body:     linkJSON,
+		SetAudit: auditFromContext(ctx),
 	}
 
 	storageObjectJSON, err := json.MarshalIndent(storageObject, "", "  ")
@@ -377,7 +609,7 @@ func (f *FSStateStore) Link(ctx context.Context, ref string, target string) erro
 		return fmt.Errorf("failed to marshal storage object: %v", err)
 	}
 
-	err = os.WriteFile(fp, storageObjectJSON, 0644)
+	err = f.writeFile(ctx, fp, storageObjectJSON)
 	if err != nil {
 		return fmt.Errorf("failed to write storage object: %v", err)
 	}
@@ -391,7 +623,7 @@ func (f *FSStateStore) Link(ctx context.Context, ref string, target string) erro
 		return fmt.Errorf("expected link, got %s", envelope.Kind)
 	}
 
-	err = f.modifyRefList(parsedTargetRef, parsedLinkRef.String(), true)
+	err = f.modifyRefList(ctx, parsedTargetRef, parsedLinkRef.String(), true)
 	if err != nil {
 		return err
 	}
@@ -407,8 +639,10 @@ func (f *FSStateStore) Unlink(ctx context.Context, ref string) error {
 
 	fp := f.pathToRef(parsedLinkRef)
 
-	if _, err := os.Stat(fp); err == nil {
-		err = os.Remove(fp)
+	if exists, err := f.fileExists(fp); err != nil {
+		return err
+	} else if exists {
+		err = f.removeFile(ctx, fp)
 		if err != nil {
 			return fmt.Errorf("failed to delete file %s: %v", fp, err)
 		}
@@ -417,11 +651,11 @@ func (f *FSStateStore) Unlink(ctx context.Context, ref string) error {
 	return nil
 }
 
-func (f *FSStateStore) modifyRefList(ref refs.Ref, link string, add bool) error {
+func (f *FSStateStore) modifyRefList(ctx context.Context, ref refs.Ref, link string, add bool) error {
 	fp := f.pathToRef(ref)
 
 	var storageObject StorageObject
-	storageObjectJSON, err := os.ReadFile(fp)
+	storageObjectJSON, err := f.readFile(fp)
 	if err != nil {
 		if errors.Is(err, fs.ErrNotExist) {
 			return nil
@@ -454,7 +688,7 @@ func (f *FSStateStore) modifyRefList(ref refs.Ref, link string, add bool) error
 		return fmt.Errorf("failed to marshal storage object: %v", err)
 	}
 
-	return os.WriteFile(fp, storageObjectJSON, 0644)
+	return f.writeFile(ctx, fp, storageObjectJSON)
 }
 
 func (f *FSStateStore) GetLinks(ctx context.Context, ref string) ([]string, error) {
@@ -470,7 +704,7 @@ func (f *FSStateStore) GetLinks(ctx context.Context, ref string) ([]string, erro
 
 func (f *FSStateStore) linksAtPath(path string) ([]string, error) {
 	var storageObject StorageObject
-	storageObjectJSON, err := os.ReadFile(path)
+	storageObjectJSON, err := f.readFile(path)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
 			return nil, nil
@@ -549,42 +783,44 @@ func (f *FSStateStore) matchRefs(globs []string, baseDir string) ([]string, erro
 	}
 
 	dir := filepath.Join(f.BasePath, baseDir)
-	if _, err := os.Stat(dir); err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			return nil, nil
-		}
-		return nil, err
-	}
 
 	var matchingRefs []string
-	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
+	seen := make(map[string]struct{})
+	matchCandidate := func(candidate string) {
+		if _, ok := seen[candidate]; ok {
+			return
 		}
-		if !d.IsDir() {
-			return nil
-		}
-
-		if _, err := os.Stat(filepath.Join(p, contentFile)); err != nil {
-			return nil
-		}
-
-		relPath, err := filepath.Rel(dir, p)
-		if err != nil {
-			return err
-		}
-		candidate := relPath
+		seen[candidate] = struct{}{}
 		for _, g := range compiledGlobs {
 			if g.Match(candidate) {
 				matchingRefs = append(matchingRefs, candidate)
-				return nil
+				return
 			}
 		}
-		return nil
+	}
+
+	err := f.index.match(dir, globs, func(relPath string) {
+		if f.journal != nil {
+			// Skip refs deleted in an open transaction
+			if _, deleted, err := f.stagedState(filepath.Join(dir, relPath, contentFile)); err == nil && deleted {
+				return
+			}
+		}
+		matchCandidate(relPath)
 	})
 	if err != nil {
 		return nil, err
 	}
+
+	// Include refs created in an open transaction
+	for _, p := range f.stagedFilesUnder(dir, contentFile) {
+		relPath, err := filepath.Rel(dir, filepath.Dir(p))
+		if err != nil {
+			return nil, err
+		}
+		matchCandidate(relPath)
+	}
+
 	return matchingRefs, nil
 }
 
@@ -598,16 +834,10 @@ func (f *FSStateStore) ActualDependencyPaths(ctx context.Context, ref string, de
 func (f *FSStateStore) AddDependency(ctx context.Context, ref string, dependency string) error {
 	dependencyMarkerPath, dependantMarkerPath := f.ActualDependencyPaths(ctx, ref, dependency)
 
-	if err := os.MkdirAll(filepath.Dir(dependencyMarkerPath), 0755); err != nil {
-		return err
-	}
-	if err := os.MkdirAll(filepath.Dir(dependantMarkerPath), 0755); err != nil {
+	if err := f.writeFile(ctx, dependencyMarkerPath, []byte(ref)); err != nil {
 		return err
 	}
-	if err := os.WriteFile(dependencyMarkerPath, []byte(ref), 0644); err != nil {
-		return err
-	}
-	if err := os.WriteFile(dependantMarkerPath, []byte(dependency), 0644); err != nil {
+	if err := f.writeFile(ctx, dependantMarkerPath, []byte(dependency)); err != nil {
 		return err
 	}
 	return nil
@@ -617,10 +847,10 @@ func (f *FSStateStore) RemoveDependency(ctx context.Context, ref string, depende
 	dependencyMarkerPath := filepath.Join(f.pathToDependencies(), ref, dependency, refMarkerFile)
 	dependantMarkerPath := filepath.Join(f.pathToDependants(), dependency, ref, refMarkerFile)
 
-	if err := os.Remove(dependencyMarkerPath); err != nil {
+	if err := f.removeFile(ctx, dependencyMarkerPath); err != nil {
 		return err
 	}
-	if err := os.Remove(dependantMarkerPath); err != nil {
+	if err := f.removeFile(ctx, dependantMarkerPath); err != nil {
 		return err
 	}
 	return nil
@@ -628,48 +858,74 @@ func (f *FSStateStore) RemoveDependency(ctx context.Context, ref string, depende
 
 func (f *FSStateStore) GetDependencies(ctx context.Context, ref string) ([]string, error) {
 	dependencyStartPath := filepath.Join(f.pathToDependencies(), ref)
-	return f.getMarkedRefsUnderPath(dependencyStartPath)
+	return f.getMarkedRefsUnderPath(dependencyStartPath, ref)
 }
 
-func (f *FSStateStore) getMarkedRefsUnderPath(p string) ([]string, error) {
-	if _, err := os.Stat(p); err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			return nil, nil
-		}
-		return nil, err
-	}
-
+// getMarkedRefsUnderPath returns the refs marked under p for owner.
+// Markers contain the ref they were written for, so markers belonging to
+// other refs nested under the same path are ignored.
+func (f *FSStateStore) getMarkedRefsUnderPath(p string, owner string) ([]string, error) {
 	var refs []string
-
-	err := filepath.Walk(p, func(path string, info os.FileInfo, err error) error {
+	seen := make(map[string]struct{})
+	addMarked := func(markerPath string) error {
+		relativePath, err := filepath.Rel(p, filepath.Dir(markerPath))
 		if err != nil {
 			return err
 		}
-		if info.IsDir() {
+		marker, err := f.readFile(markerPath)
+		if err != nil {
+			return fmt.Errorf("failed to read marker: %w", err)
+		}
+		if string(marker) != owner {
 			return nil
 		}
-
-		if info.Name() != refMarkerFile {
+		if _, ok := seen[relativePath]; ok {
 			return nil
 		}
+		seen[relativePath] = struct{}{}
+		refs = append(refs, relativePath)
+		return nil
+	}
+
+	if _, err := os.Stat(p); err == nil {
+		err := filepath.Walk(p, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				return nil
+			}
+
+			if info.Name() != refMarkerFile {
+				return nil
+			}
+
+			if exists, err := f.fileExists(path); err != nil || !exists {
+				return err
+			}
 
-		relativePath, err := filepath.Rel(p, filepath.Dir(path))
+			return addMarked(path)
+		})
 		if err != nil {
-			return err
+			return nil, err
 		}
-
-		refs = append(refs, relativePath)
-		return nil
-	})
-	if err != nil {
+	} else if !errors.Is(err, os.ErrNotExist) {
 		return nil, err
 	}
+
+	// Include markers created in an open transaction
+	for _, markerPath := range f.stagedFilesUnder(p, refMarkerFile) {
+		if err := addMarked(markerPath); err != nil {
+			return nil, err
+		}
+	}
+
 	return refs, nil
 }
 
 func (f *FSStateStore) GetDependants(ctx context.Context, ref string) ([]string, error) {
 	dependencyStartPath := filepath.Join(f.pathToDependants(), ref)
-	return f.getMarkedRefsUnderPath(dependencyStartPath)
+	return f.getMarkedRefsUnderPath(dependencyStartPath, ref)
 }
 
 func (f *FSStateStore) ActualPath(ref string) (string, error) {
@@ -700,7 +956,7 @@ func (f *FSStateStore) checkLink(ref string) (bool, string, error) {
 		return false, "", err
 	}
 
-	jsonContent, err := os.ReadFile(f.pathToRef(pr))
+	jsonContent, err := f.readFile(f.pathToRef(pr))
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
 			return false, "", nil
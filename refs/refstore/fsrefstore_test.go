@@ -1,8 +1,17 @@
 package refstore
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
 )
 
 func TestFSRefStore(t *testing.T) {
@@ -23,4 +32,454 @@ func TestFSRefStore(t *testing.T) {
 	}()
 
 	DoTestStore(t, store)
+	DoTestConditionalStore(t, store)
+
+	// A separate instance for the same directory, as another process would use
+	writer, err := NewFSRefStore(tempDir, map[string]struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	DoTestWatchableStore(t, store, writer)
+}
+
+func TestFSRefStoreWithHistory(t *testing.T) {
+	tempDir := "./testdata/fs_history_testdata"
+	_ = os.RemoveAll(tempDir)
+	if err := os.MkdirAll(tempDir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NewFSRefStoreWithConfig(tempDir, map[string]struct{}{}, FSRefStoreConfig{
+		History: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := store.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	DoTestStore(t, store)
+	DoTestHistoryStore(t, store)
+
+	// History is not available unless enabled
+	if _, err := History(context.Background(), &FSStateStore{BasePath: tempDir}, "github.com/example/repo.git/-/path/@v1"); !errors.Is(err, ErrHistoryUnsupported) {
+		t.Errorf("expected ErrHistoryUnsupported, got %v", err)
+	}
+}
+
+func TestFSRefStoreInTransaction(t *testing.T) {
+	tempDir := "./testdata/fs_transaction_testdata"
+	_ = os.RemoveAll(tempDir)
+	if err := os.MkdirAll(tempDir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NewFSRefStore(tempDir, map[string]struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.StartTransaction(context.Background(), "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	DoTestStore(t, store)
+
+	if err := store.CommitTransaction(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFSRefStoreTransactions(t *testing.T) {
+	ctx := context.Background()
+	tempDir := "./testdata/fs_transactions_testdata"
+
+	openStore := func(t *testing.T) *FSStateStore {
+		store, err := NewFSRefStore(tempDir, map[string]struct{}{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store
+	}
+
+	reset := func(t *testing.T) *FSStateStore {
+		_ = os.RemoveAll(tempDir)
+		if err := os.MkdirAll(tempDir, os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		store := openStore(t)
+		if err := store.Set(ctx, "repo.git/-/path/@/custom/existing", "before"); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Set(ctx, "repo.git/-/path/@/custom/deleted", "before"); err != nil {
+			t.Fatal(err)
+		}
+		return store
+	}
+
+	write := func(t *testing.T, store *FSStateStore) {
+		if err := store.StartTransaction(ctx, "test"); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Set(ctx, "repo.git/-/path/@/custom/existing", "after"); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Set(ctx, "repo.git/-/path/@/custom/created", "after"); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Delete(ctx, "repo.git/-/path/@/custom/deleted"); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Link(ctx, "repo.git/-/path/@/custom/link", "repo.git/-/path/@/custom/created"); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.AddDependency(ctx, "repo.git/-/path/@/custom/created", "repo.git/-/path/@/custom/existing"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	assertBefore := func(t *testing.T, store *FSStateStore) {
+		t.Helper()
+		assertValue(t, store, "repo.git/-/path/@/custom/existing", "before")
+		assertValue(t, store, "repo.git/-/path/@/custom/deleted", "before")
+		assertMissing(t, store, "repo.git/-/path/@/custom/created")
+		assertMissing(t, store, "repo.git/-/path/@/custom/link")
+		deps, err := store.GetDependencies(ctx, "repo.git/-/path/@/custom/created")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(deps) != 0 {
+			t.Errorf("expected no dependencies, got %v", deps)
+		}
+	}
+
+	assertAfter := func(t *testing.T, store *FSStateStore) {
+		t.Helper()
+		assertValue(t, store, "repo.git/-/path/@/custom/existing", "after")
+		assertValue(t, store, "repo.git/-/path/@/custom/created", "after")
+		assertValue(t, store, "repo.git/-/path/@/custom/link", "after")
+		assertMissing(t, store, "repo.git/-/path/@/custom/deleted")
+		deps, err := store.GetDependencies(ctx, "repo.git/-/path/@/custom/created")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(deps) != 1 || deps[0] != "repo.git/-/path/@/custom/existing" {
+			t.Errorf("unexpected dependencies: %v", deps)
+		}
+	}
+
+	t.Run("commit", func(t *testing.T) {
+		store := reset(t)
+		write(t, store)
+		assertAfter(t, store)
+
+		// Changes are not visible to other readers until committed
+		assertBefore(t, openReadOnly(tempDir))
+
+		if err := store.CommitTransaction(ctx); err != nil {
+			t.Fatal(err)
+		}
+		assertAfter(t, store)
+		assertAfter(t, openReadOnly(tempDir))
+		assertNoJournals(t, store)
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		store := reset(t)
+		write(t, store)
+		if err := store.RollbackTransaction(ctx); err != nil {
+			t.Fatal(err)
+		}
+		assertBefore(t, store)
+		assertNoJournals(t, store)
+	})
+
+	t.Run("rollback on close", func(t *testing.T) {
+		store := reset(t)
+		write(t, store)
+		if err := store.Close(); err != nil {
+			t.Fatal(err)
+		}
+		assertBefore(t, openStore(t))
+	})
+
+	t.Run("recover uncommitted", func(t *testing.T) {
+		store := reset(t)
+		write(t, store)
+
+		// Simulate a crash by releasing the journal's owner lock, as
+		// exiting would, then reopening without closing
+		if err := store.journal.owner.Close(); err != nil {
+			t.Fatal(err)
+		}
+		assertBefore(t, openStore(t))
+		assertNoJournals(t, store)
+	})
+
+	t.Run("keep open transactions", func(t *testing.T) {
+		store := reset(t)
+		write(t, store)
+
+		// Opening the store elsewhere leaves the open transaction in place
+		assertBefore(t, openStore(t))
+		if err := store.CommitTransaction(ctx); err != nil {
+			t.Fatal(err)
+		}
+		assertAfter(t, openStore(t))
+	})
+
+	t.Run("recover committed", func(t *testing.T) {
+		store := reset(t)
+		write(t, store)
+
+		// Simulate a crash after the commit point but before the journal
+		// was applied.
+		j := store.journal
+		store.journal = nil
+		if err := os.MkdirAll(store.journalPath(journalCommittedDir), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(store.pendingPath(j), store.journalPath(journalCommittedDir, j.id)); err != nil {
+			t.Fatal(err)
+		}
+		if err := j.owner.Close(); err != nil {
+			t.Fatal(err)
+		}
+		var manifest journalManifest
+		for rel, deleted := range j.staged {
+			manifest.Entries = append(manifest.Entries, journalEntry{Path: rel, Deleted: deleted})
+		}
+		manifestJSON, err := json.Marshal(manifest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(store.journalPath(journalCommittedDir, j.id, journalManifestFile), manifestJSON, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		assertAfter(t, openStore(t))
+		assertNoJournals(t, store)
+	})
+}
+
+func TestFSRefStoreConcurrentTransactions(t *testing.T) {
+	ctx := context.Background()
+	tempDir := t.TempDir()
+
+	var stores []*FSStateStore
+	for i := 0; i < 2; i++ {
+		store, err := NewFSRefStore(tempDir, map[string]struct{}{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		stores = append(stores, store)
+	}
+
+	// Transactions from separate instances, as separate processes would
+	// use, overlap
+	for i, store := range stores {
+		if err := store.StartTransaction(ctx, fmt.Sprintf("writer %d", i)); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Set(ctx, fmt.Sprintf("repo.git/-/path/@/custom/%d", i), "staged"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Opening the store while both are open leaves them in place
+	if _, err := NewFSRefStore(tempDir, map[string]struct{}{}); err != nil {
+		t.Fatal(err)
+	}
+	for i, store := range stores {
+		if err := store.Set(ctx, fmt.Sprintf("repo.git/-/path/@/custom/%d", i), "committed"); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.AddDependency(ctx, fmt.Sprintf("repo.git/-/path/@/custom/%d", i), "repo.git/-/path/@/custom/shared"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, store := range stores {
+		if err := store.CommitTransaction(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reopened, err := NewFSRefStore(tempDir, map[string]struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range stores {
+		assertValue(t, reopened, fmt.Sprintf("repo.git/-/path/@/custom/%d", i), "committed")
+	}
+	dependants, err := reopened.GetDependants(ctx, "repo.git/-/path/@/custom/shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dependants) != 2 {
+		t.Errorf("expected dependants from both transactions, got %v", dependants)
+	}
+	assertNoJournals(t, reopened)
+}
+
+// assertNoJournals checks that no pending or committed journals remain in a
+// store.
+func assertNoJournals(t *testing.T, store *FSStateStore) {
+	t.Helper()
+	for _, dir := range []string{journalPendingDir, journalCommittedDir} {
+		entries, err := os.ReadDir(store.journalPath(dir))
+		if err != nil && !os.IsNotExist(err) {
+			t.Fatal(err)
+		}
+		for _, entry := range entries {
+			t.Errorf("expected no journals, found %s/%s", dir, entry.Name())
+		}
+	}
+}
+
+// openReadOnly reads a store directory without recovering its journal,
+// as another process would while a transaction is open.
+func openReadOnly(basePath string) *FSStateStore {
+	return &FSStateStore{BasePath: basePath}
+}
+
+func assertValue(t *testing.T, store Store, ref string, want string) {
+	t.Helper()
+	var got string
+	if err := store.Get(context.Background(), ref, &got); err != nil {
+		t.Errorf("failed to get %s: %v", ref, err)
+		return
+	}
+	if got != want {
+		t.Errorf("unexpected value for %s: got %q, want %q", ref, got, want)
+	}
+}
+
+func assertMissing(t *testing.T, store Store, ref string) {
+	t.Helper()
+	var got string
+	if err := store.Get(context.Background(), ref, &got); err != ErrRefNotFound {
+		t.Errorf("expected %s to be missing, got %v (%q)", ref, err, got)
+	}
+}
+
+func TestFSRefStoreMatchIndex(t *testing.T) {
+	ctx := context.Background()
+	tempDir := t.TempDir()
+
+	store, err := NewFSRefStore(tempDir, map[string]struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A separate instance for the same directory, as another process would use
+	writer, err := NewFSRefStore(tempDir, map[string]struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	all := []string{
+		"repo.git/-/a/@r1",
+		"repo.git/-/a/@r1/deploy/staging/1",
+		"repo.git/-/a/@r1/deploy/staging/1/status/pending",
+		"repo.git/-/a/@r2/task/build/1/status/complete",
+		"repo.git/-/b/c/@r1/deploy/production/1/status/pending",
+		"other.git/-/a/@r1/custom/key",
+	}
+	for _, ref := range all {
+		if err := writer.Set(ctx, ref, "value"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Link(ctx, "repo.git/-/a/@/deploy/staging", "repo.git/-/a/@r1/deploy/staging/1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Make the cached directories old enough to be trusted
+	old := time.Now().Add(-time.Hour)
+	err = filepath.WalkDir(tempDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return err
+		}
+		return os.Chtimes(p, old, old)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		globs   []string
+		noLinks bool
+		want    []string
+	}{
+		{
+			globs: []string{"**/status/pending"},
+			want: []string{
+				"repo.git/-/a/@r1/deploy/staging/1/status/pending",
+				"repo.git/-/b/c/@r1/deploy/production/1/status/pending",
+			},
+		},
+		{
+			globs: []string{"repo.git/-/**/@*/{deploy,task}/*/*/status/*"},
+			want: []string{
+				"repo.git/-/a/@r1/deploy/staging/1/status/pending",
+				"repo.git/-/a/@r2/task/build/1/status/complete",
+				"repo.git/-/b/c/@r1/deploy/production/1/status/pending",
+			},
+		},
+		{
+			globs: []string{"repo.git/-/a/@*", "other.git/**"},
+			want: []string{
+				"other.git/-/a/@r1/custom/key",
+				"repo.git/-/a/@r1",
+			},
+		},
+		{
+			globs: []string{"repo.git/-/a/@{r1/deploy,/deploy}/*"},
+			want: []string{
+				"repo.git/-/a/@/deploy/staging",
+			},
+		},
+		{
+			globs:   []string{"repo.git/-/a/**"},
+			noLinks: true,
+			want: []string{
+				"repo.git/-/a/@r1",
+				"repo.git/-/a/@r1/deploy/staging/1",
+				"repo.git/-/a/@r1/deploy/staging/1/status/pending",
+				"repo.git/-/a/@r2/task/build/1/status/complete",
+			},
+		},
+	}
+
+	check := func(t *testing.T) {
+		t.Helper()
+		for _, test := range tests {
+			got, err := store.MatchOptions(ctx, MatchOptions{NoLinks: test.noLinks}, test.globs...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("MatchOptions(%v) mismatch (-want +got):\n%s", test.globs, diff)
+			}
+		}
+	}
+	check(t)
+
+	// Changes made by another process are included
+	if err := writer.Delete(ctx, "repo.git/-/b/c/@r1/deploy/production/1/status/pending"); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Set(ctx, "repo.git/-/b/c/@r1/deploy/production/1/status/complete", "value"); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Set(ctx, "repo.git/-/a/@r1/custom/key", "value"); err != nil {
+		t.Fatal(err)
+	}
+	tests[0].want = tests[0].want[:1]
+	tests[1].want[2] = "repo.git/-/b/c/@r1/deploy/production/1/status/complete"
+	tests[4].want = append(tests[4].want[:1], append([]string{"repo.git/-/a/@r1/custom/key"}, tests[4].want[1:]...)...)
+	check(t)
 }
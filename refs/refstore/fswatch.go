@@ -0,0 +1,133 @@
+package refstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/fsnotify/fsnotify"
+)
+
+// fsWatchDebounce is how long to wait after a filesystem event for further
+// events before checking for changes, so a transaction being applied is
+// reported once.
+const fsWatchDebounce = 100 * time.Millisecond
+
+// Watch implements WatchableStore.
+// Changes are detected with filesystem notifications, so writes from other
+// processes using the same directory are included. Changes made in a
+// transaction are reported once it has been committed.
+func (f *FSStateStore) Watch(ctx context.Context, globs ...string) (<-chan ChangeEvent, error) {
+	filter, err := compileWatchFilter(globs)
+	if err != nil {
+		return nil, err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create watcher: %w", err)
+	}
+	if err := watcher.Add(f.BasePath); err != nil {
+		_ = watcher.Close()
+		return nil, fmt.Errorf("failed to watch %s: %w", f.BasePath, err)
+	}
+
+	snapshot, err := f.scanRefs(watcher, nil)
+	if err != nil {
+		_ = watcher.Close()
+		return nil, err
+	}
+
+	ch := make(chan ChangeEvent)
+	go func() {
+		defer close(ch)
+		defer watcher.Close()
+
+		debounce := time.NewTimer(0)
+		<-debounce.C
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case err := <-watcher.Errors:
+				log.Error("error watching store", "path", f.BasePath, "error", err)
+			case <-watcher.Events:
+				debounce.Reset(fsWatchDebounce)
+			case <-debounce.C:
+				next, err := f.scanRefs(watcher, snapshot)
+				if err != nil {
+					log.Error("failed to scan store for changes", "path", f.BasePath, "error", err)
+					continue
+				}
+				events := filter.changes(snapshot, next, time.Now())
+				snapshot = next
+				if !sendEvents(ctx, ch, events) {
+					return
+				}
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// scanRefs reads the value of every ref on disk, ignoring any open
+// transaction. Each directory under the refs directory is added to the
+// watcher, since notifications are not recursive.
+// Refs that cannot be read, such as those part way through being written,
+// keep their value from the previous snapshot.
+func (f *FSStateStore) scanRefs(watcher *fsnotify.Watcher, previous refSnapshot) (refSnapshot, error) {
+	dir := filepath.Join(f.BasePath, refsDir)
+	snapshot := make(refSnapshot)
+
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Directories may be removed while scanning
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			if err := watcher.Add(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return fmt.Errorf("failed to watch %s: %w", p, err)
+			}
+			return nil
+		}
+		if d.Name() != contentFile {
+			return nil
+		}
+
+		content, err := os.ReadFile(p)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, filepath.Dir(p))
+		if err != nil {
+			return err
+		}
+		ref := filepath.ToSlash(rel)
+
+		digest, err := valueDigest(content)
+		if err != nil {
+			if digest, ok := previous[ref]; ok {
+				snapshot[ref] = digest
+			}
+			return nil
+		}
+		snapshot[ref] = digest
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan refs: %w", err)
+	}
+	return snapshot, nil
+}
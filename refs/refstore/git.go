@@ -2,13 +2,16 @@ package refstore
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 	"net/url"
 	"os"
 	"path/filepath"
 	"runtime/debug"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/ocuroot/gittools"
@@ -28,7 +31,26 @@ type GitRepo interface {
 	commit(ctx context.Context, message string) error
 	pull(ctx context.Context) error
 	push(ctx context.Context, remote string) error
+	rebase(ctx context.Context, remote string) error
+	discardLastCommit(ctx context.Context, paths []string) error
+	restore(ctx context.Context, paths []string) error
 	checkStagedFiles() error
+
+	log(ctx context.Context, path string) ([]gitCommit, error)
+	show(ctx context.Context, revision string, path string) ([]byte, error)
+	resolveRevision(ctx context.Context, revision string) (string, error)
+	revisionAt(ctx context.Context, t time.Time) (string, error)
+
+	remoteHead(ctx context.Context, remote string) (string, error)
+	fetchRevision(ctx context.Context, remote string, revision string) error
+	lsTree(ctx context.Context, revision string, path string) (map[string]string, error)
+}
+
+// gitCommit summarizes a commit in the history of a repo.
+type gitCommit struct {
+	Hash    string
+	Time    time.Time
+	Message string
 }
 
 var _ GitRepo = (*GitRepoWrapper)(nil)
@@ -220,6 +242,181 @@ func (g *GitRepoWrapper) push(ctx context.Context, remote string) error {
 	return g.g.Push(remote, g.branch)
 }
 
+// rebase replays local commits on top of the remote branch.
+// If the local and remote changes touch the same files, the rebase is
+// abandoned, leaving the local commits in place, and ErrConflict is returned.
+func (g *GitRepoWrapper) rebase(ctx context.Context, remote string) error {
+	stdout, stderr, err := g.g.Client.Exec("pull", "--rebase", remote, g.branch)
+	if err == nil {
+		return nil
+	}
+
+	conflicts, _, _ := g.g.Client.Exec("diff", "--name-only", "--diff-filter=U")
+	if _, abortStderr, abortErr := g.g.Client.Exec("rebase", "--abort"); abortErr != nil && !strings.Contains(string(abortStderr), "No rebase in progress") {
+		return fmt.Errorf("failed to abort rebase: %w\n%s", abortErr, string(abortStderr))
+	}
+
+	if conflictFiles := strings.Fields(string(conflicts)); len(conflictFiles) > 0 {
+		return fmt.Errorf("%w: changes on %s conflict with %s", ErrConflict, remote, strings.Join(conflictFiles, ", "))
+	}
+	return fmt.Errorf("git pull --rebase failed: %w\nstdout: %s\nstderr: %s", err, stdout, stderr)
+}
+
+// discardLastCommit removes the most recent local commit, restoring the given
+// paths to their content before it. Other changes in the working tree are kept.
+func (g *GitRepoWrapper) discardLastCommit(ctx context.Context, paths []string) error {
+	if _, stderr, err := g.g.Client.Exec("reset", "--mixed", "HEAD~1"); err != nil {
+		return fmt.Errorf("failed to reset last commit: %w\n%s", err, string(stderr))
+	}
+	return g.restore(ctx, paths)
+}
+
+// restore returns the given paths to their content in the most recent commit,
+// removing any that did not exist in it.
+func (g *GitRepoWrapper) restore(ctx context.Context, paths []string) error {
+	for _, path := range paths {
+		if _, _, err := g.g.Client.Exec("checkout", "HEAD", "--", path); err == nil {
+			continue
+		}
+
+		// The path did not exist in the commit
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(g.RepoPath(), path)
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// log returns the commits that changed the file at path, most recent first.
+func (g *GitRepoWrapper) log(ctx context.Context, path string) ([]gitCommit, error) {
+	stdout, stderr, err := g.g.Client.Exec("log", "--format=%H%x00%cI%x00%B%x1e", "--", path)
+	if err != nil {
+		return nil, fmt.Errorf("git log failed: %w\n%s", err, string(stderr))
+	}
+
+	var commits []gitCommit
+	for _, record := range strings.Split(string(stdout), "\x1e") {
+		record = strings.TrimLeft(record, "\n")
+		if record == "" {
+			continue
+		}
+		fields := strings.SplitN(record, "\x00", 3)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("unexpected git log output: %q", record)
+		}
+		commitTime, err := time.Parse(time.RFC3339, fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse commit time: %w", err)
+		}
+		commits = append(commits, gitCommit{
+			Hash:    fields[0],
+			Time:    commitTime,
+			Message: strings.TrimSpace(fields[2]),
+		})
+	}
+	return commits, nil
+}
+
+// show returns the content of the file at path, relative to the repo root,
+// as of a revision. An fs.ErrNotExist error is returned if the file did not
+// exist at that revision.
+func (g *GitRepoWrapper) show(ctx context.Context, revision string, path string) ([]byte, error) {
+	stdout, stderr, err := g.g.Client.Exec("show", revision+":"+filepath.ToSlash(path))
+	if err != nil {
+		if strings.Contains(string(stderr), "does not exist in") || strings.Contains(string(stderr), "exists on disk, but not in") {
+			return nil, &fs.PathError{Op: "show", Path: path, Err: fs.ErrNotExist}
+		}
+		return nil, fmt.Errorf("git show failed: %w\n%s", err, string(stderr))
+	}
+	return stdout, nil
+}
+
+// resolveRevision returns the full hash of the commit identified by a
+// revision, such as an abbreviated hash.
+func (g *GitRepoWrapper) resolveRevision(ctx context.Context, revision string) (string, error) {
+	stdout, _, err := g.g.Client.Exec("rev-parse", "--verify", "--quiet", revision+"^{commit}")
+	if err != nil {
+		return "", fmt.Errorf("unknown revision %q", revision)
+	}
+	return strings.TrimSpace(string(stdout)), nil
+}
+
+// revisionAt returns the most recent commit made at or before a time.
+func (g *GitRepoWrapper) revisionAt(ctx context.Context, t time.Time) (string, error) {
+	stdout, stderr, err := g.g.Client.Exec("rev-list", "-1", "--before="+t.Format(time.RFC3339), "HEAD")
+	if err != nil {
+		return "", fmt.Errorf("git rev-list failed: %w\n%s", err, string(stderr))
+	}
+	hash := strings.TrimSpace(string(stdout))
+	if hash == "" {
+		return "", ErrRefNotFound
+	}
+	return hash, nil
+}
+
+// remoteHead returns the commit at the head of the branch on a remote,
+// without fetching it. An empty string is returned if the branch does not
+// exist on the remote.
+func (g *GitRepoWrapper) remoteHead(ctx context.Context, remote string) (string, error) {
+	stdout, stderr, err := g.g.Client.Exec("ls-remote", remote, "refs/heads/"+g.branch)
+	if err != nil {
+		return "", fmt.Errorf("git ls-remote failed: %w\n%s", err, string(stderr))
+	}
+	fields := strings.Fields(string(stdout))
+	if len(fields) == 0 {
+		return "", nil
+	}
+	return fields[0], nil
+}
+
+// fetchRevision fetches the objects for a commit from a remote without
+// updating any refs, so it can be used alongside other operations on the
+// repo.
+func (g *GitRepoWrapper) fetchRevision(ctx context.Context, remote string, revision string) error {
+	if _, stderr, err := g.g.Client.Exec("fetch", "--quiet", "--no-write-fetch-head", remote, revision); err != nil {
+		return fmt.Errorf("git fetch failed: %w\n%s", err, string(stderr))
+	}
+	return nil
+}
+
+// lsTree returns the object hash of each file under path at a revision,
+// keyed by path relative to the repo root.
+func (g *GitRepoWrapper) lsTree(ctx context.Context, revision string, path string) (map[string]string, error) {
+	stdout, stderr, err := g.g.Client.Exec("ls-tree", "-r", "-z", revision, "--", filepath.ToSlash(path))
+	if err != nil {
+		return nil, fmt.Errorf("git ls-tree failed: %w\n%s", err, string(stderr))
+	}
+
+	files := make(map[string]string)
+	for _, entry := range strings.Split(string(stdout), "\x00") {
+		if entry == "" {
+			continue
+		}
+		// <mode> SP <type> SP <object> TAB <file>
+		meta, file, ok := strings.Cut(entry, "\t")
+		fields := strings.Fields(meta)
+		if !ok || len(fields) != 3 {
+			return nil, fmt.Errorf("unexpected git ls-tree output: %q", entry)
+		}
+		files[file] = fields[2]
+	}
+	return files, nil
+}
+
+// isPushConflict returns true if a push was rejected because the remote
+// branch has changes that are not present locally, or was being updated by
+// another push at the same time.
+func isPushConflict(err error) bool {
+	if errors.Is(err, gittools.ErrPushFetchFirst) || errors.Is(err, gittools.ErrPushNonFastForward) {
+		return true
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "failed to update ref") || strings.Contains(msg, "cannot lock ref")
+}
+
 func (g *GitRepoWrapper) checkStagedFiles() error {
 	if !CheckStagedFiles {
 		return nil
@@ -2,13 +2,17 @@ package refstore
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/fs"
+	"math/rand/v2"
 	"os"
 	"path/filepath"
 	"runtime/debug"
 	"strings"
 	"time"
 
+	"github.com/charmbracelet/log"
 	"github.com/ocuroot/ocuroot/refs"
 	"go.opentelemetry.io/otel/attribute"
 
@@ -19,6 +23,16 @@ type GitRefStoreConfig struct {
 	GitRepoConfig
 
 	PathPrefix string
+
+	// PushAttempts is the number of times a push will be attempted if it is
+	// rejected due to changes pushed by another writer.
+	// Values less than 1 use DefaultGitPushAttempts.
+	PushAttempts int
+
+	// WatchInterval is how often the remote is checked for changes while the
+	// store is being watched.
+	// Values less than or equal to zero use DefaultGitWatchInterval.
+	WatchInterval time.Duration
 }
 
 func NewGitRefStore(
@@ -51,12 +65,23 @@ func NewGitRefStore(
 		return nil, fmt.Errorf("failed to check if info file needs commit: %w", err)
 	}
 
+	pushAttempts := cfg.PushAttempts
+	if pushAttempts < 1 {
+		pushAttempts = DefaultGitPushAttempts
+	}
+	watchInterval := cfg.WatchInterval
+	if watchInterval <= 0 {
+		watchInterval = DefaultGitWatchInterval
+	}
+
 	r = GitRepoWithOtel(r)
 	g := &GitRefStore{
-		s:          fsStore,
-		g:          r,
-		pathPrefix: cfg.PathPrefix,
-		lastPull:   time.Now(),
+		s:             fsStore,
+		g:             r,
+		pathPrefix:    cfg.PathPrefix,
+		pushAttempts:  pushAttempts,
+		watchInterval: watchInterval,
+		lastPull:      time.Now(),
 	}
 
 	if addInfoFile {
@@ -126,19 +151,33 @@ type GitSupportFileWriter interface {
 }
 
 type GitRefStore struct {
-	s          *FSStateStore
-	g          GitRepo
-	pathPrefix string
+	s             *FSStateStore
+	g             GitRepo
+	pathPrefix    string
+	pushAttempts  int
+	watchInterval time.Duration
 
 	lastPull           time.Time
 	transactionMessage string
 	transactionStarted bool
 	transactionSteps   []string
 	transactionFiles   []string
+
+	externalChange []func()
 }
 
 var _ GitSupportFileWriter = (*GitRefStore)(nil)
 var _ Store = (*GitRefStore)(nil)
+var _ ConditionalStore = (*GitRefStore)(nil)
+var _ RollbackStore = (*GitRefStore)(nil)
+var _ HistoryStore = (*GitRefStore)(nil)
+var _ WatchableStore = (*GitRefStore)(nil)
+var _ MetadataStore = (*GitRefStore)(nil)
+var _ ChangeNotifier = (*GitRefStore)(nil)
+
+// DefaultGitPushAttempts is the number of times a push will be attempted if
+// it is rejected due to concurrent changes, unless otherwise configured.
+const DefaultGitPushAttempts = 10
 
 func getStatePath(baseDir, remote string) (string, error) {
 	p := GitURLToValidPath(remote)
@@ -175,6 +214,26 @@ func (g *GitRefStore) CommitTransaction(ctx context.Context) error {
 	return nil
 }
 
+// RollbackTransaction implements RollbackStore.
+// Files changed in the transaction are restored to their last committed
+// content.
+func (g *GitRefStore) RollbackTransaction(ctx context.Context) error {
+	files := g.transactionFiles
+
+	g.transactionMessage = ""
+	g.transactionStarted = false
+	g.transactionSteps = nil
+	g.transactionFiles = nil
+
+	if len(files) == 0 {
+		return nil
+	}
+	if err := g.g.restore(ctx, files); err != nil {
+		return fmt.Errorf("failed to roll back transaction: %w", err)
+	}
+	return nil
+}
+
 func (g *GitRefStore) applyFilesAsNeeded(ctx context.Context, paths []string, message string) error {
 	if g.transactionStarted {
 		g.transactionSteps = append(g.transactionSteps, message)
@@ -197,11 +256,10 @@ func (g *GitRefStore) applyAsNeeded(ctx context.Context, refs []string, message
 	return g.applyFilesAsNeeded(ctx, paths, message)
 }
 
+// apply commits the files at the given paths and pushes them to the remote.
+// Changes are committed before pulling, so that changes on the remote to
+// other refs can be merged in by rebasing.
 func (g *GitRefStore) apply(ctx context.Context, paths []string, message string) error {
-	if err := g.pullWithoutDebounce(ctx); err != nil {
-		return err
-	}
-
 	if err := g.g.add(ctx, paths); err != nil {
 		return err
 	}
@@ -210,15 +268,64 @@ func (g *GitRefStore) apply(ctx context.Context, paths []string, message string)
 		return err
 	}
 
+	// The audit is kept in the commit message, so deletions are also audited
 	stack := debug.Stack()
-	if err := g.g.commit(ctx, message+"\n\n"+string(stack)); err != nil {
+	if err := g.g.commit(ctx, message+"\n\n"+auditFromContext(ctx).String()+"\n\n"+string(stack)); err != nil {
 		// If nothing has changed, ignore the error
 		if strings.Contains(err.Error(), "nothing to commit") {
 			return nil
 		}
 		return err
 	}
-	return g.g.push(ctx, "origin")
+	return g.pushWithRebase(ctx, paths)
+}
+
+// pushBackoff waits for a random period that grows with the number of
+// attempts, so that writers competing to push are less likely to collide
+// again.
+func pushBackoff(attempt int) {
+	time.Sleep(rand.N(time.Duration(attempt) * 50 * time.Millisecond))
+}
+
+// pushWithRebase pushes the most recent commit to the remote. If the push is
+// rejected because another writer pushed first, the commit is rebased onto
+// their changes and the push retried.
+//
+// Each ref is stored in its own file, so changes to different refs will
+// always rebase cleanly. If the remote has changed any of the same files,
+// the commit is discarded and an ErrConflict returned.
+func (g *GitRefStore) pushWithRebase(ctx context.Context, paths []string) error {
+	for attempt := 1; ; attempt++ {
+		err := g.g.push(ctx, "origin")
+		if err == nil {
+			return nil
+		}
+		if !isPushConflict(err) {
+			return err
+		}
+		if attempt >= g.pushAttempts {
+			return fmt.Errorf("failed to push after %d attempts: %w", attempt, err)
+		}
+
+		log.Info("Push rejected, rebasing onto remote changes", "attempt", attempt)
+		pushBackoff(attempt)
+		if err := g.g.rebase(ctx, "origin"); err != nil {
+			if !errors.Is(err, ErrConflict) {
+				return err
+			}
+
+			// Leave the local state matching the remote
+			if discardErr := g.g.discardLastCommit(ctx, paths); discardErr != nil {
+				return fmt.Errorf("failed to discard conflicting commit: %w", discardErr)
+			}
+			if pullErr := g.pullWithoutDebounce(ctx); pullErr != nil {
+				return fmt.Errorf("failed to pull after conflict: %w", pullErr)
+			}
+			return err
+		}
+		g.lastPull = time.Now()
+		g.notifyExternalChange()
+	}
 }
 
 func (g *GitRefStore) Close() error {
@@ -235,6 +342,14 @@ func (g *GitRefStore) Get(ctx context.Context, ref string, v any) error {
 	return g.s.Get(ctx, ref, v)
 }
 
+// GetMetadata implements MetadataStore.
+func (g *GitRefStore) GetMetadata(ctx context.Context, ref string) (ObjectMetadata, error) {
+	if err := g.pull(ctx); err != nil {
+		return ObjectMetadata{}, err
+	}
+	return g.s.GetMetadata(ctx, ref)
+}
+
 func (g *GitRefStore) Set(ctx context.Context, ref string, v any) error {
 	// Make sure we're up to date
 	err := g.pull(ctx)
@@ -250,6 +365,83 @@ func (g *GitRefStore) Set(ctx context.Context, ref string, v any) error {
 	return g.applyAsNeeded(ctx, []string{ref}, "update state at "+ref)
 }
 
+func (g *GitRefStore) GetWithRevision(ctx context.Context, ref string, v any) (string, error) {
+	// Make sure we're up to date
+	err := g.pull(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return g.s.GetWithRevision(ctx, ref, v)
+}
+
+// SetIfAbsent implements ConditionalStore.
+// Conditional writes are pushed immediately, even during a transaction, so
+// that the condition is checked against the remote.
+func (g *GitRefStore) SetIfAbsent(ctx context.Context, ref string, v any) error {
+	return g.setConditionally(ctx, ref, "create state at "+ref, func() error {
+		return g.s.SetIfAbsent(ctx, ref, v)
+	})
+}
+
+// SetIfRevision implements ConditionalStore.
+// Conditional writes are pushed immediately, even during a transaction, so
+// that the condition is checked against the remote.
+func (g *GitRefStore) SetIfRevision(ctx context.Context, ref string, v any, revision string) error {
+	return g.setConditionally(ctx, ref, "update state at "+ref, func() error {
+		return g.s.SetIfRevision(ctx, ref, v, revision)
+	})
+}
+
+func (g *GitRefStore) setConditionally(ctx context.Context, ref string, message string, set func() error) error {
+	for attempt := 1; ; attempt++ {
+		if err := g.pullWithoutDebounce(ctx); err != nil {
+			return err
+		}
+
+		// The condition is checked against the latest pulled state
+		if err := set(); err != nil {
+			return err
+		}
+
+		path, err := g.s.ActualPath(ref)
+		if err != nil {
+			return err
+		}
+		paths := []string{filepath.Join(g.pathPrefix, path)}
+
+		if err := g.g.add(ctx, paths); err != nil {
+			return err
+		}
+		if err := g.g.commit(ctx, message); err != nil {
+			// If nothing has changed, ignore the error
+			if strings.Contains(err.Error(), "nothing to commit") {
+				return nil
+			}
+			return err
+		}
+
+		err = g.g.push(ctx, "origin")
+		if err == nil {
+			return nil
+		}
+		if !isPushConflict(err) {
+			return err
+		}
+
+		// Another writer pushed first, discard this write so the condition
+		// can be checked again against their changes.
+		if discardErr := g.g.discardLastCommit(ctx, paths); discardErr != nil {
+			return fmt.Errorf("failed to discard rejected write: %w", discardErr)
+		}
+		if attempt >= g.pushAttempts {
+			return fmt.Errorf("failed to push %s after %d attempts: %w", ref, attempt, err)
+		}
+		log.Info("Push rejected, retrying conditional write", "ref", ref, "attempt", attempt)
+		pushBackoff(attempt)
+	}
+}
+
 func (g *GitRefStore) Delete(ctx context.Context, ref string) error {
 	// Make sure we're up to date
 	err := g.pull(ctx)
@@ -432,5 +624,124 @@ func (g *GitRefStore) pull(ctx context.Context) error {
 
 func (g *GitRefStore) pullWithoutDebounce(ctx context.Context) error {
 	g.lastPull = time.Now()
+	defer g.notifyExternalChange()
 	return g.g.pull(ctx)
 }
+
+// OnExternalChange implements ChangeNotifier.
+// Functions are called after each pull from the remote.
+func (g *GitRefStore) OnExternalChange(fn func()) {
+	g.externalChange = append(g.externalChange, fn)
+}
+
+func (g *GitRefStore) notifyExternalChange() {
+	for _, fn := range g.externalChange {
+		fn()
+	}
+}
+
+// History implements HistoryStore.
+// Each commit that changed a ref is an entry in its history, with the commit
+// hash as the revision.
+func (g *GitRefStore) History(ctx context.Context, ref string) ([]HistoryEntry, error) {
+	if err := g.pull(ctx); err != nil {
+		return nil, err
+	}
+
+	parsedRef, err := refs.Parse(ref)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ref: %w", err)
+	}
+	path := filepath.Join(g.pathPrefix, refsDir, parsedRef.SetFragment("").String(), contentFile)
+
+	commits, err := g.g.log(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []HistoryEntry
+	for _, commit := range commits {
+		content, err := g.g.show(ctx, commit.Hash, path)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+
+		message, audit := commitMessage(commit.Message)
+		if audit != nil {
+			audit.Time = commit.Time
+		}
+		entry, err := historyEntryFromObject(HistoryEntry{
+			Revision: commit.Hash,
+			Time:     commit.Time,
+			Message:  message,
+			Audit:    audit,
+		}, content)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s at %s: %w", ref, commit.Hash, err)
+		}
+		out = append(out, entry)
+	}
+	return compactHistory(out), nil
+}
+
+// commitMessage removes the audit and stack trace recorded in commits made
+// by apply, returning the audit separately if there was one.
+func commitMessage(message string) (string, *Audit) {
+	if i := strings.Index(message, "\n\ngoroutine "); i >= 0 {
+		message = message[:i]
+	}
+
+	i := strings.Index(message, "\n\nActor: ")
+	if i < 0 {
+		return strings.TrimSpace(message), nil
+	}
+	audit := &Audit{}
+	for _, line := range strings.Split(message[i+2:], "\n") {
+		key, value, _ := strings.Cut(line, ": ")
+		switch key {
+		case "Actor":
+			audit.Actor = value
+		case "Ocuroot-Version":
+			audit.Version = value
+		case "Command":
+			audit.Command = strings.Fields(value)
+		case "Job":
+			audit.JobURL = value
+		}
+	}
+	return strings.TrimSpace(message[:i]), audit
+}
+
+// RevisionAt implements HistoryStore.
+func (g *GitRefStore) RevisionAt(ctx context.Context, t time.Time) (string, error) {
+	if err := g.pull(ctx); err != nil {
+		return "", err
+	}
+	return g.g.revisionAt(ctx, t)
+}
+
+// GetAt implements HistoryStore.
+// The revision may be any commit in the state repo.
+func (g *GitRefStore) GetAt(ctx context.Context, ref string, revision string, v any) error {
+	if err := g.pull(ctx); err != nil {
+		return err
+	}
+
+	hash, err := g.g.resolveRevision(ctx, revision)
+	if err != nil {
+		return err
+	}
+
+	snapshot := &FSStateStore{
+		BasePath: g.s.BasePath,
+		info:     g.s.info,
+		snapshot: func(p string) ([]byte, error) {
+			rel, err := filepath.Rel(g.g.RepoPath(), p)
+			if err != nil {
+				return nil, err
+			}
+			return g.g.show(ctx, hash, rel)
+		},
+	}
+	return snapshot.Get(ctx, ref, v)
+}
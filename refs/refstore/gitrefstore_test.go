@@ -2,9 +2,13 @@ package refstore
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/ocuroot/gittools"
 )
@@ -46,6 +50,8 @@ func TestGitRefStore(t *testing.T) {
 	}()
 
 	DoTestStore(t, store)
+	DoTestConditionalStore(t, store)
+	DoTestHistoryStore(t, store)
 }
 
 func TestGitRefStoreWithTransaction(t *testing.T) {
@@ -151,6 +157,65 @@ func TestGitRefStoreTransaction(t *testing.T) {
 	}
 }
 
+func TestGitRefStoreRollback(t *testing.T) {
+	remotePath, cleanup, err := gittools.CreateTestRemoteRepo("ocuroot_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cleanup)
+
+	store, err := NewGitRefStore(filepath.Join(t.TempDir(), "local_repo"), map[string]struct{}{}, remotePath, "main", GitRefStoreConfig{
+		GitRepoConfig: GitRepoConfig{
+			CreateBranch: true,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := store.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	ctx := context.Background()
+	if err := store.Set(ctx, "existing", "before"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.StartTransaction(ctx, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set(ctx, "existing", "after"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set(ctx, "created", "after"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RollbackTransaction(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var got string
+	if err := store.Get(ctx, "existing", &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != "before" {
+		t.Errorf("expected existing to be restored, got %q", got)
+	}
+	if err := store.Get(ctx, "created", &got); !errors.Is(err, ErrRefNotFound) {
+		t.Errorf("expected created to be removed, got %v", err)
+	}
+
+	// Later writes are committed on their own
+	if err := store.Set(ctx, "later", "value"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Get(ctx, "later", &got); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestGitRefStoreAddSupportFiles(t *testing.T) {
 	tempDir, err := os.MkdirTemp("", "ocuroot_test")
 	if err != nil {
@@ -210,3 +275,147 @@ func TestGitRefStoreAddSupportFiles(t *testing.T) {
 	}
 
 }
+
+// newGitRefStoreClones creates separate stores for the same remote, as
+// separate workers would.
+func newGitRefStoreClones(t *testing.T, count int) []*GitRefStore {
+	tempDir, err := os.MkdirTemp("", "ocuroot_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(tempDir)
+	})
+
+	remotePath, cleanup, err := gittools.CreateTestRemoteRepo("ocuroot_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cleanup)
+
+	var stores []*GitRefStore
+	for i := 0; i < count; i++ {
+		store, err := NewGitRefStore(filepath.Join(tempDir, fmt.Sprintf("local_repo_%d", i)), map[string]struct{}{}, remotePath, "main", GitRefStoreConfig{
+			GitRepoConfig: GitRepoConfig{
+				CreateBranch: true,
+			},
+			WatchInterval: 100 * time.Millisecond,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			if err := store.Close(); err != nil {
+				t.Error(err)
+			}
+		})
+		stores = append(stores, store)
+	}
+	return stores
+}
+
+func TestGitRefStoreWatch(t *testing.T) {
+	stores := newGitRefStoreClones(t, 2)
+	DoTestWatchableStore(t, stores[0], stores[1])
+}
+
+func TestGitRefStoreRebasesOnRejectedPush(t *testing.T) {
+	ctx := context.Background()
+	stores := newGitRefStoreClones(t, 2)
+	first, second := stores[0], stores[1]
+
+	refA := "github.com/example/repo.git/path/to/package/@/custom/a"
+	refB := "github.com/example/repo.git/path/to/package/@/custom/b"
+
+	if err := first.Set(ctx, refA, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The second store has not pulled the first change, so its push will be
+	// rejected and must be rebased
+	if err := second.StartTransaction(ctx, "set b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.Set(ctx, refB, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.CommitTransaction(ctx); err != nil {
+		t.Fatalf("expected push to be rebased, got %v", err)
+	}
+
+	assertValue(t, second, refA, "a")
+	assertValue(t, second, refB, "b")
+
+	if err := first.pullWithoutDebounce(ctx); err != nil {
+		t.Fatal(err)
+	}
+	assertValue(t, first, refB, "b")
+}
+
+func TestGitRefStoreReportsConflictingPush(t *testing.T) {
+	ctx := context.Background()
+	stores := newGitRefStoreClones(t, 2)
+	first, second := stores[0], stores[1]
+
+	ref := "github.com/example/repo.git/path/to/package/@/custom/conflict"
+
+	if err := first.Set(ctx, ref, "first"); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.Set(ctx, ref, "second"); !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected ErrConflict, got %v", err)
+	}
+
+	// The rejected change is discarded in favor of the remote
+	assertValue(t, second, ref, "first")
+
+	if err := second.Set(ctx, ref, "second"); err != nil {
+		t.Fatalf("expected write after conflict to succeed, got %v", err)
+	}
+	if err := first.pullWithoutDebounce(ctx); err != nil {
+		t.Fatal(err)
+	}
+	assertValue(t, first, ref, "second")
+}
+
+func TestGitRefStoreConcurrentWriters(t *testing.T) {
+	ctx := context.Background()
+	stores := newGitRefStoreClones(t, 3)
+
+	const perStore = 5
+	var wg sync.WaitGroup
+	for i, store := range stores {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < perStore; j++ {
+				ref := fmt.Sprintf("github.com/example/repo.git/path/to/package/@/custom/writer%d/%d", i, j)
+				if err := store.Set(ctx, ref, j); err != nil {
+					t.Errorf("failed to set %s: %v", ref, err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	store := stores[0]
+	if err := store.pullWithoutDebounce(ctx); err != nil {
+		t.Fatal(err)
+	}
+	matches, err := store.Match(ctx, "github.com/example/repo.git/path/to/package/@/custom/**")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := len(stores) * perStore; len(matches) != want {
+		t.Errorf("unexpected number of refs: got %d, want %d", len(matches), want)
+	}
+}
+
+func TestGitRefStoreConcurrentIncrement(t *testing.T) {
+	var stores []Store
+	for _, store := range newGitRefStoreClones(t, 2) {
+		stores = append(stores, store)
+	}
+
+	checkUniqueIncrements(t, stores, 5)
+}
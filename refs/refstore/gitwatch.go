@@ -0,0 +1,127 @@
+package refstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// DefaultGitWatchInterval is how often a watched git store checks the remote
+// for changes, unless otherwise configured.
+const DefaultGitWatchInterval = 5 * time.Second
+
+// gitWatchState is the state of the refs on the remote branch at a commit.
+type gitWatchState struct {
+	head   string
+	blobs  map[string]string
+	values refSnapshot
+}
+
+// Watch implements WatchableStore.
+// The remote branch is polled for new commits, so changes pushed by any
+// writer are reported, including this one. The working tree is not modified.
+func (g *GitRefStore) Watch(ctx context.Context, globs ...string) (<-chan ChangeEvent, error) {
+	filter, err := compileWatchFilter(globs)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := g.remoteState(ctx, gitWatchState{})
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan ChangeEvent)
+	go func() {
+		defer close(ch)
+
+		ticker := time.NewTicker(g.watchInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			next, err := g.remoteState(ctx, state)
+			if err != nil {
+				log.Error("failed to check remote for changes", "error", err)
+				continue
+			}
+			events := filter.changes(state.values, next.values, time.Now())
+			state = next
+			if !sendEvents(ctx, ch, events) {
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// remoteState reads the refs at the head of the remote branch. Only files
+// that have changed since the previous state are read.
+func (g *GitRefStore) remoteState(ctx context.Context, previous gitWatchState) (gitWatchState, error) {
+	head, err := g.g.remoteHead(ctx, "origin")
+	if err != nil {
+		return previous, err
+	}
+	if head == previous.head {
+		return previous, nil
+	}
+
+	state := gitWatchState{
+		head:   head,
+		blobs:  make(map[string]string),
+		values: make(refSnapshot),
+	}
+	if head == "" {
+		return state, nil
+	}
+
+	if err := g.g.fetchRevision(ctx, "origin", head); err != nil {
+		return previous, err
+	}
+
+	refsPath := filepath.ToSlash(filepath.Join(g.pathPrefix, refsDir))
+	files, err := g.g.lsTree(ctx, head, refsPath)
+	if err != nil {
+		return previous, err
+	}
+
+	for file, blob := range files {
+		if path.Base(file) != contentFile {
+			continue
+		}
+		ref := strings.TrimPrefix(path.Dir(file), refsPath+"/")
+		state.blobs[file] = blob
+
+		if previous.blobs[file] == blob {
+			if digest, ok := previous.values[ref]; ok {
+				state.values[ref] = digest
+			}
+			continue
+		}
+
+		content, err := g.g.show(ctx, head, file)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return previous, err
+		}
+		digest, err := valueDigest(content)
+		if err != nil {
+			return previous, fmt.Errorf("failed to read %s at %s: %w", file, head, err)
+		}
+		state.values[ref] = digest
+	}
+	return state, nil
+}
@@ -0,0 +1,119 @@
+package refstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// ErrHistoryUnsupported is returned when history is requested from a store
+// that does not implement HistoryStore, or does not have history enabled.
+var ErrHistoryUnsupported = errors.New("store does not record history")
+
+// HistoryStore is implemented by stores that retain previous values of refs.
+type HistoryStore interface {
+	Store
+
+	// History returns the changes made to a ref, most recent first.
+	// Links are not followed, so the history of a link shows changes to its
+	// target rather than to the value it points to. Changes that did not
+	// affect the value of the ref are omitted.
+	History(ctx context.Context, ref string) ([]HistoryEntry, error)
+
+	// RevisionAt returns the most recent revision at or before a time.
+	// ErrRefNotFound is returned if there were no revisions at that time.
+	RevisionAt(ctx context.Context, t time.Time) (string, error)
+
+	// GetAt behaves like Get, reading the store as it was at a revision.
+	// Links are followed as they were at that revision.
+	GetAt(ctx context.Context, ref string, revision string, v any) error
+}
+
+// HistoryEntry describes a single change to a ref.
+type HistoryEntry struct {
+	// Revision identifies the state of the store after this change.
+	// Revisions are opaque and only meaningful to the store that returned them.
+	Revision string    `json:"revision"`
+	Time     time.Time `json:"time"`
+	Message  string    `json:"message,omitempty"`
+
+	// Deleted is true if the ref was removed by this change.
+	Deleted bool `json:"deleted,omitempty"`
+	// Link is the target of the ref, if it was set as a link.
+	Link string `json:"link,omitempty"`
+	// Body is the value of the ref after this change.
+	Body json.RawMessage `json:"body,omitempty"`
+	// Audit records who made this change, if known.
+	Audit *Audit `json:"audit,omitempty"`
+}
+
+// History returns the changes made to a ref in a store that records history.
+func History(ctx context.Context, store Store, ref string) ([]HistoryEntry, error) {
+	hs, ok := store.(HistoryStore)
+	if !ok {
+		return nil, ErrHistoryUnsupported
+	}
+	return hs.History(ctx, ref)
+}
+
+// RevisionAt returns the revision of a store at a point in time.
+func RevisionAt(ctx context.Context, store Store, t time.Time) (string, error) {
+	hs, ok := store.(HistoryStore)
+	if !ok {
+		return "", ErrHistoryUnsupported
+	}
+	return hs.RevisionAt(ctx, t)
+}
+
+// GetAt gets the value of a ref at a revision of a store that records history.
+func GetAt(ctx context.Context, store Store, ref string, revision string, v any) error {
+	hs, ok := store.(HistoryStore)
+	if !ok {
+		return ErrHistoryUnsupported
+	}
+	return hs.GetAt(ctx, ref, revision, v)
+}
+
+// historyEntryFromObject creates a history entry from the stored content of
+// a ref, which is nil if the ref was deleted.
+func historyEntryFromObject(entry HistoryEntry, content []byte) (HistoryEntry, error) {
+	if content == nil {
+		entry.Deleted = true
+		return entry, nil
+	}
+
+	var storageObject StorageObject
+	if err := json.Unmarshal(content, &storageObject); err != nil {
+		return entry, err
+	}
+	if storageObject.SetAudit != nil {
+		entry.Audit = storageObject.SetAudit
+	}
+	if storageObject.Kind == StorageKindLink {
+		if err := json.Unmarshal(storageObject.Body, &entry.Link); err != nil {
+			return entry, err
+		}
+		return entry, nil
+	}
+	entry.Body = storageObject.Body
+	return entry, nil
+}
+
+// compactHistory removes entries that did not change the value of a ref, such
+// as updates to the list of links pointing at it. Entries are expected to be
+// most recent first.
+func compactHistory(entries []HistoryEntry) []HistoryEntry {
+	var out []HistoryEntry
+	for i, entry := range entries {
+		if i+1 < len(entries) {
+			older := entries[i+1]
+			if entry.Deleted == older.Deleted && entry.Link == older.Link && bytes.Equal(entry.Body, older.Body) {
+				continue
+			}
+		}
+		out = append(out, entry)
+	}
+	return out
+}
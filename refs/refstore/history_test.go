@@ -0,0 +1,90 @@
+package refstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func DoTestHistoryStore(t *testing.T, store HistoryStore) {
+	ctx := context.Background()
+	ref := "github.com/example/repo.git/path/to/package/@/custom/history"
+	link := "github.com/example/repo.git/path/to/package/@/custom/history_link"
+	t.Cleanup(func() {
+		_ = store.Unlink(ctx, link)
+		_ = store.Delete(ctx, ref)
+	})
+
+	if err := store.Set(ctx, ref, "first"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Link(ctx, link, ref); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set(ctx, ref, "second"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(ctx, ref); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set(ctx, ref, "third"); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := store.History(ctx, ref)
+	if err != nil {
+		t.Fatalf("failed to get history: %v", err)
+	}
+	if len(entries) != 4 {
+		t.Fatalf("expected 4 history entries, got %d: %+v", len(entries), entries)
+	}
+	for i, want := range []string{`"third"`, "", `"second"`, `"first"`} {
+		if got := string(entries[i].Body); got != want {
+			t.Errorf("entry %d: unexpected body: got %q, want %q", i, got, want)
+		}
+		if deleted := want == ""; entries[i].Deleted != deleted {
+			t.Errorf("entry %d: unexpected deleted flag: got %v, want %v", i, entries[i].Deleted, deleted)
+		}
+	}
+
+	linkEntries, err := store.History(ctx, link)
+	if err != nil {
+		t.Fatalf("failed to get link history: %v", err)
+	}
+	if len(linkEntries) != 1 || linkEntries[0].Link != ref {
+		t.Errorf("unexpected link history: %+v", linkEntries)
+	}
+
+	assertValueAt := func(revision string, ref string, want string) {
+		t.Helper()
+		var got string
+		if err := store.GetAt(ctx, ref, revision, &got); err != nil {
+			t.Errorf("failed to get %s at %s: %v", ref, revision, err)
+			return
+		}
+		if got != want {
+			t.Errorf("unexpected value for %s at %s: got %q, want %q", ref, revision, got, want)
+		}
+	}
+
+	assertValueAt(entries[3].Revision, ref, "first")
+	assertValueAt(entries[2].Revision, ref, "second")
+	assertValueAt(entries[2].Revision, link, "second")
+	assertValueAt(entries[0].Revision, ref, "third")
+
+	var v string
+	if err := store.GetAt(ctx, ref, entries[1].Revision, &v); !errors.Is(err, ErrRefNotFound) {
+		t.Errorf("expected ErrRefNotFound for deleted ref, got %v", err)
+	}
+
+	revision, err := store.RevisionAt(ctx, time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatalf("failed to get current revision: %v", err)
+	}
+	assertValueAt(revision, ref, "third")
+
+	if _, err := store.RevisionAt(ctx, time.Now().Add(-24*time.Hour)); !errors.Is(err, ErrRefNotFound) {
+		t.Errorf("expected ErrRefNotFound before any changes, got %v", err)
+	}
+}
@@ -0,0 +1,533 @@
+package refstore
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// DefaultHTTPTransactionTimeout is how long a transaction may be left idle
+// before the server rolls it back.
+const DefaultHTTPTransactionTimeout = 5 * time.Minute
+
+var errTransactionNotFound = errors.New("transaction not found, it may have timed out")
+
+// HTTPToken is a token that clients may authenticate with.
+type HTTPToken struct {
+	Token string
+	// Actor is recorded as the actor of changes made with the token. The
+	// server's own actor is recorded if empty.
+	Actor string
+	// ReadOnly tokens may not write to the store or start transactions.
+	ReadOnly bool
+}
+
+type HTTPHandlerConfig struct {
+	// Stores are served by name, usually "state" and "intent".
+	Stores map[string]Store
+	// Tokens are accepted from clients. Requests are rejected if none are
+	// configured.
+	Tokens []HTTPToken
+	// TransactionTimeout is how long a transaction may be left idle,
+	// DefaultHTTPTransactionTimeout if zero.
+	TransactionTimeout time.Duration
+}
+
+// NewHTTPHandler serves stores with the HTTP store API, described alongside
+// HTTPStore. Close should be called when the server stops, to roll back any
+// open transactions.
+func NewHTTPHandler(config HTTPHandlerConfig) *HTTPHandler {
+	if config.TransactionTimeout == 0 {
+		config.TransactionTimeout = DefaultHTTPTransactionTimeout
+	}
+
+	h := &HTTPHandler{
+		mux:    http.NewServeMux(),
+		tokens: config.Tokens,
+		stores: make(map[string]*httpServedStore),
+	}
+	for name, store := range config.Stores {
+		h.stores[name] = &httpServedStore{
+			store:   NewSyncStore(store),
+			lock:    make(chan struct{}, 1),
+			timeout: config.TransactionTimeout,
+		}
+	}
+
+	h.handle("GET /v1/{store}/info", false, func(w http.ResponseWriter, r *http.Request, s *httpServedStore) error {
+		return writeJSON(w, http.StatusOK, s.store.Info())
+	})
+	h.handle("GET /v1/{store}/refs/{ref...}", false, refHandler(serveGet))
+	h.handle("PUT /v1/{store}/refs/{ref...}", true, refHandler(serveSet))
+	h.handle("DELETE /v1/{store}/refs/{ref...}", true, func(w http.ResponseWriter, r *http.Request, s *httpServedStore) error {
+		return s.write(w, r, func(ctx context.Context) error {
+			return s.store.Delete(ctx, r.PathValue("ref"))
+		})
+	})
+	h.handle("GET /v1/{store}/match", false, func(w http.ResponseWriter, r *http.Request, s *httpServedStore) error {
+		options := MatchOptions{NoLinks: r.URL.Query().Get("no_links") != ""}
+		var matches []string
+		err := s.read(r, func() (err error) {
+			matches, err = s.store.MatchOptions(r.Context(), options, r.URL.Query()["glob"]...)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, http.StatusOK, emptyIfNil(matches))
+	})
+	h.handle("PUT /v1/{store}/links/{ref...}", true, func(w http.ResponseWriter, r *http.Request, s *httpServedStore) error {
+		var body httpLinkBody
+		if err := decodeRequest(r, &body); err != nil {
+			return err
+		}
+		return s.write(w, r, func(ctx context.Context) error {
+			return s.store.Link(ctx, r.PathValue("ref"), body.Target)
+		})
+	})
+	h.handle("DELETE /v1/{store}/links/{ref...}", true, func(w http.ResponseWriter, r *http.Request, s *httpServedStore) error {
+		return s.write(w, r, func(ctx context.Context) error {
+			return s.store.Unlink(ctx, r.PathValue("ref"))
+		})
+	})
+	h.handle("GET /v1/{store}/links/{ref...}", false, func(w http.ResponseWriter, r *http.Request, s *httpServedStore) error {
+		var links []string
+		err := s.read(r, func() (err error) {
+			links, err = s.store.GetLinks(r.Context(), r.PathValue("ref"))
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, http.StatusOK, emptyIfNil(links))
+	})
+	h.handle("GET /v1/{store}/resolve/{ref...}", false, func(w http.ResponseWriter, r *http.Request, s *httpServedStore) error {
+		var resolved string
+		err := s.read(r, func() (err error) {
+			resolved, err = s.store.ResolveLink(r.Context(), r.PathValue("ref"))
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, http.StatusOK, httpResolveBody{Ref: resolved})
+	})
+	h.handle("PUT /v1/{store}/dependencies/{ref...}", true, func(w http.ResponseWriter, r *http.Request, s *httpServedStore) error {
+		var body httpDependencyBody
+		if err := decodeRequest(r, &body); err != nil {
+			return err
+		}
+		return s.write(w, r, func(ctx context.Context) error {
+			return s.store.AddDependency(ctx, r.PathValue("ref"), body.Dependency)
+		})
+	})
+	h.handle("DELETE /v1/{store}/dependencies/{ref...}", true, func(w http.ResponseWriter, r *http.Request, s *httpServedStore) error {
+		return s.write(w, r, func(ctx context.Context) error {
+			return s.store.RemoveDependency(ctx, r.PathValue("ref"), r.URL.Query().Get("dependency"))
+		})
+	})
+	h.handle("GET /v1/{store}/dependencies/{ref...}", false, func(w http.ResponseWriter, r *http.Request, s *httpServedStore) error {
+		var deps []string
+		err := s.read(r, func() (err error) {
+			deps, err = s.store.GetDependencies(r.Context(), r.PathValue("ref"))
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, http.StatusOK, emptyIfNil(deps))
+	})
+	h.handle("GET /v1/{store}/dependants/{ref...}", false, func(w http.ResponseWriter, r *http.Request, s *httpServedStore) error {
+		var dependants []string
+		err := s.read(r, func() (err error) {
+			dependants, err = s.store.GetDependants(r.Context(), r.PathValue("ref"))
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, http.StatusOK, emptyIfNil(dependants))
+	})
+	h.handle("POST /v1/{store}/transactions", true, func(w http.ResponseWriter, r *http.Request, s *httpServedStore) error {
+		var body httpTransactionBody
+		if err := decodeRequest(r, &body); err != nil {
+			return err
+		}
+		id, err := s.startTransaction(r, body.Message)
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, http.StatusCreated, httpTransactionBody{ID: id})
+	})
+	h.handle("POST /v1/{store}/transactions/{id}/commit", true, func(w http.ResponseWriter, r *http.Request, s *httpServedStore) error {
+		if err := s.commitTransaction(r.Context(), r.PathValue("id")); err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	})
+	h.handle("POST /v1/{store}/transactions/{id}/rollback", true, func(w http.ResponseWriter, r *http.Request, s *httpServedStore) error {
+		if err := s.rollbackTransaction(r.Context(), r.PathValue("id")); err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	})
+
+	return h
+}
+
+var _ http.Handler = (*HTTPHandler)(nil)
+
+type HTTPHandler struct {
+	mux    *http.ServeMux
+	tokens []HTTPToken
+	stores map[string]*httpServedStore
+}
+
+// ServeHTTP implements http.Handler.
+func (h *HTTPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// Close rolls back any open transactions, since their clients did not
+// finish them. The stores themselves are left open.
+func (h *HTTPHandler) Close() error {
+	var errs []error
+	for _, s := range h.stores {
+		s.mu.Lock()
+		t := s.transaction
+		s.mu.Unlock()
+		if t != nil {
+			log.Warn("Rolling back unfinished transaction", "id", t.id)
+			errs = append(errs, s.rollbackTransaction(context.Background(), t.id))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// handle registers a handler for requests to a store, which must be
+// authenticated with a token that permits writes if write is set.
+func (h *HTTPHandler) handle(pattern string, write bool, fn func(w http.ResponseWriter, r *http.Request, s *httpServedStore) error) {
+	h.mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		token, ok := h.authenticate(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, httpErrorBody{Error: "a valid token is required"})
+			return
+		}
+		if write && token.ReadOnly {
+			writeError(w, http.StatusForbidden, httpErrorBody{Error: "token is read-only"})
+			return
+		}
+
+		s, ok := h.stores[r.PathValue("store")]
+		if !ok {
+			writeError(w, http.StatusNotFound, httpErrorBody{Error: fmt.Sprintf("unknown store %q", r.PathValue("store"))})
+			return
+		}
+		r = r.WithContext(requestContext(r, token))
+
+		if err := fn(w, r, s); err != nil {
+			status := httpStatus(err)
+			if status == http.StatusInternalServerError {
+				log.Error("Store request failed", "method", r.Method, "path", r.URL.Path, "err", err)
+			}
+			body := httpErrorBody{Error: err.Error()}
+			if status == http.StatusNotFound {
+				body.Ref = r.PathValue("ref")
+			}
+			var schemaErr *SchemaError
+			if errors.As(err, &schemaErr) {
+				body.Ref = schemaErr.Ref
+				body.Fields = schemaErr.Errors
+			}
+			writeError(w, status, body)
+		}
+	})
+}
+
+// authenticate finds the token a request was made with.
+func (h *HTTPHandler) authenticate(r *http.Request) (HTTPToken, bool) {
+	presented, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || presented == "" {
+		return HTTPToken{}, false
+	}
+	for _, token := range h.tokens {
+		if subtle.ConstantTimeCompare([]byte(token.Token), []byte(presented)) == 1 {
+			return token, true
+		}
+	}
+	return HTTPToken{}, false
+}
+
+// httpServedStore is a store served over HTTP. Writes are serialized, with
+// a transaction holding the store until it is committed or rolled back.
+// Reads outside the transaction wait for it to finish, so they never see its
+// uncommitted writes.
+type httpServedStore struct {
+	store   Store
+	lock    chan struct{}
+	timeout time.Duration
+
+	mu          sync.Mutex
+	transaction *httpTransaction
+}
+
+type httpTransaction struct {
+	id    string
+	timer *time.Timer
+}
+
+// acquire waits to hold the store for writing.
+func (s *httpServedStore) acquire(ctx context.Context) error {
+	select {
+	case s.lock <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *httpServedStore) release() {
+	<-s.lock
+}
+
+// write makes a change to the store, as part of the request's transaction if
+// it has one.
+func (s *httpServedStore) write(w http.ResponseWriter, r *http.Request, fn func(ctx context.Context) error) error {
+	if err := s.hold(r, func() error {
+		return fn(r.Context())
+	}); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// read reads from the store, within the request's transaction if it has one.
+func (s *httpServedStore) read(r *http.Request, fn func() error) error {
+	return s.hold(r, fn)
+}
+
+// hold calls fn within the request's transaction if it has one, otherwise
+// holding the store so that no transaction is open.
+func (s *httpServedStore) hold(r *http.Request, fn func() error) error {
+	if id := r.Header.Get(httpTransactionHeader); id != "" {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.transaction == nil || s.transaction.id != id {
+			return errTransactionNotFound
+		}
+		s.transaction.timer.Reset(s.timeout)
+		return fn()
+	}
+
+	if err := s.acquire(r.Context()); err != nil {
+		return err
+	}
+	defer s.release()
+	return fn()
+}
+
+// startTransaction waits for the store to be free and starts a transaction
+// that holds it until committed.
+func (s *httpServedStore) startTransaction(r *http.Request, message string) (string, error) {
+	ctx := r.Context()
+	if err := s.acquire(ctx); err != nil {
+		return "", err
+	}
+	// The transaction outlives the request that started it
+	if err := s.store.StartTransaction(context.WithoutCancel(ctx), message); err != nil {
+		s.release()
+		return "", err
+	}
+
+	idBytes := make([]byte, 16)
+	_, _ = rand.Read(idBytes)
+	id := hex.EncodeToString(idBytes)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.transaction = &httpTransaction{id: id}
+	s.transaction.timer = time.AfterFunc(s.timeout, func() {
+		log.Warn("Rolling back idle transaction", "id", id)
+		err := s.rollbackTransaction(context.Background(), id)
+		if err != nil && !errors.Is(err, errTransactionNotFound) {
+			log.Error("Failed to roll back idle transaction", "id", id, "err", err)
+		}
+	})
+	return id, nil
+}
+
+// commitTransaction commits a transaction and frees the store for other
+// writers.
+func (s *httpServedStore) commitTransaction(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.transaction == nil || s.transaction.id != id {
+		return errTransactionNotFound
+	}
+	s.transaction.timer.Stop()
+	s.transaction = nil
+	defer s.release()
+
+	return s.store.CommitTransaction(ctx)
+}
+
+// rollbackTransaction discards the changes made in a transaction and frees
+// the store for other writers.
+func (s *httpServedStore) rollbackTransaction(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.transaction == nil || s.transaction.id != id {
+		return errTransactionNotFound
+	}
+	s.transaction.timer.Stop()
+	s.transaction = nil
+	defer s.release()
+
+	return RollbackTransaction(ctx, s.store)
+}
+
+// serveGet returns the value of a ref, with its revision if the store
+// supports conditional writes.
+func serveGet(w http.ResponseWriter, r *http.Request, store Store) error {
+	var value json.RawMessage
+	revision, err := GetWithRevision(r.Context(), store, r.PathValue("ref"), &value)
+	if errors.Is(err, ErrConditionalWritesUnsupported) {
+		err = store.Get(r.Context(), r.PathValue("ref"), &value)
+	}
+	if err != nil {
+		return err
+	}
+	if revision != "" {
+		w.Header().Set("ETag", `"`+revision+`"`)
+	}
+	return writeJSON(w, http.StatusOK, value)
+}
+
+// serveSet writes the value of a ref, conditionally if requested.
+func serveSet(w http.ResponseWriter, r *http.Request, store Store) error {
+	var value json.RawMessage
+	if err := decodeRequest(r, &value); err != nil {
+		return err
+	}
+	ref := r.PathValue("ref")
+	ifNoneMatch := r.Header.Get("If-None-Match")
+	ifMatch := strings.Trim(r.Header.Get("If-Match"), `"`)
+
+	switch {
+	case ifNoneMatch == "*":
+		return SetIfAbsent(r.Context(), store, ref, value)
+	case ifMatch != "":
+		return SetIfRevision(r.Context(), store, ref, value, ifMatch)
+	default:
+		return store.Set(r.Context(), ref, value)
+	}
+}
+
+// refHandler adapts a handler for reading or writing a ref. Reads and writes
+// are made through httpServedStore so they are isolated from transactions.
+func refHandler(fn func(w http.ResponseWriter, r *http.Request, store Store) error) func(w http.ResponseWriter, r *http.Request, s *httpServedStore) error {
+	return func(w http.ResponseWriter, r *http.Request, s *httpServedStore) error {
+		if r.Method == http.MethodGet {
+			return s.read(r, func() error {
+				return fn(w, r, s.store)
+			})
+		}
+		return s.write(w, r, func(ctx context.Context) error {
+			return fn(w, r.WithContext(ctx), s.store)
+		})
+	}
+}
+
+// requestContext returns the context for a request, attributing writes to
+// the actor of the token it was authenticated with. Only the command and job
+// URL are taken from the audit sent by the client, as they cannot be
+// verified.
+func requestContext(r *http.Request, token HTTPToken) context.Context {
+	audit := CurrentAudit()
+	if token.Actor != "" {
+		audit.Actor = token.Actor
+	}
+	audit.Command = nil
+	audit.JobURL = ""
+
+	if header := r.Header.Get(httpAuditHeader); header != "" {
+		var client Audit
+		if err := json.Unmarshal([]byte(header), &client); err != nil {
+			log.Warn("Ignoring invalid audit header", "err", err)
+		} else {
+			audit.Command = client.Command
+			audit.JobURL = client.JobURL
+		}
+	}
+	return ContextWithAudit(r.Context(), audit)
+}
+
+func decodeRequest(r *http.Request, v any) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return &httpBadRequest{err: fmt.Errorf("invalid request body: %w", err)}
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) error {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, err = w.Write(content)
+	return err
+}
+
+func writeError(w http.ResponseWriter, status int, body httpErrorBody) {
+	_ = writeJSON(w, status, body)
+}
+
+type httpBadRequest struct {
+	err error
+}
+
+func (e *httpBadRequest) Error() string {
+	return e.err.Error()
+}
+
+// httpStatus returns the status code for an error from a store.
+func httpStatus(err error) int {
+	var (
+		schemaErr  *SchemaError
+		badRequest *httpBadRequest
+	)
+	switch {
+	case errors.Is(err, ErrRefNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrConflict):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, ErrConditionalWritesUnsupported), errors.Is(err, ErrRollbackUnsupported):
+		return http.StatusNotImplemented
+	case errors.Is(err, ErrReadOnly):
+		return http.StatusForbidden
+	case errors.Is(err, errTransactionNotFound):
+		return http.StatusConflict
+	case errors.As(err, &schemaErr):
+		return http.StatusUnprocessableEntity
+	case errors.As(err, &badRequest):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,439 @@
+package refstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/charmbracelet/log"
+)
+
+// The HTTP store API serves stores over HTTP so many workers can share one
+// store, see NewHTTPHandler. Each named store, usually "state" and "intent",
+// is served under /v1/{store}:
+//
+//	GET    /v1/{store}/info                       -> StoreInfo
+//	GET    /v1/{store}/refs/{ref}                 -> value
+//	PUT    /v1/{store}/refs/{ref}                 value -> 204
+//	DELETE /v1/{store}/refs/{ref}                 -> 204
+//	GET    /v1/{store}/match?glob=...&no_links=1  -> ["ref", ...]
+//	PUT    /v1/{store}/links/{ref}                {"target": "..."} -> 204
+//	DELETE /v1/{store}/links/{ref}                -> 204
+//	GET    /v1/{store}/links/{ref}                -> ["ref", ...]
+//	GET    /v1/{store}/resolve/{ref}              -> {"ref": "..."}
+//	PUT    /v1/{store}/dependencies/{ref}         {"dependency": "..."} -> 204
+//	DELETE /v1/{store}/dependencies/{ref}?dependency=... -> 204
+//	GET    /v1/{store}/dependencies/{ref}         -> ["ref", ...]
+//	GET    /v1/{store}/dependants/{ref}           -> ["ref", ...]
+//	POST   /v1/{store}/transactions               {"message": "..."} -> {"id": "..."}
+//	POST   /v1/{store}/transactions/{id}/commit   -> 204
+//	POST   /v1/{store}/transactions/{id}/rollback -> 204
+//
+// Refs are included in the path as-is, with any fragment escaped. Every
+// request must have an "Authorization: Bearer <token>" header.
+//
+// GET of a ref returns its revision as an ETag when the store supports
+// conditional writes. A PUT with "If-None-Match: *" only creates the ref, and
+// one with "If-Match" only replaces the given revision, failing with 412
+// Precondition Failed otherwise.
+//
+// Writes are made one at a time. A transaction holds the store until it is
+// committed or rolled back, and requests within it are sent with its id in
+// the Ocuroot-Transaction header. Other requests wait for the transaction to
+// finish, so never see its uncommitted writes. Transactions that are left
+// idle, or are open when the server stops, are rolled back.
+//
+// Writes are attributed to the actor of the token they were made with. They
+// may include the Audit of the client in the Ocuroot-Audit header as JSON,
+// from which the server records only the command and job URL.
+//
+// Errors are returned as {"error": "..."}, with 404 for refs that do not exist
+// and 422 with a list of "fields" for values that do not match their schema.
+
+const (
+	httpTransactionHeader = "Ocuroot-Transaction"
+	httpAuditHeader       = "Ocuroot-Audit"
+)
+
+type HTTPStoreConfig struct {
+	// URL is the base URL of the server.
+	URL string
+	// Store is the name of the store on the server, "state" or "intent".
+	Store string
+	// Token is sent to authenticate each request.
+	Token string
+	// Client is used to make requests, http.DefaultClient if nil.
+	Client *http.Client
+}
+
+// HTTPError is an error response from a store server.
+type HTTPError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("store server returned %d: %s", e.StatusCode, e.Message)
+}
+
+type httpErrorBody struct {
+	Error  string       `json:"error"`
+	Ref    string       `json:"ref,omitempty"`
+	Fields []FieldError `json:"fields,omitempty"`
+}
+
+type httpLinkBody struct {
+	Target string `json:"target"`
+}
+
+type httpResolveBody struct {
+	Ref string `json:"ref"`
+}
+
+type httpDependencyBody struct {
+	Dependency string `json:"dependency"`
+}
+
+type httpTransactionBody struct {
+	ID      string `json:"id,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// NewHTTPStore returns a store that forwards each operation to a store
+// served by NewHTTPHandler, checking that the server can be reached.
+func NewHTTPStore(tags map[string]struct{}, config HTTPStoreConfig) (*HTTPStore, error) {
+	log.Info("Initializing HTTPStore", "url", config.URL, "store", config.Store, "tags", tags)
+	base, err := url.Parse(strings.TrimSuffix(config.URL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid store URL: %w", err)
+	}
+	if config.Client == nil {
+		config.Client = http.DefaultClient
+	}
+
+	h := &HTTPStore{
+		config: config,
+		base:   base,
+	}
+	if err := h.do(context.Background(), http.MethodGet, "info", nil, nil, &h.info); err != nil {
+		return nil, fmt.Errorf("failed to connect to store server: %w", err)
+	}
+	// Tags describe how this store is used, which may differ from the server
+	h.info.Tags = tags
+	return h, nil
+}
+
+var _ Store = (*HTTPStore)(nil)
+var _ ConditionalStore = (*HTTPStore)(nil)
+var _ RollbackStore = (*HTTPStore)(nil)
+
+type HTTPStore struct {
+	config HTTPStoreConfig
+	base   *url.URL
+	info   StoreInfo
+
+	mu          sync.Mutex
+	transaction string
+}
+
+// request builds a request for a path under the store.
+func (h *HTTPStore) request(ctx context.Context, method string, path string, query url.Values, body any) (*http.Request, error) {
+	u := *h.base
+	u.Path = h.base.Path + "/v1/" + url.PathEscape(h.config.Store) + "/" + path
+	u.RawPath = ""
+	u.RawQuery = query.Encode()
+
+	var reader io.Reader
+	if body != nil {
+		content, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(content)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if h.config.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.config.Token)
+	}
+
+	if method != http.MethodGet {
+		audit, err := json.Marshal(auditFromContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set(httpAuditHeader, string(audit))
+	}
+
+	h.mu.Lock()
+	if h.transaction != "" {
+		req.Header.Set(httpTransactionHeader, h.transaction)
+	}
+	h.mu.Unlock()
+	return req, nil
+}
+
+// send makes a request, returning the response if it succeeded.
+func (h *HTTPStore) send(req *http.Request) (*http.Response, error) {
+	resp, err := h.config.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	var body httpErrorBody
+	content, _ := io.ReadAll(resp.Body)
+	if err := json.Unmarshal(content, &body); err != nil || body.Error == "" {
+		body.Error = strings.TrimSpace(string(content))
+	}
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		if body.Ref != "" {
+			return nil, ErrRefNotFound
+		}
+	case http.StatusPreconditionFailed:
+		return nil, ErrConflict
+	case http.StatusNotImplemented:
+		return nil, ErrConditionalWritesUnsupported
+	case http.StatusUnprocessableEntity:
+		if len(body.Fields) > 0 {
+			return nil, &SchemaError{Ref: body.Ref, Errors: body.Fields}
+		}
+	}
+	return nil, &HTTPError{StatusCode: resp.StatusCode, Message: body.Error}
+}
+
+// do makes a request with a JSON body and decodes any response into v.
+func (h *HTTPStore) do(ctx context.Context, method string, path string, query url.Values, body any, v any) error {
+	req, err := h.request(ctx, method, path, query, body)
+	if err != nil {
+		return err
+	}
+	resp, err := h.send(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if v == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode response from store server: %w", err)
+	}
+	return nil
+}
+
+// put writes a value to a ref, with any extra headers for conditional writes.
+func (h *HTTPStore) put(ctx context.Context, ref string, v any, header http.Header) error {
+	req, err := h.request(ctx, http.MethodPut, "refs/"+ref, nil, v)
+	if err != nil {
+		return err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	resp, err := h.send(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Info implements Store.
+func (h *HTTPStore) Info() StoreInfo {
+	return h.info
+}
+
+// StartTransaction implements Store.
+// The store is held by this client until the transaction is committed.
+func (h *HTTPStore) StartTransaction(ctx context.Context, message string) error {
+	var out httpTransactionBody
+	if err := h.do(ctx, http.MethodPost, "transactions", nil, httpTransactionBody{Message: message}, &out); err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.transaction = out.ID
+	return nil
+}
+
+// CommitTransaction implements Store.
+func (h *HTTPStore) CommitTransaction(ctx context.Context) error {
+	h.mu.Lock()
+	id := h.transaction
+	h.mu.Unlock()
+	if id == "" {
+		return nil
+	}
+
+	err := h.do(ctx, http.MethodPost, "transactions/"+url.PathEscape(id)+"/commit", nil, nil, nil)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.transaction = ""
+	return err
+}
+
+// RollbackTransaction implements RollbackStore.
+func (h *HTTPStore) RollbackTransaction(ctx context.Context) error {
+	h.mu.Lock()
+	id := h.transaction
+	h.mu.Unlock()
+	if id == "" {
+		return nil
+	}
+
+	err := h.do(ctx, http.MethodPost, "transactions/"+url.PathEscape(id)+"/rollback", nil, nil, nil)
+	// The server returns 501 for any unsupported operation
+	if errors.Is(err, ErrConditionalWritesUnsupported) {
+		err = ErrRollbackUnsupported
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.transaction = ""
+	return err
+}
+
+// Get implements Store.
+func (h *HTTPStore) Get(ctx context.Context, ref string, v any) error {
+	_, err := h.get(ctx, ref, v)
+	return err
+}
+
+// get reads a ref, returning its revision if the server provided one.
+func (h *HTTPStore) get(ctx context.Context, ref string, v any) (string, error) {
+	req, err := h.request(ctx, http.MethodGet, "refs/"+ref, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := h.send(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return "", fmt.Errorf("failed to decode %s: %w", ref, err)
+	}
+	return strings.Trim(resp.Header.Get("ETag"), `"`), nil
+}
+
+// Set implements Store.
+func (h *HTTPStore) Set(ctx context.Context, ref string, v any) error {
+	return h.put(ctx, ref, v, nil)
+}
+
+// GetWithRevision implements ConditionalStore.
+func (h *HTTPStore) GetWithRevision(ctx context.Context, ref string, v any) (string, error) {
+	revision, err := h.get(ctx, ref, v)
+	if err != nil {
+		return "", err
+	}
+	if revision == "" {
+		return "", ErrConditionalWritesUnsupported
+	}
+	return revision, nil
+}
+
+// SetIfAbsent implements ConditionalStore.
+func (h *HTTPStore) SetIfAbsent(ctx context.Context, ref string, v any) error {
+	return h.put(ctx, ref, v, http.Header{"If-None-Match": {"*"}})
+}
+
+// SetIfRevision implements ConditionalStore.
+func (h *HTTPStore) SetIfRevision(ctx context.Context, ref string, v any, revision string) error {
+	return h.put(ctx, ref, v, http.Header{"If-Match": {`"` + revision + `"`}})
+}
+
+// Delete implements Store.
+func (h *HTTPStore) Delete(ctx context.Context, ref string) error {
+	return h.do(ctx, http.MethodDelete, "refs/"+ref, nil, nil, nil)
+}
+
+// Match implements Store.
+func (h *HTTPStore) Match(ctx context.Context, glob ...string) ([]string, error) {
+	return h.MatchOptions(ctx, MatchOptions{}, glob...)
+}
+
+// MatchOptions implements Store.
+func (h *HTTPStore) MatchOptions(ctx context.Context, options MatchOptions, glob ...string) ([]string, error) {
+	query := url.Values{"glob": glob}
+	if options.NoLinks {
+		query.Set("no_links", "1")
+	}
+	var out []string
+	err := h.do(ctx, http.MethodGet, "match", query, nil, &out)
+	return out, err
+}
+
+// Link implements Store.
+func (h *HTTPStore) Link(ctx context.Context, ref string, target string) error {
+	return h.do(ctx, http.MethodPut, "links/"+ref, nil, httpLinkBody{Target: target}, nil)
+}
+
+// Unlink implements Store.
+func (h *HTTPStore) Unlink(ctx context.Context, ref string) error {
+	return h.do(ctx, http.MethodDelete, "links/"+ref, nil, nil, nil)
+}
+
+// GetLinks implements Store.
+func (h *HTTPStore) GetLinks(ctx context.Context, ref string) ([]string, error) {
+	var out []string
+	err := h.do(ctx, http.MethodGet, "links/"+ref, nil, nil, &out)
+	return out, err
+}
+
+// ResolveLink implements Store.
+func (h *HTTPStore) ResolveLink(ctx context.Context, ref string) (string, error) {
+	var out httpResolveBody
+	err := h.do(ctx, http.MethodGet, "resolve/"+ref, nil, nil, &out)
+	return out.Ref, err
+}
+
+// AddDependency implements Store.
+func (h *HTTPStore) AddDependency(ctx context.Context, ref string, dependency string) error {
+	return h.do(ctx, http.MethodPut, "dependencies/"+ref, nil, httpDependencyBody{Dependency: dependency}, nil)
+}
+
+// RemoveDependency implements Store.
+func (h *HTTPStore) RemoveDependency(ctx context.Context, ref string, dependency string) error {
+	return h.do(ctx, http.MethodDelete, "dependencies/"+ref, url.Values{"dependency": {dependency}}, nil, nil)
+}
+
+// GetDependencies implements Store.
+func (h *HTTPStore) GetDependencies(ctx context.Context, ref string) ([]string, error) {
+	var out []string
+	err := h.do(ctx, http.MethodGet, "dependencies/"+ref, nil, nil, &out)
+	return out, err
+}
+
+// GetDependants implements Store.
+func (h *HTTPStore) GetDependants(ctx context.Context, ref string) ([]string, error) {
+	var out []string
+	err := h.do(ctx, http.MethodGet, "dependants/"+ref, nil, nil, &out)
+	return out, err
+}
+
+// Close implements Store.
+// Any open transaction is committed.
+func (h *HTTPStore) Close() error {
+	return h.CommitTransaction(context.Background())
+}
@@ -0,0 +1,364 @@
+package refstore
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func newTestHTTPServer(t *testing.T, stores map[string]Store) *httptest.Server {
+	t.Helper()
+	handler := NewHTTPHandler(HTTPHandlerConfig{
+		Stores: stores,
+		Tokens: []HTTPToken{
+			{Token: "write-token", Actor: "ci-worker"},
+			{Token: "read-token", ReadOnly: true},
+		},
+	})
+	server := httptest.NewServer(handler)
+	t.Cleanup(func() {
+		server.Close()
+		if err := handler.Close(); err != nil {
+			t.Error(err)
+		}
+	})
+	return server
+}
+
+func newTestHTTPStore(t *testing.T, url string, token string) *HTTPStore {
+	t.Helper()
+	store, err := NewHTTPStore(map[string]struct{}{"state": {}}, HTTPStoreConfig{
+		URL:   url,
+		Store: "state",
+		Token: token,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := store.Close(); err != nil {
+			t.Error(err)
+		}
+	})
+	return store
+}
+
+func TestHTTPStore(t *testing.T) {
+	backend, err := NewFSRefStore(filepath.Join(t.TempDir(), "store"), map[string]struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newTestHTTPServer(t, map[string]Store{"state": backend})
+	store := newTestHTTPStore(t, server.URL, "write-token")
+
+	if _, ok := store.Info().Tags["state"]; !ok {
+		t.Errorf("expected store to be tagged as state, got %v", store.Info().Tags)
+	}
+
+	DoTestStore(t, store)
+
+	ctx := context.Background()
+
+	t.Run("not found", func(t *testing.T) {
+		var v any
+		err := store.Get(ctx, "github.com/example/repo.git/-/missing/@/custom/value", &v)
+		if !errors.Is(err, ErrRefNotFound) {
+			t.Errorf("expected ErrRefNotFound, got %v", err)
+		}
+	})
+
+	t.Run("conditional writes", func(t *testing.T) {
+		ref := "github.com/example/repo.git/-/package/@r1/custom/conditional"
+		if err := SetIfAbsent(ctx, store, ref, "first"); err != nil {
+			t.Fatal(err)
+		}
+		if err := SetIfAbsent(ctx, store, ref, "second"); !errors.Is(err, ErrConflict) {
+			t.Errorf("expected ErrConflict creating an existing ref, got %v", err)
+		}
+
+		var got string
+		revision, err := GetWithRevision(ctx, store, ref, &got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := SetIfRevision(ctx, store, ref, "third", revision); err != nil {
+			t.Fatal(err)
+		}
+		if err := SetIfRevision(ctx, store, ref, "fourth", revision); !errors.Is(err, ErrConflict) {
+			t.Errorf("expected ErrConflict writing a stale revision, got %v", err)
+		}
+	})
+
+	t.Run("audit", func(t *testing.T) {
+		ref := "github.com/example/repo.git/-/package/@r1/custom/audited"
+		audit := &Audit{Actor: "someone-else", JobURL: "https://ci.example.com/job/1"}
+		if err := store.Set(ContextWithAudit(ctx, audit), ref, "value"); err != nil {
+			t.Fatal(err)
+		}
+		metadata, err := GetMetadata(ctx, backend, ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The actor is taken from the token rather than the client
+		if metadata.SetAudit == nil || metadata.SetAudit.Actor != "ci-worker" || metadata.SetAudit.JobURL != audit.JobURL {
+			t.Errorf("expected the token's actor and the client's job to be recorded, got %+v", metadata.SetAudit)
+		}
+	})
+
+	t.Run("auth", func(t *testing.T) {
+		if _, err := NewHTTPStore(nil, HTTPStoreConfig{URL: server.URL, Store: "state", Token: "wrong"}); err == nil {
+			t.Errorf("expected an invalid token to be rejected")
+		}
+
+		readOnly := newTestHTTPStore(t, server.URL, "read-token")
+		var got string
+		if err := readOnly.Get(ctx, "github.com/example/repo.git/-/package/@r1/custom/audited", &got); err != nil {
+			t.Errorf("expected a read-only token to read, got %v", err)
+		}
+		var httpErr *HTTPError
+		err := readOnly.Set(ctx, "github.com/example/repo.git/-/package/@r1/custom/audited", "other")
+		if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusForbidden {
+			t.Errorf("expected a read-only token to be forbidden from writing, got %v", err)
+		}
+	})
+}
+
+func TestHTTPStoreTransaction(t *testing.T) {
+	ctx := context.Background()
+	backend, err := NewFSRefStore(filepath.Join(t.TempDir(), "store"), map[string]struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newTestHTTPServer(t, map[string]Store{"state": backend})
+	first := newTestHTTPStore(t, server.URL, "write-token")
+	second := newTestHTTPStore(t, server.URL, "write-token")
+
+	ref := "github.com/example/repo.git/-/package/@r1/custom/transaction"
+	if err := first.StartTransaction(ctx, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Set(ctx, ref, "first"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Other writers wait for the transaction to be committed
+	done := make(chan error)
+	go func() {
+		done <- second.Set(ctx, ref, "second")
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("expected write to wait for the transaction, got %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := first.CommitTransaction(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	var got string
+	if err := first.Get(ctx, ref, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != "second" {
+		t.Errorf("expected the waiting write to be made after the transaction, got %q", got)
+	}
+}
+
+func TestHTTPStoreRollback(t *testing.T) {
+	ctx := context.Background()
+	backend, err := NewFSRefStore(filepath.Join(t.TempDir(), "store"), map[string]struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newTestHTTPServer(t, map[string]Store{"state": backend})
+	first := newTestHTTPStore(t, server.URL, "write-token")
+	second := newTestHTTPStore(t, server.URL, "write-token")
+
+	ref := "github.com/example/repo.git/-/package/@r1/custom/transaction"
+	if err := first.StartTransaction(ctx, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Set(ctx, ref, "first"); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.RollbackTransaction(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var got string
+	if err := second.Get(ctx, ref, &got); !errors.Is(err, ErrRefNotFound) {
+		t.Errorf("expected the write to be rolled back, got %v", err)
+	}
+
+	// The store is freed for other writers
+	if err := second.Set(ctx, ref, "second"); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Get(ctx, ref, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != "second" {
+		t.Errorf("expected the later write, got %q", got)
+	}
+}
+
+func TestHTTPStoreIsolation(t *testing.T) {
+	ctx := context.Background()
+	backend, err := NewFSRefStore(filepath.Join(t.TempDir(), "store"), map[string]struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newTestHTTPServer(t, map[string]Store{"state": backend})
+	first := newTestHTTPStore(t, server.URL, "write-token")
+	second := newTestHTTPStore(t, server.URL, "read-token")
+
+	ref := "github.com/example/repo.git/-/package/@r1/custom/isolated"
+	if err := first.StartTransaction(ctx, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Set(ctx, ref, "first"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reads within the transaction see its writes
+	var got string
+	if err := first.Get(ctx, ref, &got); err != nil || got != "first" {
+		t.Errorf("expected the transaction to read its own write, got %q, %v", got, err)
+	}
+
+	// Other readers wait for the transaction to finish
+	type result struct {
+		value string
+		err   error
+	}
+	done := make(chan result)
+	go func() {
+		var value string
+		err := second.Get(ctx, ref, &value)
+		done <- result{value: value, err: err}
+	}()
+	select {
+	case r := <-done:
+		t.Fatalf("expected read to wait for the transaction, got %q, %v", r.value, r.err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := first.RollbackTransaction(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if r := <-done; !errors.Is(r.err, ErrRefNotFound) {
+		t.Errorf("expected the rolled back write not to be read, got %q, %v", r.value, r.err)
+	}
+}
+
+func TestHTTPStoreIdleTransaction(t *testing.T) {
+	ctx := context.Background()
+	backend, err := NewFSRefStore(filepath.Join(t.TempDir(), "store"), map[string]struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := NewHTTPHandler(HTTPHandlerConfig{
+		Stores:             map[string]Store{"state": backend},
+		Tokens:             []HTTPToken{{Token: "write-token"}},
+		TransactionTimeout: 100 * time.Millisecond,
+	})
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	client := newTestHTTPStore(t, server.URL, "write-token")
+
+	ref := "github.com/example/repo.git/-/package/@r1/custom/idle"
+	if err := client.StartTransaction(ctx, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Set(ctx, ref, "value"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(300 * time.Millisecond)
+
+	var httpErr *HTTPError
+	if err := client.CommitTransaction(ctx); !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusConflict {
+		t.Errorf("expected the idle transaction to have ended, got %v", err)
+	}
+	var got string
+	if err := backend.Get(ctx, ref, &got); !errors.Is(err, ErrRefNotFound) {
+		t.Errorf("expected the idle transaction to be rolled back, got %q, %v", got, err)
+	}
+}
+
+func TestHTTPHandlerClose(t *testing.T) {
+	ctx := context.Background()
+	backend, err := NewFSRefStore(filepath.Join(t.TempDir(), "store"), map[string]struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := NewHTTPHandler(HTTPHandlerConfig{
+		Stores: map[string]Store{"state": backend},
+		Tokens: []HTTPToken{{Token: "write-token"}},
+	})
+	server := httptest.NewServer(handler)
+	client, err := NewHTTPStore(map[string]struct{}{"state": {}}, HTTPStoreConfig{
+		URL:   server.URL,
+		Store: "state",
+		Token: "write-token",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ref := "github.com/example/repo.git/-/package/@r1/custom/unfinished"
+	if err := client.StartTransaction(ctx, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Set(ctx, ref, "value"); err != nil {
+		t.Fatal(err)
+	}
+
+	server.Close()
+	if err := handler.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var got string
+	if err := backend.Get(ctx, ref, &got); !errors.Is(err, ErrRefNotFound) {
+		t.Errorf("expected the unfinished transaction to be rolled back, got %q, %v", got, err)
+	}
+}
+
+func TestHTTPStoreSchemaError(t *testing.T) {
+	backend, err := NewFSRefStore(filepath.Join(t.TempDir(), "store"), map[string]struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema, err := ParseSchema([]byte(`{"type": "object", "required": ["host"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	schemas, err := NewSchemaSet(SchemaRule{Glob: "**/@*/custom/database", Schema: schema})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newTestHTTPServer(t, map[string]Store{"state": NewValidatingStore(backend, schemas)})
+	store := newTestHTTPStore(t, server.URL, "write-token")
+
+	ref := "github.com/example/repo.git/-/package/@r1/custom/database"
+	err = store.Set(context.Background(), ref, map[string]any{"port": 1})
+	var schemaErr *SchemaError
+	if !errors.As(err, &schemaErr) {
+		t.Fatalf("expected a schema error, got %v", err)
+	}
+	if schemaErr.Ref != ref {
+		t.Errorf("unexpected ref %q", schemaErr.Ref)
+	}
+	if diff := cmp.Diff([]FieldError{{Path: "host", Message: "is required"}}, schemaErr.Errors); diff != "" {
+		t.Errorf("unexpected errors (-want +got):\n%s", diff)
+	}
+}
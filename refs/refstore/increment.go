@@ -2,12 +2,51 @@ package refstore
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
 )
 
+// incrementAttempts is the number of times IncrementPath will try to reserve
+// a ref before giving up due to concurrent writers.
+const incrementAttempts = 100
+
+// IncrementPath returns the next numbered ref under a prefix. For example, if
+// the refs "prefix1" and "prefix2" exist, "prefix3" will be returned.
+//
+// If the store supports conditional writes, the returned ref is reserved by
+// creating it with a placeholder, which the caller is expected to overwrite.
+// This guarantees that concurrent callers receive different refs. Callers that
+// fail before overwriting the placeholder should remove it with
+// CancelReservation.
 func IncrementPath(ctx context.Context, store Store, pathPrefix string) (string, error) {
+	for attempt := 0; attempt < incrementAttempts; attempt++ {
+		next, err := nextPath(ctx, store, pathPrefix)
+		if err != nil {
+			return "", err
+		}
+
+		placeholder, err := newReservation()
+		if err != nil {
+			return "", err
+		}
+
+		err = SetIfAbsent(ctx, store, next, placeholder)
+		if err == nil || errors.Is(err, ErrConditionalWritesUnsupported) {
+			return next, nil
+		}
+		if !errors.Is(err, ErrConflict) {
+			return "", fmt.Errorf("failed to reserve %s: %w", next, err)
+		}
+	}
+
+	return "", fmt.Errorf("failed to reserve a ref with prefix %s after %d attempts: %w", pathPrefix, incrementAttempts, ErrConflict)
+}
+
+func nextPath(ctx context.Context, store Store, pathPrefix string) (string, error) {
 	matches, err := store.Match(ctx, fmt.Sprintf("%s*", pathPrefix))
 	if err != nil {
 		return "", fmt.Errorf("failed to match prefix: %w", err)
@@ -32,3 +71,48 @@ func IncrementPath(ctx context.Context, store Store, pathPrefix string) (string,
 
 	return fmt.Sprintf("%s%d", pathPrefix, maxVersion+1), nil
 }
+
+// reservation is the placeholder value written by IncrementPath.
+// Each reservation is unique so that identical writes from separate callers
+// cannot be mistaken for one another, as could happen with Git commits
+// created with the same parent, content and timestamp.
+type reservation struct {
+	Reservation string `json:"reservation"`
+}
+
+func newReservation() (reservation, error) {
+	var token [8]byte
+	if _, err := rand.Read(token[:]); err != nil {
+		return reservation{}, fmt.Errorf("failed to generate reservation token: %w", err)
+	}
+	return reservation{Reservation: hex.EncodeToString(token[:])}, nil
+}
+
+// CancelReservation removes a ref reserved by IncrementPath, if it still holds
+// the placeholder. Refs that have since been written to are left in place.
+func CancelReservation(ctx context.Context, store Store, ref string) error {
+	var value any
+	if err := store.Get(ctx, ref, &value); err != nil {
+		if errors.Is(err, ErrRefNotFound) {
+			return nil
+		}
+		return fmt.Errorf("failed to get %s: %w", ref, err)
+	}
+	if !isReservation(value) {
+		return nil
+	}
+	if err := store.Delete(ctx, ref); err != nil && !errors.Is(err, ErrRefNotFound) {
+		return fmt.Errorf("failed to delete reservation %s: %w", ref, err)
+	}
+	return nil
+}
+
+// isReservation reports whether a decoded value is a reservation placeholder.
+func isReservation(value any) bool {
+	m, ok := value.(map[string]any)
+	if !ok || len(m) != 1 {
+		return false
+	}
+	token, ok := m["reservation"].(string)
+	return ok && token != ""
+}
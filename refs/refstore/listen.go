@@ -2,6 +2,7 @@ package refstore
 
 import (
 	"context"
+	"time"
 
 	"github.com/gobwas/glob"
 )
@@ -23,6 +24,11 @@ func ListenToStateChanges(f func(ctx context.Context, ref string), store Store,
 }
 
 var _ Store = &stateListener{}
+var _ ConditionalStore = &stateListener{}
+var _ RollbackStore = &stateListener{}
+var _ HistoryStore = &stateListener{}
+var _ WatchableStore = &stateListener{}
+var _ MetadataStore = &stateListener{}
 
 type stateListener struct {
 	store    Store
@@ -136,6 +142,56 @@ func (s *stateListener) Set(ctx context.Context, ref string, v any) error {
 	return nil
 }
 
+// GetWithRevision implements ConditionalStore.
+func (s *stateListener) GetWithRevision(ctx context.Context, ref string, v any) (string, error) {
+	return GetWithRevision(ctx, s.store, ref, v)
+}
+
+// SetIfAbsent implements ConditionalStore.
+func (s *stateListener) SetIfAbsent(ctx context.Context, ref string, v any) error {
+	err := SetIfAbsent(ctx, s.store, ref, v)
+	if err != nil {
+		return err
+	}
+	s.updateIfMatches(ctx, ref, s.inTransaction)
+	return nil
+}
+
+// SetIfRevision implements ConditionalStore.
+func (s *stateListener) SetIfRevision(ctx context.Context, ref string, v any, revision string) error {
+	err := SetIfRevision(ctx, s.store, ref, v, revision)
+	if err != nil {
+		return err
+	}
+	s.updateIfMatches(ctx, ref, s.inTransaction)
+	return nil
+}
+
+// History implements HistoryStore.
+func (s *stateListener) History(ctx context.Context, ref string) ([]HistoryEntry, error) {
+	return History(ctx, s.store, ref)
+}
+
+// RevisionAt implements HistoryStore.
+func (s *stateListener) RevisionAt(ctx context.Context, t time.Time) (string, error) {
+	return RevisionAt(ctx, s.store, t)
+}
+
+// GetAt implements HistoryStore.
+func (s *stateListener) GetAt(ctx context.Context, ref string, revision string, v any) error {
+	return GetAt(ctx, s.store, ref, revision, v)
+}
+
+// GetMetadata implements MetadataStore.
+func (s *stateListener) GetMetadata(ctx context.Context, ref string) (ObjectMetadata, error) {
+	return GetMetadata(ctx, s.store, ref)
+}
+
+// Watch implements WatchableStore.
+func (s *stateListener) Watch(ctx context.Context, globs ...string) (<-chan ChangeEvent, error) {
+	return Watch(ctx, s.store, globs...)
+}
+
 // StartTransaction implements RefStore.
 func (s *stateListener) StartTransaction(ctx context.Context, message string) error {
 	s.inTransaction = true
@@ -151,3 +207,11 @@ func (s *stateListener) CommitTransaction(ctx context.Context) error {
 	s.transactionRefs = nil
 	return s.store.CommitTransaction(ctx)
 }
+
+// RollbackTransaction implements RollbackStore.
+// Listeners are not notified of changes that were rolled back.
+func (s *stateListener) RollbackTransaction(ctx context.Context) error {
+	s.inTransaction = false
+	s.transactionRefs = nil
+	return RollbackTransaction(ctx, s.store)
+}
@@ -0,0 +1,76 @@
+package refstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrMetadataUnsupported is returned when metadata is requested from a store
+// that does not implement MetadataStore.
+var ErrMetadataUnsupported = errors.New("store does not record metadata")
+
+// MetadataStore is implemented by stores that record metadata alongside the
+// value of each ref.
+type MetadataStore interface {
+	Store
+
+	// GetMetadata returns the metadata recorded for a ref, following links.
+	// ErrRefNotFound is returned if the ref does not exist.
+	GetMetadata(ctx context.Context, ref string) (ObjectMetadata, error)
+}
+
+// ObjectMetadata describes how the value of a ref was written.
+type ObjectMetadata struct {
+	BodyType    string   `json:"body_type,omitempty"`
+	CreateStack []string `json:"create_stack,omitempty"`
+	SetStack    []string `json:"set_stack,omitempty"`
+	// CreateAudit records who created the ref.
+	CreateAudit *Audit `json:"create_audit,omitempty"`
+	// SetAudit records who last changed the value of the ref.
+	SetAudit *Audit `json:"set_audit,omitempty"`
+}
+
+// IsEmpty returns true if no metadata was recorded.
+func (m ObjectMetadata) IsEmpty() bool {
+	return m.BodyType == "" && len(m.CreateStack) == 0 && len(m.SetStack) == 0 && m.CreateAudit == nil && m.SetAudit == nil
+}
+
+// GetMetadata returns the metadata for a ref in a store that records it.
+func GetMetadata(ctx context.Context, store Store, ref string) (ObjectMetadata, error) {
+	ms, ok := store.(MetadataStore)
+	if !ok {
+		return ObjectMetadata{}, ErrMetadataUnsupported
+	}
+	return ms.GetMetadata(ctx, ref)
+}
+
+func metadataOf(storageObject StorageObject) ObjectMetadata {
+	return ObjectMetadata{
+		BodyType:    storageObject.BodyType,
+		CreateStack: storageObject.CreateStack,
+		SetStack:    storageObject.SetStack,
+		CreateAudit: storageObject.CreateAudit,
+		SetAudit:    storageObject.SetAudit,
+	}
+}
+
+// encodedValue is a value that has already been encoded as JSON by a wrapping
+// store, which keeps the name of the value's original type for metadata.
+type encodedValue struct {
+	content  json.RawMessage
+	typeName string
+}
+
+func (v encodedValue) MarshalJSON() ([]byte, error) {
+	return v.content, nil
+}
+
+// bodyType returns the type name recorded in the metadata of a value.
+func bodyType(v any) string {
+	if encoded, ok := v.(encodedValue); ok {
+		return encoded.typeName
+	}
+	return fmt.Sprintf("%T", v)
+}
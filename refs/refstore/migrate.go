@@ -0,0 +1,248 @@
+package refstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// ErrDestinationNotEmpty is returned when migrating into a store that already
+// contains refs, unless overwriting is enabled.
+var ErrDestinationNotEmpty = errors.New("destination store is not empty")
+
+type MigrateOptions struct {
+	// DryRun reads the source store and reports what would be copied without
+	// writing to the destination.
+	DryRun bool
+	// Overwrite allows migrating into a store that already contains refs.
+	// Refs in the destination that are also in the source are replaced.
+	Overwrite bool
+}
+
+// StoreContents describes everything held in a store.
+type StoreContents struct {
+	// Values maps each ref that is not a link to its value.
+	Values map[string]json.RawMessage
+	// Links maps each link to its immediate target.
+	Links map[string]string
+	// Dependencies maps each ref to the refs it depends on.
+	Dependencies map[string][]string
+}
+
+// DependencyCount returns the number of dependency edges.
+func (c *StoreContents) DependencyCount() int {
+	var count int
+	for _, deps := range c.Dependencies {
+		count += len(deps)
+	}
+	return count
+}
+
+// ReadContents reads every ref, link and dependency edge in a store.
+func ReadContents(ctx context.Context, store Store) (*StoreContents, error) {
+	return ReadContentsMatching(ctx, store, "**")
+}
+
+// ReadContentsMatching reads the refs matching any of the provided globs,
+// along with links and dependency edges involving them.
+// Matching refs that are links to refs outside the globs are included as
+// links to the ref they resolve to.
+func ReadContentsMatching(ctx context.Context, store Store, glob ...string) (*StoreContents, error) {
+	contents := &StoreContents{
+		Values:       make(map[string]json.RawMessage),
+		Links:        make(map[string]string),
+		Dependencies: make(map[string][]string),
+	}
+
+	allRefs, err := store.Match(ctx, glob...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list refs: %w", err)
+	}
+
+	// Dependency edges are found from both ends, so an edge is included if
+	// either ref exists
+	edges := make(map[string]map[string]struct{})
+	addEdge := func(ref, dependency string) {
+		if edges[ref] == nil {
+			edges[ref] = make(map[string]struct{})
+		}
+		edges[ref][dependency] = struct{}{}
+	}
+
+	for _, ref := range allRefs {
+		links, err := store.GetLinks(ctx, ref)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get links to %s: %w", ref, err)
+		}
+		for _, link := range links {
+			contents.Links[link] = ref
+		}
+
+		deps, err := store.GetDependencies(ctx, ref)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get dependencies of %s: %w", ref, err)
+		}
+		for _, dep := range deps {
+			addEdge(ref, dep)
+		}
+
+		dependants, err := store.GetDependants(ctx, ref)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get dependants of %s: %w", ref, err)
+		}
+		for _, dependant := range dependants {
+			addEdge(dependant, ref)
+		}
+	}
+	for ref, deps := range edges {
+		contents.Dependencies[ref] = sortedKeys(deps)
+	}
+
+	for _, ref := range allRefs {
+		if _, isLink := contents.Links[ref]; isLink {
+			continue
+		}
+		target, err := store.ResolveLink(ctx, ref)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve %s: %w", ref, err)
+		}
+		if target != ref {
+			contents.Links[ref] = target
+			continue
+		}
+
+		var value json.RawMessage
+		if err := store.Get(ctx, ref, &value); err != nil {
+			return nil, fmt.Errorf("failed to get %s: %w", ref, err)
+		}
+		contents.Values[ref] = value
+	}
+
+	return contents, nil
+}
+
+// Migrate copies every ref, link and dependency edge from one store to
+// another in a single transaction, returning the contents of the source.
+// Links are created after the refs they point to.
+func Migrate(ctx context.Context, from Store, to Store, options MigrateOptions) (*StoreContents, error) {
+	contents, err := ReadContents(ctx, from)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read source store: %w", err)
+	}
+
+	if !options.Overwrite {
+		existing, err := to.Match(ctx, "**")
+		if err != nil {
+			return nil, fmt.Errorf("failed to list refs in destination: %w", err)
+		}
+		if len(existing) > 0 {
+			return nil, fmt.Errorf("%w: found %d refs", ErrDestinationNotEmpty, len(existing))
+		}
+	}
+
+	if options.DryRun {
+		return contents, nil
+	}
+
+	if err := to.StartTransaction(ctx, "migrate state"); err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+
+	for _, ref := range sortedKeys(contents.Values) {
+		if err := to.Set(ctx, ref, contents.Values[ref]); err != nil {
+			return nil, fmt.Errorf("failed to set %s: %w", ref, err)
+		}
+	}
+
+	for _, link := range sortedKeys(contents.Links) {
+		target := contents.Links[link]
+		if err := to.Link(ctx, link, target); err != nil {
+			return nil, fmt.Errorf("failed to link %s to %s: %w", link, target, err)
+		}
+	}
+
+	for _, ref := range sortedKeys(contents.Dependencies) {
+		for _, dep := range contents.Dependencies[ref] {
+			if err := to.AddDependency(ctx, ref, dep); err != nil {
+				return nil, fmt.Errorf("failed to add dependency of %s on %s: %w", ref, dep, err)
+			}
+		}
+	}
+
+	if err := to.CommitTransaction(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return contents, nil
+}
+
+// VerifyMigration compares the contents of two stores, returning a
+// description of each ref, link or dependency in the source that is missing
+// or different in the destination.
+// Additional content in the destination is not reported.
+func VerifyMigration(ctx context.Context, from Store, to Store) ([]string, error) {
+	source, err := ReadContents(ctx, from)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read source store: %w", err)
+	}
+	dest, err := ReadContents(ctx, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read destination store: %w", err)
+	}
+
+	var problems []string
+	for _, ref := range sortedKeys(source.Values) {
+		destValue, ok := dest.Values[ref]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("ref %s is missing", ref))
+			continue
+		}
+		equal, err := jsonEqual(source.Values[ref], destValue)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compare %s: %w", ref, err)
+		}
+		if !equal {
+			problems = append(problems, fmt.Sprintf("ref %s has a different value", ref))
+		}
+	}
+	for _, link := range sortedKeys(source.Links) {
+		if target := dest.Links[link]; target != source.Links[link] {
+			problems = append(problems, fmt.Sprintf("link %s should point to %s", link, source.Links[link]))
+		}
+	}
+	for _, ref := range sortedKeys(source.Dependencies) {
+		destDeps := make(map[string]bool)
+		for _, dep := range dest.Dependencies[ref] {
+			destDeps[dep] = true
+		}
+		for _, dep := range source.Dependencies[ref] {
+			if !destDeps[dep] {
+				problems = append(problems, fmt.Sprintf("dependency of %s on %s is missing", ref, dep))
+			}
+		}
+	}
+	return problems, nil
+}
+
+// jsonEqual compares two JSON documents, ignoring formatting.
+func jsonEqual(a, b json.RawMessage) (bool, error) {
+	var av, bv any
+	if err := json.Unmarshal(a, &av); err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(b, &bv); err != nil {
+		return false, err
+	}
+	return reflect.DeepEqual(av, bv), nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,140 @@
+package refstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	tempDir := t.TempDir()
+
+	from, err := NewFSRefStore(filepath.Join(tempDir, "fs"), map[string]struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, err := NewSQLiteRefStore(filepath.Join(tempDir, "state.db"), map[string]struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = to.Close()
+	})
+
+	release := "github.com/example/repo.git/path/to/package/@r1"
+	deploy := release + "/deploy/staging"
+	if err := from.Set(ctx, release, map[string]any{"commit": "abc"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := from.Set(ctx, deploy, map[string]any{"outputs": map[string]any{"count": 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := from.Link(ctx, "github.com/example/repo.git/path/to/package/@", release); err != nil {
+		t.Fatal(err)
+	}
+	if err := from.Link(ctx, "github.com/example/repo.git/path/to/package/@/deploy/staging", deploy); err != nil {
+		t.Fatal(err)
+	}
+	if err := from.AddDependency(ctx, deploy, release); err != nil {
+		t.Fatal(err)
+	}
+
+	contents, err := Migrate(ctx, from, to, MigrateOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("dry run failed: %v", err)
+	}
+	if len(contents.Values) != 2 || len(contents.Links) != 2 || contents.DependencyCount() != 1 {
+		t.Errorf("unexpected contents: %+v", contents)
+	}
+	if existing, _ := to.Match(ctx, "**"); len(existing) != 0 {
+		t.Fatalf("dry run wrote to destination: %v", existing)
+	}
+
+	if _, err := Migrate(ctx, from, to, MigrateOptions{}); err != nil {
+		t.Fatalf("migration failed: %v", err)
+	}
+
+	problems, err := VerifyMigration(ctx, from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) > 0 {
+		t.Errorf("unexpected differences after migration: %v", problems)
+	}
+
+	var got map[string]any
+	if err := to.Get(ctx, "github.com/example/repo.git/path/to/package/@", &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["commit"] != "abc" {
+		t.Errorf("unexpected value through link: %v", got)
+	}
+
+	// The destination now has content
+	if _, err := Migrate(ctx, from, to, MigrateOptions{}); !errors.Is(err, ErrDestinationNotEmpty) {
+		t.Errorf("expected ErrDestinationNotEmpty, got %v", err)
+	}
+	if _, err := Migrate(ctx, from, to, MigrateOptions{Overwrite: true}); err != nil {
+		t.Errorf("failed to migrate with overwrite: %v", err)
+	}
+
+	// Differences are reported
+	if err := to.Set(ctx, deploy, "changed"); err != nil {
+		t.Fatal(err)
+	}
+	if err := to.RemoveDependency(ctx, deploy, release); err != nil {
+		t.Fatal(err)
+	}
+	problems, err = VerifyMigration(ctx, from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 2 {
+		t.Errorf("expected 2 differences, got %v", problems)
+	}
+}
+
+func TestFSRefStoreUpgrade(t *testing.T) {
+	tempDir := t.TempDir()
+	tags := map[string]struct{}{"state": {}}
+
+	info, err := json.Marshal(StoreInfo{Version: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, storeInfoFile), info, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NewFSRefStore(tempDir, tags)
+	if err != nil {
+		t.Fatalf("failed to open version 2 store: %v", err)
+	}
+	if got := store.Info(); got.Version != stateVersion || len(got.Tags) != 1 {
+		t.Errorf("unexpected store info after upgrade: %+v", got)
+	}
+
+	// The upgrade is persisted
+	store, err = NewFSRefStore(tempDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := store.Info(); got.Version != stateVersion || len(got.Tags) != 1 {
+		t.Errorf("unexpected store info after reopening: %+v", got)
+	}
+
+	info, err = json.Marshal(StoreInfo{Version: stateVersion + 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, storeInfoFile), info, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFSRefStore(tempDir, tags); err == nil {
+		t.Error("expected an error opening a store from a newer version")
+	}
+}
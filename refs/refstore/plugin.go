@@ -0,0 +1,462 @@
+package refstore
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"sync"
+
+	"github.com/charmbracelet/log"
+	"github.com/ocuroot/ocuroot/refs"
+)
+
+// Store plugins are external programs that implement a store, so state can be
+// kept in services Ocuroot does not support natively.
+//
+// A plugin is started as a subprocess and speaks JSON-RPC 2.0 over its stdin
+// and stdout, one message per line. Anything written to stderr is passed
+// through to Ocuroot's stderr. Requests are sent one at a time, each waiting
+// for its response. A plugin that does not respond before the request's
+// context is done, or that sends a malformed response, is stopped and the
+// store can no longer be used.
+//
+// The first request is always "initialize", and the last is "close", after
+// which stdin is closed and the plugin should exit. The methods mirror Store:
+//
+//	initialize           {"protocol_version": 1, "tags": ["state"], "path_prefix": "state"} -> StoreInfo
+//	start_transaction    {"message": "..."} -> null
+//	commit_transaction   {} -> null
+//	rollback_transaction {} -> null
+//	get                  {"ref": "..."} -> value
+//	set                  {"ref": "...", "value": value, "audit": Audit} -> null
+//	delete               {"ref": "...", "audit": Audit} -> null
+//	match                {"globs": ["..."], "no_links": false} -> ["ref", ...]
+//	link                 {"ref": "...", "target": "...", "audit": Audit} -> null
+//	unlink               {"ref": "..."} -> null
+//	get_links            {"ref": "..."} -> ["ref", ...]
+//	resolve_link         {"ref": "..."} -> "ref"
+//	add_dependency       {"ref": "...", "dependency": "..."} -> null
+//	remove_dependency    {"ref": "...", "dependency": "..."} -> null
+//	get_dependencies     {"ref": "..."} -> ["ref", ...]
+//	get_dependants       {"ref": "..."} -> ["ref", ...]
+//	close                {} -> null
+//
+// Refs sent to get never have a fragment, fragments are handled by Ocuroot.
+// Links must be followed by get, set and delete. When a ref does not exist,
+// get and delete return an error with code PluginErrorRefNotFound.
+//
+// Writes include an Audit describing who made the change, which plugins
+// should store alongside the ref where possible.
+//
+// The path prefix distinguishes state from intent when both are kept in the
+// same plugin store, in the same way as the filesystem store.
+//
+// Plugins written in Go can implement Store and call ServePlugin.
+
+// PluginProtocolVersion is the version of the store plugin protocol.
+const PluginProtocolVersion = 1
+
+// PluginErrorRefNotFound is the JSON-RPC error code for ErrRefNotFound.
+const PluginErrorRefNotFound = -32001
+
+// pluginErrorInternal is the JSON-RPC error code for any other store error.
+const pluginErrorInternal = -32000
+
+type PluginConfig struct {
+	// Command is the program to run and its arguments.
+	Command []string
+	// Dir is the working directory of the plugin.
+	Dir string
+	// PathPrefix is sent to the plugin when it is initialized.
+	PathPrefix string
+}
+
+type pluginRequest struct {
+	JSONRPC string `json:"jsonrpc"`
+	ID      int64  `json:"id"`
+	Method  string `json:"method"`
+	Params  any    `json:"params"`
+}
+
+type pluginResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      int64           `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *PluginError    `json:"error,omitempty"`
+}
+
+// PluginError is an error returned by a plugin.
+type PluginError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *PluginError) Error() string {
+	return fmt.Sprintf("plugin error %d: %s", e.Code, e.Message)
+}
+
+// PluginInitializeParams are the parameters of the initialize method.
+type PluginInitializeParams struct {
+	ProtocolVersion int      `json:"protocol_version"`
+	Tags            []string `json:"tags"`
+	PathPrefix      string   `json:"path_prefix,omitempty"`
+}
+
+type pluginRefParams struct {
+	Ref string `json:"ref"`
+	// Audit is only set for delete.
+	Audit *Audit `json:"audit,omitempty"`
+}
+
+type pluginSetParams struct {
+	Ref   string          `json:"ref"`
+	Value json.RawMessage `json:"value"`
+	Audit *Audit          `json:"audit,omitempty"`
+}
+
+type pluginMatchParams struct {
+	Globs   []string `json:"globs"`
+	NoLinks bool     `json:"no_links,omitempty"`
+}
+
+type pluginLinkParams struct {
+	Ref    string `json:"ref"`
+	Target string `json:"target"`
+	Audit  *Audit `json:"audit,omitempty"`
+}
+
+type pluginDependencyParams struct {
+	Ref        string `json:"ref"`
+	Dependency string `json:"dependency"`
+}
+
+type pluginTransactionParams struct {
+	Message string `json:"message"`
+}
+
+// NewPluginStore starts a plugin and returns a store that forwards each
+// operation to it.
+func NewPluginStore(tags map[string]struct{}, config PluginConfig) (*PluginStore, error) {
+	log.Info("Initializing PluginStore", "command", config.Command, "tags", tags)
+	if len(config.Command) == 0 {
+		return nil, fmt.Errorf("plugin command is empty")
+	}
+
+	cmd := exec.Command(config.Command[0], config.Command[1:]...)
+	cmd.Dir = config.Dir
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start plugin: %w", err)
+	}
+
+	p := &PluginStore{
+		cmd:    cmd,
+		stdin:  stdin,
+		stdout: bufio.NewReader(stdout),
+		lock:   make(chan struct{}, 1),
+	}
+
+	var tagList []string
+	for tag := range tags {
+		tagList = append(tagList, tag)
+	}
+	sort.Strings(tagList)
+
+	if err := p.call(context.Background(), "initialize", PluginInitializeParams{
+		ProtocolVersion: PluginProtocolVersion,
+		Tags:            tagList,
+		PathPrefix:      config.PathPrefix,
+	}, &p.info); err != nil {
+		_ = p.stop()
+		return nil, fmt.Errorf("failed to initialize plugin: %w", err)
+	}
+	return p, nil
+}
+
+var _ Store = (*PluginStore)(nil)
+var _ RollbackStore = (*PluginStore)(nil)
+
+type PluginStore struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+	info   StoreInfo
+
+	// lock is held while a request is in flight. It is a channel so that
+	// waiting for it can be cancelled.
+	lock   chan struct{}
+	nextID int64
+
+	mu     sync.Mutex
+	closed bool
+
+	waitOnce sync.Once
+	waitErr  error
+}
+
+type pluginResult struct {
+	response pluginResponse
+	err      error
+}
+
+// call sends a request to the plugin and decodes the result into v.
+//
+// If ctx is done before the plugin responds, or the plugin breaks the
+// protocol, the plugin is stopped since later responses could no longer be
+// matched to their requests.
+func (p *PluginStore) call(ctx context.Context, method string, params any, v any) error {
+	select {
+	case p.lock <- struct{}{}:
+	case <-ctx.Done():
+		return fmt.Errorf("failed to send %s to plugin: %w", method, ctx.Err())
+	}
+	defer func() { <-p.lock }()
+
+	if p.isClosed() {
+		return fmt.Errorf("plugin is closed")
+	}
+
+	p.nextID++
+	id := p.nextID
+	request, err := json.Marshal(pluginRequest{
+		JSONRPC: "2.0",
+		ID:      id,
+		Method:  method,
+		Params:  params,
+	})
+	if err != nil {
+		return err
+	}
+
+	done := make(chan pluginResult, 1)
+	go func() {
+		response, err := p.roundTrip(method, id, request)
+		done <- pluginResult{response: response, err: err}
+	}()
+
+	var response pluginResponse
+	select {
+	case result := <-done:
+		if result.err != nil {
+			p.fail()
+			return result.err
+		}
+		response = result.response
+	case <-ctx.Done():
+		p.fail()
+		return fmt.Errorf("no response to %s from plugin: %w", method, ctx.Err())
+	}
+
+	if response.Error != nil {
+		if response.Error.Code == PluginErrorRefNotFound {
+			return ErrRefNotFound
+		}
+		return response.Error
+	}
+	if v == nil || len(response.Result) == 0 {
+		return nil
+	}
+	return json.Unmarshal(response.Result, v)
+}
+
+// roundTrip writes a request to the plugin and reads its response.
+func (p *PluginStore) roundTrip(method string, id int64, request []byte) (pluginResponse, error) {
+	var response pluginResponse
+	if _, err := p.stdin.Write(append(request, '\n')); err != nil {
+		return response, fmt.Errorf("failed to send %s to plugin: %w", method, err)
+	}
+
+	line, err := p.stdout.ReadBytes('\n')
+	if err != nil {
+		return response, fmt.Errorf("failed to read response to %s from plugin: %w", method, err)
+	}
+	if err := json.Unmarshal(line, &response); err != nil {
+		return response, fmt.Errorf("failed to decode response to %s from plugin: %w", method, err)
+	}
+	if response.ID != id {
+		return response, fmt.Errorf("plugin responded to request %d, expected %d", response.ID, id)
+	}
+	return response, nil
+}
+
+func (p *PluginStore) isClosed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.closed
+}
+
+// fail closes the plugin after a request could not be completed, and kills
+// it in case it is hung.
+func (p *PluginStore) fail() {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+
+	log.Warn("Stopping store plugin after a failed request", "command", p.cmd.Args)
+	_ = p.cmd.Process.Kill()
+	go func() {
+		_ = p.wait()
+	}()
+}
+
+func (p *PluginStore) stop() error {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+
+	_ = p.stdin.Close()
+	return p.wait()
+}
+
+func (p *PluginStore) wait() error {
+	p.waitOnce.Do(func() {
+		p.waitErr = p.cmd.Wait()
+	})
+	return p.waitErr
+}
+
+// Info implements Store.
+func (p *PluginStore) Info() StoreInfo {
+	return p.info
+}
+
+// StartTransaction implements Store.
+func (p *PluginStore) StartTransaction(ctx context.Context, message string) error {
+	return p.call(ctx, "start_transaction", pluginTransactionParams{Message: message}, nil)
+}
+
+// CommitTransaction implements Store.
+func (p *PluginStore) CommitTransaction(ctx context.Context) error {
+	return p.call(ctx, "commit_transaction", struct{}{}, nil)
+}
+
+// RollbackTransaction implements RollbackStore.
+func (p *PluginStore) RollbackTransaction(ctx context.Context) error {
+	return p.call(ctx, "rollback_transaction", struct{}{}, nil)
+}
+
+// Get implements Store.
+// The whole value is requested from the plugin, and any fragment extracted
+// from it.
+func (p *PluginStore) Get(ctx context.Context, ref string, v any) error {
+	parsedRef, err := refs.Parse(ref)
+	if err != nil {
+		return fmt.Errorf("failed to parse ref: %w", err)
+	}
+
+	var body json.RawMessage
+	if err := p.call(ctx, "get", pluginRefParams{Ref: parsedRef.SetFragment("").String()}, &body); err != nil {
+		return err
+	}
+	return unmarshalFragment(body, parsedRef.Fragment, v)
+}
+
+// Set implements Store.
+func (p *PluginStore) Set(ctx context.Context, ref string, v any) error {
+	parsedRef, err := refs.Parse(ref)
+	if err != nil {
+		return fmt.Errorf("failed to parse ref: %w", err)
+	}
+	if parsedRef.Fragment != "" {
+		return fmt.Errorf("setting by fragment not supported")
+	}
+
+	value, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return p.call(ctx, "set", pluginSetParams{Ref: ref, Value: value, Audit: auditFromContext(ctx)}, nil)
+}
+
+// Delete implements Store.
+func (p *PluginStore) Delete(ctx context.Context, ref string) error {
+	return p.call(ctx, "delete", pluginRefParams{Ref: ref, Audit: auditFromContext(ctx)}, nil)
+}
+
+// Match implements Store.
+func (p *PluginStore) Match(ctx context.Context, glob ...string) ([]string, error) {
+	return p.MatchOptions(ctx, MatchOptions{}, glob...)
+}
+
+// MatchOptions implements Store.
+func (p *PluginStore) MatchOptions(ctx context.Context, options MatchOptions, glob ...string) ([]string, error) {
+	var out []string
+	err := p.call(ctx, "match", pluginMatchParams{Globs: glob, NoLinks: options.NoLinks}, &out)
+	return out, err
+}
+
+// Link implements Store.
+func (p *PluginStore) Link(ctx context.Context, ref string, target string) error {
+	return p.call(ctx, "link", pluginLinkParams{Ref: ref, Target: target, Audit: auditFromContext(ctx)}, nil)
+}
+
+// Unlink implements Store.
+func (p *PluginStore) Unlink(ctx context.Context, ref string) error {
+	return p.call(ctx, "unlink", pluginRefParams{Ref: ref}, nil)
+}
+
+// GetLinks implements Store.
+func (p *PluginStore) GetLinks(ctx context.Context, ref string) ([]string, error) {
+	var out []string
+	err := p.call(ctx, "get_links", pluginRefParams{Ref: ref}, &out)
+	return out, err
+}
+
+// ResolveLink implements Store.
+func (p *PluginStore) ResolveLink(ctx context.Context, ref string) (string, error) {
+	var out string
+	err := p.call(ctx, "resolve_link", pluginRefParams{Ref: ref}, &out)
+	return out, err
+}
+
+// AddDependency implements Store.
+func (p *PluginStore) AddDependency(ctx context.Context, ref string, dependency string) error {
+	return p.call(ctx, "add_dependency", pluginDependencyParams{Ref: ref, Dependency: dependency}, nil)
+}
+
+// RemoveDependency implements Store.
+func (p *PluginStore) RemoveDependency(ctx context.Context, ref string, dependency string) error {
+	return p.call(ctx, "remove_dependency", pluginDependencyParams{Ref: ref, Dependency: dependency}, nil)
+}
+
+// GetDependencies implements Store.
+func (p *PluginStore) GetDependencies(ctx context.Context, ref string) ([]string, error) {
+	var out []string
+	err := p.call(ctx, "get_dependencies", pluginRefParams{Ref: ref}, &out)
+	return out, err
+}
+
+// GetDependants implements Store.
+func (p *PluginStore) GetDependants(ctx context.Context, ref string) ([]string, error) {
+	var out []string
+	err := p.call(ctx, "get_dependants", pluginRefParams{Ref: ref}, &out)
+	return out, err
+}
+
+// Close implements Store.
+// The plugin is asked to close, then its stdin is closed and it is waited
+// for.
+func (p *PluginStore) Close() error {
+	if p.isClosed() {
+		return nil
+	}
+
+	callErr := p.call(context.Background(), "close", struct{}{}, nil)
+	if err := p.stop(); err != nil {
+		return fmt.Errorf("plugin exited with an error: %w", err)
+	}
+	return callErr
+}
@@ -0,0 +1,166 @@
+package refstore
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestPluginConformance runs the store tests against a store plugin.
+//
+// The reference plugin is built and tested by default. Plugin authors can
+// test their own plugin by setting OCUROOT_TEST_STORE_PLUGIN to the command
+// that starts it, which is run in a temporary directory:
+//
+//	OCUROOT_TEST_STORE_PLUGIN="my-plugin --flag" go test ./refs/refstore -run TestPluginConformance
+func TestPluginConformance(t *testing.T) {
+	tempDir := t.TempDir()
+
+	command := strings.Fields(os.Getenv("OCUROOT_TEST_STORE_PLUGIN"))
+	if len(command) == 0 {
+		command = []string{buildReferencePlugin(t), filepath.Join(tempDir, "store")}
+	}
+
+	store, err := NewPluginStore(map[string]struct{}{"state": {}}, PluginConfig{
+		Command:    command,
+		Dir:        tempDir,
+		PathPrefix: "state",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := store.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	if _, ok := store.Info().Tags["state"]; !ok {
+		t.Errorf("expected store to be tagged as state, got %v", store.Info().Tags)
+	}
+
+	DoTestStore(t, store)
+
+	t.Run("not found", func(t *testing.T) {
+		var v any
+		err := store.Get(context.Background(), "github.com/example/repo.git/-/missing/@/custom/value", &v)
+		if !errors.Is(err, ErrRefNotFound) {
+			t.Errorf("expected ErrRefNotFound, got %v", err)
+		}
+	})
+
+	t.Run("transaction", func(t *testing.T) {
+		ctx := context.Background()
+		ref := "github.com/example/repo.git/-/package/@r1/custom/transaction"
+		if err := store.StartTransaction(ctx, "test"); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Set(ctx, ref, "value"); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.CommitTransaction(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		var got string
+		if err := store.Get(ctx, ref, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got != "value" {
+			t.Errorf("unexpected value after transaction: %q", got)
+		}
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		ctx := context.Background()
+		ref := "github.com/example/repo.git/-/package/@r1/custom/rollback"
+		if err := store.StartTransaction(ctx, "test"); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Set(ctx, ref, "value"); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.RollbackTransaction(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		var got string
+		if err := store.Get(ctx, ref, &got); !errors.Is(err, ErrRefNotFound) {
+			t.Errorf("expected ErrRefNotFound after rollback, got %v", err)
+		}
+	})
+}
+
+// buildReferencePlugin builds ocuroot-store-fs-plugin, returning the path to
+// the binary.
+func buildReferencePlugin(t *testing.T) string {
+	t.Helper()
+
+	binary := filepath.Join(t.TempDir(), "ocuroot-store-fs-plugin")
+	cmd := exec.Command("go", "build", "-o", binary, "../../cmd/ocuroot-store-fs-plugin")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("failed to build reference plugin: %v\n%s", err, out)
+	}
+	return binary
+}
+
+// scriptPlugin starts a plugin from a shell script that has already responded
+// to initialize.
+func scriptPlugin(t *testing.T, script string) *PluginStore {
+	t.Helper()
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+
+	initialize := `read line; echo '{"jsonrpc":"2.0","id":1,"result":{}}'; `
+	store, err := NewPluginStore(map[string]struct{}{"state": {}}, PluginConfig{
+		Command: []string{"sh", "-c", initialize + script},
+		Dir:     t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = store.Close()
+	})
+	return store
+}
+
+func TestPluginTimeout(t *testing.T) {
+	store := scriptPlugin(t, "exec sleep 60")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	var v any
+	err := store.Get(ctx, "github.com/example/repo.git/-/package/@r1/custom/value", &v)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline to be exceeded, got %v", err)
+	}
+
+	// The hung plugin is not waited on by later requests
+	err = store.Get(context.Background(), "github.com/example/repo.git/-/package/@r1/custom/value", &v)
+	if err == nil || !strings.Contains(err.Error(), "plugin is closed") {
+		t.Errorf("expected plugin to be closed, got %v", err)
+	}
+}
+
+func TestPluginProtocolError(t *testing.T) {
+	store := scriptPlugin(t, `while read line; do echo '{"jsonrpc":"2.0","id":99,"result":"value"}'; done`)
+
+	ctx := context.Background()
+	var v any
+	err := store.Get(ctx, "github.com/example/repo.git/-/package/@r1/custom/value", &v)
+	if err == nil || !strings.Contains(err.Error(), "expected 2") {
+		t.Fatalf("expected an ID mismatch, got %v", err)
+	}
+
+	err = store.Get(ctx, "github.com/example/repo.git/-/package/@r1/custom/value", &v)
+	if err == nil || !strings.Contains(err.Error(), "plugin is closed") {
+		t.Errorf("expected plugin to be closed, got %v", err)
+	}
+}
@@ -0,0 +1,203 @@
+package refstore
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// PluginOpener creates the store served by a plugin when it is initialized.
+type PluginOpener func(ctx context.Context, params PluginInitializeParams) (Store, error)
+
+// ServePlugin serves the store plugin protocol on r and w, usually stdin and
+// stdout, until the close method is called or r is closed.
+func ServePlugin(ctx context.Context, r io.Reader, w io.Writer, open PluginOpener) error {
+	var store Store
+	defer func() {
+		if store != nil {
+			_ = store.Close()
+		}
+	}()
+
+	scanner := bufio.NewScanner(r)
+	// Values may be much larger than the default limit of a line
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<30)
+	encoder := json.NewEncoder(w)
+
+	for scanner.Scan() {
+		var request struct {
+			ID     int64           `json:"id"`
+			Method string          `json:"method"`
+			Params json.RawMessage `json:"params"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &request); err != nil {
+			return fmt.Errorf("failed to decode request: %w", err)
+		}
+
+		var (
+			result any
+			err    error
+		)
+		switch {
+		case request.Method == "initialize":
+			var params PluginInitializeParams
+			if err = json.Unmarshal(request.Params, &params); err != nil {
+				break
+			}
+			if params.ProtocolVersion > PluginProtocolVersion {
+				err = fmt.Errorf("unsupported protocol version %d", params.ProtocolVersion)
+				break
+			}
+			store, err = open(ctx, params)
+			if err == nil {
+				result = store.Info()
+			}
+		case store == nil:
+			err = fmt.Errorf("plugin has not been initialized")
+		case request.Method == "close":
+			err = store.Close()
+			store = nil
+		default:
+			result, err = servePluginMethod(ctx, store, request.Method, request.Params)
+		}
+
+		response := pluginResponse{
+			JSONRPC: "2.0",
+			ID:      request.ID,
+		}
+		if err != nil {
+			response.Error = &PluginError{Code: pluginErrorInternal, Message: err.Error()}
+			if errors.Is(err, ErrRefNotFound) {
+				response.Error.Code = PluginErrorRefNotFound
+			}
+		} else {
+			response.Result, err = json.Marshal(result)
+			if err != nil {
+				return fmt.Errorf("failed to encode result of %s: %w", request.Method, err)
+			}
+		}
+		if err := encoder.Encode(response); err != nil {
+			return fmt.Errorf("failed to write response: %w", err)
+		}
+
+		if request.Method == "close" {
+			return nil
+		}
+	}
+	return scanner.Err()
+}
+
+// servePluginMethod calls the store method for a request.
+func servePluginMethod(ctx context.Context, store Store, method string, rawParams json.RawMessage) (any, error) {
+	decode := func(params any) error {
+		if err := json.Unmarshal(rawParams, params); err != nil {
+			return fmt.Errorf("invalid params for %s: %w", method, err)
+		}
+		return nil
+	}
+
+	switch method {
+	case "start_transaction":
+		var params pluginTransactionParams
+		if err := decode(&params); err != nil {
+			return nil, err
+		}
+		return nil, store.StartTransaction(ctx, params.Message)
+	case "commit_transaction":
+		return nil, store.CommitTransaction(ctx)
+	case "rollback_transaction":
+		return nil, RollbackTransaction(ctx, store)
+	case "get":
+		var params pluginRefParams
+		if err := decode(&params); err != nil {
+			return nil, err
+		}
+		var value json.RawMessage
+		if err := store.Get(ctx, params.Ref, &value); err != nil {
+			return nil, err
+		}
+		return value, nil
+	case "set":
+		var params pluginSetParams
+		if err := decode(&params); err != nil {
+			return nil, err
+		}
+		return nil, store.Set(ContextWithAudit(ctx, params.Audit), params.Ref, params.Value)
+	case "delete":
+		var params pluginRefParams
+		if err := decode(&params); err != nil {
+			return nil, err
+		}
+		return nil, store.Delete(ContextWithAudit(ctx, params.Audit), params.Ref)
+	case "match":
+		var params pluginMatchParams
+		if err := decode(&params); err != nil {
+			return nil, err
+		}
+		matches, err := store.MatchOptions(ctx, MatchOptions{NoLinks: params.NoLinks}, params.Globs...)
+		return emptyIfNil(matches), err
+	case "link":
+		var params pluginLinkParams
+		if err := decode(&params); err != nil {
+			return nil, err
+		}
+		return nil, store.Link(ContextWithAudit(ctx, params.Audit), params.Ref, params.Target)
+	case "unlink":
+		var params pluginRefParams
+		if err := decode(&params); err != nil {
+			return nil, err
+		}
+		return nil, store.Unlink(ctx, params.Ref)
+	case "get_links":
+		var params pluginRefParams
+		if err := decode(&params); err != nil {
+			return nil, err
+		}
+		links, err := store.GetLinks(ctx, params.Ref)
+		return emptyIfNil(links), err
+	case "resolve_link":
+		var params pluginRefParams
+		if err := decode(&params); err != nil {
+			return nil, err
+		}
+		return store.ResolveLink(ctx, params.Ref)
+	case "add_dependency":
+		var params pluginDependencyParams
+		if err := decode(&params); err != nil {
+			return nil, err
+		}
+		return nil, store.AddDependency(ctx, params.Ref, params.Dependency)
+	case "remove_dependency":
+		var params pluginDependencyParams
+		if err := decode(&params); err != nil {
+			return nil, err
+		}
+		return nil, store.RemoveDependency(ctx, params.Ref, params.Dependency)
+	case "get_dependencies":
+		var params pluginRefParams
+		if err := decode(&params); err != nil {
+			return nil, err
+		}
+		deps, err := store.GetDependencies(ctx, params.Ref)
+		return emptyIfNil(deps), err
+	case "get_dependants":
+		var params pluginRefParams
+		if err := decode(&params); err != nil {
+			return nil, err
+		}
+		dependants, err := store.GetDependants(ctx, params.Ref)
+		return emptyIfNil(dependants), err
+	default:
+		return nil, fmt.Errorf("unknown method %s", method)
+	}
+}
+
+func emptyIfNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
@@ -0,0 +1,477 @@
+package refstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	libglob "github.com/gobwas/glob"
+)
+
+// Query selects refs by their path and the content of their values. Queries
+// are written as a glob, optionally followed by a where clause filtering on
+// fields of each value and a select clause listing fields to return:
+//
+//	**/@/deploy/* where release ~ "*/@r1*" select $ref, release
+//	**/@*/task/build where output/image contains "sha256:abc" select output/image
+//	**/@* where commit ~ "a1b2*" and not ($ref ~ "*/@v1.*" or package = null)
+//
+// Fields are paths within a value, in the same form as a ref fragment. $ref is
+// the ref itself and $value the whole value. Comparisons are =, !=, <, <=, >
+// and >=, ~ matching a string against a glob, and contains, which matches a
+// substring or an element of a list. A field on its own matches when it is
+// set to anything other than null or false. Comparisons may be combined with
+// and, or, not and parentheses.
+//
+// Values are written as JSON strings, numbers, true, false or null. Any other
+// word is treated as a string.
+type Query struct {
+	Glob   string
+	Select []string
+
+	where queryExpr
+}
+
+// QueryResult is a ref matching a query.
+type QueryResult struct {
+	Ref   string
+	Value any
+	// Fields holds the value of each selected field, nil where it is not set.
+	Fields []any
+}
+
+// ParseQuery parses a query.
+func ParseQuery(query string) (*Query, error) {
+	glob, rest, err := splitQueryGlob(query)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := libglob.Compile(glob, '/'); err != nil {
+		return nil, fmt.Errorf("invalid glob %q: %w", glob, err)
+	}
+
+	tokens, err := lexQuery(rest)
+	if err != nil {
+		return nil, err
+	}
+	p := &queryParser{tokens: tokens}
+	q := &Query{Glob: glob}
+
+	if p.keyword("where") {
+		if q.where, err = p.parseOr(); err != nil {
+			return nil, err
+		}
+	}
+	if p.keyword("select") {
+		for {
+			field, err := p.field()
+			if err != nil {
+				return nil, err
+			}
+			q.Select = append(q.Select, field)
+			if !p.punct(",") {
+				break
+			}
+		}
+	}
+	if t, ok := p.peek(); ok {
+		return nil, fmt.Errorf("unexpected %q in query", t.text)
+	}
+	return q, nil
+}
+
+// Matches returns true if a ref and its value match the query's where
+// clause.
+func (q *Query) Matches(ref string, value any) bool {
+	return q.where == nil || q.where.eval(ref, value)
+}
+
+// Project returns the values of the selected fields of a ref.
+func (q *Query) Project(ref string, value any) []any {
+	var out []any
+	for _, field := range q.Select {
+		v, _ := queryField(ref, value, field)
+		out = append(out, v)
+	}
+	return out
+}
+
+// RunQuery finds the refs in a store matching a query. Each ref matching the
+// glob is read from the store and checked against the where clause.
+func RunQuery(ctx context.Context, store Store, q *Query) ([]QueryResult, error) {
+	matches, err := store.Match(ctx, q.Glob)
+	if err != nil {
+		return nil, fmt.Errorf("failed to match refs: %w", err)
+	}
+	sort.Strings(matches)
+
+	var out []QueryResult
+	for _, ref := range matches {
+		var value any
+		if err := store.Get(ctx, ref, &value); err != nil {
+			// Links may point to refs that have since been removed
+			if errors.Is(err, ErrRefNotFound) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to get %s: %w", ref, err)
+		}
+		if !q.Matches(ref, value) {
+			continue
+		}
+		out = append(out, QueryResult{
+			Ref:    ref,
+			Value:  value,
+			Fields: q.Project(ref, value),
+		})
+	}
+	return out, nil
+}
+
+// queryField returns the value of a field, and whether it was set.
+func queryField(ref string, value any, field string) (any, bool) {
+	switch field {
+	case "$ref":
+		return ref, true
+	case "$value":
+		return value, value != nil
+	}
+	return lookupFragment(value, strings.Trim(field, "#/"))
+}
+
+type queryExpr interface {
+	eval(ref string, value any) bool
+}
+
+type queryAnd []queryExpr
+
+func (e queryAnd) eval(ref string, value any) bool {
+	for _, expr := range e {
+		if !expr.eval(ref, value) {
+			return false
+		}
+	}
+	return true
+}
+
+type queryOr []queryExpr
+
+func (e queryOr) eval(ref string, value any) bool {
+	for _, expr := range e {
+		if expr.eval(ref, value) {
+			return true
+		}
+	}
+	return false
+}
+
+type queryNot struct {
+	expr queryExpr
+}
+
+func (e queryNot) eval(ref string, value any) bool {
+	return !e.expr.eval(ref, value)
+}
+
+type queryComparison struct {
+	field string
+	op    string
+	value any
+	glob  libglob.Glob
+}
+
+func (c queryComparison) eval(ref string, value any) bool {
+	got, ok := queryField(ref, value, c.field)
+
+	switch c.op {
+	case "":
+		return ok && got != false
+	case "=":
+		return queryEqual(got, c.value)
+	case "!=":
+		return !queryEqual(got, c.value)
+	case "~":
+		s, isString := got.(string)
+		return isString && c.glob.Match(s)
+	case "contains":
+		switch got := got.(type) {
+		case string:
+			s, isString := c.value.(string)
+			return isString && strings.Contains(got, s)
+		case []any:
+			for _, item := range got {
+				if queryEqual(item, c.value) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	cmp, comparable := queryCompare(got, c.value)
+	if !comparable {
+		return false
+	}
+	switch c.op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
+
+// queryEqual compares decoded JSON values.
+func queryEqual(a any, b any) bool {
+	return reflect.DeepEqual(a, b)
+}
+
+// queryCompare orders two numbers or two strings.
+func queryCompare(a any, b any) (int, bool) {
+	switch a := a.(type) {
+	case float64:
+		if b, ok := b.(float64); ok {
+			switch {
+			case a < b:
+				return -1, true
+			case a > b:
+				return 1, true
+			}
+			return 0, true
+		}
+	case string:
+		if b, ok := b.(string); ok {
+			return strings.Compare(a, b), true
+		}
+	}
+	return 0, false
+}
+
+type queryToken struct {
+	text string
+	// quoted is set for string literals
+	quoted bool
+	// punct is set for operators, commas and parentheses
+	punct bool
+}
+
+// splitQueryGlob separates the glob at the start of a query from the rest.
+// The glob may be quoted if it contains spaces.
+func splitQueryGlob(query string) (string, string, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return "", "", errors.New("query must start with a glob")
+	}
+	if query[0] == '"' {
+		prefix, err := strconv.QuotedPrefix(query)
+		if err != nil {
+			return "", "", fmt.Errorf("unterminated glob in query: %s", query)
+		}
+		glob, err := strconv.Unquote(prefix)
+		return glob, query[len(prefix):], err
+	}
+	glob, rest := query, ""
+	if end := strings.IndexFunc(query, unicode.IsSpace); end >= 0 {
+		glob, rest = query[:end], query[end:]
+	}
+	return glob, rest, nil
+}
+
+func lexQuery(query string) ([]queryToken, error) {
+	var tokens []queryToken
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case unicode.IsSpace(rune(c)):
+			i++
+		case c == '"':
+			prefix, err := strconv.QuotedPrefix(query[i:])
+			if err != nil {
+				return nil, fmt.Errorf("unterminated string in query: %s", query[i:])
+			}
+			text, err := strconv.Unquote(prefix)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, queryToken{text: text, quoted: true})
+			i += len(prefix)
+		case strings.HasPrefix(query[i:], "!=") || strings.HasPrefix(query[i:], "<=") || strings.HasPrefix(query[i:], ">="):
+			tokens = append(tokens, queryToken{text: query[i : i+2], punct: true})
+			i += 2
+		case strings.ContainsRune("=<>~(),", rune(c)):
+			tokens = append(tokens, queryToken{text: string(c), punct: true})
+			i++
+		case c == '!':
+			return nil, fmt.Errorf("unexpected ! in query, use not or !=")
+		default:
+			end := i
+			for end < len(query) && !unicode.IsSpace(rune(query[end])) && !strings.ContainsRune(`"=!<>~(),`, rune(query[end])) {
+				end++
+			}
+			tokens = append(tokens, queryToken{text: query[i:end]})
+			i = end
+		}
+	}
+	return tokens, nil
+}
+
+type queryParser struct {
+	tokens []queryToken
+	pos    int
+}
+
+func (p *queryParser) peek() (queryToken, bool) {
+	if p.pos >= len(p.tokens) {
+		return queryToken{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+// keyword consumes the next token if it is the given keyword.
+func (p *queryParser) keyword(keyword string) bool {
+	t, ok := p.peek()
+	if ok && !t.quoted && !t.punct && strings.EqualFold(t.text, keyword) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// punct consumes the next token if it is the given operator or punctuation.
+func (p *queryParser) punct(text string) bool {
+	t, ok := p.peek()
+	if ok && t.punct && t.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *queryParser) field() (string, error) {
+	t, ok := p.peek()
+	if !ok {
+		return "", errors.New("expected a field at end of query")
+	}
+	if t.punct || isQueryKeyword(t.text) {
+		return "", fmt.Errorf("expected a field, got %q", t.text)
+	}
+	p.pos++
+	return t.text, nil
+}
+
+func (p *queryParser) parseOr() (queryExpr, error) {
+	var out queryOr
+	for {
+		expr, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, expr)
+		if !p.keyword("or") {
+			break
+		}
+	}
+	if len(out) == 1 {
+		return out[0], nil
+	}
+	return out, nil
+}
+
+func (p *queryParser) parseAnd() (queryExpr, error) {
+	var out queryAnd
+	for {
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, expr)
+		if !p.keyword("and") {
+			break
+		}
+	}
+	if len(out) == 1 {
+		return out[0], nil
+	}
+	return out, nil
+}
+
+func (p *queryParser) parseUnary() (queryExpr, error) {
+	if p.keyword("not") {
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return queryNot{expr: expr}, nil
+	}
+	if p.punct("(") {
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.punct(")") {
+			return nil, errors.New("expected ) in query")
+		}
+		return expr, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *queryParser) parseComparison() (queryExpr, error) {
+	field, err := p.field()
+	if err != nil {
+		return nil, err
+	}
+	out := queryComparison{field: field}
+
+	t, ok := p.peek()
+	switch {
+	case ok && t.punct && strings.ContainsAny(t.text, "=<>~"):
+		out.op = t.text
+	case ok && !t.quoted && strings.EqualFold(t.text, "contains"):
+		out.op = "contains"
+	default:
+		return out, nil
+	}
+	p.pos++
+
+	t, ok = p.peek()
+	if !ok || t.punct {
+		return nil, fmt.Errorf("expected a value after %s %s", field, out.op)
+	}
+	p.pos++
+	out.value = t.text
+	if !t.quoted {
+		var literal any
+		if err := json.Unmarshal([]byte(t.text), &literal); err == nil {
+			out.value = literal
+		}
+	}
+
+	if out.op == "~" {
+		pattern, ok := out.value.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected a glob after %s ~", field)
+		}
+		if out.glob, err = libglob.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("invalid glob %q: %w", pattern, err)
+		}
+	}
+	return out, nil
+}
+
+func isQueryKeyword(word string) bool {
+	switch strings.ToLower(word) {
+	case "where", "select", "and", "or", "not", "contains":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,136 @@
+package refstore
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestQueryMatches(t *testing.T) {
+	ref := "github.com/example/repo.git/-/package/@r1/task/build"
+	value := map[string]any{
+		"type":    "task",
+		"release": "github.com/example/repo.git/-/package/@r1",
+		"output": map[string]any{
+			"image": "registry/app@sha256:abc123",
+			"count": float64(3),
+			"tags":  []any{"latest", "v1"},
+			"ready": false,
+		},
+	}
+
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		{query: "**", want: true},
+		{query: "** where type = task", want: true},
+		{query: `** where type = "deploy"`, want: false},
+		{query: "** where type != deploy", want: true},
+		{query: `** where output/image contains "sha256:abc"`, want: true},
+		{query: `** where output/tags contains v1`, want: true},
+		{query: `** where output/tags contains v2`, want: false},
+		{query: "** where output/count >= 3 and output/count < 4", want: true},
+		{query: "** where output/count > 3", want: false},
+		{query: `** where output/count = "3"`, want: false},
+		{query: `** where release ~ "*/@r*"`, want: true},
+		{query: `** where $ref ~ "*/task/deploy"`, want: false},
+		{query: "** where output/image", want: true},
+		{query: "** where output/ready", want: false},
+		{query: "** where output/missing", want: false},
+		{query: "** where output/missing = null", want: true},
+		{query: "** where not output/missing", want: true},
+		{query: "** where type = deploy or output/count = 3", want: true},
+		{query: "** where not (type = deploy or output/count = 3)", want: false},
+		{query: "** WHERE type = task AND NOT type = deploy", want: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			q, err := ParseQuery(test.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := q.Matches(ref, value); got != test.want {
+				t.Errorf("expected %v, got %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestParseQuery(t *testing.T) {
+	q, err := ParseQuery(`"**/@*/task/*" where type = task select $ref, output/image,release`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Glob != "**/@*/task/*" {
+		t.Errorf("unexpected glob %q", q.Glob)
+	}
+	if diff := cmp.Diff([]string{"$ref", "output/image", "release"}, q.Select); diff != "" {
+		t.Errorf("unexpected select (-want +got):\n%s", diff)
+	}
+
+	for _, query := range []string{
+		"",
+		"** where",
+		"** where type =",
+		"** where (type = task",
+		"** where type ! task",
+		`** where type = "task`,
+		"** where type ~ 1",
+		"** select",
+		"** select type extra",
+		"** where and",
+	} {
+		if _, err := ParseQuery(query); err == nil {
+			t.Errorf("expected an error parsing %q", query)
+		}
+	}
+}
+
+func TestRunQuery(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewFSRefStore(filepath.Join(t.TempDir(), "store"), map[string]struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	base := "github.com/example/repo.git/-/package/@"
+	for ref, value := range map[string]any{
+		base + "r1/custom/database": map[string]any{"host": "db1", "port": 5432},
+		base + "r2/custom/database": map[string]any{"host": "db2", "port": 5433},
+		base + "r3/custom/database": map[string]any{"host": "db3"},
+		base + "r1/custom/other":    map[string]any{"host": "other"},
+	} {
+		if err := store.Set(ctx, ref, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	q, err := ParseQuery("**/@*/custom/database where port > 5000 select host, port")
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := RunQuery(ctx, store, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []QueryResult{
+		{
+			Ref:    base + "r1/custom/database",
+			Value:  map[string]any{"host": "db1", "port": float64(5432)},
+			Fields: []any{"db1", float64(5432)},
+		},
+		{
+			Ref:    base + "r2/custom/database",
+			Value:  map[string]any{"host": "db2", "port": float64(5433)},
+			Fields: []any{"db2", float64(5433)},
+		},
+	}
+	if diff := cmp.Diff(want, results); diff != "" {
+		t.Errorf("unexpected results (-want +got):\n%s", diff)
+	}
+}
@@ -3,6 +3,7 @@ package refstore
 import (
 	"context"
 	"errors"
+	"time"
 )
 
 var ErrReadOnly = errors.New("read-only store")
@@ -34,6 +35,11 @@ func (r *ReadOnlyStore) CommitTransaction(ctx context.Context) error {
 	return ErrReadOnly
 }
 
+// RollbackTransaction implements RollbackStore.
+func (r *ReadOnlyStore) RollbackTransaction(ctx context.Context) error {
+	return ErrReadOnly
+}
+
 // Delete implements RefStore.
 func (r *ReadOnlyStore) Delete(ctx context.Context, ref string) error {
 	return ErrReadOnly
@@ -94,6 +100,46 @@ func (r *ReadOnlyStore) Set(ctx context.Context, ref string, v any) error {
 	return ErrReadOnly
 }
 
+// GetWithRevision implements ConditionalStore.
+func (r *ReadOnlyStore) GetWithRevision(ctx context.Context, ref string, v any) (string, error) {
+	return GetWithRevision(ctx, r.store, ref, v)
+}
+
+// SetIfAbsent implements ConditionalStore.
+func (r *ReadOnlyStore) SetIfAbsent(ctx context.Context, ref string, v any) error {
+	return ErrReadOnly
+}
+
+// SetIfRevision implements ConditionalStore.
+func (r *ReadOnlyStore) SetIfRevision(ctx context.Context, ref string, v any, revision string) error {
+	return ErrReadOnly
+}
+
+// History implements HistoryStore.
+func (r *ReadOnlyStore) History(ctx context.Context, ref string) ([]HistoryEntry, error) {
+	return History(ctx, r.store, ref)
+}
+
+// RevisionAt implements HistoryStore.
+func (r *ReadOnlyStore) RevisionAt(ctx context.Context, t time.Time) (string, error) {
+	return RevisionAt(ctx, r.store, t)
+}
+
+// GetAt implements HistoryStore.
+func (r *ReadOnlyStore) GetAt(ctx context.Context, ref string, revision string, v any) error {
+	return GetAt(ctx, r.store, ref, revision, v)
+}
+
+// GetMetadata implements MetadataStore.
+func (r *ReadOnlyStore) GetMetadata(ctx context.Context, ref string) (ObjectMetadata, error) {
+	return GetMetadata(ctx, r.store, ref)
+}
+
+// Watch implements WatchableStore.
+func (r *ReadOnlyStore) Watch(ctx context.Context, globs ...string) (<-chan ChangeEvent, error) {
+	return Watch(ctx, r.store, globs...)
+}
+
 // StartTransaction implements RefStore.
 func (r *ReadOnlyStore) StartTransaction(ctx context.Context, message string) error {
 	return ErrReadOnly
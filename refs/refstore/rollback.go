@@ -0,0 +1,30 @@
+package refstore
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrRollbackUnsupported is returned when a transaction is rolled back in a
+// store that does not implement RollbackStore.
+var ErrRollbackUnsupported = errors.New("store does not support rolling back transactions")
+
+// RollbackStore is implemented by stores that can discard the changes made in
+// an open transaction.
+type RollbackStore interface {
+	Store
+
+	// RollbackTransaction discards the changes made since the transaction was
+	// started and ends it. Nothing is done if no transaction is open.
+	RollbackTransaction(ctx context.Context) error
+}
+
+// RollbackTransaction rolls back the open transaction in a store that
+// supports it.
+func RollbackTransaction(ctx context.Context, store Store) error {
+	rs, ok := store.(RollbackStore)
+	if !ok {
+		return ErrRollbackUnsupported
+	}
+	return rs.RollbackTransaction(ctx)
+}
@@ -0,0 +1,528 @@
+package refstore
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ocuroot/ocuroot/refs"
+)
+
+// Schema is a JSON Schema describing the value of a ref.
+//
+// Only a subset of JSON Schema is supported: type, enum, properties,
+// required, additionalProperties, items, string, number and array bounds,
+// pattern and the date-time format. Schemas using other keywords are
+// rejected when parsed, rather than silently accepting any value.
+type Schema struct {
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+
+	Type   SchemaType `json:"type,omitempty"`
+	Enum   []any      `json:"enum,omitempty"`
+	Format string     `json:"format,omitempty"`
+
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+
+	Items    *Schema `json:"items,omitempty"`
+	MinItems *int    `json:"minItems,omitempty"`
+	MaxItems *int    `json:"maxItems,omitempty"`
+
+	MinLength *int   `json:"minLength,omitempty"`
+	MaxLength *int   `json:"maxLength,omitempty"`
+	Pattern   string `json:"pattern,omitempty"`
+
+	Minimum *float64 `json:"minimum,omitempty"`
+	Maximum *float64 `json:"maximum,omitempty"`
+
+	// Never is set for the schema `false`, which no value matches.
+	Never bool `json:"-"`
+
+	pattern *regexp.Regexp
+}
+
+// SchemaType is the list of JSON types allowed by a schema. It is written as
+// a single string when there is only one.
+type SchemaType []string
+
+func (t SchemaType) MarshalJSON() ([]byte, error) {
+	if len(t) == 1 {
+		return json.Marshal(t[0])
+	}
+	return json.Marshal([]string(t))
+}
+
+func (t *SchemaType) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*t = SchemaType{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("type must be a string or list of strings")
+	}
+	*t = list
+	return nil
+}
+
+// schemaKeywords are the keywords that may appear in a parsed schema.
+// Annotations that do not affect validation are accepted and ignored.
+var schemaKeywords = map[string]struct{}{
+	"$schema": {}, "$id": {}, "$comment": {}, "title": {}, "description": {}, "default": {}, "examples": {},
+	"type": {}, "enum": {}, "format": {},
+	"properties": {}, "required": {}, "additionalProperties": {},
+	"items": {}, "minItems": {}, "maxItems": {},
+	"minLength": {}, "maxLength": {}, "pattern": {},
+	"minimum": {}, "maximum": {},
+}
+
+type schemaAlias Schema
+
+func (s Schema) MarshalJSON() ([]byte, error) {
+	if s.Never {
+		return []byte("false"), nil
+	}
+	return json.Marshal(schemaAlias(s))
+}
+
+func (s *Schema) UnmarshalJSON(data []byte) error {
+	switch string(bytes.TrimSpace(data)) {
+	case "true":
+		*s = Schema{}
+		return nil
+	case "false":
+		*s = Schema{Never: true}
+		return nil
+	}
+
+	var keywords map[string]json.RawMessage
+	if err := json.Unmarshal(data, &keywords); err != nil {
+		return fmt.Errorf("schema must be an object or boolean")
+	}
+	for keyword := range keywords {
+		if _, ok := schemaKeywords[keyword]; !ok {
+			return fmt.Errorf("unsupported schema keyword %q", keyword)
+		}
+	}
+
+	var alias schemaAlias
+	if err := json.Unmarshal(data, &alias); err != nil {
+		return err
+	}
+	*s = Schema(alias)
+	return nil
+}
+
+// ParseSchema parses a JSON Schema, checking that it only uses supported
+// keywords.
+func ParseSchema(data []byte) (*Schema, error) {
+	var schema Schema
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return nil, fmt.Errorf("failed to parse schema: %w", err)
+	}
+	if err := schema.compile(); err != nil {
+		return nil, err
+	}
+	return &schema, nil
+}
+
+// compile checks a schema and prepares its patterns for validation.
+func (s *Schema) compile() error {
+	if s == nil {
+		return nil
+	}
+	for _, t := range s.Type {
+		switch t {
+		case "null", "boolean", "object", "array", "number", "integer", "string":
+		default:
+			return fmt.Errorf("unknown schema type %q", t)
+		}
+	}
+	if s.Pattern != "" && s.pattern == nil {
+		pattern, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid schema pattern %q: %w", s.Pattern, err)
+		}
+		s.pattern = pattern
+	}
+	for _, property := range s.Properties {
+		if err := property.compile(); err != nil {
+			return err
+		}
+	}
+	if err := s.AdditionalProperties.compile(); err != nil {
+		return err
+	}
+	return s.Items.compile()
+}
+
+// FieldError describes a part of a value that does not match its schema.
+type FieldError struct {
+	// Path is the location of the field within the value, in the same form
+	// as a ref fragment. It is empty for the value as a whole.
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (e FieldError) String() string {
+	if e.Path == "" {
+		return "(root): " + e.Message
+	}
+	return e.Path + ": " + e.Message
+}
+
+// SchemaError is returned when a value written to a ref does not match the
+// ref's schema.
+type SchemaError struct {
+	Ref    string
+	Errors []FieldError
+}
+
+func (e *SchemaError) Error() string {
+	lines := []string{fmt.Sprintf("value for %s does not match its schema:", e.Ref)}
+	for _, fieldErr := range e.Errors {
+		lines = append(lines, "  "+fieldErr.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Validate checks a value against the schema, returning an error for each
+// field that does not match.
+func (s *Schema) Validate(v any) ([]FieldError, error) {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var value any
+	if err := decodeJSON(content, &value); err != nil {
+		return nil, err
+	}
+
+	var errs []FieldError
+	s.validate("", value, &errs)
+	return errs, nil
+}
+
+func (s *Schema) validate(path string, value any, errs *[]FieldError) {
+	if s == nil {
+		return
+	}
+	fail := func(format string, args ...any) {
+		*errs = append(*errs, FieldError{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if s.Never {
+		fail("is not allowed")
+		return
+	}
+
+	// Encrypted values cannot be checked, values marked as sensitive are
+	// checked as the value they will be once decrypted
+	if _, ok := encryptedValue(value); ok {
+		return
+	}
+	if inner, ok := sensitiveValue(value); ok {
+		s.validate(path, inner, errs)
+		return
+	}
+
+	if len(s.Type) > 0 && !s.matchesType(value) {
+		fail("expected %s, got %s", strings.Join(s.Type, " or "), jsonType(value))
+		return
+	}
+
+	if len(s.Enum) > 0 && !enumContains(s.Enum, value) {
+		var options []string
+		for _, option := range s.Enum {
+			content, _ := json.Marshal(option)
+			options = append(options, string(content))
+		}
+		fail("must be one of %s", strings.Join(options, ", "))
+		return
+	}
+
+	switch v := value.(type) {
+	case string:
+		length := utf8.RuneCountInString(v)
+		if s.MinLength != nil && length < *s.MinLength {
+			fail("must be at least %d characters", *s.MinLength)
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			fail("must be at most %d characters", *s.MaxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			fail("must match pattern %q", s.Pattern)
+		}
+		if s.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, v); err != nil {
+				fail("must be a date-time")
+			}
+		}
+	case json.Number:
+		n, err := v.Float64()
+		if err != nil {
+			fail("is not a valid number")
+			return
+		}
+		if s.Minimum != nil && n < *s.Minimum {
+			fail("must be at least %v", *s.Minimum)
+		}
+		if s.Maximum != nil && n > *s.Maximum {
+			fail("must be at most %v", *s.Maximum)
+		}
+	case []any:
+		if s.MinItems != nil && len(v) < *s.MinItems {
+			fail("must have at least %d items", *s.MinItems)
+		}
+		if s.MaxItems != nil && len(v) > *s.MaxItems {
+			fail("must have at most %d items", *s.MaxItems)
+		}
+		for i, item := range v {
+			s.Items.validate(joinFieldPath(path, fmt.Sprint(i)), item, errs)
+		}
+	case map[string]any:
+		for _, key := range s.Required {
+			if _, ok := v[key]; !ok {
+				*errs = append(*errs, FieldError{Path: joinFieldPath(path, key), Message: "is required"})
+			}
+		}
+		for _, key := range sortedKeys(v) {
+			fieldPath := joinFieldPath(path, key)
+			if property, ok := s.Properties[key]; ok {
+				property.validate(fieldPath, v[key], errs)
+				continue
+			}
+			if s.AdditionalProperties != nil && s.AdditionalProperties.Never {
+				*errs = append(*errs, FieldError{Path: fieldPath, Message: "is not a known field"})
+				continue
+			}
+			s.AdditionalProperties.validate(fieldPath, v[key], errs)
+		}
+	}
+}
+
+func (s *Schema) matchesType(value any) bool {
+	actual := jsonType(value)
+	for _, t := range s.Type {
+		if t == actual || (t == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonType returns the JSON Schema type of a decoded JSON value.
+func jsonType(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	case json.Number:
+		if n, err := v.Float64(); err == nil && n == math.Trunc(n) {
+			return "integer"
+		}
+		return "number"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func enumContains(enum []any, value any) bool {
+	content, err := json.Marshal(value)
+	if err != nil {
+		return false
+	}
+	for _, option := range enum {
+		equal, err := jsonEqual(content, mustMarshal(option))
+		if err == nil && equal {
+			return true
+		}
+	}
+	return false
+}
+
+func mustMarshal(v any) json.RawMessage {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return json.RawMessage("null")
+	}
+	return content
+}
+
+func joinFieldPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "/" + key
+}
+
+var (
+	timeType          = reflect.TypeFor[time.Time]()
+	refType           = reflect.TypeFor[refs.Ref]()
+	rawMessageType    = reflect.TypeFor[json.RawMessage]()
+	jsonMarshalerType = reflect.TypeFor[json.Marshaler]()
+	textMarshalerType = reflect.TypeFor[encoding.TextMarshaler]()
+)
+
+// SchemaFor generates a schema matching the JSON encoding of a Go value.
+//
+// Fields without omitempty are required, and fields that are not part of the
+// type are rejected. Values with their own JSON encoding are accepted as-is,
+// apart from times and refs, which must be strings.
+func SchemaFor(v any) *Schema {
+	return schemaForType(reflect.TypeOf(v), make(map[reflect.Type]bool))
+}
+
+func schemaForType(t reflect.Type, seen map[reflect.Type]bool) *Schema {
+	if t == nil {
+		return &Schema{}
+	}
+
+	switch t {
+	case timeType:
+		return &Schema{Type: SchemaType{"string"}, Format: "date-time"}
+	case refType:
+		return &Schema{Type: SchemaType{"string"}}
+	case rawMessageType:
+		return &Schema{}
+	}
+
+	if t.Kind() == reflect.Pointer {
+		return nullable(schemaForType(t.Elem(), seen))
+	}
+	if t.Implements(jsonMarshalerType) || reflect.PointerTo(t).Implements(jsonMarshalerType) {
+		return &Schema{}
+	}
+	if t.Implements(textMarshalerType) || reflect.PointerTo(t).Implements(textMarshalerType) {
+		return &Schema{Type: SchemaType{"string"}}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: SchemaType{"boolean"}}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return &Schema{Type: SchemaType{"integer"}}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: SchemaType{"number"}}
+	case reflect.String:
+		return &Schema{Type: SchemaType{"string"}}
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return nullable(&Schema{Type: SchemaType{"string"}})
+		}
+		return nullable(&Schema{Type: SchemaType{"array"}, Items: schemaForType(t.Elem(), seen)})
+	case reflect.Array:
+		return &Schema{Type: SchemaType{"array"}, Items: schemaForType(t.Elem(), seen)}
+	case reflect.Map:
+		return nullable(&Schema{Type: SchemaType{"object"}, AdditionalProperties: schemaForType(t.Elem(), seen)})
+	case reflect.Struct:
+		if seen[t] {
+			return &Schema{}
+		}
+		seen[t] = true
+		defer delete(seen, t)
+
+		out := &Schema{
+			Type:                 SchemaType{"object"},
+			Properties:           make(map[string]*Schema),
+			AdditionalProperties: &Schema{Never: true},
+		}
+		addStructFields(out, t, true, seen)
+		sort.Strings(out.Required)
+		return out
+	}
+
+	// Interfaces may hold any value
+	return &Schema{}
+}
+
+// addStructFields adds the JSON fields of a struct to an object schema,
+// flattening embedded structs as encoding/json does.
+func addStructFields(out *Schema, t reflect.Type, required bool, seen map[reflect.Type]bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			embeddedRequired := required
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+				embeddedRequired = false
+			}
+			if embedded.Kind() == reflect.Struct {
+				addStructFields(out, embedded, embeddedRequired, seen)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		switch field.Type.Kind() {
+		case reflect.Func, reflect.Chan, reflect.Complex64, reflect.Complex128, reflect.UnsafePointer:
+			continue
+		}
+
+		var property *Schema
+		if hasTagOption(options, "string") {
+			property = &Schema{Type: SchemaType{"string"}}
+		} else {
+			property = schemaForType(field.Type, seen)
+		}
+		out.Properties[name] = property
+		if required && !hasTagOption(options, "omitempty") && !hasTagOption(options, "omitzero") {
+			out.Required = append(out.Required, name)
+		}
+	}
+}
+
+func hasTagOption(options string, option string) bool {
+	for _, o := range strings.Split(options, ",") {
+		if o == option {
+			return true
+		}
+	}
+	return false
+}
+
+// nullable allows a schema to also match null, as nil pointers, slices and
+// maps are encoded.
+func nullable(s *Schema) *Schema {
+	if len(s.Type) == 0 || s.Never {
+		return s
+	}
+	for _, t := range s.Type {
+		if t == "null" {
+			return s
+		}
+	}
+	out := *s
+	out.Type = append(append(SchemaType{}, s.Type...), "null")
+	return &out
+}
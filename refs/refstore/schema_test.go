@@ -0,0 +1,233 @@
+package refstore
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/ocuroot/ocuroot/refs"
+)
+
+func TestSchemaValidate(t *testing.T) {
+	schema, err := ParseSchema([]byte(`{
+		"type": "object",
+		"properties": {
+			"host": {"type": "string", "minLength": 1},
+			"port": {"type": "integer", "minimum": 1, "maximum": 65535},
+			"tier": {"enum": ["gold", "silver"]},
+			"tags": {"type": "array", "items": {"type": "string", "pattern": "^[a-z]+$"}}
+		},
+		"required": ["host", "port"],
+		"additionalProperties": false
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		value any
+		want  []FieldError
+	}{
+		{
+			name: "valid",
+			value: map[string]any{
+				"host": "db.example.com",
+				"port": 5432,
+				"tier": "gold",
+				"tags": []string{"primary"},
+			},
+		},
+		{
+			name:  "wrong type",
+			value: "db.example.com",
+			want:  []FieldError{{Path: "", Message: "expected object, got string"}},
+		},
+		{
+			name: "invalid fields",
+			value: map[string]any{
+				"host":  "",
+				"port":  1.5,
+				"tier":  "bronze",
+				"tags":  []string{"ok", "NOT OK"},
+				"extra": true,
+			},
+			want: []FieldError{
+				{Path: "extra", Message: "is not a known field"},
+				{Path: "host", Message: "must be at least 1 characters"},
+				{Path: "port", Message: "expected integer, got number"},
+				{Path: "tags/1", Message: `must match pattern "^[a-z]+$"`},
+				{Path: "tier", Message: `must be one of "gold", "silver"`},
+			},
+		},
+		{
+			name:  "missing fields",
+			value: map[string]any{"port": 70000},
+			want: []FieldError{
+				{Path: "host", Message: "is required"},
+				{Path: "port", Message: "must be at most 65535"},
+			},
+		},
+		{
+			name: "sensitive values are checked",
+			value: map[string]any{
+				"host": Sensitive{Value: 1},
+				"port": 1,
+			},
+			want: []FieldError{{Path: "host", Message: "expected string, got integer"}},
+		},
+		{
+			name: "encrypted values are accepted",
+			value: map[string]any{
+				"host": map[string]any{encryptedKey: "ciphertext"},
+				"port": 1,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := schema.Validate(test.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("unexpected errors (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestParseSchemaUnsupported(t *testing.T) {
+	for _, schema := range []string{
+		`{"oneOf": [{"type": "string"}]}`,
+		`{"type": "text"}`,
+		`{"type": "string", "pattern": "("}`,
+		`{"properties": {"nested": {"$ref": "#/definitions/x"}}}`,
+	} {
+		if _, err := ParseSchema([]byte(schema)); err == nil {
+			t.Errorf("expected an error parsing %s", schema)
+		}
+	}
+}
+
+type schemaTestEmbedded struct {
+	Embedded string `json:"embedded"`
+}
+
+type schemaTestValue struct {
+	schemaTestEmbedded
+	Name     string            `json:"name"`
+	Count    int               `json:"count,omitempty"`
+	Ref      refs.Ref          `json:"ref"`
+	Time     time.Time         `json:"time"`
+	Labels   map[string]string `json:"labels"`
+	Items    []string          `json:"items"`
+	Optional *string           `json:"optional,omitempty"`
+	Any      any               `json:"any"`
+	Ignored  string            `json:"-"`
+	Untagged bool
+}
+
+func TestSchemaFor(t *testing.T) {
+	schema := SchemaFor(schemaTestValue{})
+
+	wantRequired := []string{"Untagged", "any", "embedded", "items", "labels", "name", "ref", "time"}
+	if diff := cmp.Diff(wantRequired, schema.Required); diff != "" {
+		t.Errorf("unexpected required fields (-want +got):\n%s", diff)
+	}
+	if _, ok := schema.Properties["Ignored"]; ok {
+		t.Errorf("expected ignored field to be left out")
+	}
+
+	// Values written from the type always match
+	errs, err := schema.Validate(schemaTestValue{
+		Name: "example",
+		Ref:  refs.Ref{Repo: "repo", Filename: "package.ocu.star"},
+		Time: time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 0 {
+		t.Errorf("expected value to match its own schema, got %v", errs)
+	}
+
+	errs, err = schema.Validate(map[string]any{
+		"embedded": "value",
+		"name":     "example",
+		"ref":      map[string]any{},
+		"time":     "yesterday",
+		"labels":   map[string]any{"key": 1},
+		"items":    nil,
+		"any":      []any{1},
+		"Untagged": false,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []FieldError{
+		{Path: "labels/key", Message: "expected string, got integer"},
+		{Path: "ref", Message: "expected string, got object"},
+		{Path: "time", Message: "must be a date-time"},
+	}
+	if diff := cmp.Diff(want, errs); diff != "" {
+		t.Errorf("unexpected errors (-want +got):\n%s", diff)
+	}
+}
+
+func TestValidatingStore(t *testing.T) {
+	ctx := context.Background()
+
+	fsStore, err := NewFSRefStore(filepath.Join(t.TempDir(), "store"), map[string]struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schema, err := ParseSchema([]byte(`{"type": "object", "required": ["host"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	schemas, err := NewSchemaSet(SchemaRule{Glob: "**/@*/custom/database*", Schema: schema})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewValidatingStore(fsStore, schemas)
+
+	ref := "github.com/example/repo.git/-/package/@r1/custom/database"
+	if err := store.Set(ctx, ref, map[string]any{"host": "db"}); err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Set(ctx, ref, map[string]any{"port": 1})
+	var schemaErr *SchemaError
+	if !errors.As(err, &schemaErr) {
+		t.Fatalf("expected a schema error, got %v", err)
+	}
+	if diff := cmp.Diff([]FieldError{{Path: "host", Message: "is required"}}, schemaErr.Errors); diff != "" {
+		t.Errorf("unexpected errors (-want +got):\n%s", diff)
+	}
+	if err := SetIfAbsent(ctx, store, "github.com/example/repo.git/-/package/@r1/custom/other", "not an object"); err != nil {
+		t.Errorf("expected refs without a schema to accept any value, got %v", err)
+	}
+
+	var got map[string]any
+	if err := store.Get(ctx, ref, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["host"] != "db" {
+		t.Errorf("expected rejected write to leave the value unchanged, got %v", got)
+	}
+
+	// Refs reserved by IncrementPath are not checked until written
+	reserved, err := IncrementPath(ctx, store, ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reserved != ref+"1" {
+		t.Errorf("unexpected reserved ref %q", reserved)
+	}
+}
@@ -0,0 +1,405 @@
+package refstore
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ocuroot/ocuroot/refs"
+)
+
+// releaseIDRegex matches the IDs given to releases, as opposed to tags.
+var releaseIDRegex = regexp.MustCompile(`^r([0-9]+)$`)
+
+// ResolveReleaseSelector replaces a release selector in a ref with the ID of
+// the release it selects, see refs.ReleaseSelector. Refs without a selector
+// are returned unchanged.
+func ResolveReleaseSelector(ctx context.Context, store Store, ref string) (string, error) {
+	if !strings.ContainsAny(ref, "~:") {
+		return ref, nil
+	}
+	parsed, err := refs.Parse(ref)
+	if err != nil {
+		return ref, nil
+	}
+	selector, ok := parsed.Release.Selector()
+	if !ok {
+		return ref, nil
+	}
+
+	pkg := refs.Ref{
+		Repo:     parsed.Repo,
+		Filename: parsed.Filename,
+	}
+	release, err := selectRelease(ctx, store, pkg, selector)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve release %q of %s: %w", parsed.Release, pkg.String(), err)
+	}
+	return parsed.SetRelease(release).String(), nil
+}
+
+// selectRelease finds the ID of the release of a package chosen by a
+// selector.
+func selectRelease(ctx context.Context, store Store, pkg refs.Ref, selector refs.ReleaseSelector) (string, error) {
+	var release string
+	switch {
+	case selector.Deployed != "":
+		deployRef := pkg.SetRelease("").SetSubPathType(refs.SubPathTypeDeploy).SetSubPath(selector.Deployed)
+		resolved, err := store.ResolveLink(ctx, deployRef.String())
+		if err != nil {
+			return "", err
+		}
+		parsed, err := refs.Parse(resolved)
+		if err != nil {
+			return "", err
+		}
+		if parsed.Release == "" {
+			return "", fmt.Errorf("nothing is deployed to %s: %w", selector.Deployed, ErrRefNotFound)
+		}
+		release = string(parsed.Release)
+	case selector.Tag != "":
+		matches, err := store.Match(ctx, pkg.SetRelease(selector.Tag).String())
+		if err != nil {
+			return "", err
+		}
+		var tagged []string
+		for _, match := range matches {
+			tagRef, err := refs.Parse(match)
+			if err != nil || releaseIDRegex.MatchString(string(tagRef.Release)) {
+				continue
+			}
+			resolved, err := resolveRelease(ctx, store, tagRef)
+			if err != nil {
+				return "", err
+			}
+			tagged = append(tagged, resolved)
+		}
+		if len(tagged) == 0 {
+			return "", fmt.Errorf("no release is tagged %s: %w", selector.Tag, ErrRefNotFound)
+		}
+		sortReleases(tagged)
+		release = tagged[len(tagged)-1]
+	case selector.Base == "":
+		releases, err := listReleases(ctx, store, pkg)
+		if err != nil {
+			return "", err
+		}
+		if len(releases) == 0 {
+			return "", fmt.Errorf("there are no releases: %w", ErrRefNotFound)
+		}
+		release = releases[len(releases)-1]
+	default:
+		resolved, err := resolveRelease(ctx, store, pkg.SetRelease(string(selector.Base)))
+		if err != nil {
+			return "", err
+		}
+		release = resolved
+	}
+
+	if selector.Previous == 0 {
+		return release, nil
+	}
+
+	releases, err := listReleases(ctx, store, pkg)
+	if err != nil {
+		return "", err
+	}
+	i := slices.Index(releases, release)
+	if i < 0 || i-selector.Previous < 0 {
+		return "", fmt.Errorf("there is no release %d before %s: %w", selector.Previous, release, ErrRefNotFound)
+	}
+	return releases[i-selector.Previous], nil
+}
+
+// resolveRelease follows a tag to the ID of the release it points to.
+func resolveRelease(ctx context.Context, store Store, releaseRef refs.Ref) (string, error) {
+	resolved, err := store.ResolveLink(ctx, releaseRef.String())
+	if err != nil {
+		return "", err
+	}
+	parsed, err := refs.Parse(resolved)
+	if err != nil {
+		return "", err
+	}
+	if !releaseIDRegex.MatchString(string(parsed.Release)) {
+		return "", fmt.Errorf("%s is not a release: %w", releaseRef.String(), ErrRefNotFound)
+	}
+	return string(parsed.Release), nil
+}
+
+// listReleases returns the IDs of the releases of a package, oldest first.
+func listReleases(ctx context.Context, store Store, pkg refs.Ref) ([]string, error) {
+	matches, err := store.MatchOptions(ctx, MatchOptions{NoLinks: true}, pkg.SetRelease("r*").String())
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, match := range matches {
+		parsed, err := refs.Parse(match)
+		if err != nil || !releaseIDRegex.MatchString(string(parsed.Release)) {
+			continue
+		}
+		out = append(out, string(parsed.Release))
+	}
+	sortReleases(out)
+	return out, nil
+}
+
+// sortReleases sorts release IDs by number.
+func sortReleases(releases []string) {
+	number := func(release string) int {
+		n, _ := strconv.Atoi(releaseIDRegex.FindStringSubmatch(release)[1])
+		return n
+	}
+	sort.Slice(releases, func(i, j int) bool {
+		return number(releases[i]) < number(releases[j])
+	})
+}
+
+// NewSelectorStore wraps a store to resolve release selectors in the refs
+// passed to it, so refs such as example/-/package/@~1 can be read and written
+// like any other. Globs passed to Match are left as they are.
+func NewSelectorStore(store Store) *SelectorStore {
+	return &SelectorStore{
+		store: store,
+	}
+}
+
+var _ Store = (*SelectorStore)(nil)
+var _ ConditionalStore = (*SelectorStore)(nil)
+var _ RollbackStore = (*SelectorStore)(nil)
+var _ HistoryStore = (*SelectorStore)(nil)
+var _ WatchableStore = (*SelectorStore)(nil)
+var _ MetadataStore = (*SelectorStore)(nil)
+var _ GitSupportFileWriter = (*SelectorStore)(nil)
+
+type SelectorStore struct {
+	store Store
+}
+
+func (s *SelectorStore) resolve(ctx context.Context, ref string) (string, error) {
+	return ResolveReleaseSelector(ctx, s.store, ref)
+}
+
+func (s *SelectorStore) Info() StoreInfo {
+	return s.store.Info()
+}
+
+// StartTransaction implements Store.
+func (s *SelectorStore) StartTransaction(ctx context.Context, message string) error {
+	return s.store.StartTransaction(ctx, message)
+}
+
+// CommitTransaction implements Store.
+func (s *SelectorStore) CommitTransaction(ctx context.Context) error {
+	return s.store.CommitTransaction(ctx)
+}
+
+// RollbackTransaction implements RollbackStore.
+func (s *SelectorStore) RollbackTransaction(ctx context.Context) error {
+	return RollbackTransaction(ctx, s.store)
+}
+
+// Get implements Store.
+func (s *SelectorStore) Get(ctx context.Context, ref string, v any) error {
+	ref, err := s.resolve(ctx, ref)
+	if err != nil {
+		return err
+	}
+	return s.store.Get(ctx, ref, v)
+}
+
+// Set implements Store.
+func (s *SelectorStore) Set(ctx context.Context, ref string, v any) error {
+	ref, err := s.resolve(ctx, ref)
+	if err != nil {
+		return err
+	}
+	return s.store.Set(ctx, ref, v)
+}
+
+// Delete implements Store.
+func (s *SelectorStore) Delete(ctx context.Context, ref string) error {
+	ref, err := s.resolve(ctx, ref)
+	if err != nil {
+		return err
+	}
+	return s.store.Delete(ctx, ref)
+}
+
+// Match implements Store.
+func (s *SelectorStore) Match(ctx context.Context, glob ...string) ([]string, error) {
+	return s.store.Match(ctx, glob...)
+}
+
+// MatchOptions implements Store.
+func (s *SelectorStore) MatchOptions(ctx context.Context, options MatchOptions, glob ...string) ([]string, error) {
+	return s.store.MatchOptions(ctx, options, glob...)
+}
+
+// Link implements Store.
+func (s *SelectorStore) Link(ctx context.Context, ref string, target string) error {
+	ref, err := s.resolve(ctx, ref)
+	if err != nil {
+		return err
+	}
+	target, err = s.resolve(ctx, target)
+	if err != nil {
+		return err
+	}
+	return s.store.Link(ctx, ref, target)
+}
+
+// Unlink implements Store.
+func (s *SelectorStore) Unlink(ctx context.Context, ref string) error {
+	ref, err := s.resolve(ctx, ref)
+	if err != nil {
+		return err
+	}
+	return s.store.Unlink(ctx, ref)
+}
+
+// GetLinks implements Store.
+func (s *SelectorStore) GetLinks(ctx context.Context, ref string) ([]string, error) {
+	ref, err := s.resolve(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	return s.store.GetLinks(ctx, ref)
+}
+
+// ResolveLink implements Store.
+func (s *SelectorStore) ResolveLink(ctx context.Context, ref string) (string, error) {
+	ref, err := s.resolve(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+	return s.store.ResolveLink(ctx, ref)
+}
+
+// AddDependency implements Store.
+func (s *SelectorStore) AddDependency(ctx context.Context, ref string, dependency string) error {
+	ref, err := s.resolve(ctx, ref)
+	if err != nil {
+		return err
+	}
+	dependency, err = s.resolve(ctx, dependency)
+	if err != nil {
+		return err
+	}
+	return s.store.AddDependency(ctx, ref, dependency)
+}
+
+// RemoveDependency implements Store.
+func (s *SelectorStore) RemoveDependency(ctx context.Context, ref string, dependency string) error {
+	ref, err := s.resolve(ctx, ref)
+	if err != nil {
+		return err
+	}
+	dependency, err = s.resolve(ctx, dependency)
+	if err != nil {
+		return err
+	}
+	return s.store.RemoveDependency(ctx, ref, dependency)
+}
+
+// GetDependencies implements Store.
+func (s *SelectorStore) GetDependencies(ctx context.Context, ref string) ([]string, error) {
+	ref, err := s.resolve(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	return s.store.GetDependencies(ctx, ref)
+}
+
+// GetDependants implements Store.
+func (s *SelectorStore) GetDependants(ctx context.Context, ref string) ([]string, error) {
+	ref, err := s.resolve(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	return s.store.GetDependants(ctx, ref)
+}
+
+// Close implements Store.
+func (s *SelectorStore) Close() error {
+	return s.store.Close()
+}
+
+// GetWithRevision implements ConditionalStore.
+func (s *SelectorStore) GetWithRevision(ctx context.Context, ref string, v any) (string, error) {
+	ref, err := s.resolve(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+	return GetWithRevision(ctx, s.store, ref, v)
+}
+
+// SetIfAbsent implements ConditionalStore.
+func (s *SelectorStore) SetIfAbsent(ctx context.Context, ref string, v any) error {
+	ref, err := s.resolve(ctx, ref)
+	if err != nil {
+		return err
+	}
+	return SetIfAbsent(ctx, s.store, ref, v)
+}
+
+// SetIfRevision implements ConditionalStore.
+func (s *SelectorStore) SetIfRevision(ctx context.Context, ref string, v any, revision string) error {
+	ref, err := s.resolve(ctx, ref)
+	if err != nil {
+		return err
+	}
+	return SetIfRevision(ctx, s.store, ref, v, revision)
+}
+
+// History implements HistoryStore.
+func (s *SelectorStore) History(ctx context.Context, ref string) ([]HistoryEntry, error) {
+	ref, err := s.resolve(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	return History(ctx, s.store, ref)
+}
+
+// RevisionAt implements HistoryStore.
+func (s *SelectorStore) RevisionAt(ctx context.Context, t time.Time) (string, error) {
+	return RevisionAt(ctx, s.store, t)
+}
+
+// GetAt implements HistoryStore.
+// Selectors are resolved against the current state of the store.
+func (s *SelectorStore) GetAt(ctx context.Context, ref string, revision string, v any) error {
+	ref, err := s.resolve(ctx, ref)
+	if err != nil {
+		return err
+	}
+	return GetAt(ctx, s.store, ref, revision, v)
+}
+
+// GetMetadata implements MetadataStore.
+func (s *SelectorStore) GetMetadata(ctx context.Context, ref string) (ObjectMetadata, error) {
+	ref, err := s.resolve(ctx, ref)
+	if err != nil {
+		return ObjectMetadata{}, err
+	}
+	return GetMetadata(ctx, s.store, ref)
+}
+
+// Watch implements WatchableStore.
+func (s *SelectorStore) Watch(ctx context.Context, globs ...string) (<-chan ChangeEvent, error) {
+	return Watch(ctx, s.store, globs...)
+}
+
+// AddSupportFiles implements GitSupportFileWriter.
+func (s *SelectorStore) AddSupportFiles(ctx context.Context, files map[string]string) error {
+	if gitSupportFileWriter, ok := s.store.(GitSupportFileWriter); ok {
+		return gitSupportFileWriter.AddSupportFiles(ctx, files)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package refstore
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveReleaseSelector(t *testing.T) {
+	ctx := context.Background()
+	fsStore, err := NewFSRefStore(filepath.Join(t.TempDir(), "store"), map[string]struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewSelectorStore(fsStore)
+
+	pkg := "github.com/example/repo.git/-/package"
+	for _, release := range []string{"r1", "r2", "r3", "r10"} {
+		if err := store.Set(ctx, pkg+"/@"+release, map[string]any{"commit": release}); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Set(ctx, pkg+"/@"+release+"/task/build", map[string]any{"output": map[string]any{"image": "app:" + release}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for tag, release := range map[string]string{"v1.0": "r1", "v1.1": "r2", "v2.0": "r3"} {
+		if err := store.Link(ctx, pkg+"/@"+tag, pkg+"/@"+release); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Set(ctx, pkg+"/@r2/deploy/production", map[string]any{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Link(ctx, pkg+"/@/deploy/production", pkg+"/@r2/deploy/production"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ref      string
+		expected string
+	}{
+		{ref: pkg + "/@r3/task/build", expected: pkg + "/@r3/task/build"},
+		{ref: pkg + "/@~1/task/build", expected: pkg + "/@r3/task/build"},
+		{ref: pkg + "/@~3", expected: pkg + "/@r1"},
+		{ref: pkg + "/@r3~1#commit", expected: pkg + "/@r2#commit"},
+		{ref: pkg + "/@v2.0~2", expected: pkg + "/@r1"},
+		{ref: pkg + "/@deployed:production/task/build", expected: pkg + "/@r2/task/build"},
+		{ref: pkg + "/@deployed:production~1", expected: pkg + "/@r1"},
+		{ref: pkg + "/@tag:v1.*/task/build", expected: pkg + "/@r2/task/build"},
+		{ref: pkg + "/@tag:v*", expected: pkg + "/@r3"},
+	}
+	for _, test := range tests {
+		t.Run(test.ref, func(t *testing.T) {
+			got, err := ResolveReleaseSelector(ctx, fsStore, test.ref)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, got)
+			}
+		})
+	}
+
+	for _, ref := range []string{
+		pkg + "/@~4",
+		pkg + "/@deployed:staging",
+		pkg + "/@tag:v3.*",
+		pkg + "/@r4~1",
+	} {
+		if _, err := ResolveReleaseSelector(ctx, fsStore, ref); !errors.Is(err, ErrRefNotFound) {
+			t.Errorf("expected ErrRefNotFound resolving %s, got %v", ref, err)
+		}
+	}
+
+	var image string
+	if err := store.Get(ctx, pkg+"/@deployed:production~1/task/build#output/image", &image); err != nil {
+		t.Fatal(err)
+	}
+	if image != "app:r1" {
+		t.Errorf("unexpected image through selector store: %q", image)
+	}
+}
@@ -0,0 +1,706 @@
+package refstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/charmbracelet/log"
+	libglob "github.com/gobwas/glob"
+	"github.com/ocuroot/ocuroot/refs"
+
+	_ "modernc.org/sqlite"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS store_info (
+	id INTEGER PRIMARY KEY CHECK (id = 1),
+	info TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS refs (
+	ref TEXT PRIMARY KEY,
+	kind TEXT NOT NULL,
+	body_type TEXT NOT NULL DEFAULT '',
+	body TEXT NOT NULL,
+	target TEXT,
+	create_audit TEXT NOT NULL DEFAULT '',
+	set_audit TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS refs_target ON refs (target) WHERE target IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS dependencies (
+	ref TEXT NOT NULL,
+	dependency TEXT NOT NULL,
+	PRIMARY KEY (ref, dependency)
+);
+
+CREATE INDEX IF NOT EXISTS dependencies_dependency ON dependencies (dependency, ref);
+`
+
+// NewSQLiteRefStore opens a store backed by the SQLite database at path,
+// creating it if it does not already exist.
+func NewSQLiteRefStore(path string, tags map[string]struct{}) (*SQLiteStateStore, error) {
+	log.Info("Initializing SQLiteRefStore", "path", path, "tags", tags)
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory %s: %w", dir, err)
+	}
+
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(10000)&_pragma=journal_mode(WAL)&_pragma=synchronous(NORMAL)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	// All operations, including those in a transaction, share a single
+	// connection so that reads always see uncommitted writes.
+	db.SetMaxOpenConns(1)
+
+	s := &SQLiteStateStore{
+		Path: path,
+		db:   db,
+	}
+
+	if err := s.init(tags); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+var _ Store = (*SQLiteStateStore)(nil)
+var _ ConditionalStore = (*SQLiteStateStore)(nil)
+var _ RollbackStore = (*SQLiteStateStore)(nil)
+var _ MetadataStore = (*SQLiteStateStore)(nil)
+
+type SQLiteStateStore struct {
+	Path string
+
+	db   *sql.DB
+	tx   *sql.Tx
+	info StoreInfo
+}
+
+type sqliteQuerier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func (s *SQLiteStateStore) init(tags map[string]struct{}) error {
+	ctx := context.Background()
+
+	if _, err := s.db.ExecContext(ctx, sqliteSchema); err != nil {
+		return fmt.Errorf("failed to create schema: %w", err)
+	}
+	if err := s.addAuditColumns(ctx); err != nil {
+		return fmt.Errorf("failed to add audit columns: %w", err)
+	}
+
+	var infoJSON string
+	err := s.db.QueryRowContext(ctx, `SELECT info FROM store_info WHERE id = 1`).Scan(&infoJSON)
+	if errors.Is(err, sql.ErrNoRows) {
+		log.Info("Creating store info", "path", s.Path)
+		s.info = StoreInfo{Version: stateVersion, Tags: tags}
+		infoBytes, err := json.Marshal(s.info)
+		if err != nil {
+			return fmt.Errorf("failed to marshal store info: %w", err)
+		}
+		if _, err := s.db.ExecContext(ctx, `INSERT INTO store_info (id, info) VALUES (1, ?)`, string(infoBytes)); err != nil {
+			return fmt.Errorf("failed to write store info: %w", err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read store info: %w", err)
+	}
+
+	if err := json.Unmarshal([]byte(infoJSON), &s.info); err != nil {
+		return fmt.Errorf("failed to unmarshal store info: %w", err)
+	}
+
+	// SQLite stores were introduced at version 3, so have no upgrades yet
+	upgraded, err := storeUpgrades{}.apply(&s.info)
+	if err != nil {
+		return err
+	}
+	if upgraded {
+		infoBytes, err := json.Marshal(s.info)
+		if err != nil {
+			return fmt.Errorf("failed to marshal store info: %w", err)
+		}
+		if _, err := s.db.ExecContext(ctx, `UPDATE store_info SET info = ? WHERE id = 1`, string(infoBytes)); err != nil {
+			return fmt.Errorf("failed to write store info: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *SQLiteStateStore) q() sqliteQuerier {
+	if s.tx != nil {
+		return s.tx
+	}
+	return s.db
+}
+
+// Info implements Store.
+func (s *SQLiteStateStore) Info() StoreInfo {
+	return s.info
+}
+
+// StartTransaction implements Store.
+func (s *SQLiteStateStore) StartTransaction(ctx context.Context, message string) error {
+	if s.tx != nil {
+		// Continue the open transaction
+		return nil
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	s.tx = tx
+	return nil
+}
+
+// CommitTransaction implements Store.
+func (s *SQLiteStateStore) CommitTransaction(ctx context.Context) error {
+	if s.tx == nil {
+		return nil
+	}
+
+	tx := s.tx
+	s.tx = nil
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// RollbackTransaction implements RollbackStore.
+func (s *SQLiteStateStore) RollbackTransaction(ctx context.Context) error {
+	if s.tx == nil {
+		return nil
+	}
+
+	tx := s.tx
+	s.tx = nil
+	if err := tx.Rollback(); err != nil {
+		return fmt.Errorf("failed to roll back transaction: %w", err)
+	}
+	return nil
+}
+
+// Close implements Store.
+// Any uncommitted transaction is rolled back.
+func (s *SQLiteStateStore) Close() error {
+	if err := s.RollbackTransaction(context.Background()); err != nil {
+		return err
+	}
+	return s.db.Close()
+}
+
+// addAuditColumns adds the audit columns to databases created before they
+// were introduced. Older versions ignore these columns, so the store version
+// is unchanged.
+func (s *SQLiteStateStore) addAuditColumns(ctx context.Context) error {
+	columns, err := s.queryStrings(ctx, `SELECT name FROM pragma_table_info('refs')`)
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]bool)
+	for _, column := range columns {
+		existing[column] = true
+	}
+	for _, column := range []string{"create_audit", "set_audit"} {
+		if existing[column] {
+			continue
+		}
+		if _, err := s.db.ExecContext(ctx, `ALTER TABLE refs ADD COLUMN `+column+` TEXT NOT NULL DEFAULT ''`); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// auditJSON encodes the audit for a write made with ctx.
+func auditJSON(ctx context.Context) (string, error) {
+	audit, err := json.Marshal(auditFromContext(ctx))
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal audit: %w", err)
+	}
+	return string(audit), nil
+}
+
+// Get implements Store.
+func (s *SQLiteStateStore) Get(ctx context.Context, ref string, v any) error {
+	_, err := s.GetWithRevision(ctx, ref, v)
+	return err
+}
+
+// GetWithRevision implements ConditionalStore.
+func (s *SQLiteStateStore) GetWithRevision(ctx context.Context, ref string, v any) (string, error) {
+	parsedRef, err := refs.Parse(ref)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse ref: %w", err)
+	}
+
+	refWithoutFragment := parsedRef
+	refWithoutFragment.Fragment = ""
+
+	targetRef, err := s.resolveLink(ctx, refWithoutFragment.String())
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve link: %w", err)
+	}
+
+	var kind, body string
+	err = s.q().QueryRowContext(ctx, `SELECT kind, body FROM refs WHERE ref = ?`, targetRef.String()).Scan(&kind, &body)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrRefNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get ref: %w", err)
+	}
+
+	if StorageKind(kind) != StorageKindRef {
+		return "", fmt.Errorf("expected ref, got %s", kind)
+	}
+
+	if err := unmarshalFragment(json.RawMessage(body), parsedRef.Fragment, v); err != nil {
+		return "", err
+	}
+	return revisionOf([]byte(body)), nil
+}
+
+// GetMetadata implements MetadataStore.
+// Stacks are not recorded.
+func (s *SQLiteStateStore) GetMetadata(ctx context.Context, ref string) (ObjectMetadata, error) {
+	targetRef, err := s.resolveLink(ctx, ref)
+	if err != nil {
+		return ObjectMetadata{}, fmt.Errorf("failed to resolve link: %w", err)
+	}
+
+	var kind, bodyType, createAudit, setAudit string
+	err = s.q().QueryRowContext(ctx, `SELECT kind, body_type, create_audit, set_audit FROM refs WHERE ref = ?`, targetRef.SetFragment("").String()).Scan(&kind, &bodyType, &createAudit, &setAudit)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ObjectMetadata{}, ErrRefNotFound
+	}
+	if err != nil {
+		return ObjectMetadata{}, fmt.Errorf("failed to get ref: %w", err)
+	}
+
+	if StorageKind(kind) != StorageKindRef {
+		return ObjectMetadata{}, fmt.Errorf("expected ref, got %s", kind)
+	}
+
+	metadata := ObjectMetadata{BodyType: bodyType}
+	for _, audit := range []struct {
+		value string
+		dest  **Audit
+	}{
+		{createAudit, &metadata.CreateAudit},
+		{setAudit, &metadata.SetAudit},
+	} {
+		if audit.value == "" {
+			continue
+		}
+		if err := json.Unmarshal([]byte(audit.value), audit.dest); err != nil {
+			return ObjectMetadata{}, fmt.Errorf("failed to unmarshal audit: %w", err)
+		}
+	}
+	return metadata, nil
+}
+
+// Set implements Store.
+func (s *SQLiteStateStore) Set(ctx context.Context, ref string, v any) error {
+	targetRef, body, err := s.prepareSet(ctx, ref, v)
+	if err != nil {
+		return err
+	}
+	audit, err := auditJSON(ctx)
+	if err != nil {
+		return err
+	}
+
+	// The audit of the previous write is kept if the value is unchanged
+	_, err = s.q().ExecContext(ctx, `
+		INSERT INTO refs (ref, kind, body_type, body, create_audit, set_audit) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (ref) DO UPDATE SET
+			kind = excluded.kind,
+			body_type = excluded.body_type,
+			set_audit = CASE WHEN refs.body = excluded.body AND refs.set_audit != '' THEN refs.set_audit ELSE excluded.set_audit END,
+			body = excluded.body,
+			target = NULL`,
+		targetRef, string(StorageKindRef), bodyType(v), body, audit, audit,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to set ref: %w", err)
+	}
+	return nil
+}
+
+// SetIfAbsent implements ConditionalStore.
+func (s *SQLiteStateStore) SetIfAbsent(ctx context.Context, ref string, v any) error {
+	targetRef, body, err := s.prepareSet(ctx, ref, v)
+	if err != nil {
+		return err
+	}
+	audit, err := auditJSON(ctx)
+	if err != nil {
+		return err
+	}
+
+	result, err := s.q().ExecContext(ctx, `
+		INSERT INTO refs (ref, kind, body_type, body, create_audit, set_audit) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (ref) DO NOTHING`,
+		targetRef, string(StorageKindRef), bodyType(v), body, audit, audit,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to set ref: %w", err)
+	}
+	return conflictIfUnchanged(result)
+}
+
+// SetIfRevision implements ConditionalStore.
+func (s *SQLiteStateStore) SetIfRevision(ctx context.Context, ref string, v any, revision string) error {
+	targetRef, body, err := s.prepareSet(ctx, ref, v)
+	if err != nil {
+		return err
+	}
+
+	var currentBody string
+	err = s.q().QueryRowContext(ctx, `SELECT body FROM refs WHERE ref = ? AND kind = ?`, targetRef, string(StorageKindRef)).Scan(&currentBody)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrConflict
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get ref: %w", err)
+	}
+	if revisionOf([]byte(currentBody)) != revision {
+		return ErrConflict
+	}
+
+	audit, err := auditJSON(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Only update if the body is unchanged since it was read
+	result, err := s.q().ExecContext(ctx, `
+		UPDATE refs SET body_type = ?, body = ?, set_audit = ? WHERE ref = ? AND body = ?`,
+		bodyType(v), body, audit, targetRef, currentBody,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to set ref: %w", err)
+	}
+	return conflictIfUnchanged(result)
+}
+
+// prepareSet resolves the ref to be written and encodes its value.
+func (s *SQLiteStateStore) prepareSet(ctx context.Context, ref string, v any) (string, string, error) {
+	parsedRef, err := s.resolveLink(ctx, ref)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to resolve link: %w", err)
+	}
+
+	if parsedRef.Fragment != "" {
+		return "", "", fmt.Errorf("setting by fragment not supported")
+	}
+
+	body, err := json.Marshal(v)
+	if err != nil {
+		return "", "", err
+	}
+	return parsedRef.String(), string(body), nil
+}
+
+func conflictIfUnchanged(result sql.Result) error {
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrConflict
+	}
+	return nil
+}
+
+// Delete implements Store.
+func (s *SQLiteStateStore) Delete(ctx context.Context, ref string) error {
+	parsedRef, err := s.resolveLink(ctx, ref)
+	if err != nil {
+		return fmt.Errorf("failed to resolve link: %w", err)
+	}
+
+	if parsedRef.Fragment != "" {
+		return fmt.Errorf("delete by fragment not supported")
+	}
+
+	result, err := s.q().ExecContext(ctx, `DELETE FROM refs WHERE ref = ?`, parsedRef.String())
+	if err != nil {
+		return fmt.Errorf("failed to delete ref: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrRefNotFound
+	}
+	return nil
+}
+
+// Link implements Store.
+func (s *SQLiteStateStore) Link(ctx context.Context, ref string, target string) error {
+	parsedLinkRef, err := refs.Parse(ref)
+	if err != nil {
+		return err
+	}
+
+	parsedTargetRef, err := refs.Parse(target)
+	if err != nil {
+		return err
+	}
+
+	var kind string
+	err = s.q().QueryRowContext(ctx, `SELECT kind FROM refs WHERE ref = ?`, parsedLinkRef.String()).Scan(&kind)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("failed to get ref: %w", err)
+	}
+	if err == nil && StorageKind(kind) != StorageKindLink {
+		return fmt.Errorf("existing ref is not a link, cannot overwrite")
+	}
+
+	body, err := json.Marshal(parsedTargetRef.String())
+	if err != nil {
+		return err
+	}
+
+	audit, err := auditJSON(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.q().ExecContext(ctx, `
+		INSERT INTO refs (ref, kind, body, target, create_audit, set_audit) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (ref) DO UPDATE SET
+			body = excluded.body,
+			target = excluded.target,
+			set_audit = excluded.set_audit`,
+		parsedLinkRef.String(), string(StorageKindLink), string(body), parsedTargetRef.String(), audit, audit,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to set link: %w", err)
+	}
+	return nil
+}
+
+// Unlink implements Store.
+func (s *SQLiteStateStore) Unlink(ctx context.Context, ref string) error {
+	parsedLinkRef, err := refs.Parse(ref)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.q().ExecContext(ctx, `DELETE FROM refs WHERE ref = ?`, parsedLinkRef.String()); err != nil {
+		return fmt.Errorf("failed to delete link: %w", err)
+	}
+	return nil
+}
+
+// GetLinks implements Store.
+func (s *SQLiteStateStore) GetLinks(ctx context.Context, ref string) ([]string, error) {
+	parsedRef, err := refs.Parse(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.queryStrings(ctx, `SELECT ref FROM refs WHERE target = ? AND kind = ? ORDER BY ref`, parsedRef.String(), string(StorageKindLink))
+}
+
+// ResolveLink implements Store.
+func (s *SQLiteStateStore) ResolveLink(ctx context.Context, ref string) (string, error) {
+	r, err := s.resolveLink(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+	return r.String(), nil
+}
+
+func (s *SQLiteStateStore) resolveLink(ctx context.Context, ref string) (refs.Ref, error) {
+	parsedRef, err := refs.Parse(ref)
+	if err != nil {
+		return refs.Ref{}, err
+	}
+
+	refWithoutFragment := parsedRef
+	refWithoutFragment.Fragment = ""
+
+	// Find the longest link that is this ref or one of its parents
+	var candidates []any
+	for candidateRef := refWithoutFragment.String(); strings.Contains(candidateRef, "/"); candidateRef = filepath.Dir(candidateRef) {
+		candidates = append(candidates, candidateRef)
+	}
+	if len(candidates) == 0 {
+		return parsedRef, nil
+	}
+
+	var foundLink, resolvedLink string
+	err = s.q().QueryRowContext(ctx,
+		`SELECT ref, target FROM refs WHERE kind = ? AND ref IN (?`+strings.Repeat(", ?", len(candidates)-1)+`) ORDER BY length(ref) DESC LIMIT 1`,
+		append([]any{string(StorageKindLink)}, candidates...)...,
+	).Scan(&foundLink, &resolvedLink)
+	if errors.Is(err, sql.ErrNoRows) {
+		return parsedRef, nil
+	}
+	if err != nil {
+		return refs.Ref{}, fmt.Errorf("failed to query links: %w", err)
+	}
+
+	resolvedRef := strings.Replace(ref, foundLink, resolvedLink, 1)
+
+	out, err := refs.Parse(resolvedRef)
+	if err != nil {
+		return refs.Ref{}, fmt.Errorf("%v: %w", resolvedRef, err)
+	}
+
+	return out, nil
+}
+
+// Match implements Store.
+func (s *SQLiteStateStore) Match(ctx context.Context, glob ...string) ([]string, error) {
+	return s.matchRefs(ctx, glob, false)
+}
+
+// MatchOptions implements Store.
+func (s *SQLiteStateStore) MatchOptions(ctx context.Context, options MatchOptions, glob ...string) ([]string, error) {
+	return s.matchRefs(ctx, glob, options.NoLinks)
+}
+
+func (s *SQLiteStateStore) matchRefs(ctx context.Context, globs []string, noLinks bool) ([]string, error) {
+	var (
+		matches []string
+		seen    = make(map[string]struct{})
+	)
+
+	for _, glob := range globs {
+		g, err := libglob.Compile(glob, '/')
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile glob %s: %w", glob, err)
+		}
+
+		// Narrow the candidates using the index on refs before matching
+		query := `SELECT ref FROM refs WHERE 1 = 1`
+		var args []any
+		prefix := globLiteralPrefix(glob)
+		if prefix != "" {
+			query += ` AND ref >= ?`
+			args = append(args, prefix)
+			if upper, ok := prefixUpperBound(prefix); ok {
+				query += ` AND ref < ?`
+				args = append(args, upper)
+			}
+		}
+		if noLinks {
+			query += ` AND kind != ?`
+			args = append(args, string(StorageKindLink))
+		}
+
+		candidates, err := s.queryStrings(ctx, query, args...)
+		if err != nil {
+			return nil, err
+		}
+		for _, candidate := range candidates {
+			if _, ok := seen[candidate]; ok {
+				continue
+			}
+			if g.Match(candidate) {
+				seen[candidate] = struct{}{}
+				matches = append(matches, candidate)
+			}
+		}
+	}
+
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// AddDependency implements Store.
+func (s *SQLiteStateStore) AddDependency(ctx context.Context, ref string, dependency string) error {
+	_, err := s.q().ExecContext(ctx, `INSERT OR IGNORE INTO dependencies (ref, dependency) VALUES (?, ?)`, ref, dependency)
+	if err != nil {
+		return fmt.Errorf("failed to add dependency: %w", err)
+	}
+	return nil
+}
+
+// RemoveDependency implements Store.
+func (s *SQLiteStateStore) RemoveDependency(ctx context.Context, ref string, dependency string) error {
+	result, err := s.q().ExecContext(ctx, `DELETE FROM dependencies WHERE ref = ? AND dependency = ?`, ref, dependency)
+	if err != nil {
+		return fmt.Errorf("failed to remove dependency: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("dependency %s of %s not found", dependency, ref)
+	}
+	return nil
+}
+
+// GetDependencies implements Store.
+func (s *SQLiteStateStore) GetDependencies(ctx context.Context, ref string) ([]string, error) {
+	return s.queryStrings(ctx, `SELECT dependency FROM dependencies WHERE ref = ? ORDER BY dependency`, ref)
+}
+
+// GetDependants implements Store.
+func (s *SQLiteStateStore) GetDependants(ctx context.Context, ref string) ([]string, error) {
+	return s.queryStrings(ctx, `SELECT ref FROM dependencies WHERE dependency = ? ORDER BY ref`, ref)
+}
+
+func (s *SQLiteStateStore) queryStrings(ctx context.Context, query string, args ...any) ([]string, error) {
+	rows, err := s.q().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query: %w", err)
+	}
+	defer rows.Close()
+
+	var out []string
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		out = append(out, value)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read rows: %w", err)
+	}
+	return out, nil
+}
+
+// globLiteralPrefix returns the portion of a glob before its first special
+// character. Every string matching the glob starts with this prefix.
+func globLiteralPrefix(glob string) string {
+	if i := strings.IndexAny(glob, `*?[{\`); i >= 0 {
+		return glob[:i]
+	}
+	return glob
+}
+
+// prefixUpperBound returns the smallest string greater than every string
+// starting with prefix, if there is one.
+func prefixUpperBound(prefix string) (string, bool) {
+	b := []byte(prefix)
+	for i := len(b) - 1; i >= 0; i-- {
+		if b[i] < 0xff {
+			b[i]++
+			return string(b[:i+1]), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,105 @@
+package refstore
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSQLiteRefStore(t *testing.T) {
+	tempDir := "./testdata/sqlite_testdata"
+	_ = os.RemoveAll(tempDir)
+
+	store, err := NewSQLiteRefStore(filepath.Join(tempDir, "state.db"), map[string]struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := store.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	DoTestStore(t, store)
+	DoTestConditionalStore(t, store)
+}
+
+func TestSQLiteRefStoreTransactions(t *testing.T) {
+	ctx := context.Background()
+	tempDir := "./testdata/sqlite_transactions_testdata"
+	_ = os.RemoveAll(tempDir)
+	dbPath := filepath.Join(tempDir, "state.db")
+
+	store, err := NewSQLiteRefStore(dbPath, map[string]struct{}{"state": {}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.StartTransaction(ctx, "committed"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set(ctx, "repo.git/-/path/@/custom/committed", "value"); err != nil {
+		t.Fatal(err)
+	}
+	assertValue(t, store, "repo.git/-/path/@/custom/committed", "value")
+	if err := store.CommitTransaction(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.StartTransaction(ctx, "uncommitted"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set(ctx, "repo.git/-/path/@/custom/uncommitted", "value"); err != nil {
+		t.Fatal(err)
+	}
+	assertValue(t, store, "repo.git/-/path/@/custom/uncommitted", "value")
+	if err := store.RollbackTransaction(ctx); err != nil {
+		t.Fatal(err)
+	}
+	assertMissing(t, store, "repo.git/-/path/@/custom/uncommitted")
+
+	if err := store.StartTransaction(ctx, "closed"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set(ctx, "repo.git/-/path/@/custom/closed", "value"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = NewSQLiteRefStore(dbPath, map[string]struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := store.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	if _, ok := store.Info().Tags["state"]; !ok {
+		t.Errorf("expected tags to be retained, got %v", store.Info().Tags)
+	}
+	assertValue(t, store, "repo.git/-/path/@/custom/committed", "value")
+	assertMissing(t, store, "repo.git/-/path/@/custom/uncommitted")
+	assertMissing(t, store, "repo.git/-/path/@/custom/closed")
+}
+
+func TestGlobLiteralPrefix(t *testing.T) {
+	var tests = []struct {
+		glob   string
+		prefix string
+	}{
+		{glob: "repo.git/-/path/@/**", prefix: "repo.git/-/path/@/"},
+		{glob: "repo.git/-/path/@r?/deploy/*", prefix: "repo.git/-/path/@r"},
+		{glob: "{a,b}/**", prefix: ""},
+		{glob: "repo.git/-/path/@r1", prefix: "repo.git/-/path/@r1"},
+	}
+	for _, test := range tests {
+		if got := globLiteralPrefix(test.glob); got != test.prefix {
+			t.Errorf("globLiteralPrefix(%q) = %q, want %q", test.glob, got, test.prefix)
+		}
+	}
+}
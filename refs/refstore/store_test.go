@@ -2,9 +2,12 @@ package refstore
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"path/filepath"
 	"testing"
+
+	"github.com/google/go-cmp/cmp"
 )
 
 func DoTestStore(t *testing.T, store Store) {
@@ -308,4 +311,58 @@ func testStoreFragments(t *testing.T, store Store) {
 	if got != "value1" {
 		t.Errorf("unexpected value for key: got %q, want %q", got, "value1")
 	}
+	createTestRefs(t, store,
+		"repo.git/package/@/custom/lists", map[string]any{
+			"digests": []string{"sha256:amd64", "sha256:arm64"},
+			"endpoints": []map[string]any{
+				{"host": "a.example.com", "port": 443},
+				{"host": "b.example.com"},
+			},
+			"regions": map[string]any{
+				"us": map[string]any{"host": "us.example.com"},
+				"eu": map[string]any{"host": "eu.example.com"},
+			},
+		},
+	)
+
+	tests := []struct {
+		fragment string
+		want     any
+	}{
+		{fragment: "digests/0", want: "sha256:amd64"},
+		{fragment: "digests/1", want: "sha256:arm64"},
+		{fragment: "digests/-1", want: "sha256:arm64"},
+		{fragment: "digests/-2", want: "sha256:amd64"},
+		{fragment: "endpoints/0/host", want: "a.example.com"},
+		{fragment: "endpoints/-1/host", want: "b.example.com"},
+		{fragment: "endpoints/*/host", want: []any{"a.example.com", "b.example.com"}},
+		{fragment: "endpoints/*/port", want: []any{float64(443)}},
+		{fragment: "endpoints/*/missing", want: []any{}},
+		{fragment: "digests/*", want: []any{"sha256:amd64", "sha256:arm64"}},
+		{fragment: "regions/*/host", want: []any{"eu.example.com", "us.example.com"}},
+	}
+	for _, test := range tests {
+		var got any
+		if err := store.Get(ctx, "repo.git/package/@/custom/lists#"+test.fragment, &got); err != nil {
+			t.Errorf("failed to get %s: %v", test.fragment, err)
+			continue
+		}
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("unexpected value for %s (-want +got):\n%s", test.fragment, diff)
+		}
+	}
+
+	for _, fragment := range []string{
+		"digests/2",
+		"digests/-3",
+		"digests/first",
+		"digests/0/value",
+		"regions/0",
+		"endpoints/1/port",
+	} {
+		var got any
+		if err := store.Get(ctx, "repo.git/package/@/custom/lists#"+fragment, &got); !errors.Is(err, ErrRefNotFound) {
+			t.Errorf("expected ErrRefNotFound for %s, got %v", fragment, err)
+		}
+	}
 }
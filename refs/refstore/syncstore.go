@@ -0,0 +1,212 @@
+package refstore
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// NewSyncStore wraps a store so that it may be shared between goroutines.
+// Each operation holds a lock on the underlying store for its duration.
+//
+// This does not group operations into transactions, callers that need
+// transactions to be isolated from each other must coordinate themselves.
+func NewSyncStore(store Store) Store {
+	if _, ok := store.(*SyncStore); ok {
+		return store
+	}
+	return &SyncStore{store: store}
+}
+
+var _ Store = (*SyncStore)(nil)
+var _ ConditionalStore = (*SyncStore)(nil)
+var _ RollbackStore = (*SyncStore)(nil)
+var _ HistoryStore = (*SyncStore)(nil)
+var _ WatchableStore = (*SyncStore)(nil)
+var _ MetadataStore = (*SyncStore)(nil)
+
+type SyncStore struct {
+	mu    sync.Mutex
+	store Store
+}
+
+func (s *SyncStore) Info() StoreInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.store.Info()
+}
+
+// StartTransaction implements Store.
+func (s *SyncStore) StartTransaction(ctx context.Context, message string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.store.StartTransaction(ctx, message)
+}
+
+// CommitTransaction implements Store.
+func (s *SyncStore) CommitTransaction(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.store.CommitTransaction(ctx)
+}
+
+// RollbackTransaction implements RollbackStore.
+func (s *SyncStore) RollbackTransaction(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return RollbackTransaction(ctx, s.store)
+}
+
+// Get implements Store.
+func (s *SyncStore) Get(ctx context.Context, ref string, v any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.store.Get(ctx, ref, v)
+}
+
+// Set implements Store.
+func (s *SyncStore) Set(ctx context.Context, ref string, v any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.store.Set(ctx, ref, v)
+}
+
+// GetWithRevision implements ConditionalStore.
+func (s *SyncStore) GetWithRevision(ctx context.Context, ref string, v any) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return GetWithRevision(ctx, s.store, ref, v)
+}
+
+// SetIfAbsent implements ConditionalStore.
+func (s *SyncStore) SetIfAbsent(ctx context.Context, ref string, v any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return SetIfAbsent(ctx, s.store, ref, v)
+}
+
+// SetIfRevision implements ConditionalStore.
+func (s *SyncStore) SetIfRevision(ctx context.Context, ref string, v any, revision string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return SetIfRevision(ctx, s.store, ref, v, revision)
+}
+
+// History implements HistoryStore.
+func (s *SyncStore) History(ctx context.Context, ref string) ([]HistoryEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return History(ctx, s.store, ref)
+}
+
+// RevisionAt implements HistoryStore.
+func (s *SyncStore) RevisionAt(ctx context.Context, t time.Time) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return RevisionAt(ctx, s.store, t)
+}
+
+// GetAt implements HistoryStore.
+func (s *SyncStore) GetAt(ctx context.Context, ref string, revision string, v any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return GetAt(ctx, s.store, ref, revision, v)
+}
+
+// GetMetadata implements MetadataStore.
+func (s *SyncStore) GetMetadata(ctx context.Context, ref string) (ObjectMetadata, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return GetMetadata(ctx, s.store, ref)
+}
+
+// Watch implements WatchableStore.
+func (s *SyncStore) Watch(ctx context.Context, globs ...string) (<-chan ChangeEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return Watch(ctx, s.store, globs...)
+}
+
+// Delete implements Store.
+func (s *SyncStore) Delete(ctx context.Context, ref string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.store.Delete(ctx, ref)
+}
+
+// Match implements Store.
+func (s *SyncStore) Match(ctx context.Context, glob ...string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.store.Match(ctx, glob...)
+}
+
+// MatchOptions implements Store.
+func (s *SyncStore) MatchOptions(ctx context.Context, options MatchOptions, glob ...string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.store.MatchOptions(ctx, options, glob...)
+}
+
+// Link implements Store.
+func (s *SyncStore) Link(ctx context.Context, ref string, target string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.store.Link(ctx, ref, target)
+}
+
+// Unlink implements Store.
+func (s *SyncStore) Unlink(ctx context.Context, ref string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.store.Unlink(ctx, ref)
+}
+
+// GetLinks implements Store.
+func (s *SyncStore) GetLinks(ctx context.Context, ref string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.store.GetLinks(ctx, ref)
+}
+
+// ResolveLink implements Store.
+func (s *SyncStore) ResolveLink(ctx context.Context, ref string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.store.ResolveLink(ctx, ref)
+}
+
+// AddDependency implements Store.
+func (s *SyncStore) AddDependency(ctx context.Context, ref string, dependency string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.store.AddDependency(ctx, ref, dependency)
+}
+
+// RemoveDependency implements Store.
+func (s *SyncStore) RemoveDependency(ctx context.Context, ref string, dependency string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.store.RemoveDependency(ctx, ref, dependency)
+}
+
+// GetDependencies implements Store.
+func (s *SyncStore) GetDependencies(ctx context.Context, ref string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.store.GetDependencies(ctx, ref)
+}
+
+// GetDependants implements Store.
+func (s *SyncStore) GetDependants(ctx context.Context, ref string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.store.GetDependants(ctx, ref)
+}
+
+// Close implements Store.
+func (s *SyncStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.store.Close()
+}
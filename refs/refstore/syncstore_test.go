@@ -0,0 +1,55 @@
+package refstore
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestSyncStore(t *testing.T) {
+	tempDir := "./testdata/sync_testdata"
+	_ = os.RemoveAll(tempDir)
+	if err := os.MkdirAll(tempDir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	fs, err := NewFSRefStore(tempDir, map[string]struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewSyncStore(fs)
+	defer func() {
+		if err := store.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	DoTestStore(t, store)
+
+	t.Run("concurrent", func(t *testing.T) {
+		ctx := context.Background()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				ref := fmt.Sprintf("repo.git/package/@/custom/concurrent%d", i)
+				if err := store.Set(ctx, ref, i); err != nil {
+					t.Errorf("failed to set %s: %v", ref, err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		matches, err := store.Match(ctx, "repo.git/package/@/custom/concurrent*")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(matches) != 10 {
+			t.Errorf("unexpected number of matches: got %d, want %d", len(matches), 10)
+		}
+	})
+}
@@ -0,0 +1,42 @@
+package refstore
+
+import "fmt"
+
+// storeUpgrades bring a store from the version they are keyed by up to the
+// next version. When stateVersion is increased, an upgrade from the previous
+// version must be added for each store type so existing stores keep working.
+type storeUpgrades map[int]func(info *StoreInfo) error
+
+// apply runs each upgrade needed to bring a store from its current version up
+// to stateVersion, returning true if any were run.
+func (u storeUpgrades) apply(info *StoreInfo) (bool, error) {
+	if info.Version > stateVersion {
+		return false, fmt.Errorf("incompatible store version: expected %d, got %d (the store was written by a newer version of ocuroot)", stateVersion, info.Version)
+	}
+
+	upgraded := false
+	for info.Version < stateVersion {
+		upgrade, ok := u[info.Version]
+		if !ok {
+			return upgraded, fmt.Errorf("incompatible store version: expected %d, got %d (no upgrade is available)", stateVersion, info.Version)
+		}
+		from := info.Version
+		if err := upgrade(info); err != nil {
+			return upgraded, fmt.Errorf("failed to upgrade store from version %d: %w", from, err)
+		}
+		info.Version = from + 1
+		upgraded = true
+	}
+	return upgraded, nil
+}
+
+// fsUpgrades returns the upgrades for a filesystem store.
+func (f *FSStateStore) fsUpgrades(tags map[string]struct{}) storeUpgrades {
+	return storeUpgrades{
+		// Version 2 stores did not record their tags
+		2: func(info *StoreInfo) error {
+			info.Tags = tags
+			return nil
+		},
+	}
+}
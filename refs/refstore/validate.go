@@ -0,0 +1,251 @@
+package refstore
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	libglob "github.com/gobwas/glob"
+)
+
+// SchemaRule applies a schema to the refs matching a glob.
+type SchemaRule struct {
+	Glob   string
+	Schema *Schema
+}
+
+// SchemaSet finds the schema for a ref from a list of rules.
+type SchemaSet struct {
+	rules []compiledSchemaRule
+}
+
+type compiledSchemaRule struct {
+	SchemaRule
+	glob libglob.Glob
+}
+
+// NewSchemaSet compiles a list of schema rules. Where more than one rule
+// matches a ref, the first is used.
+func NewSchemaSet(rules ...SchemaRule) (*SchemaSet, error) {
+	out := &SchemaSet{}
+	for _, rule := range rules {
+		compiled, err := libglob.Compile(rule.Glob, '/')
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile schema glob %q: %w", rule.Glob, err)
+		}
+		if err := rule.Schema.compile(); err != nil {
+			return nil, fmt.Errorf("invalid schema for %q: %w", rule.Glob, err)
+		}
+		out.rules = append(out.rules, compiledSchemaRule{
+			SchemaRule: rule,
+			glob:       compiled,
+		})
+	}
+	return out, nil
+}
+
+// Lookup returns the schema for a ref, or nil if the ref may hold any value.
+func (s *SchemaSet) Lookup(ref string) *Schema {
+	if s == nil {
+		return nil
+	}
+	ref, _, _ = strings.Cut(ref, "#")
+	for _, rule := range s.rules {
+		if rule.glob.Match(ref) {
+			return rule.Schema
+		}
+	}
+	return nil
+}
+
+// Validate checks a value to be written to a ref against the ref's schema,
+// returning a SchemaError if it does not match.
+func (s *SchemaSet) Validate(ref string, v any) error {
+	// Placeholders reserving a ref are replaced by the real value later
+	if _, ok := v.(reservation); ok {
+		return nil
+	}
+
+	schema := s.Lookup(ref)
+	if schema == nil {
+		return nil
+	}
+	errs, err := schema.Validate(v)
+	if err != nil {
+		return fmt.Errorf("failed to validate %s: %w", ref, err)
+	}
+	if len(errs) > 0 {
+		return &SchemaError{Ref: ref, Errors: errs}
+	}
+	return nil
+}
+
+// NewValidatingStore wraps a store to check each value written against the
+// schema for its ref. Writes that do not match are rejected with a
+// SchemaError.
+func NewValidatingStore(store Store, schemas *SchemaSet) *ValidatingStore {
+	return &ValidatingStore{
+		store:   store,
+		schemas: schemas,
+	}
+}
+
+var _ Store = (*ValidatingStore)(nil)
+var _ ConditionalStore = (*ValidatingStore)(nil)
+var _ RollbackStore = (*ValidatingStore)(nil)
+var _ HistoryStore = (*ValidatingStore)(nil)
+var _ WatchableStore = (*ValidatingStore)(nil)
+var _ MetadataStore = (*ValidatingStore)(nil)
+var _ GitSupportFileWriter = (*ValidatingStore)(nil)
+
+type ValidatingStore struct {
+	store   Store
+	schemas *SchemaSet
+}
+
+func (v *ValidatingStore) Info() StoreInfo {
+	return v.store.Info()
+}
+
+// StartTransaction implements Store.
+func (v *ValidatingStore) StartTransaction(ctx context.Context, message string) error {
+	return v.store.StartTransaction(ctx, message)
+}
+
+// CommitTransaction implements Store.
+func (v *ValidatingStore) CommitTransaction(ctx context.Context) error {
+	return v.store.CommitTransaction(ctx)
+}
+
+// RollbackTransaction implements RollbackStore.
+func (v *ValidatingStore) RollbackTransaction(ctx context.Context) error {
+	return RollbackTransaction(ctx, v.store)
+}
+
+// Get implements Store.
+func (v *ValidatingStore) Get(ctx context.Context, ref string, value any) error {
+	return v.store.Get(ctx, ref, value)
+}
+
+// Set implements Store.
+func (v *ValidatingStore) Set(ctx context.Context, ref string, value any) error {
+	if err := v.schemas.Validate(ref, value); err != nil {
+		return err
+	}
+	return v.store.Set(ctx, ref, value)
+}
+
+// Delete implements Store.
+func (v *ValidatingStore) Delete(ctx context.Context, ref string) error {
+	return v.store.Delete(ctx, ref)
+}
+
+// Match implements Store.
+func (v *ValidatingStore) Match(ctx context.Context, glob ...string) ([]string, error) {
+	return v.store.Match(ctx, glob...)
+}
+
+// MatchOptions implements Store.
+func (v *ValidatingStore) MatchOptions(ctx context.Context, options MatchOptions, glob ...string) ([]string, error) {
+	return v.store.MatchOptions(ctx, options, glob...)
+}
+
+// Link implements Store.
+func (v *ValidatingStore) Link(ctx context.Context, ref string, target string) error {
+	return v.store.Link(ctx, ref, target)
+}
+
+// Unlink implements Store.
+func (v *ValidatingStore) Unlink(ctx context.Context, ref string) error {
+	return v.store.Unlink(ctx, ref)
+}
+
+// GetLinks implements Store.
+func (v *ValidatingStore) GetLinks(ctx context.Context, ref string) ([]string, error) {
+	return v.store.GetLinks(ctx, ref)
+}
+
+// ResolveLink implements Store.
+func (v *ValidatingStore) ResolveLink(ctx context.Context, ref string) (string, error) {
+	return v.store.ResolveLink(ctx, ref)
+}
+
+// AddDependency implements Store.
+func (v *ValidatingStore) AddDependency(ctx context.Context, ref string, dependency string) error {
+	return v.store.AddDependency(ctx, ref, dependency)
+}
+
+// RemoveDependency implements Store.
+func (v *ValidatingStore) RemoveDependency(ctx context.Context, ref string, dependency string) error {
+	return v.store.RemoveDependency(ctx, ref, dependency)
+}
+
+// GetDependencies implements Store.
+func (v *ValidatingStore) GetDependencies(ctx context.Context, ref string) ([]string, error) {
+	return v.store.GetDependencies(ctx, ref)
+}
+
+// GetDependants implements Store.
+func (v *ValidatingStore) GetDependants(ctx context.Context, ref string) ([]string, error) {
+	return v.store.GetDependants(ctx, ref)
+}
+
+// Close implements Store.
+func (v *ValidatingStore) Close() error {
+	return v.store.Close()
+}
+
+// GetWithRevision implements ConditionalStore.
+func (v *ValidatingStore) GetWithRevision(ctx context.Context, ref string, value any) (string, error) {
+	return GetWithRevision(ctx, v.store, ref, value)
+}
+
+// SetIfAbsent implements ConditionalStore.
+func (v *ValidatingStore) SetIfAbsent(ctx context.Context, ref string, value any) error {
+	if err := v.schemas.Validate(ref, value); err != nil {
+		return err
+	}
+	return SetIfAbsent(ctx, v.store, ref, value)
+}
+
+// SetIfRevision implements ConditionalStore.
+func (v *ValidatingStore) SetIfRevision(ctx context.Context, ref string, value any, revision string) error {
+	if err := v.schemas.Validate(ref, value); err != nil {
+		return err
+	}
+	return SetIfRevision(ctx, v.store, ref, value, revision)
+}
+
+// History implements HistoryStore.
+func (v *ValidatingStore) History(ctx context.Context, ref string) ([]HistoryEntry, error) {
+	return History(ctx, v.store, ref)
+}
+
+// RevisionAt implements HistoryStore.
+func (v *ValidatingStore) RevisionAt(ctx context.Context, t time.Time) (string, error) {
+	return RevisionAt(ctx, v.store, t)
+}
+
+// GetAt implements HistoryStore.
+func (v *ValidatingStore) GetAt(ctx context.Context, ref string, revision string, value any) error {
+	return GetAt(ctx, v.store, ref, revision, value)
+}
+
+// GetMetadata implements MetadataStore.
+func (v *ValidatingStore) GetMetadata(ctx context.Context, ref string) (ObjectMetadata, error) {
+	return GetMetadata(ctx, v.store, ref)
+}
+
+// Watch implements WatchableStore.
+func (v *ValidatingStore) Watch(ctx context.Context, globs ...string) (<-chan ChangeEvent, error) {
+	return Watch(ctx, v.store, globs...)
+}
+
+// AddSupportFiles implements GitSupportFileWriter.
+func (v *ValidatingStore) AddSupportFiles(ctx context.Context, files map[string]string) error {
+	if gitSupportFileWriter, ok := v.store.(GitSupportFileWriter); ok {
+		return gitSupportFileWriter.AddSupportFiles(ctx, files)
+	}
+	return nil
+}
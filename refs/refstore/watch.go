@@ -0,0 +1,139 @@
+package refstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	libglob "github.com/gobwas/glob"
+)
+
+// ErrWatchUnsupported is returned when watching a store that does not
+// implement WatchableStore.
+var ErrWatchUnsupported = errors.New("store does not support watching for changes")
+
+// WatchableStore is implemented by stores that can report changes made to
+// refs by any writer, including other processes.
+type WatchableStore interface {
+	Store
+
+	// Watch emits an event for each ref matching any of the globs that is
+	// created, updated or deleted after the call. All refs are watched if no
+	// globs are provided.
+	// The channel is closed when the context is done.
+	Watch(ctx context.Context, globs ...string) (<-chan ChangeEvent, error)
+}
+
+type ChangeType string
+
+const (
+	ChangeCreated ChangeType = "create"
+	ChangeUpdated ChangeType = "update"
+	ChangeDeleted ChangeType = "delete"
+)
+
+// ChangeEvent describes a change to a ref observed by Watch.
+// Changes that do not affect the value of a ref, such as updates to the list
+// of links pointing at it, are not reported.
+type ChangeEvent struct {
+	Type ChangeType `json:"type"`
+	Ref  string     `json:"ref"`
+	Time time.Time  `json:"time"`
+}
+
+// Watch watches a store for changes to refs matching the globs.
+func Watch(ctx context.Context, store Store, globs ...string) (<-chan ChangeEvent, error) {
+	ws, ok := store.(WatchableStore)
+	if !ok {
+		return nil, ErrWatchUnsupported
+	}
+	return ws.Watch(ctx, globs...)
+}
+
+// refSnapshot maps refs to a digest of their values, so changes between two
+// points in time can be found.
+type refSnapshot map[string]string
+
+// valueDigest returns a digest of the value held in the stored content of a
+// ref, ignoring metadata such as links and stack traces.
+func valueDigest(content []byte) (string, error) {
+	var storageObject StorageObject
+	if err := json.Unmarshal(content, &storageObject); err != nil {
+		return "", fmt.Errorf("failed to unmarshal storage object: %w", err)
+	}
+	h := sha256.New()
+	h.Write([]byte(storageObject.Kind))
+	h.Write([]byte{0})
+	h.Write(storageObject.Body)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+type watchFilter []libglob.Glob
+
+func compileWatchFilter(globs []string) (watchFilter, error) {
+	var filter watchFilter
+	for _, glob := range globs {
+		g, err := libglob.Compile(glob, '/')
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile glob %s: %w", glob, err)
+		}
+		filter = append(filter, g)
+	}
+	return filter, nil
+}
+
+func (w watchFilter) match(ref string) bool {
+	if len(w) == 0 {
+		return true
+	}
+	for _, g := range w {
+		if g.Match(ref) {
+			return true
+		}
+	}
+	return false
+}
+
+// changes returns events for the refs matching the filter that differ
+// between two snapshots, ordered by ref.
+func (w watchFilter) changes(before, after refSnapshot, at time.Time) []ChangeEvent {
+	var events []ChangeEvent
+	for ref, digest := range after {
+		if !w.match(ref) {
+			continue
+		}
+		previous, existed := before[ref]
+		if !existed {
+			events = append(events, ChangeEvent{Type: ChangeCreated, Ref: ref, Time: at})
+		} else if previous != digest {
+			events = append(events, ChangeEvent{Type: ChangeUpdated, Ref: ref, Time: at})
+		}
+	}
+	for ref := range before {
+		if _, exists := after[ref]; !exists && w.match(ref) {
+			events = append(events, ChangeEvent{Type: ChangeDeleted, Ref: ref, Time: at})
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Ref < events[j].Ref
+	})
+	return events
+}
+
+// sendEvents sends events to a watcher, returning false if the context was
+// done before they could all be sent.
+func sendEvents(ctx context.Context, ch chan<- ChangeEvent, events []ChangeEvent) bool {
+	for _, event := range events {
+		select {
+		case ch <- event:
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return true
+}
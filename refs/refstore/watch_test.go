@@ -0,0 +1,74 @@
+package refstore
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// DoTestWatchableStore confirms that changes made through writer, which may
+// be a separate store instance sharing the same backing storage, are reported
+// by watching store.
+func DoTestWatchableStore(t *testing.T, store WatchableStore, writer Store) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ref := "github.com/example/repo.git/path/to/package/@/custom/watched"
+	ignored := "github.com/example/repo.git/path/to/package/@/deploy/ignored"
+	t.Cleanup(func() {
+		_ = writer.Delete(context.Background(), ref)
+		_ = writer.Delete(context.Background(), ignored)
+	})
+
+	events, err := store.Watch(ctx, "**/@/custom/*")
+	if err != nil {
+		t.Fatalf("failed to watch store: %v", err)
+	}
+
+	expectEvent := func(want ChangeType) {
+		t.Helper()
+		select {
+		case event, ok := <-events:
+			if !ok {
+				t.Fatal("watch channel closed unexpectedly")
+			}
+			if event.Type != want || event.Ref != ref {
+				t.Fatalf("unexpected event: got %s %s, want %s %s", event.Type, event.Ref, want, ref)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("timed out waiting for %s event", want)
+		}
+	}
+
+	if err := writer.Set(ctx, ref, "first"); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(ChangeCreated)
+
+	if err := writer.Set(ctx, ignored, "value"); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Set(ctx, ref, "second"); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(ChangeUpdated)
+
+	// Writing the same value is not a change
+	if err := writer.Set(ctx, ref, "second"); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Delete(ctx, ref); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(ChangeDeleted)
+
+	cancel()
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Error("expected no further events")
+		}
+	case <-time.After(10 * time.Second):
+		t.Error("watch channel was not closed")
+	}
+}
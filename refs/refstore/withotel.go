@@ -4,6 +4,7 @@ import (
 	"context"
 	"os"
 	"strings"
+	"time"
 
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
@@ -21,6 +22,11 @@ func StoreWithOtel(store Store) Store {
 }
 
 var _ Store = (*WithOtel)(nil)
+var _ ConditionalStore = (*WithOtel)(nil)
+var _ RollbackStore = (*WithOtel)(nil)
+var _ HistoryStore = (*WithOtel)(nil)
+var _ WatchableStore = (*WithOtel)(nil)
+var _ MetadataStore = (*WithOtel)(nil)
 
 type WithOtel struct {
 	Store              Store
@@ -59,6 +65,19 @@ func (w *WithOtel) CommitTransaction(ctx context.Context) error {
 	return w.Store.CommitTransaction(ctx)
 }
 
+// RollbackTransaction implements RollbackStore.
+func (w *WithOtel) RollbackTransaction(ctx context.Context) error {
+	_, span := tracer.Start(
+		ctx,
+		"RefStore.RollbackTransaction",
+		trace.WithAttributes(attribute.String("message", w.transactionMessage)),
+	)
+	defer span.End()
+
+	w.transactionMessage = ""
+	return RollbackTransaction(ctx, w.Store)
+}
+
 // Delete implements Store.
 func (w *WithOtel) Delete(ctx context.Context, ref string) error {
 	span := trace.SpanFromContext(ctx)
@@ -169,6 +188,86 @@ func (w *WithOtel) Set(ctx context.Context, ref string, v any) error {
 	return w.Store.Set(ctx, ref, v)
 }
 
+// GetWithRevision implements ConditionalStore.
+func (w *WithOtel) GetWithRevision(ctx context.Context, ref string, v any) (string, error) {
+	span := trace.SpanFromContext(ctx)
+	if span.IsRecording() {
+		span.AddEvent("RefStore.GetWithRevision", trace.WithAttributes(attribute.String("ref", ref)))
+	}
+
+	return GetWithRevision(ctx, w.Store, ref, v)
+}
+
+// SetIfAbsent implements ConditionalStore.
+func (w *WithOtel) SetIfAbsent(ctx context.Context, ref string, v any) error {
+	span := trace.SpanFromContext(ctx)
+	if span.IsRecording() {
+		span.AddEvent("RefStore.SetIfAbsent", trace.WithAttributes(attribute.String("ref", ref)))
+	}
+
+	return SetIfAbsent(ctx, w.Store, ref, v)
+}
+
+// SetIfRevision implements ConditionalStore.
+func (w *WithOtel) SetIfRevision(ctx context.Context, ref string, v any, revision string) error {
+	span := trace.SpanFromContext(ctx)
+	if span.IsRecording() {
+		span.AddEvent("RefStore.SetIfRevision", trace.WithAttributes(attribute.String("ref", ref), attribute.String("revision", revision)))
+	}
+
+	return SetIfRevision(ctx, w.Store, ref, v, revision)
+}
+
+// History implements HistoryStore.
+func (w *WithOtel) History(ctx context.Context, ref string) ([]HistoryEntry, error) {
+	span := trace.SpanFromContext(ctx)
+	if span != nil {
+		span.AddEvent("RefStore.History", trace.WithAttributes(attribute.String("ref", ref)))
+	}
+
+	return History(ctx, w.Store, ref)
+}
+
+// RevisionAt implements HistoryStore.
+func (w *WithOtel) RevisionAt(ctx context.Context, t time.Time) (string, error) {
+	span := trace.SpanFromContext(ctx)
+	if span != nil {
+		span.AddEvent("RefStore.RevisionAt", trace.WithAttributes(attribute.String("time", t.Format(time.RFC3339))))
+	}
+
+	return RevisionAt(ctx, w.Store, t)
+}
+
+// GetAt implements HistoryStore.
+func (w *WithOtel) GetAt(ctx context.Context, ref string, revision string, v any) error {
+	span := trace.SpanFromContext(ctx)
+	if span != nil {
+		span.AddEvent("RefStore.GetAt", trace.WithAttributes(attribute.String("ref", ref), attribute.String("revision", revision)))
+	}
+
+	return GetAt(ctx, w.Store, ref, revision, v)
+}
+
+// GetMetadata implements MetadataStore.
+func (w *WithOtel) GetMetadata(ctx context.Context, ref string) (ObjectMetadata, error) {
+	span := trace.SpanFromContext(ctx)
+	if span != nil {
+		span.AddEvent("RefStore.GetMetadata", trace.WithAttributes(attribute.String("ref", ref)))
+	}
+
+	return GetMetadata(ctx, w.Store, ref)
+}
+
+// Watch implements WatchableStore.
+func (w *WithOtel) Watch(ctx context.Context, globs ...string) (<-chan ChangeEvent, error) {
+	span := trace.SpanFromContext(ctx)
+	if span != nil {
+		span.AddEvent("RefStore.Watch", trace.WithAttributes(attribute.StringSlice("globs", globs)))
+	}
+
+	return Watch(ctx, w.Store, globs...)
+}
+
 // StartTransaction implements Store.
 func (w *WithOtel) StartTransaction(ctx context.Context, message string) error {
 	_, span := tracer.Start(
@@ -255,6 +354,111 @@ func (g *GitRepoWrapperWithOtel) commit(ctx context.Context, message string) err
 	return g.r.commit(ctx, message)
 }
 
+// discardLastCommit implements GitRepo.
+func (g *GitRepoWrapperWithOtel) discardLastCommit(ctx context.Context, paths []string) error {
+	_, span := tracer.Start(ctx, "git.discardLastCommit", trace.WithAttributes(
+		attribute.StringSlice("paths", paths),
+	))
+	defer span.End()
+
+	return g.r.discardLastCommit(ctx, paths)
+}
+
+// restore implements GitRepo.
+func (g *GitRepoWrapperWithOtel) restore(ctx context.Context, paths []string) error {
+	_, span := tracer.Start(ctx, "git.restore", trace.WithAttributes(
+		attribute.StringSlice("paths", paths),
+	))
+	defer span.End()
+
+	return g.r.restore(ctx, paths)
+}
+
+// log implements GitRepo.
+func (g *GitRepoWrapperWithOtel) log(ctx context.Context, path string) ([]gitCommit, error) {
+	_, span := tracer.Start(ctx, "git.log", trace.WithAttributes(
+		attribute.String("path", path),
+	))
+	defer span.End()
+
+	return g.r.log(ctx, path)
+}
+
+// show implements GitRepo.
+func (g *GitRepoWrapperWithOtel) show(ctx context.Context, revision string, path string) ([]byte, error) {
+	_, span := tracer.Start(ctx, "git.show", trace.WithAttributes(
+		attribute.String("revision", revision),
+		attribute.String("path", path),
+	))
+	defer span.End()
+
+	return g.r.show(ctx, revision, path)
+}
+
+// resolveRevision implements GitRepo.
+func (g *GitRepoWrapperWithOtel) resolveRevision(ctx context.Context, revision string) (string, error) {
+	_, span := tracer.Start(ctx, "git.resolveRevision", trace.WithAttributes(
+		attribute.String("revision", revision),
+	))
+	defer span.End()
+
+	return g.r.resolveRevision(ctx, revision)
+}
+
+// revisionAt implements GitRepo.
+func (g *GitRepoWrapperWithOtel) revisionAt(ctx context.Context, t time.Time) (string, error) {
+	_, span := tracer.Start(ctx, "git.revisionAt", trace.WithAttributes(
+		attribute.String("time", t.Format(time.RFC3339)),
+	))
+	defer span.End()
+
+	return g.r.revisionAt(ctx, t)
+}
+
+// remoteHead implements GitRepo.
+func (g *GitRepoWrapperWithOtel) remoteHead(ctx context.Context, remote string) (string, error) {
+	_, span := tracer.Start(ctx, "git.remoteHead", trace.WithAttributes(
+		attribute.String("remote", remote),
+		attribute.String("branch", g.r.Branch()),
+	))
+	defer span.End()
+
+	return g.r.remoteHead(ctx, remote)
+}
+
+// fetchRevision implements GitRepo.
+func (g *GitRepoWrapperWithOtel) fetchRevision(ctx context.Context, remote string, revision string) error {
+	_, span := tracer.Start(ctx, "git.fetchRevision", trace.WithAttributes(
+		attribute.String("remote", remote),
+		attribute.String("revision", revision),
+	))
+	defer span.End()
+
+	return g.r.fetchRevision(ctx, remote, revision)
+}
+
+// lsTree implements GitRepo.
+func (g *GitRepoWrapperWithOtel) lsTree(ctx context.Context, revision string, path string) (map[string]string, error) {
+	_, span := tracer.Start(ctx, "git.lsTree", trace.WithAttributes(
+		attribute.String("revision", revision),
+		attribute.String("path", path),
+	))
+	defer span.End()
+
+	return g.r.lsTree(ctx, revision, path)
+}
+
+// rebase implements GitRepo.
+func (g *GitRepoWrapperWithOtel) rebase(ctx context.Context, remote string) error {
+	_, span := tracer.Start(ctx, "git.rebase", trace.WithAttributes(
+		attribute.String("remote", remote),
+		attribute.String("branch", g.r.Branch()),
+	))
+	defer span.End()
+
+	return g.r.rebase(ctx, remote)
+}
+
 // pull implements GitRepo.
 func (g *GitRepoWrapperWithOtel) pull(ctx context.Context) error {
 	_, span := tracer.Start(ctx, "git.pull", trace.WithAttributes(
@@ -2,6 +2,7 @@ package sdk
 
 import (
 	"context"
+	"encoding/json"
 	"io"
 
 	"github.com/ocuroot/ocuroot/refs"
@@ -133,8 +134,16 @@ type SecretsBackend interface {
 }
 
 type Store struct {
-	State  StorageBackend  `json:"state"`
-	Intent *StorageBackend `json:"intent,omitempty"`
+	State      StorageBackend  `json:"state"`
+	Intent     *StorageBackend `json:"intent,omitempty"`
+	Encryption *Encryption     `json:"encryption,omitempty"`
+	// Schemas are JSON Schemas for custom state and intent, by name
+	Schemas map[string]json.RawMessage `json:"schemas,omitempty"`
+}
+
+type Encryption struct {
+	Recipients []string `json:"recipients" starlark:"recipients"`
+	Sensitive  []string `json:"sensitive,omitempty" starlark:"sensitive,omitempty"`
 }
 
 type StorageBackend struct {
@@ -144,10 +153,21 @@ type StorageBackend struct {
 		SupportFiles map[string]string `json:"support_files,omitempty" starlark:"support_files,omitempty"`
 		PathPrefix   string            `json:"path_prefix,omitempty" starlark:"path_prefix,omitempty"`
 		CreateBranch bool              `json:"create_branch,omitempty" starlark:"create_branch,omitempty"`
+		PushAttempts int               `json:"push_attempts,omitempty" starlark:"push_attempts,omitempty"`
 	} `json:"git,omitempty" starlark:"git,omitempty"`
 	Fs *struct {
-		Path string `json:"path" starlark:"path"`
+		Path    string `json:"path" starlark:"path"`
+		History bool   `json:"history,omitempty" starlark:"history,omitempty"`
 	} `json:"fs,omitempty" starlark:"fs,omitempty"`
+	Sqlite *struct {
+		Path string `json:"path" starlark:"path"`
+	} `json:"sqlite,omitempty" starlark:"sqlite,omitempty"`
+	Plugin *struct {
+		Command []string `json:"command" starlark:"command"`
+	} `json:"plugin,omitempty" starlark:"plugin,omitempty"`
+	Http *struct {
+		URL string `json:"url" starlark:"url"`
+	} `json:"http,omitempty" starlark:"http,omitempty"`
 }
 
 type StoreBackend interface {
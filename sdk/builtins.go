@@ -37,6 +37,12 @@ func AvailableVersions() []string {
 	return versions
 }
 
+// ResolveVersion returns the SDK version that will be used for a version
+// requested with ocuroot().
+func ResolveVersion(version string) string {
+	return resolveVersionAlias(version)
+}
+
 // resolveVersionAlias resolves a version to its target SDK version using semver constraints
 // Supports patterns like "0.3.x", ">=0.3", "0.3.14", etc.
 func resolveVersionAlias(version string) string {
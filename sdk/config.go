@@ -48,6 +48,20 @@ func LoadConfig(
 	filename string,
 	backend Backend,
 	print func(thread *starlark.Thread, msg string),
+) (*Config, error) {
+	return LoadConfigWithGlobals(ctx, resolver, filename, backend, print, nil)
+}
+
+// LoadConfigWithGlobals loads a config as LoadConfig does, with additional
+// globals available to the main file alongside the SDK builtins. They are not
+// available to loaded modules.
+func LoadConfigWithGlobals(
+	ctx context.Context,
+	resolver ModuleResolver,
+	filename string,
+	backend Backend,
+	print func(thread *starlark.Thread, msg string),
+	additionalGlobals starlark.StringDict,
 ) (*Config, error) {
 	log.Debug("Loading config", "filename", filename)
 	c := &configLoader{
@@ -88,6 +102,18 @@ func LoadConfig(
 		}
 	}
 
+	if len(additionalGlobals) > 0 {
+		// Merge SDK builtins with additional globals
+		mergedGlobals := make(starlark.StringDict)
+		for k, v := range builtins {
+			mergedGlobals[k] = v
+		}
+		for k, v := range additionalGlobals {
+			mergedGlobals[k] = v
+		}
+		builtins = mergedGlobals
+	}
+
 	_, mod, err := starlark.SourceProgramOptions(
 		syntax.LegacyFileOptions(),
 		filepath.Base(filename),
@@ -86,9 +86,10 @@ type Next struct {
 }
 
 type Done struct {
-	Outputs map[string]any `json:"outputs"`
-	Watch   []string       `json:"watch"`
-	Tags    []string       `json:"tags"`
+	Outputs   map[string]any `json:"outputs"`
+	Sensitive []string       `json:"sensitive,omitempty"`
+	Watch     []string       `json:"watch"`
+	Tags      []string       `json:"tags"`
 }
 
 type Log struct {
@@ -2,43 +2,117 @@ package sdk
 
 import (
 	"context"
+	"fmt"
 	"io"
+	"strings"
 
+	"github.com/gobwas/glob"
 	"github.com/ocuroot/ocuroot/refs"
+	"go.starlark.net/starlark"
 )
 
+// Mocks configures the responses of a mock backend, and records the calls
+// made to it.
+type Mocks struct {
+	Shell        []MockShell
+	HTTP         []MockHTTP
+	Environments []Environment
+
+	// Strict causes shell commands and HTTP requests without a matching mock
+	// to fail, rather than returning an empty response.
+	Strict bool
+
+	ShellCalls []HostShellRequest
+	HTTPCalls  []HTTPRequest
+}
+
+// MockShell is the response to shell commands matching a glob.
+type MockShell struct {
+	Command  string
+	Response HostShellResponse
+}
+
+// MockHTTP is the response to HTTP requests with a URL matching a glob. An
+// empty method matches any method.
+type MockHTTP struct {
+	Method   string
+	URL      string
+	Response HTTPResponse
+}
+
 func NewMockBackend() Backend {
+	return NewMockBackendWithMocks(&Mocks{})
+}
+
+// NewMockBackendWithMocks creates a mock backend responding with the given
+// mocks. Mocks may be changed between calls. Where more than one mock
+// matches, the last one added is used.
+func NewMockBackendWithMocks(mocks *Mocks) Backend {
 	return Backend{
-		Refs: NewRefBackend(refs.Ref{}),
-		Environments: &mockEnvironmentBackend{
-			all: []Environment{},
-		},
+		Refs:                     NewRefBackend(refs.Ref{}),
+		Environments:             &mockEnvironmentBackend{mocks: mocks},
 		AllowPackageRegistration: true,
-		Http:                     &mockHTTPBackend{},
+		Http:                     &mockHTTPBackend{mocks: mocks},
 		Secrets:                  &mockSecretsBackend{},
-		Host:                     &mockHostBackend{},
+		Host:                     &mockHostBackend{mocks: mocks},
 		Store:                    &mockStoreBackend{},
 		Debug:                    &mockDebugBackend{},
+		Repo:                     &mockRepoBackend{},
 	}
 }
 
+// mockMatches matches a value against a glob, treating invalid globs as
+// literal strings.
+func mockMatches(pattern string, value string) bool {
+	g, err := glob.Compile(pattern)
+	if err != nil {
+		return pattern == value
+	}
+	return g.Match(value)
+}
+
 type mockEnvironmentBackend struct {
-	all []Environment
+	mocks *Mocks
 }
 
 func (m *mockEnvironmentBackend) All(ctx context.Context) ([]Environment, error) {
-	return m.all, nil
+	return append([]Environment{}, m.mocks.Environments...), nil
 }
 
 func (m *mockEnvironmentBackend) Register(ctx context.Context, env Environment) error {
-	m.all = append(m.all, env)
+	m.mocks.Environments = append(m.mocks.Environments, env)
+	return nil
+}
+
+type mockRepoBackend struct {
+}
+
+func (m *mockRepoBackend) Alias(ctx context.Context, alias string) error {
+	return nil
+}
+
+func (m *mockRepoBackend) Trigger(ctx context.Context, fn *starlark.Function) {
+}
+
+func (m *mockRepoBackend) Remotes(ctx context.Context, remotes []string) error {
 	return nil
 }
 
 type mockHTTPBackend struct {
+	mocks *Mocks
 }
 
 func (m *mockHTTPBackend) Req(ctx context.Context, req HTTPRequest) (HTTPResponse, error) {
+	m.mocks.HTTPCalls = append(m.mocks.HTTPCalls, req)
+	for i := len(m.mocks.HTTP) - 1; i >= 0; i-- {
+		mock := m.mocks.HTTP[i]
+		if (mock.Method == "" || strings.EqualFold(mock.Method, req.Method)) && mockMatches(mock.URL, req.URL) {
+			return mock.Response, nil
+		}
+	}
+	if m.mocks.Strict {
+		return HTTPResponse{}, fmt.Errorf("no mock for HTTP request %s %s", req.Method, req.URL)
+	}
 	return HTTPResponse{}, nil
 }
 
@@ -49,6 +123,7 @@ func (m *mockSecretsBackend) Register(value string) {
 }
 
 type mockHostBackend struct {
+	mocks *Mocks
 }
 
 func (m *mockHostBackend) OS() string {
@@ -64,6 +139,27 @@ func (m *mockHostBackend) Env() map[string]string {
 }
 
 func (m *mockHostBackend) Shell(ctx context.Context, req HostShellRequest, stdout io.Writer) (HostShellResponse, error) {
+	m.mocks.ShellCalls = append(m.mocks.ShellCalls, req)
+	for i := len(m.mocks.Shell) - 1; i >= 0; i-- {
+		mock := m.mocks.Shell[i]
+		if !mockMatches(mock.Command, req.Cmd) {
+			continue
+		}
+		resp := mock.Response
+		if resp.CombinedOutput == "" {
+			resp.CombinedOutput = resp.Stdout + resp.Stderr
+		}
+		if stdout != nil && !req.Mute {
+			io.WriteString(stdout, resp.CombinedOutput)
+		}
+		if resp.ExitCode != 0 && !req.ContinueOnError {
+			return resp, fmt.Errorf("%v: exit status %d", req.Cmd, resp.ExitCode)
+		}
+		return resp, nil
+	}
+	if m.mocks.Strict {
+		return HostShellResponse{}, fmt.Errorf("no mock for shell command %q", req.Cmd)
+	}
 	return HostShellResponse{}, nil
 }
 
@@ -0,0 +1,63 @@
+package sdk
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestMockBackendWithMocks(t *testing.T) {
+	ctx := context.Background()
+	mocks := &Mocks{
+		Shell: []MockShell{
+			{Command: "git *", Response: HostShellResponse{Stdout: "any"}},
+			{Command: "git rev-parse *", Response: HostShellResponse{Stdout: "abc123"}},
+			{Command: "make test", Response: HostShellResponse{Stderr: "failed", ExitCode: 2}},
+		},
+		HTTP: []MockHTTP{
+			{Method: "POST", URL: "https://example.com/*", Response: HTTPResponse{StatusCode: 201}},
+		},
+	}
+	backend := NewMockBackendWithMocks(mocks)
+
+	var out bytes.Buffer
+	resp, err := backend.Host.Shell(ctx, HostShellRequest{Cmd: "git rev-parse HEAD"}, &out)
+	if err != nil || resp.Stdout != "abc123" || out.String() != "abc123" {
+		t.Errorf("expected the last matching mock to be used, got %+v, %q, %v", resp, out.String(), err)
+	}
+
+	if _, err := backend.Host.Shell(ctx, HostShellRequest{Cmd: "make test"}, nil); err == nil {
+		t.Error("expected a non-zero exit code to fail")
+	}
+	resp, err = backend.Host.Shell(ctx, HostShellRequest{Cmd: "make test", ContinueOnError: true}, nil)
+	if err != nil || resp.ExitCode != 2 || resp.CombinedOutput != "failed" {
+		t.Errorf("expected the failure to be returned, got %+v, %v", resp, err)
+	}
+
+	if resp, err := backend.Host.Shell(ctx, HostShellRequest{Cmd: "ls"}, nil); err != nil || resp != (HostShellResponse{}) {
+		t.Errorf("expected an empty response for an unmatched command, got %+v, %v", resp, err)
+	}
+	mocks.Strict = true
+	if _, err := backend.Host.Shell(ctx, HostShellRequest{Cmd: "ls"}, nil); err == nil {
+		t.Error("expected an unmatched command to fail when strict")
+	}
+	if len(mocks.ShellCalls) != 5 {
+		t.Errorf("expected 5 shell calls to be recorded, got %d", len(mocks.ShellCalls))
+	}
+
+	httpResp, err := backend.Http.Req(ctx, HTTPRequest{Method: "post", URL: "https://example.com/items"})
+	if err != nil || httpResp.StatusCode != 201 {
+		t.Errorf("expected the POST mock to match, got %+v, %v", httpResp, err)
+	}
+	if _, err := backend.Http.Req(ctx, HTTPRequest{Method: "GET", URL: "https://example.com/items"}); err == nil {
+		t.Error("expected a GET to fail when strict")
+	}
+
+	if err := backend.Environments.Register(ctx, Environment{Name: "staging"}); err != nil {
+		t.Fatal(err)
+	}
+	envs, err := backend.Environments.All(ctx)
+	if err != nil || len(envs) != 1 || envs[0].Name != "staging" {
+		t.Errorf("expected registered environments to be returned, got %v, %v", envs, err)
+	}
+}
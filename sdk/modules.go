@@ -104,24 +104,26 @@ type moduleLoader struct {
 }
 
 func (m *moduleLoader) Load(_ *starlark.Thread, module string) (starlark.StringDict, error) {
-	if module, exists := m.cache[module]; exists {
-		return module, nil
-	}
-
-	if _, exists := m.loading[module]; exists {
-		return nil, fmt.Errorf("cycle in load graph")
-	}
-
-	m.loading[module] = struct{}{}
-	defer delete(m.loading, module)
-
 	log.Debug("Loading module", "module", module)
 
+	// Modules are cached by their resolved filename, as the same relative
+	// path may refer to different files from different modules
 	filename, data, err := m.resolver.Resolve(module)
 	if err != nil {
 		return nil, err
 	}
 
+	if module, exists := m.cache[filename]; exists {
+		return module, nil
+	}
+
+	if _, exists := m.loading[filename]; exists {
+		return nil, fmt.Errorf("cycle in load graph")
+	}
+
+	m.loading[filename] = struct{}{}
+	defer delete(m.loading, filename)
+
 	sdkVersion, err := IdentifySDKVersion(filename, data)
 	if err != nil {
 		return nil, err
@@ -158,7 +160,7 @@ func (m *moduleLoader) Load(_ *starlark.Thread, module string) (starlark.StringD
 		return nil, err
 	}
 
-	m.cache[module] = globals
+	m.cache[filename] = globals
 
 	return globals, nil
 }
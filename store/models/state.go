@@ -1,6 +1,8 @@
 package models
 
 import (
+	"time"
+
 	"github.com/ocuroot/gittools"
 	"github.com/ocuroot/ocuroot/refs"
 	"github.com/ocuroot/ocuroot/sdk"
@@ -36,6 +38,18 @@ type Run struct {
 	WatchFiles []string       `json:"watch_files"`
 }
 
+// Lease records the worker that has claimed a run.
+// A lease that has passed its expiry is considered abandoned.
+type Lease struct {
+	Holder   string    `json:"holder"`
+	Hostname string    `json:"hostname"`
+	Expires  time.Time `json:"expires"`
+}
+
+func (l Lease) Expired(now time.Time) bool {
+	return now.After(l.Expires)
+}
+
 type Function struct {
 	Fn           sdk.FunctionDef                `json:"fn"`
 	Dependencies []refs.Ref                     `json:"dependencies,omitempty"`